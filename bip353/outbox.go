@@ -0,0 +1,129 @@
+package bip353
+
+import (
+	"context"
+	"errors"
+	"log"
+	"time"
+
+	"github.com/breez/breez-lnurl/dns"
+	lnurl "github.com/breez/breez-lnurl/persist/lnurl"
+)
+
+// The interval the outbox is polled for due DNS updates at
+var OutboxInterval time.Duration = 2 * time.Second
+
+// The delay before the first retry of a failed DNS update, doubled on every attempt
+var OutboxRetryInterval time.Duration = 30 * time.Second
+
+// The longest delay between the retries of a failed DNS update
+var MaxOutboxRetryInterval time.Duration = time.Hour
+
+// The attempts after which a DNS update is failed until the offer is registered again
+var MaxOutboxAttempts = 12
+
+// How long a claimed DNS update is skipped by other workers, longer than the DNS timeouts
+var outboxLease = 5 * time.Minute
+
+// The DNS updates applied per poll
+var outboxBatchSize = 100
+
+var errNoDnsService = errors.New("no DNS service")
+
+// Publisher applies the DNS updates queued in the outbox by the store, in
+// the same transaction as the offers, retrying failed updates with an
+// exponential backoff.
+type Publisher struct {
+	service dns.DnsService
+	store   lnurl.Store
+}
+
+func NewPublisher(service dns.DnsService, store lnurl.Store) *Publisher {
+	return &Publisher{
+		service: service,
+		store:   store,
+	}
+}
+
+// Start applies the due DNS updates periodically until the context is done.
+func (p *Publisher) Start(ctx context.Context) {
+	for {
+		if _, err := p.Publish(ctx); err != nil {
+			log.Printf("failed to publish BIP353 records: %v", err)
+		}
+		select {
+		case <-time.After(OutboxInterval):
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// Publish applies the due DNS updates, returning how many were claimed.
+func (p *Publisher) Publish(ctx context.Context) (int, error) {
+	now := time.Now()
+	records, err := p.store.ClaimDnsRecords(ctx, now, now.Add(outboxLease), outboxBatchSize)
+	if err != nil {
+		return 0, err
+	}
+	for _, record := range records {
+		if err := ctx.Err(); err != nil {
+			return len(records), err
+		}
+		p.apply(ctx, record)
+	}
+	return len(records), nil
+}
+
+func (p *Publisher) apply(ctx context.Context, record lnurl.DnsRecord) {
+	var err error
+	if record.Offer == nil {
+		err = p.service.Remove(record.Username)
+	} else {
+		var ttl uint32
+		ttl, err = p.service.Set(record.Username, dns.TxtRecord(*record.Offer, record.Bip21Params))
+		if err == nil && ttl == 0 {
+			err = errNoDnsService
+		}
+	}
+	if err == nil {
+		if err = p.store.MarkDnsRecordPublished(ctx, record.Username, record.Version); err != nil {
+			log.Printf("failed to mark DNS TXT record for %v published: %v", record.Username, err)
+		}
+		return
+	}
+
+	var retryAt *time.Time
+	attempts := record.Attempts + 1
+	if attempts < MaxOutboxAttempts && !errors.Is(err, errNoDnsService) {
+		next := time.Now().Add(retryDelay(attempts))
+		retryAt = &next
+	}
+	log.Printf("failed to update DNS TXT record for %v (attempt %v): %v", record.Username, attempts, err)
+	if err := p.store.MarkDnsRecordFailed(ctx, record.Username, record.Version, err.Error(), retryAt); err != nil {
+		log.Printf("failed to mark DNS TXT record for %v failed: %v", record.Username, err)
+	}
+}
+
+// retryDelay returns the delay before retrying an update after its attempts.
+func retryDelay(attempts int) time.Duration {
+	delay := OutboxRetryInterval
+	for i := 1; i < attempts && delay < MaxOutboxRetryInterval; i++ {
+		delay *= 2
+	}
+	return min(delay, MaxOutboxRetryInterval)
+}
+
+// RecordState returns the state of the DNS TXT record of the username's
+// offer. Offers without a queued update were published before the outbox.
+func RecordState(ctx context.Context, store lnurl.Store, username string) string {
+	record, err := store.GetDnsRecord(ctx, username)
+	if err != nil {
+		log.Printf("failed to get the DNS TXT record of %v: %v", username, err)
+		return lnurl.DnsRecordPending
+	}
+	if record == nil {
+		return lnurl.DnsRecordPublished
+	}
+	return record.State
+}
@@ -0,0 +1,173 @@
+package bip353
+
+import (
+	"context"
+	"errors"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/breez/breez-lnurl/dns"
+	lnurl "github.com/breez/breez-lnurl/persist/lnurl"
+	miekg "github.com/miekg/dns"
+	"gotest.tools/assert"
+)
+
+// failingZone fails every update until it is fixed.
+type failingZone struct {
+	fakeZone
+	err error
+}
+
+func (z *failingZone) Set(username, record string) (uint32, error) {
+	if z.err != nil {
+		return 0, z.err
+	}
+	return z.fakeZone.Set(username, record)
+}
+
+func TestPublishQueuedUpdates(t *testing.T) {
+	ctx := context.Background()
+	store := lnurl.NewMemoryStore()
+	zone := &fakeZone{records: map[string][]string{}}
+	publisher := NewPublisher(zone, store)
+
+	offer := "lno1alice"
+	_, err := store.SetPubkeyDetails(ctx, "01", "alice", &offer, nil)
+	assert.NilError(t, err)
+	assert.Equal(t, RecordState(ctx, store, "alice"), lnurl.DnsRecordPending)
+	claimed, err := publisher.Publish(ctx)
+	assert.NilError(t, err)
+	assert.Equal(t, claimed, 1)
+	assert.DeepEqual(t, zone.records, map[string][]string{"alice": {dns.TxtRecord(offer, nil)}})
+	assert.Equal(t, RecordState(ctx, store, "alice"), lnurl.DnsRecordPublished)
+
+	// Registering the same offer queues nothing
+	_, err = store.SetPubkeyDetails(ctx, "01", "alice", &offer, nil)
+	assert.NilError(t, err)
+	claimed, err = publisher.Publish(ctx)
+	assert.NilError(t, err)
+	assert.Equal(t, claimed, 0)
+
+	// Renaming removes the record of the last username
+	_, err = store.SetPubkeyDetails(ctx, "01", "alice2", &offer, nil)
+	assert.NilError(t, err)
+	claimed, err = publisher.Publish(ctx)
+	assert.NilError(t, err)
+	assert.Equal(t, claimed, 2)
+	assert.DeepEqual(t, zone.records, map[string][]string{"alice2": {dns.TxtRecord(offer, nil)}})
+	record, err := store.GetDnsRecord(ctx, "alice")
+	assert.NilError(t, err)
+	assert.Assert(t, record == nil, "applied removals are deleted")
+}
+
+func TestPublishPaymentParams(t *testing.T) {
+	ctx := context.Background()
+	store := lnurl.NewMemoryStore()
+	zone := &fakeZone{records: map[string][]string{}}
+	publisher := NewPublisher(zone, store)
+
+	offer := "lno1alice"
+	_, err := store.SetPubkeyDetails(ctx, "01", "alice", &offer, nil)
+	assert.NilError(t, err)
+	_, err = publisher.Publish(ctx)
+	assert.NilError(t, err)
+
+	// Changing the parameters alone updates the record
+	params := &lnurl.PaymentParams{Address: "bc1qalice", SilentPayment: "sp1alice"}
+	_, err = store.SetPubkeyDetails(ctx, "01", "alice", &offer, params)
+	assert.NilError(t, err)
+	claimed, err := publisher.Publish(ctx)
+	assert.NilError(t, err)
+	assert.Equal(t, claimed, 1)
+	assert.Equal(t, zone.records["alice"][1], "bitcoin:bc1qalice?lno=lno1alice&sp=sp1alice")
+
+	_, err = store.SetPubkeyDetails(ctx, "01", "alice", &offer, &lnurl.PaymentParams{Address: "bc1qalice", SilentPayment: "sp1alice"})
+	assert.NilError(t, err)
+	claimed, err = publisher.Publish(ctx)
+	assert.NilError(t, err)
+	assert.Equal(t, claimed, 0)
+}
+
+func TestRetryFailedUpdates(t *testing.T) {
+	ctx := context.Background()
+	store := lnurl.NewMemoryStore()
+	zone := &failingZone{fakeZone: fakeZone{records: map[string][]string{}}, err: errors.New("refused")}
+	publisher := NewPublisher(zone, store)
+
+	offer := "lno1alice"
+	_, err := store.SetPubkeyDetails(ctx, "01", "alice", &offer, nil)
+	assert.NilError(t, err)
+	_, err = publisher.Publish(ctx)
+	assert.NilError(t, err)
+	record, err := store.GetDnsRecord(ctx, "alice")
+	assert.NilError(t, err)
+	assert.Equal(t, record.State, lnurl.DnsRecordPending)
+	assert.Equal(t, record.Attempts, 1)
+	assert.Equal(t, *record.LastError, "refused")
+	assert.Assert(t, record.NextAttemptAt.After(time.Now().Add(OutboxRetryInterval/2)))
+
+	// Not retried before the backoff, and given up on after the last attempt
+	claimed, err := publisher.Publish(ctx)
+	assert.NilError(t, err)
+	assert.Equal(t, claimed, 0)
+	for i := 1; i < MaxOutboxAttempts; i++ {
+		assert.NilError(t, store.MarkDnsRecordFailed(ctx, "alice", record.Version, "refused", &time.Time{}))
+	}
+	_, err = publisher.Publish(ctx)
+	assert.NilError(t, err)
+	assert.Equal(t, RecordState(ctx, store, "alice"), lnurl.DnsRecordFailed)
+
+	// Registering the offer again retries it
+	zone.err = nil
+	_, err = store.SetPubkeyDetails(ctx, "01", "alice", &offer, nil)
+	assert.NilError(t, err)
+	_, err = publisher.Publish(ctx)
+	assert.NilError(t, err)
+	assert.Equal(t, RecordState(ctx, store, "alice"), lnurl.DnsRecordPublished)
+}
+
+func TestPublishWithoutDns(t *testing.T) {
+	ctx := context.Background()
+	store := lnurl.NewMemoryStore()
+	publisher := NewPublisher(dns.NewNoDns(), store)
+
+	offer := "lno1alice"
+	_, err := store.SetPubkeyDetails(ctx, "01", "alice", &offer, nil)
+	assert.NilError(t, err)
+	_, err = publisher.Publish(ctx)
+	assert.NilError(t, err)
+	record, err := store.GetDnsRecord(ctx, "alice")
+	assert.NilError(t, err)
+	assert.Equal(t, record.State, lnurl.DnsRecordFailed)
+	assert.Equal(t, record.Attempts, 1)
+}
+
+func TestRetryDelay(t *testing.T) {
+	assert.Equal(t, retryDelay(1), OutboxRetryInterval)
+	assert.Equal(t, retryDelay(3), 4*OutboxRetryInterval)
+	assert.Equal(t, retryDelay(100), MaxOutboxRetryInterval)
+}
+
+func TestPublishToEmbeddedDns(t *testing.T) {
+	ctx := context.Background()
+	store := lnurl.NewMemoryStore()
+	externalURL, _ := url.Parse("https://example.com")
+	server := dns.NewEmbeddedDns(externalURL, store, dns.EmbeddedDnsConfig{Address: "127.0.0.1:0"})
+	assert.NilError(t, server.Start())
+	defer server.Shutdown()
+
+	offer := "lno1alice"
+	_, err := store.SetPubkeyDetails(ctx, "01", "alice", &offer, nil)
+	assert.NilError(t, err)
+	_, err = NewPublisher(server, store).Publish(ctx)
+	assert.NilError(t, err)
+	assert.Equal(t, RecordState(ctx, store, "alice"), lnurl.DnsRecordPublished)
+
+	m := new(miekg.Msg)
+	m.SetQuestion("alice.user._bitcoin-payment.example.com.", miekg.TypeTXT)
+	reply, err := miekg.Exchange(m, server.Addr())
+	assert.NilError(t, err)
+	assert.Equal(t, len(reply.Answer), 1)
+	assert.DeepEqual(t, reply.Answer[0].(*miekg.TXT).Txt, []string{dns.TxtRecord(offer, nil)})
+}
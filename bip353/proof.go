@@ -0,0 +1,126 @@
+package bip353
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/gorilla/mux"
+	miekg "github.com/miekg/dns"
+)
+
+// The file of the records authenticating the zone from the DNS root, in the
+// zone file format: the DNSKEY and DS RRsets of the parent zones down to the
+// DS RRset of the zone, with their RRSIGs. It is read again when modified, so
+// it can be refreshed before the signatures expire.
+var ProofChainFile string
+
+// ProofZone builds the part of the authentication chains in the zone.
+type ProofZone interface {
+	// Proof returns the signed DNSKEY and TXT RRsets of the username, nil
+	// when it has no offer
+	Proof(ctx context.Context, username string) ([]miekg.RR, error)
+}
+
+// proofChain caches the records of the chain file.
+type proofChain struct {
+	path    string
+	mu      sync.Mutex
+	modTime time.Time
+	records []miekg.RR
+}
+
+// load returns the records of the chain file, read again when modified.
+func (c *proofChain) load() ([]miekg.RR, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	info, err := os.Stat(c.path)
+	if err != nil {
+		return nil, err
+	}
+	if c.records != nil && info.ModTime().Equal(c.modTime) {
+		return c.records, nil
+	}
+	file, err := os.Open(c.path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	records, err := parseChain(file)
+	if err != nil {
+		return nil, fmt.Errorf("invalid proof chain file %v: %w", c.path, err)
+	}
+	c.records = records
+	c.modTime = info.ModTime()
+	return records, nil
+}
+
+func parseChain(r io.Reader) ([]miekg.RR, error) {
+	var records []miekg.RR
+	parser := miekg.NewZoneParser(r, ".", "")
+	for rr, ok := parser.Next(); ok; rr, ok = parser.Next() {
+		switch rr.Header().Rrtype {
+		case miekg.TypeDNSKEY, miekg.TypeDS, miekg.TypeRRSIG:
+			records = append(records, rr)
+		default:
+			return nil, fmt.Errorf("unexpected %v record", miekg.TypeToString[rr.Header().Rrtype])
+		}
+	}
+	if err := parser.Err(); err != nil {
+		return nil, err
+	}
+	return records, nil
+}
+
+// encodeProof serializes the records as an RFC 9102 authentication chain,
+// the uncompressed wire format records one after the other.
+func encodeProof(records []miekg.RR) ([]byte, error) {
+	var proof []byte
+	for _, rr := range records {
+		buf := make([]byte, miekg.Len(rr))
+		off, err := miekg.PackRR(rr, buf, 0, nil, false)
+		if err != nil {
+			return nil, err
+		}
+		proof = append(proof, buf[:off]...)
+	}
+	return proof, nil
+}
+
+/*
+Proof returns the RFC 9102 DNSSEC authentication chain of the BIP353 record of
+the username, to verify it offline
+*/
+func (s *Bip353Router) Proof(w http.ResponseWriter, r *http.Request) {
+	username := mux.Vars(r)["username"]
+	records, err := s.proofZone.Proof(r.Context(), username)
+	if err != nil {
+		log.Printf("failed to build the BIP353 proof of %v: %v", username, err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	if records == nil {
+		http.Error(w, "not found", http.StatusNotFound)
+		return
+	}
+	chain, err := s.chain.load()
+	if err != nil {
+		log.Printf("failed to load the BIP353 proof chain: %v", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	proof, err := encodeProof(slices.Concat(chain, records))
+	if err != nil {
+		log.Printf("failed to encode the BIP353 proof of %v: %v", username, err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	w.Header().Add("Content-Type", "application/octet-stream")
+	w.Write(proof)
+}
@@ -0,0 +1,162 @@
+package bip353
+
+import (
+	"context"
+	"crypto"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/breez/breez-lnurl/dns"
+	"github.com/breez/breez-lnurl/persist"
+	"github.com/gorilla/mux"
+	miekg "github.com/miekg/dns"
+	"gotest.tools/assert"
+)
+
+// signRRset signs the records with the key of the parent zone.
+func signRRset(t *testing.T, key *miekg.DNSKEY, private crypto.PrivateKey, rrset []miekg.RR) miekg.RR {
+	rrsig := &miekg.RRSIG{
+		Hdr:        miekg.RR_Header{Ttl: rrset[0].Header().Ttl},
+		Algorithm:  key.Algorithm,
+		KeyTag:     key.KeyTag(),
+		SignerName: key.Hdr.Name,
+		Inception:  uint32(time.Now().Add(-time.Hour).Unix()),
+		Expiration: uint32(time.Now().Add(time.Hour).Unix()),
+	}
+	assert.NilError(t, rrsig.Sign(private.(crypto.Signer), rrset))
+	return rrsig
+}
+
+// writeParentChain writes the chain of the parent zone example.com, which
+// is trusted, delegating to the BIP353 zone with its DS records.
+func writeParentChain(t *testing.T, ds []string) (*miekg.DNSKEY, string) {
+	parentKey := &miekg.DNSKEY{
+		Hdr:       miekg.RR_Header{Name: "example.com.", Rrtype: miekg.TypeDNSKEY, Class: miekg.ClassINET, Ttl: 3600},
+		Flags:     257,
+		Protocol:  3,
+		Algorithm: miekg.ECDSAP256SHA256,
+	}
+	private, err := parentKey.Generate(256)
+	assert.NilError(t, err)
+
+	var dsRRset []miekg.RR
+	for _, record := range ds {
+		rr, err := miekg.NewRR(record)
+		assert.NilError(t, err)
+		dsRRset = append(dsRRset, rr)
+	}
+	chain := []miekg.RR{parentKey, signRRset(t, parentKey, private, []miekg.RR{parentKey})}
+	chain = append(chain, dsRRset...)
+	chain = append(chain, signRRset(t, parentKey, private, dsRRset))
+
+	var lines []string
+	for _, rr := range chain {
+		lines = append(lines, rr.String())
+	}
+	path := filepath.Join(t.TempDir(), "chain.zone")
+	assert.NilError(t, os.WriteFile(path, []byte(strings.Join(lines, "\n")), 0600))
+	return parentKey, path
+}
+
+// validateProof validates the authentication chain from the trusted key down
+// to the TXT records of the name, and returns them.
+func validateProof(t *testing.T, proof []byte, anchor *miekg.DNSKEY, name string) []string {
+	type rrsetKey struct {
+		name   string
+		rrtype uint16
+	}
+	rrsets := make(map[rrsetKey][]miekg.RR)
+	rrsigs := make(map[rrsetKey][]*miekg.RRSIG)
+	for off := 0; off < len(proof); {
+		rr, next, err := miekg.UnpackRR(proof, off)
+		assert.NilError(t, err)
+		off = next
+		if rrsig, ok := rr.(*miekg.RRSIG); ok {
+			key := rrsetKey{miekg.CanonicalName(rrsig.Hdr.Name), rrsig.TypeCovered}
+			rrsigs[key] = append(rrsigs[key], rrsig)
+			continue
+		}
+		key := rrsetKey{miekg.CanonicalName(rr.Header().Name), rr.Header().Rrtype}
+		rrsets[key] = append(rrsets[key], rr)
+	}
+
+	trusted := map[string][]*miekg.DNSKEY{anchor.Hdr.Name: {anchor}}
+	verified := func(key rrsetKey) bool {
+		for _, rrsig := range rrsigs[key] {
+			for _, dnskey := range trusted[rrsig.SignerName] {
+				if rrsig.KeyTag == dnskey.KeyTag() && rrsig.Verify(dnskey, rrsets[key]) == nil && rrsig.ValidityPeriod(time.Now()) {
+					return true
+				}
+			}
+		}
+		return false
+	}
+	trust := func(zone string) {
+		for _, rr := range rrsets[rrsetKey{zone, miekg.TypeDNSKEY}] {
+			trusted[zone] = append(trusted[zone], rr.(*miekg.DNSKEY))
+		}
+	}
+
+	assert.Assert(t, verified(rrsetKey{"example.com.", miekg.TypeDNSKEY}))
+	trust("example.com.")
+	zone := "_bitcoin-payment.example.com."
+	assert.Assert(t, verified(rrsetKey{zone, miekg.TypeDS}))
+	// The zone's keys are trusted through the DS records of the parent zone
+	for _, rr := range rrsets[rrsetKey{zone, miekg.TypeDS}] {
+		ds := rr.(*miekg.DS)
+		for _, key := range rrsets[rrsetKey{zone, miekg.TypeDNSKEY}] {
+			dnskey := key.(*miekg.DNSKEY)
+			if zoneDs := dnskey.ToDS(ds.DigestType); zoneDs != nil && strings.EqualFold(zoneDs.Digest, ds.Digest) {
+				trusted[zone] = append(trusted[zone], dnskey)
+			}
+		}
+	}
+	assert.Assert(t, verified(rrsetKey{zone, miekg.TypeDNSKEY}))
+	trust(zone)
+	txtKey := rrsetKey{name, miekg.TypeTXT}
+	assert.Assert(t, verified(txtKey))
+	var txts []string
+	for _, rr := range rrsets[txtKey] {
+		txts = append(txts, strings.Join(rr.(*miekg.TXT).Txt, ""))
+	}
+	return txts
+}
+
+func TestProof(t *testing.T) {
+	store := persist.NewMemoryStore()
+	externalURL, _ := url.Parse("https://example.com")
+	server := dns.NewEmbeddedDns(externalURL, store.LnUrl, dns.EmbeddedDnsConfig{Address: "127.0.0.1:0", Keys: store.Dnssec})
+	assert.NilError(t, server.Start())
+	defer server.Shutdown()
+	offer := "lno1" + strings.Repeat("q", 300)
+	_, err := store.LnUrl.SetPubkeyDetails(context.Background(), "01", "alice", &offer, nil)
+	assert.NilError(t, err)
+
+	anchor, path := writeParentChain(t, server.Signer().DS())
+	chainFile := ProofChainFile
+	ProofChainFile = path
+	defer func() { ProofChainFile = chainFile }()
+	router := mux.NewRouter()
+	RegisterBip353Router(t.Context(), router, router.PathPrefix("/admin").Subrouter(), externalURL, store, server)
+	httpServer := httptest.NewServer(router)
+	defer httpServer.Close()
+
+	res, err := http.Get(httpServer.URL + "/bip353/alice/proof")
+	assert.NilError(t, err)
+	assert.Equal(t, res.StatusCode, http.StatusOK)
+	proof, err := io.ReadAll(res.Body)
+	assert.NilError(t, err)
+	txts := validateProof(t, proof, anchor, "alice.user._bitcoin-payment.example.com.")
+	assert.DeepEqual(t, txts, []string{dns.TxtRecord(offer, nil)})
+
+	res, err = http.Get(httpServer.URL + "/bip353/bob/proof")
+	assert.NilError(t, err)
+	assert.Equal(t, res.StatusCode, http.StatusNotFound)
+}
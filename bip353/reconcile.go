@@ -0,0 +1,295 @@
+package bip353
+
+import (
+	"context"
+	"log"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/breez/breez-lnurl/dns"
+	lnurl "github.com/breez/breez-lnurl/persist/lnurl"
+	nwc "github.com/breez/breez-lnurl/persist/nwc"
+)
+
+// The interval between reconciliations of the published records with the stored offers
+var ReconcileInterval time.Duration = time.Hour
+
+// The time to wait before trying to lead again after the leader lock failed
+var LeaderRetryInterval time.Duration = 5 * time.Second
+
+// DriftReport describes how the published records differed from the stored
+// offers, and what was repaired.
+type DriftReport struct {
+	StartedAt  time.Time `json:"startedAt"`
+	FinishedAt time.Time `json:"finishedAt"`
+	// Whether the zone was transferred. Otherwise the records of the stored
+	// usernames were queried one by one, and the records of unknown
+	// usernames could not be found.
+	Transferred bool `json:"transferred"`
+	// The number of stored offers
+	Offers int `json:"offers"`
+	// The usernames with an offer but no record
+	Missing []string `json:"missing"`
+	// The usernames whose record doesn't match their offer
+	Stale []string `json:"stale"`
+	// The usernames with a record but no offer
+	Orphaned []string `json:"orphaned"`
+	// The drifted usernames with a DNS update still queued in the outbox,
+	// left to the publisher
+	Pending []string `json:"pending"`
+	// The drifted usernames whose offer or DNS update changed while
+	// reconciling, left to the next reconciliation
+	Changed []string `json:"changed"`
+	// Whether the drift was repaired, rather than only reported
+	Repair   bool     `json:"repair"`
+	Repaired int      `json:"repaired"`
+	Failed   []string `json:"failed"`
+}
+
+// Reconciler compares the BIP353 records published in the zone with the
+// offers stored in pubkey_details, which are authoritative, and repairs the
+// missing, stale and orphaned records.
+type Reconciler struct {
+	service dns.DnsService
+	zone    dns.Zone
+	store   lnurl.Store
+	// Held while reconciling, so repairs aren't applied twice
+	running sync.Mutex
+	mu      sync.Mutex
+	last    *DriftReport
+}
+
+func NewReconciler(service dns.DnsService, zone dns.Zone, store lnurl.Store) *Reconciler {
+	return &Reconciler{
+		service: service,
+		zone:    zone,
+		store:   store,
+	}
+}
+
+// Start reconciles the records periodically until the context is done.
+func (r *Reconciler) Start(ctx context.Context) {
+	for {
+		if _, err := r.Reconcile(ctx, true); err != nil {
+			log.Printf("failed to reconcile BIP353 records: %v", err)
+		}
+		select {
+		case <-time.After(ReconcileInterval):
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// StartLeaderLoop reconciles the records periodically while this instance
+// holds the BIP353 leader lock. With several server instances, a single one
+// repairs the zone, and another takes over when it stops.
+func (r *Reconciler) StartLeaderLoop(ctx context.Context, store nwc.Store) {
+	for {
+		leaderCtx, err := store.Lead(ctx, nwc.Bip353LeaderLock)
+		if err != nil {
+			if ctx.Err() != nil {
+				return
+			}
+			log.Printf("failed to take the BIP353 leadership: %v", err)
+			select {
+			case <-time.After(LeaderRetryInterval):
+				continue
+			case <-ctx.Done():
+				return
+			}
+		}
+
+		log.Printf("leading the BIP353 reconciliation")
+		r.Start(leaderCtx)
+		if ctx.Err() != nil {
+			return
+		}
+		log.Printf("lost the BIP353 leadership")
+	}
+}
+
+// LastReport returns the report of the last reconciliation, nil before the first.
+func (r *Reconciler) LastReport() *DriftReport {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.last
+}
+
+// Reconcile compares the published records with the stored offers, and
+// repairs the drift unless only reporting it.
+func (r *Reconciler) Reconcile(ctx context.Context, repair bool) (*DriftReport, error) {
+	r.running.Lock()
+	defer r.running.Unlock()
+
+	report := &DriftReport{
+		StartedAt: time.Now(),
+		Missing:   []string{},
+		Stale:     []string{},
+		Orphaned:  []string{},
+		Pending:   []string{},
+		Changed:   []string{},
+		Repair:    repair,
+		Failed:    []string{},
+	}
+	details, err := r.store.ListPubkeyDetails(ctx)
+	if err != nil {
+		return nil, err
+	}
+	// The TXT record of each username with an offer
+	offers := make(map[string]string)
+	for _, detail := range details {
+		if detail.Offer != nil {
+			offers[detail.Username] = dns.TxtRecord(*detail.Offer, detail.Bip21Params)
+		}
+	}
+	report.Offers = len(offers)
+
+	records, err := r.zone.Records()
+	if err == nil {
+		report.Transferred = true
+	} else {
+		log.Printf("failed to transfer the BIP353 zone, querying the records one by one: %v", err)
+		if records, err = r.lookupRecords(ctx, details); err != nil {
+			return nil, err
+		}
+	}
+
+	for username, record := range offers {
+		published := records[username]
+		switch {
+		case len(published) == 0:
+			report.Missing = append(report.Missing, username)
+		case len(published) > 1 || published[0] != record:
+			report.Stale = append(report.Stale, username)
+		}
+	}
+	for username := range records {
+		if _, ok := offers[username]; !ok && len(records[username]) > 0 {
+			report.Orphaned = append(report.Orphaned, username)
+		}
+	}
+	slices.Sort(report.Missing)
+	slices.Sort(report.Stale)
+	slices.Sort(report.Orphaned)
+
+	queued := make(map[string]*lnurl.DnsRecord)
+	for _, username := range slices.Concat(report.Missing, report.Stale, report.Orphaned) {
+		record, err := r.store.GetDnsRecord(ctx, username)
+		if err != nil {
+			return nil, err
+		}
+		if record == nil {
+			continue
+		}
+		if record.State == lnurl.DnsRecordPending {
+			report.Pending = append(report.Pending, username)
+		}
+		queued[username] = record
+	}
+
+	if repair {
+		r.repair(ctx, report, offers, queued)
+	}
+	report.FinishedAt = time.Now()
+	if len(report.Missing)+len(report.Stale)+len(report.Orphaned) > 0 {
+		log.Printf("BIP353 records drift: missing:%v stale:%v orphaned:%v pending:%v repaired:%v failed:%v",
+			report.Missing, report.Stale, report.Orphaned, report.Pending, report.Repaired, report.Failed)
+	}
+	r.mu.Lock()
+	r.last = report
+	r.mu.Unlock()
+	return report, nil
+}
+
+// lookupRecords queries the records of the stored usernames, including
+// those whose offer was removed.
+func (r *Reconciler) lookupRecords(ctx context.Context, details []lnurl.PubkeyDetails) (map[string][]string, error) {
+	records := make(map[string][]string)
+	for _, detail := range details {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		published, err := r.zone.Lookup(detail.Username)
+		if err != nil {
+			return nil, err
+		}
+		if len(published) > 0 {
+			records[detail.Username] = published
+		}
+	}
+	return records, nil
+}
+
+func (r *Reconciler) repair(ctx context.Context, report *DriftReport, offers map[string]string, queued map[string]*lnurl.DnsRecord) {
+	// Stale records are removed first, in case the service adds to the existing records when setting
+	for _, username := range slices.Concat(report.Stale, report.Orphaned) {
+		if slices.Contains(report.Pending, username) || !r.unchanged(ctx, report, username, offers, queued) {
+			continue
+		}
+		if err := r.service.Remove(username); err != nil {
+			log.Printf("failed to remove DNS TXT record for %v: %v", username, err)
+			report.Failed = append(report.Failed, username)
+			continue
+		}
+		if _, ok := offers[username]; !ok {
+			r.repaired(ctx, report, queued[username])
+		}
+	}
+	for _, username := range slices.Concat(report.Missing, report.Stale) {
+		if slices.Contains(report.Failed, username) || slices.Contains(report.Pending, username) || slices.Contains(report.Changed, username) {
+			continue
+		}
+		// The stale records were checked before their removal
+		if !slices.Contains(report.Stale, username) && !r.unchanged(ctx, report, username, offers, queued) {
+			continue
+		}
+		if _, err := r.service.Set(username, offers[username]); err != nil {
+			log.Printf("failed to set DNS TXT record for %v: %v", username, err)
+			report.Failed = append(report.Failed, username)
+			continue
+		}
+		r.repaired(ctx, report, queued[username])
+	}
+}
+
+// unchanged returns whether the offer and the DNS update of the username are
+// still those read by the reconciliation. A username registered and published
+// since the offers were listed looks orphaned, and its record must be kept.
+func (r *Reconciler) unchanged(ctx context.Context, report *DriftReport, username string, offers map[string]string, queued map[string]*lnurl.DnsRecord) bool {
+	details, err := r.store.GetPubkeyDetails(ctx, username)
+	if err != nil {
+		log.Printf("failed to get the offer of %v: %v", username, err)
+		report.Failed = append(report.Failed, username)
+		return false
+	}
+	record, err := r.store.GetDnsRecord(ctx, username)
+	if err != nil {
+		log.Printf("failed to get the DNS update of %v: %v", username, err)
+		report.Failed = append(report.Failed, username)
+		return false
+	}
+
+	offer := ""
+	if details != nil && details.Offer != nil {
+		offer = dns.TxtRecord(*details.Offer, details.Bip21Params)
+	}
+	previous := queued[username]
+	if offer != offers[username] || (record == nil) != (previous == nil) || (record != nil && record.Version != previous.Version) {
+		report.Changed = append(report.Changed, username)
+		return false
+	}
+	return true
+}
+
+// repaired counts a repair, and marks the failed update of the record published.
+func (r *Reconciler) repaired(ctx context.Context, report *DriftReport, record *lnurl.DnsRecord) {
+	report.Repaired++
+	if record == nil || record.State != lnurl.DnsRecordFailed {
+		return
+	}
+	if err := r.store.MarkDnsRecordPublished(ctx, record.Username, record.Version); err != nil {
+		log.Printf("failed to mark DNS TXT record for %v published: %v", record.Username, err)
+	}
+}
@@ -0,0 +1,217 @@
+package bip353
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/breez/breez-lnurl/dns"
+	lnurl "github.com/breez/breez-lnurl/persist/lnurl"
+	nwc "github.com/breez/breez-lnurl/persist/nwc"
+	"gotest.tools/assert"
+)
+
+// fakeZone is a DnsService keeping its records in memory.
+type fakeZone struct {
+	records       map[string][]string
+	transferError error
+	// Called when the records are read
+	onRecords func()
+}
+
+func (z *fakeZone) Set(username, record string) (uint32, error) {
+	z.records[username] = append(z.records[username], record)
+	return 3600, nil
+}
+
+func (z *fakeZone) Remove(username string) error {
+	delete(z.records, username)
+	return nil
+}
+
+func (z *fakeZone) Records() (map[string][]string, error) {
+	if z.transferError != nil {
+		return nil, z.transferError
+	}
+	if z.onRecords != nil {
+		z.onRecords()
+	}
+	return z.records, nil
+}
+
+func (z *fakeZone) Lookup(username string) ([]string, error) {
+	return z.records[username], nil
+}
+
+func setupReconciler(t *testing.T) (*Reconciler, *fakeZone, *lnurl.MemoryStore) {
+	ctx := context.Background()
+	store := lnurl.NewMemoryStore()
+	for _, details := range []struct{ pubkey, username, offer string }{
+		{"01", "alice", "lno1alice"},
+		{"02", "bob", "lno1bob"},
+		{"03", "carol", "lno1carol"},
+		{"04", "dave", ""},
+	} {
+		var offer *string
+		if details.offer != "" {
+			offer = &details.offer
+		}
+		_, err := store.SetPubkeyDetails(ctx, details.pubkey, details.username, offer, nil)
+		assert.NilError(t, err)
+	}
+	// The queued updates failed to apply
+	records, err := store.ClaimDnsRecords(ctx, time.Now(), time.Now(), 10)
+	assert.NilError(t, err)
+	assert.Equal(t, len(records), 3)
+	for _, record := range records {
+		assert.NilError(t, store.MarkDnsRecordFailed(ctx, record.Username, record.Version, "refused", nil))
+	}
+
+	zone := &fakeZone{records: map[string][]string{
+		"alice":   {dns.TxtRecord("lno1alice", nil)},
+		"bob":     {dns.TxtRecord("lno1old", nil)},
+		"dave":    {dns.TxtRecord("lno1dave", nil)},
+		"mallory": {dns.TxtRecord("lno1mallory", nil)},
+	}}
+	return NewReconciler(zone, zone, store), zone, store
+}
+
+func TestReconcileRepairsDrift(t *testing.T) {
+	reconciler, zone, store := setupReconciler(t)
+
+	report, err := reconciler.Reconcile(context.Background(), false)
+	assert.NilError(t, err)
+	assert.Assert(t, report.Transferred)
+	assert.Equal(t, report.Offers, 3)
+	assert.DeepEqual(t, report.Missing, []string{"carol"})
+	assert.DeepEqual(t, report.Stale, []string{"bob"})
+	assert.DeepEqual(t, report.Orphaned, []string{"dave", "mallory"})
+	assert.Equal(t, report.Repaired, 0)
+	assert.Equal(t, len(zone.records), 4, "a dry run doesn't repair")
+
+	report, err = reconciler.Reconcile(context.Background(), true)
+	assert.NilError(t, err)
+	assert.Equal(t, report.Repaired, 4)
+	assert.DeepEqual(t, zone.records, map[string][]string{
+		"alice": {dns.TxtRecord("lno1alice", nil)},
+		"bob":   {dns.TxtRecord("lno1bob", nil)},
+		"carol": {dns.TxtRecord("lno1carol", nil)},
+	})
+	record, err := store.GetDnsRecord(context.Background(), "carol")
+	assert.NilError(t, err)
+	assert.Equal(t, record.State, lnurl.DnsRecordPublished)
+
+	report, err = reconciler.Reconcile(context.Background(), true)
+	assert.NilError(t, err)
+	assert.Equal(t, len(report.Missing)+len(report.Stale)+len(report.Orphaned), 0)
+	assert.Equal(t, reconciler.LastReport(), report)
+}
+
+func TestReconcileWithoutTransfer(t *testing.T) {
+	reconciler, zone, _ := setupReconciler(t)
+	zone.transferError = errors.New("refused")
+
+	report, err := reconciler.Reconcile(context.Background(), false)
+	assert.NilError(t, err)
+	assert.Assert(t, !report.Transferred)
+	assert.DeepEqual(t, report.Missing, []string{"carol"})
+	assert.DeepEqual(t, report.Stale, []string{"bob"})
+	// Only the stored usernames are queried
+	assert.DeepEqual(t, report.Orphaned, []string{"dave"})
+}
+
+func TestReconcileSkipsPendingUpdates(t *testing.T) {
+	reconciler, zone, store := setupReconciler(t)
+	offer := "lno1carol2"
+	_, err := store.SetPubkeyDetails(context.Background(), "03", "carol", &offer, nil)
+	assert.NilError(t, err)
+
+	report, err := reconciler.Reconcile(context.Background(), true)
+	assert.NilError(t, err)
+	assert.DeepEqual(t, report.Pending, []string{"carol"})
+	assert.Equal(t, report.Repaired, 3)
+	_, ok := zone.records["carol"]
+	assert.Assert(t, !ok, "the publisher applies the pending update")
+}
+
+func TestReconcileKeepsRecordsPublishedMeanwhile(t *testing.T) {
+	reconciler, zone, store := setupReconciler(t)
+	ctx := context.Background()
+
+	// A username is registered and published after the offers were listed
+	zone.onRecords = func() {
+		zone.onRecords = nil
+		offer := "lno1erin"
+		_, err := store.SetPubkeyDetails(ctx, "05", "erin", &offer, nil)
+		assert.NilError(t, err)
+		records, err := store.ClaimDnsRecords(ctx, time.Now(), time.Now(), 10)
+		assert.NilError(t, err)
+		for _, record := range records {
+			if record.Username == "erin" {
+				zone.records["erin"] = []string{dns.TxtRecord(offer, nil)}
+				assert.NilError(t, store.MarkDnsRecordPublished(ctx, record.Username, record.Version))
+			}
+		}
+	}
+
+	report, err := reconciler.Reconcile(ctx, true)
+	assert.NilError(t, err)
+	assert.DeepEqual(t, report.Orphaned, []string{"dave", "erin", "mallory"})
+	assert.DeepEqual(t, report.Changed, []string{"erin"})
+	assert.Equal(t, report.Repaired, 4)
+	assert.DeepEqual(t, zone.records["erin"], []string{dns.TxtRecord("lno1erin", nil)})
+	_, ok := zone.records["mallory"]
+	assert.Assert(t, !ok, "the unchanged orphaned records are still removed")
+
+	report, err = reconciler.Reconcile(ctx, true)
+	assert.NilError(t, err)
+	assert.Equal(t, len(report.Missing)+len(report.Stale)+len(report.Orphaned), 0)
+}
+
+// sharedLeaderStore leads one instance at a time, like the Postgres advisory lock.
+type sharedLeaderStore struct {
+	*nwc.MemoryStore
+	lock chan struct{}
+}
+
+func (s *sharedLeaderStore) Lead(ctx context.Context, lock nwc.LeaderLock) (context.Context, error) {
+	select {
+	case s.lock <- struct{}{}:
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+	go func() {
+		<-ctx.Done()
+		<-s.lock
+	}()
+	return ctx, nil
+}
+
+func waitForReport(t *testing.T, reconciler *Reconciler) {
+	deadline := time.Now().Add(5 * time.Second)
+	for reconciler.LastReport() == nil {
+		if time.Now().After(deadline) {
+			t.Fatal("no reconciliation")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestReconcileOnLeaderOnly(t *testing.T) {
+	leader := &sharedLeaderStore{MemoryStore: nwc.NewMemoryStore(), lock: make(chan struct{}, 1)}
+	first, _, _ := setupReconciler(t)
+	second, _, _ := setupReconciler(t)
+
+	ctx, cancel := context.WithCancel(t.Context())
+	defer cancel()
+	go first.StartLeaderLoop(ctx, leader)
+	waitForReport(t, first)
+	go second.StartLeaderLoop(t.Context(), leader)
+	time.Sleep(50 * time.Millisecond)
+	assert.Assert(t, second.LastReport() == nil)
+
+	// The second instance takes over when the first one stops
+	cancel()
+	waitForReport(t, second)
+}
@@ -0,0 +1,134 @@
+package bip353
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"slices"
+	"strings"
+
+	miekg "github.com/miekg/dns"
+)
+
+// The source of the resolved records, the stored offers rather than the public DNS
+const ResolveSource = "pubkey_details"
+
+// The content type of the DNS messages over HTTPS
+const dnsMessageType = "application/dns-message"
+
+// Resolver answers the DNS queries of the zone from the stored offers.
+type Resolver interface {
+	// Answer returns the response to the query
+	Answer(ctx context.Context, r *miekg.Msg) *miekg.Msg
+}
+
+// ResolveResponse is the BIP353 record of an address, resolved from the
+// stored offers.
+type ResolveResponse struct {
+	Address string `json:"address"`
+	// The name of the TXT record
+	Name string `json:"name"`
+	// The BIP21 URI of the TXT record
+	Uri string `json:"uri"`
+	// The TTL of the record in seconds
+	Ttl uint32 `json:"ttl"`
+	// Where the record came from, the stored offers
+	Source string `json:"source"`
+	// The state of the record in the public DNS: pending, published or failed
+	State string `json:"state"`
+}
+
+/*
+DnsQuery answers the RFC 8484 DNS-over-HTTPS queries of the zone from the
+stored offers, with the dns parameter of GET requests or the body of POST
+requests
+*/
+func (s *Bip353Router) DnsQuery(w http.ResponseWriter, r *http.Request) {
+	var query []byte
+	var err error
+	if r.Method == http.MethodGet {
+		query, err = base64.RawURLEncoding.DecodeString(r.URL.Query().Get("dns"))
+	} else {
+		if r.Header.Get("Content-Type") != dnsMessageType {
+			http.Error(w, "unsupported content type", http.StatusUnsupportedMediaType)
+			return
+		}
+		query, err = io.ReadAll(io.LimitReader(r.Body, miekg.MaxMsgSize))
+	}
+	req := new(miekg.Msg)
+	if err != nil || len(query) == 0 || req.Unpack(query) != nil {
+		http.Error(w, "invalid dns query", http.StatusBadRequest)
+		return
+	}
+
+	answer := s.resolver.Answer(r.Context(), req)
+	reply, err := answer.Pack()
+	if err != nil {
+		log.Printf("failed to pack the DNS response to %v: %v", req.Question, err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", dnsMessageType)
+	w.Header().Set("X-BIP353-Source", ResolveSource)
+	if ttl, ok := minTtl(answer); ok {
+		w.Header().Set("Cache-Control", fmt.Sprintf("max-age=%v", ttl))
+	}
+	w.Write(reply)
+}
+
+/*
+Resolve returns the BIP353 record of the address user@domain in the zone,
+from the stored offers, even before it propagated in the public DNS
+*/
+func (s *Bip353Router) Resolve(w http.ResponseWriter, r *http.Request) {
+	address := strings.TrimPrefix(r.URL.Query().Get("address"), "₿")
+	username, domain, ok := strings.Cut(address, "@")
+	if !ok || username == "" || !strings.EqualFold(domain, s.domain) {
+		http.Error(w, "invalid address", http.StatusBadRequest)
+		return
+	}
+	name := miekg.Fqdn(fmt.Sprintf("%s.user._bitcoin-payment.%s", strings.ToLower(username), s.domain))
+	if _, ok := miekg.IsDomainName(name); !ok {
+		http.Error(w, "invalid address", http.StatusBadRequest)
+		return
+	}
+
+	req := new(miekg.Msg)
+	req.SetQuestion(name, miekg.TypeTXT)
+	reply := s.resolver.Answer(r.Context(), req)
+	if reply.Rcode == miekg.RcodeServerFailure {
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	for _, rr := range reply.Answer {
+		if txt, ok := rr.(*miekg.TXT); ok {
+			writeJson(w, ResolveResponse{
+				Address: address,
+				Name:    name,
+				Uri:     strings.Join(txt.Txt, ""),
+				Ttl:     txt.Hdr.Ttl,
+				Source:  ResolveSource,
+				State:   RecordState(r.Context(), s.store, strings.ToLower(username)),
+			})
+			return
+		}
+	}
+	http.Error(w, "not found", http.StatusNotFound)
+}
+
+// minTtl returns the lowest TTL of the records of the response, which is
+// how long it can be cached.
+func minTtl(m *miekg.Msg) (uint32, bool) {
+	var ttl uint32
+	found := false
+	for _, rr := range slices.Concat(m.Answer, m.Ns) {
+		if !found || rr.Header().Ttl < ttl {
+			ttl = rr.Header().Ttl
+			found = true
+		}
+	}
+	return ttl, found
+}
@@ -0,0 +1,160 @@
+package bip353
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/breez/breez-lnurl/dns"
+	"github.com/breez/breez-lnurl/persist"
+	lnurl "github.com/breez/breez-lnurl/persist/lnurl"
+	"github.com/gorilla/mux"
+	miekg "github.com/miekg/dns"
+	"gotest.tools/assert"
+)
+
+func setupResolver(t *testing.T, dnsService dns.DnsService, store *persist.Store) *httptest.Server {
+	return setupResolverAt(t, "https://example.com", dnsService, store)
+}
+
+func setupResolverAt(t *testing.T, rootURL string, dnsService dns.DnsService, store *persist.Store) *httptest.Server {
+	externalURL, _ := url.Parse(rootURL)
+	router := mux.NewRouter()
+	RegisterBip353Router(t.Context(), router, router.PathPrefix("/admin").Subrouter(), externalURL, store, dnsService)
+	httpServer := httptest.NewServer(router)
+	t.Cleanup(httpServer.Close)
+	return httpServer
+}
+
+func dohQuery(t *testing.T, httpServer *httptest.Server, post bool, name string, dnssecOk bool) (*http.Response, *miekg.Msg) {
+	req := new(miekg.Msg)
+	req.SetQuestion(name, miekg.TypeTXT)
+	req.Id = 0
+	if dnssecOk {
+		req.SetEdns0(4096, true)
+	}
+	query, err := req.Pack()
+	assert.NilError(t, err)
+	var res *http.Response
+	if post {
+		res, err = http.Post(httpServer.URL+"/dns-query", "application/dns-message", bytes.NewReader(query))
+	} else {
+		res, err = http.Get(httpServer.URL + "/dns-query?dns=" + base64.RawURLEncoding.EncodeToString(query))
+	}
+	assert.NilError(t, err)
+	assert.Equal(t, res.StatusCode, http.StatusOK)
+	assert.Equal(t, res.Header.Get("Content-Type"), "application/dns-message")
+	body, err := io.ReadAll(res.Body)
+	assert.NilError(t, err)
+	reply := new(miekg.Msg)
+	assert.NilError(t, reply.Unpack(body))
+	return res, reply
+}
+
+func TestDnsOverHttps(t *testing.T) {
+	store := persist.NewMemoryStore()
+	httpServer := setupResolver(t, dns.NewNoDns(), store)
+	offer := "lno1alice"
+	_, err := store.LnUrl.SetPubkeyDetails(context.Background(), "01", "alice", &offer, nil)
+	assert.NilError(t, err)
+
+	for _, post := range []bool{false, true} {
+		res, reply := dohQuery(t, httpServer, post, "alice.user._bitcoin-payment.example.com.", false)
+		assert.Equal(t, reply.Rcode, miekg.RcodeSuccess)
+		assert.Equal(t, len(reply.Answer), 1)
+		assert.DeepEqual(t, reply.Answer[0].(*miekg.TXT).Txt, []string{dns.TxtRecord(offer, nil)})
+		assert.Equal(t, res.Header.Get("X-BIP353-Source"), ResolveSource)
+		assert.Equal(t, res.Header.Get("Cache-Control"), "max-age=3600")
+	}
+
+	// Negative answers are cached for the negative TTL
+	res, reply := dohQuery(t, httpServer, false, "bob.user._bitcoin-payment.example.com.", false)
+	assert.Equal(t, len(reply.Answer), 0)
+	assert.Equal(t, res.Header.Get("Cache-Control"), "max-age=300")
+	_, reply = dohQuery(t, httpServer, false, "alice.user._bitcoin-payment.example.org.", false)
+	assert.Equal(t, reply.Rcode, miekg.RcodeRefused)
+
+	res, err = http.Get(httpServer.URL + "/dns-query?dns=invalid")
+	assert.NilError(t, err)
+	assert.Equal(t, res.StatusCode, http.StatusBadRequest)
+	res, err = http.Post(httpServer.URL+"/dns-query", "text/plain", bytes.NewReader([]byte("query")))
+	assert.NilError(t, err)
+	assert.Equal(t, res.StatusCode, http.StatusUnsupportedMediaType)
+}
+
+func TestDnsOverHttpsSigned(t *testing.T) {
+	store := persist.NewMemoryStore()
+	externalURL, _ := url.Parse("https://example.com")
+	server := dns.NewEmbeddedDns(externalURL, store.LnUrl, dns.EmbeddedDnsConfig{Address: "127.0.0.1:0", Keys: store.Dnssec})
+	assert.NilError(t, server.Start())
+	defer server.Shutdown()
+	httpServer := setupResolver(t, server, store)
+	offer := "lno1alice"
+	_, err := store.LnUrl.SetPubkeyDetails(context.Background(), "01", "alice", &offer, nil)
+	assert.NilError(t, err)
+
+	_, reply := dohQuery(t, httpServer, true, "alice.user._bitcoin-payment.example.com.", true)
+	assert.Equal(t, len(reply.Answer), 2)
+	assert.Equal(t, reply.Answer[1].Header().Rrtype, miekg.TypeRRSIG)
+}
+
+func TestResolve(t *testing.T) {
+	ctx := context.Background()
+	store := persist.NewMemoryStore()
+	httpServer := setupResolver(t, dns.NewNoDns(), store)
+	offer := "lno1alice"
+	params := &lnurl.PaymentParams{Address: "bc1qalice"}
+	_, err := store.LnUrl.SetPubkeyDetails(ctx, "01", "alice", &offer, params)
+	assert.NilError(t, err)
+
+	res, err := http.Get(httpServer.URL + "/bip353/resolve?address=" + url.QueryEscape("₿Alice@example.com"))
+	assert.NilError(t, err)
+	assert.Equal(t, res.StatusCode, http.StatusOK)
+	var resolved ResolveResponse
+	assert.NilError(t, json.NewDecoder(res.Body).Decode(&resolved))
+	assert.DeepEqual(t, resolved, ResolveResponse{
+		Address: "Alice@example.com",
+		Name:    "alice.user._bitcoin-payment.example.com.",
+		Uri:     dns.TxtRecord(offer, params),
+		Ttl:     dns.RecordTTL,
+		Source:  ResolveSource,
+		// Not published in the public DNS yet
+		State: lnurl.DnsRecordPending,
+	})
+
+	for address, status := range map[string]int{
+		"bob@example.com":   http.StatusNotFound,
+		"alice@example.org": http.StatusBadRequest,
+		"alice":             http.StatusBadRequest,
+		"@example.com":      http.StatusBadRequest,
+	} {
+		res, err := http.Get(httpServer.URL + "/bip353/resolve?address=" + url.QueryEscape(address))
+		assert.NilError(t, err)
+		assert.Equal(t, res.StatusCode, status, address)
+	}
+}
+
+func TestResolveWithPort(t *testing.T) {
+	store := persist.NewMemoryStore()
+	httpServer := setupResolverAt(t, "https://example.com:8443", dns.NewNoDns(), store)
+	offer := "lno1alice"
+	_, err := store.LnUrl.SetPubkeyDetails(context.Background(), "01", "alice", &offer, nil)
+	assert.NilError(t, err)
+
+	// The addresses and record names don't include the port
+	res, err := http.Get(httpServer.URL + "/bip353/resolve?address=" + url.QueryEscape("alice@example.com"))
+	assert.NilError(t, err)
+	assert.Equal(t, res.StatusCode, http.StatusOK)
+	var resolved ResolveResponse
+	assert.NilError(t, json.NewDecoder(res.Body).Decode(&resolved))
+	assert.Equal(t, resolved.Name, "alice.user._bitcoin-payment.example.com.")
+
+	_, reply := dohQuery(t, httpServer, false, "alice.user._bitcoin-payment.example.com.", false)
+	assert.Equal(t, len(reply.Answer), 1)
+}
@@ -0,0 +1,160 @@
+package bip353
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+
+	"github.com/breez/breez-lnurl/dns"
+	"github.com/breez/breez-lnurl/persist"
+	lnurl "github.com/breez/breez-lnurl/persist/lnurl"
+	"github.com/gorilla/mux"
+)
+
+type Bip353Router struct {
+	domain     string
+	store      lnurl.Store
+	resolver   Resolver
+	reconciler *Reconciler
+	signer     *dns.Signer
+	proofZone  ProofZone
+	chain      *proofChain
+}
+
+// RegisterBip353Router resolves the records from the stored offers, over
+// DNS-over-HTTPS and JSON. It reconciles the published records with the
+// stored offers, when the DNS service can read its zone back, and manages the
+// DNSSEC keys and serves the proofs of the records when the DNS service signs
+// the zone. The reconciliation stops when the context is done.
+func RegisterBip353Router(ctx context.Context, router *mux.Router, adminRouter *mux.Router, rootURL *url.URL, store *persist.Store, dnsService dns.DnsService) {
+	bip353Router := &Bip353Router{
+		domain: rootURL.Hostname(),
+		store:  store.LnUrl,
+	}
+	// The embedded name server answers with its DNSSEC signatures, otherwise
+	// the answers are built the same way, unsigned
+	if resolver, ok := dnsService.(Resolver); ok {
+		bip353Router.resolver = resolver
+	} else {
+		bip353Router.resolver = dns.NewEmbeddedDns(rootURL, store.LnUrl, dns.EmbeddedDnsConfig{})
+	}
+	router.HandleFunc("/dns-query", bip353Router.DnsQuery).Methods("GET", "POST")
+	router.HandleFunc("/bip353/resolve", bip353Router.Resolve).Methods("GET")
+
+	if zone, ok := dnsService.(dns.Zone); ok {
+		bip353Router.reconciler = NewReconciler(dnsService, zone, store.LnUrl)
+		go bip353Router.reconciler.StartLeaderLoop(ctx, store.Nwc)
+
+		adminRouter.HandleFunc("/bip353/reconcile", bip353Router.LastReport).Methods("GET")
+		adminRouter.HandleFunc("/bip353/reconcile", bip353Router.Reconcile).Methods("POST")
+	}
+
+	if signed, ok := dnsService.(interface{ Signer() *dns.Signer }); ok && signed.Signer() != nil {
+		bip353Router.signer = signed.Signer()
+
+		adminRouter.HandleFunc("/bip353/dnssec/keys", bip353Router.ListKeys).Methods("GET")
+		adminRouter.HandleFunc("/bip353/dnssec/ds", bip353Router.DS).Methods("GET")
+		adminRouter.HandleFunc("/bip353/dnssec/rollover", bip353Router.Rollover).Methods("POST")
+		adminRouter.HandleFunc("/bip353/dnssec/keys/{id}/retire", bip353Router.RetireKey).Methods("POST")
+
+		if proofZone, ok := dnsService.(ProofZone); ok && ProofChainFile != "" {
+			bip353Router.proofZone = proofZone
+			bip353Router.chain = &proofChain{path: ProofChainFile}
+			if _, err := bip353Router.chain.load(); err != nil {
+				log.Printf("failed to load the BIP353 proof chain: %v", err)
+			}
+			router.HandleFunc("/bip353/{username}/proof", bip353Router.Proof).Methods("GET")
+		}
+	}
+}
+
+/*
+LastReport returns the drift found by the last reconciliation
+*/
+func (s *Bip353Router) LastReport(w http.ResponseWriter, r *http.Request) {
+	report := s.reconciler.LastReport()
+	if report == nil {
+		http.Error(w, "no reconciliation yet", http.StatusNotFound)
+		return
+	}
+	writeJson(w, report)
+}
+
+/*
+Reconcile compares the published records with the stored offers now, only
+reporting the drift when dryRun is set
+*/
+func (s *Bip353Router) Reconcile(w http.ResponseWriter, r *http.Request) {
+	repair := r.URL.Query().Get("dryRun") != "true"
+	report, err := s.reconciler.Reconcile(r.Context(), repair)
+	if err != nil {
+		log.Printf("failed to reconcile BIP353 records: %v", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	writeJson(w, report)
+}
+
+/*
+ListKeys returns the DNSSEC keys of the zone, without their private keys
+*/
+func (s *Bip353Router) ListKeys(w http.ResponseWriter, r *http.Request) {
+	writeJson(w, s.signer.Keys())
+}
+
+/*
+DS returns the DS records of the active key signing keys, for the parent zone
+*/
+func (s *Bip353Router) DS(w http.ResponseWriter, r *http.Request) {
+	writeJson(w, s.signer.DS())
+}
+
+/*
+Rollover starts replacing the zone signing key, or the key signing key when
+type is ksk
+*/
+func (s *Bip353Router) Rollover(w http.ResponseWriter, r *http.Request) {
+	keyType := r.URL.Query().Get("type")
+	if keyType != "ksk" && keyType != "zsk" {
+		http.Error(w, "invalid type", http.StatusBadRequest)
+		return
+	}
+	key, err := s.signer.Rollover(r.Context(), keyType == "ksk")
+	if err != nil {
+		log.Printf("failed to roll the DNSSEC %v over: %v", keyType, err)
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	}
+	writeJson(w, key)
+}
+
+/*
+RetireKey retires an active key signing key, after the parent zone's DS
+record was updated to another one
+*/
+func (s *Bip353Router) RetireKey(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.ParseInt(mux.Vars(r)["id"], 10, 64)
+	if err != nil {
+		http.Error(w, "invalid id", http.StatusBadRequest)
+		return
+	}
+	if err := s.signer.Retire(r.Context(), id); err != nil {
+		log.Printf("failed to retire the DNSSEC key %v: %v", id, err)
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	}
+	writeJson(w, s.signer.Keys())
+}
+
+func writeJson(w http.ResponseWriter, value any) {
+	body, err := json.Marshal(value)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	w.Header().Add("Content-Type", "application/json")
+	w.Write(body)
+}
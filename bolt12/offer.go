@@ -13,8 +13,8 @@ import (
 
 	"log"
 
+	"github.com/breez/breez-lnurl/bip353"
 	"github.com/breez/breez-lnurl/constant"
-	"github.com/breez/breez-lnurl/dns"
 	"github.com/breez/breez-lnurl/persist"
 	lnurl "github.com/breez/breez-lnurl/persist/lnurl"
 	"github.com/breez/lspd/lightning"
@@ -30,6 +30,8 @@ type RegisterBolt12OfferRequest struct {
 
 type RegisterRecoverBolt12OfferResponse struct {
 	BIP353Address string `json:"bip353_address"`
+	// The state of the BIP353 DNS TXT record: pending, published or failed
+	BIP353State string `json:"bip353_state,omitempty"`
 }
 
 func (w *RegisterBolt12OfferRequest) Verify(pubkey string) error {
@@ -77,14 +79,12 @@ func (w *UnregisterRecoverBolt12OfferRequest) Verify(pubkey string) error {
 
 type Bolt12OfferRouter struct {
 	store   *persist.Store
-	dns     dns.DnsService
 	rootURL *url.URL
 }
 
-func RegisterBolt12OfferRouter(router *mux.Router, rootURL *url.URL, store *persist.Store, dns dns.DnsService) {
+func RegisterBolt12OfferRouter(router *mux.Router, rootURL *url.URL, store *persist.Store) {
 	Bolt12OfferRouter := &Bolt12OfferRouter{
 		store:   store,
-		dns:     dns,
 		rootURL: rootURL,
 	}
 	router.HandleFunc("/bolt12offer/{pubkey}", Bolt12OfferRouter.Register).Methods("POST")
@@ -122,9 +122,13 @@ func (s *Bolt12OfferRouter) Recover(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 	bip353Address := fmt.Sprintf("%v@%v", lastPkUsername.Username, s.rootURL.Host)
-	body, err := json.Marshal(RegisterRecoverBolt12OfferResponse{
+	response := RegisterRecoverBolt12OfferResponse{
 		BIP353Address: bip353Address,
-	})
+	}
+	if lastPkUsername.Offer != nil {
+		response.BIP353State = bip353.RecordState(r.Context(), s.store.LnUrl, lastPkUsername.Username)
+	}
+	body, err := json.Marshal(response)
 	if err != nil {
 		w.WriteHeader(http.StatusInternalServerError)
 		return
@@ -158,9 +162,8 @@ func (s *Bolt12OfferRouter) Register(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Get the last pubkey username for the pubkey to use it to check if the offer has changed
-	lastPkUsername, _ := s.store.LnUrl.GetPubkeyDetails(r.Context(), pubkey)
-	updatedPkUsername, err := s.store.LnUrl.SetPubkeyDetails(r.Context(), pubkey, addRequest.Username, &addRequest.Offer)
+	// The BIP353 DNS TXT records are updated from the outbox the store queues the changes to
+	updatedPkUsername, err := s.store.LnUrl.SetPubkeyDetails(r.Context(), pubkey, addRequest.Username, &addRequest.Offer, nil)
 
 	if err != nil {
 		if serr, ok := err.(*lnurl.ErrorUsernameConflict); ok {
@@ -178,43 +181,11 @@ func (s *Bolt12OfferRouter) Register(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Update the BIP353 DNS TXT records
-	if updatedPkUsername.Offer != nil {
-		shouldSetOffer := lastPkUsername == nil || lastPkUsername.Offer == nil
-		username := updatedPkUsername.Username
-		offer := *updatedPkUsername.Offer
-
-		if lastPkUsername != nil && lastPkUsername.Offer != nil {
-			// If the last webhook exists, we need to check if the username or offer has changed
-			lastUsername := lastPkUsername.Username
-			lastOffer := *lastPkUsername.Offer
-			shouldSetOffer = username != lastUsername || offer != lastOffer
-
-			if username != lastUsername {
-				if err = s.dns.Remove(lastUsername); err != nil {
-					log.Printf("failed to remove DNS TXT record for %v: %v", lastUsername, err)
-				}
-			}
-		}
-
-		if shouldSetOffer {
-			ttl, err := s.dns.Set(username, offer)
-			if err != nil {
-				log.Printf("failed to set DNS TXT record for %v, %v: %v", username, offer, err)
-			}
-			// Only set the offer if the DNS service returns a TTL
-			maybeOffer := &offer
-			if ttl == 0 {
-				maybeOffer = nil
-			}
-			s.store.LnUrl.SetPubkeyDetails(r.Context(), pubkey, username, maybeOffer)
-		}
-	}
-
 	log.Printf("registration added: pubkey:%v\n", pubkey)
 	bip353Address := fmt.Sprintf("%v@%v", updatedPkUsername.Username, s.rootURL.Host)
 	body, err := json.Marshal(RegisterRecoverBolt12OfferResponse{
 		BIP353Address: bip353Address,
+		BIP353State:   bip353.RecordState(r.Context(), s.store.LnUrl, updatedPkUsername.Username),
 	})
 	if err != nil {
 		w.WriteHeader(http.StatusInternalServerError)
@@ -255,13 +226,11 @@ func (s *Bolt12OfferRouter) Unregister(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Remove the DNS TXT record for this username/offer
+	// Remove the offer, queueing the removal of its DNS TXT record
 	if pkUsername.Offer != nil {
-		username := pkUsername.Username
-		if err = s.dns.Remove(username); err != nil {
-			log.Printf("failed to remove DNS TXT record for %v: %v", username, err)
+		if _, err = s.store.LnUrl.SetPubkeyDetails(r.Context(), pubkey, pkUsername.Username, nil, nil); err != nil {
+			log.Printf("failed to remove the offer of pubkey %v: %v", pubkey, err)
 		}
-		s.store.LnUrl.SetPubkeyDetails(r.Context(), pubkey, username, nil)
 	}
 
 	log.Printf("registration removed: pubkey:%v offer: %v\n", pubkey, removeRequest.Offer)
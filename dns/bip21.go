@@ -0,0 +1,124 @@
+package dns
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+
+	lnurl "github.com/breez/breez-lnurl/persist/lnurl"
+	"github.com/btcsuite/btcd/btcutil"
+	"github.com/btcsuite/btcd/btcutil/base58"
+	"github.com/btcsuite/btcd/btcutil/bech32"
+	"github.com/btcsuite/btcd/chaincfg"
+)
+
+// The maximum length of a BIP353 TXT record, split in strings of 255
+// characters, so the answer with its signatures fits in a TCP response
+var MaxTxtRecordLength = 4096
+
+// The networks of the on-chain fallback addresses
+var addressNetworks = []*chaincfg.Params{
+	&chaincfg.MainNetParams,
+	&chaincfg.TestNet3Params,
+	&chaincfg.SigNetParams,
+	&chaincfg.RegressionNetParams,
+}
+
+// The human-readable parts of the BIP352 silent payment addresses
+var silentPaymentHrps = []string{"sp", "tsp"}
+
+// The human-readable parts of the confidential Liquid addresses, encoded with
+// blech32, and of the unconfidential ones, encoded with bech32
+var (
+	liquidBlech32Hrps = []string{"lq", "tlq", "el"}
+	liquidBech32Hrps  = []string{"ex", "tex", "ert"}
+	// The versions of the legacy Liquid addresses, P2PKH, P2SH and
+	// confidential on the main, test and regtest networks
+	liquidBase58Versions = []byte{57, 39, 12, 36, 19, 23, 235, 75, 4}
+)
+
+// TxtRecord returns the BIP353 TXT record of the offer: a BIP21 URI with the
+// on-chain fallback address as its path, then the offer, the silent payment
+// and the Liquid addresses, always in this order.
+func TxtRecord(offer string, params *lnurl.PaymentParams) string {
+	if params == nil {
+		params = &lnurl.PaymentParams{}
+	}
+	uri := fmt.Sprintf("bitcoin:%s?lno=%s", params.Address, offer)
+	if params.SilentPayment != "" {
+		uri += "&sp=" + params.SilentPayment
+	}
+	if params.Liquid != "" {
+		uri += "&liquid=" + params.Liquid
+	}
+	return uri
+}
+
+// ValidatePaymentParams checks the BIP21 parameters are valid addresses, and
+// the record published with the offer isn't too long.
+func ValidatePaymentParams(offer string, params *lnurl.PaymentParams) error {
+	if params == nil {
+		return nil
+	}
+	if params.Address != "" && !validAddress(params.Address) {
+		return fmt.Errorf("invalid address %v", params.Address)
+	}
+	if params.SilentPayment != "" && !validSilentPayment(params.SilentPayment) {
+		return fmt.Errorf("invalid silent payment address %v", params.SilentPayment)
+	}
+	if params.Liquid != "" && !validLiquid(params.Liquid) {
+		return fmt.Errorf("invalid liquid address %v", params.Liquid)
+	}
+	if len(TxtRecord(offer, params)) > MaxTxtRecordLength {
+		return errors.New("record too long")
+	}
+	return nil
+}
+
+func validAddress(address string) bool {
+	for _, params := range addressNetworks {
+		if _, err := btcutil.DecodeAddress(address, params); err == nil {
+			return true
+		}
+	}
+	return false
+}
+
+func validSilentPayment(address string) bool {
+	hrp, data, err := bech32.DecodeNoLimit(address)
+	if err != nil || !slices.Contains(silentPaymentHrps, hrp) || len(data) == 0 || data[0] != 0 {
+		return false
+	}
+	// Silent payment addresses are encoded with bech32m
+	if encoded, err := bech32.EncodeM(hrp, data); err != nil || encoded != strings.ToLower(address) {
+		return false
+	}
+	keys, err := bech32.ConvertBits(data[1:], 5, 8, false)
+	// The scan and spend public keys
+	return err == nil && len(keys) == 66
+}
+
+func validLiquid(address string) bool {
+	if _, version, err := base58.CheckDecode(address); err == nil {
+		return slices.Contains(liquidBase58Versions, version)
+	}
+	if hrp, _, err := bech32.DecodeNoLimit(address); err == nil {
+		return slices.Contains(liquidBech32Hrps, hrp)
+	}
+	// The blech32 checksum isn't verified, only the charset
+	lower := strings.ToLower(address)
+	if lower != address && strings.ToUpper(address) != address {
+		return false
+	}
+	one := strings.LastIndexByte(lower, '1')
+	if one < 1 || !slices.Contains(liquidBlech32Hrps, lower[:one]) || len(lower)-one-1 < 6 {
+		return false
+	}
+	for _, c := range lower[one+1:] {
+		if !strings.ContainsRune("qpzry9x8gf2tvdw0s3jn54khce6mua7l", c) {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,65 @@
+package dns
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	lnurl "github.com/breez/breez-lnurl/persist/lnurl"
+	"github.com/btcsuite/btcd/btcutil/bech32"
+	"gotest.tools/assert"
+)
+
+// encodeAddress encodes the payload as a versioned bech32 or bech32m address.
+func encodeAddress(t *testing.T, hrp string, payload []byte, bech32m bool) string {
+	data, err := bech32.ConvertBits(payload, 8, 5, true)
+	assert.NilError(t, err)
+	data = append([]byte{0}, data...)
+	encode := bech32.Encode
+	if bech32m {
+		encode = bech32.EncodeM
+	}
+	address, err := encode(hrp, data)
+	assert.NilError(t, err)
+	return address
+}
+
+func TestTxtRecord(t *testing.T) {
+	assert.Equal(t, TxtRecord("lno1alice", nil), "bitcoin:?lno=lno1alice")
+	params := &lnurl.PaymentParams{
+		Address:       "bc1qalice",
+		SilentPayment: "sp1alice",
+		Liquid:        "lq1alice",
+	}
+	assert.Equal(t, TxtRecord("lno1alice", params), "bitcoin:bc1qalice?lno=lno1alice&sp=sp1alice&liquid=lq1alice")
+	assert.Equal(t, TxtRecord("lno1alice", &lnurl.PaymentParams{Liquid: "lq1alice"}), "bitcoin:?lno=lno1alice&liquid=lq1alice")
+}
+
+func TestValidatePaymentParams(t *testing.T) {
+	keys := bytes.Repeat([]byte{2}, 66)
+	for _, test := range []struct {
+		params lnurl.PaymentParams
+		err    string
+	}{
+		{lnurl.PaymentParams{Address: "bc1qw508d6qejxtdg4y5r3zarvary0c5xw7kv8f3t4"}, ""},
+		{lnurl.PaymentParams{Address: "tb1qw508d6qejxtdg4y5r3zarvary0c5xw7kxpjzsx"}, ""},
+		{lnurl.PaymentParams{Address: "1BvBMSEYstWetqTFn5Au4m4GFg7xJaNVN2"}, ""},
+		{lnurl.PaymentParams{Address: "bc1qw508d6qejxtdg4y5r3zarvary0c5xw7kv8f3t5"}, "invalid address"},
+		{lnurl.PaymentParams{SilentPayment: encodeAddress(t, "sp", keys, true)}, ""},
+		{lnurl.PaymentParams{SilentPayment: encodeAddress(t, "tsp", keys, true)}, ""},
+		{lnurl.PaymentParams{SilentPayment: encodeAddress(t, "sp", keys, false)}, "invalid silent payment address"},
+		{lnurl.PaymentParams{SilentPayment: encodeAddress(t, "sp", keys[:33], true)}, "invalid silent payment address"},
+		{lnurl.PaymentParams{Liquid: encodeAddress(t, "ex", keys[:20], false)}, ""},
+		{lnurl.PaymentParams{Liquid: "lq1qqvxk052kf3qtkxmrakx50a9gc3smqad2ync54hzntjt980kfej9kkfe0247rp5h4yzmdftsahhw64uy8pzfe7cpg4fgykm7cv"}, ""},
+		{lnurl.PaymentParams{Liquid: "lq1notbech32"}, "invalid liquid address"},
+		{lnurl.PaymentParams{Liquid: "bc1qw508d6qejxtdg4y5r3zarvary0c5xw7kv8f3t4"}, "invalid liquid address"},
+		{lnurl.PaymentParams{Address: "bc1qw508d6qejxtdg4y5r3zarvary0c5xw7kv8f3t4", Liquid: "lq1" + strings.Repeat("q", MaxTxtRecordLength)}, "too long"},
+	} {
+		err := ValidatePaymentParams("lno1alice", &test.params)
+		if test.err == "" {
+			assert.NilError(t, err, "%+v", test.params)
+		} else {
+			assert.ErrorContains(t, err, test.err)
+		}
+	}
+}
@@ -1,28 +1,43 @@
 package dns
 
 import (
+	"errors"
 	"fmt"
 	"log"
 	"net/url"
+	"slices"
+	"strings"
+	"sync"
 	"time"
 
 	"github.com/miekg/dns"
 )
 
 type DnsService interface {
-	Set(username, offer string) (uint32, error)
+	// Set replaces the TXT record of the username, returning its TTL
+	Set(username, record string) (uint32, error)
 	Remove(username string) error
 }
 
+// Zone reads the published BIP353 records back, so they can be reconciled
+// with the stored offers.
+type Zone interface {
+	// Records returns the TXT records of each username in the zone, read
+	// with a zone transfer
+	Records() (map[string][]string, error)
+	// Lookup returns the TXT records of the username, none when missing
+	Lookup(username string) ([]string, error)
+}
+
 func NewNoDns() DnsService {
 	return &NoDns{}
 }
 
 type NoDns struct{}
 
-func (n *NoDns) Set(username, offer string) (uint32, error) {
+func (n *NoDns) Set(username, record string) (uint32, error) {
 	// No DNS implementation, do nothing
-	log.Printf("No DNS implementation, not setting username: %s, offer: %s", username, offer)
+	log.Printf("No DNS implementation, not setting username: %s, record: %s", username, record)
 	return 0, nil
 }
 
@@ -32,27 +47,72 @@ func (n *NoDns) Remove(username string) error {
 	return nil
 }
 
-func NewDns(externalURL *url.URL, nameServer, protocol, tsigKey, tsigSecret string) *Dns {
-	dnsTimeout := 60 * time.Second
+// The time a request to a name server waits for its reply, before trying the next one
+var DnsTimeout = 5 * time.Second
+
+// The time a name server is tried after the others once it failed
+var NameServerRetryInterval = 30 * time.Second
+
+// The TSIG algorithms updates can be authenticated with
+var tsigAlgorithms = []string{dns.HmacSHA1, dns.HmacSHA224, dns.HmacSHA256, dns.HmacSHA384, dns.HmacSHA512}
+
+type DnsConfig struct {
+	// The name servers to send the updates to, tried in order
+	NameServers []string
+	// One of "tcp", "tcp-tls" or "udp"
+	Protocol   string
+	TsigKey    string
+	TsigSecret string
+	// The TSIG algorithm, e.g. "hmac-sha256" (optional, "hmac-sha256" by default)
+	TsigAlgorithm string
+}
+
+func NewDns(externalURL *url.URL, config DnsConfig) (*Dns, error) {
+	if len(config.NameServers) == 0 {
+		return nil, errors.New("no name server")
+	}
+	algorithm := dns.HmacSHA256
+	if config.TsigAlgorithm != "" {
+		algorithm = dns.Fqdn(strings.ToLower(config.TsigAlgorithm))
+		if !slices.Contains(tsigAlgorithms, algorithm) {
+			return nil, fmt.Errorf("unsupported TSIG algorithm %v", config.TsigAlgorithm)
+		}
+	}
+	tsigKey := dns.Fqdn(config.TsigKey)
+	// The client is shared by the concurrent requests, and never modified
 	client := &dns.Client{
-		Timeout: dnsTimeout,
-		Net:     protocol,
+		Timeout:    DnsTimeout,
+		Net:        config.Protocol,
+		TsigSecret: map[string]string{tsigKey: config.TsigSecret},
 	}
-	return &Dns{
-		domain:     externalURL.Host,
-		nameServer: nameServer,
-		tsigKey:    tsigKey,
-		tsigSecret: tsigSecret,
-		client:     client,
+	var nameServers []*nameServer
+	for _, address := range config.NameServers {
+		nameServers = append(nameServers, &nameServer{address: strings.TrimSpace(address)})
 	}
+	return &Dns{
+		domain:        externalURL.Host,
+		tsigKey:       tsigKey,
+		tsigAlgorithm: algorithm,
+		client:        client,
+		nameServers:   nameServers,
+	}, nil
 }
 
 type Dns struct {
-	domain     string
-	nameServer string
-	tsigKey    string
-	tsigSecret string
-	client     *dns.Client
+	domain        string
+	tsigKey       string
+	tsigAlgorithm string
+	client        *dns.Client
+
+	mu          sync.Mutex
+	nameServers []*nameServer
+}
+
+// nameServer is a name server the requests are sent to, tried after the
+// others for a while when it fails.
+type nameServer struct {
+	address   string
+	downUntil time.Time
 }
 
 func chunks(s string, chunkSize int) []string {
@@ -77,41 +137,106 @@ func chunks(s string, chunkSize int) []string {
 	return chunks
 }
 
-func (d *Dns) Set(username, offer string) (uint32, error) {
+// servers returns the name servers in the order to try them: the healthy ones
+// in the configured order, then the failed ones, the least recently failed first.
+func (d *Dns) servers() []string {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	now := time.Now()
+	var healthy, down []*nameServer
+	for _, server := range d.nameServers {
+		if server.downUntil.After(now) {
+			down = append(down, server)
+		} else {
+			healthy = append(healthy, server)
+		}
+	}
+	slices.SortStableFunc(down, func(a, b *nameServer) int { return a.downUntil.Compare(b.downUntil) })
+	var addresses []string
+	for _, server := range slices.Concat(healthy, down) {
+		addresses = append(addresses, server.address)
+	}
+	return addresses
+}
+
+// setHealth records whether the name server replied.
+func (d *Dns) setHealth(address string, healthy bool) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	for _, server := range d.nameServers {
+		if server.address != address {
+			continue
+		}
+		if healthy {
+			server.downUntil = time.Time{}
+		} else {
+			server.downUntil = time.Now().Add(NameServerRetryInterval)
+		}
+	}
+}
+
+// exchange sends the message to the name servers until one replies. A name
+// server failing the request is marked down, and the next one is tried.
+func (d *Dns) exchange(m *dns.Msg) (*dns.Msg, error) {
+	var errs []error
+	for _, address := range d.servers() {
+		reply, _, err := d.client.Exchange(m, address)
+		if err == nil && reply.Rcode == dns.RcodeServerFailure {
+			err = fmt.Errorf("server replied: %s", dns.RcodeToString[reply.Rcode])
+		}
+		if err != nil {
+			log.Printf("name server %v failed: %v", address, err)
+			d.setHealth(address, false)
+			errs = append(errs, fmt.Errorf("%v: %w", address, err))
+			continue
+		}
+		d.setHealth(address, true)
+		return reply, nil
+	}
+	return nil, errors.Join(errs...)
+}
+
+// update sends the signed update to the name servers.
+func (d *Dns) update(m *dns.Msg) (*dns.Msg, error) {
+	m.SetTsig(d.tsigKey, d.tsigAlgorithm, 300, time.Now().Unix())
+	reply, err := d.exchange(m)
+	if err != nil {
+		return nil, err
+	}
+	if reply.Rcode != dns.RcodeSuccess {
+		return nil, fmt.Errorf("server replied: %s", dns.RcodeToString[reply.Rcode])
+	}
+	return reply, nil
+}
+
+func (d *Dns) Set(username, record string) (uint32, error) {
 	ttl := uint32(3600)
-	zone := fmt.Sprintf("_bitcoin-payment.%s.", d.domain)
+	zone := d.zone()
 	name := fmt.Sprintf("%s.user.%s", username, zone)
-	txt := fmt.Sprintf("bitcoin:?lno=%s", offer)
 
 	rr := new(dns.TXT)
 	rr.Hdr = dns.RR_Header{Name: name, Rrtype: dns.TypeTXT, Class: dns.ClassINET, Ttl: ttl}
-	rr.Txt = chunks(txt, 255)
+	rr.Txt = chunks(record, 255)
 	rrs := []dns.RR{rr}
 
 	m := new(dns.Msg)
 	m.SetUpdate(zone)
+	// Replace the existing records, so applying an update again is harmless
+	m.RemoveRRset([]dns.RR{&dns.TXT{Hdr: dns.RR_Header{Name: name, Rrtype: dns.TypeTXT, Class: dns.ClassINET}}})
 	m.Insert(rrs)
 
-	z := dns.Fqdn(d.tsigKey)
-	m.SetTsig(z, dns.HmacSHA256, 300, time.Now().Unix())
-	d.client.TsigSecret = map[string]string{z: d.tsigSecret}
-	reply, _, err := d.client.Exchange(m, d.nameServer)
+	reply, err := d.update(m)
 	if err != nil {
 		log.Printf("DNS update failed: %v", err)
 		return 0, err
 	}
-	if reply != nil && reply.Rcode != dns.RcodeSuccess {
-		err := fmt.Errorf("server replied: %s", dns.RcodeToString[reply.Rcode])
-		log.Printf("DNS update failed: %v", err)
-		return 0, err
-	}
 	log.Printf("DNS update success (Set): %#v", reply)
 
 	return ttl, nil
 }
 
 func (d *Dns) Remove(username string) error {
-	zone := fmt.Sprintf("_bitcoin-payment.%s.", d.domain)
+	zone := d.zone()
 	name := fmt.Sprintf("%s.user.%s", username, zone)
 
 	rr := new(dns.TXT)
@@ -122,20 +247,92 @@ func (d *Dns) Remove(username string) error {
 	m.SetUpdate(zone)
 	m.RemoveName(rrs)
 
-	z := dns.Fqdn(d.tsigKey)
-	m.SetTsig(z, dns.HmacSHA256, 300, time.Now().Unix())
-	d.client.TsigSecret = map[string]string{z: d.tsigSecret}
-	reply, _, err := d.client.Exchange(m, d.nameServer)
+	reply, err := d.update(m)
 	if err != nil {
 		log.Printf("DNS update failed: %v", err)
 		return err
 	}
-	if reply != nil && reply.Rcode != dns.RcodeSuccess {
-		err := fmt.Errorf("server replied: %s", dns.RcodeToString[reply.Rcode])
-		log.Printf("DNS update failed: %v", err)
-		return err
-	}
 	log.Printf("DNS update success (Remove): %#v", reply)
 
 	return nil
 }
+
+func (d *Dns) zone() string {
+	return fmt.Sprintf("_bitcoin-payment.%s.", d.domain)
+}
+
+func (d *Dns) Records() (map[string][]string, error) {
+	var errs []error
+	for _, address := range d.servers() {
+		records, err := d.transfer(address)
+		if err != nil {
+			log.Printf("name server %v failed: %v", address, err)
+			d.setHealth(address, false)
+			errs = append(errs, fmt.Errorf("%v: %w", address, err))
+			continue
+		}
+		d.setHealth(address, true)
+		return records, nil
+	}
+	return nil, errors.Join(errs...)
+}
+
+// transfer reads the records of the zone from the name server.
+func (d *Dns) transfer(address string) (map[string][]string, error) {
+	zone := d.zone()
+	m := new(dns.Msg)
+	m.SetAxfr(zone)
+	m.SetTsig(d.tsigKey, d.tsigAlgorithm, 300, time.Now().Unix())
+
+	t := &dns.Transfer{
+		DialTimeout: d.client.Timeout,
+		ReadTimeout: d.client.Timeout,
+		TsigSecret:  d.client.TsigSecret,
+	}
+	envelopes, err := t.In(m, address)
+	if err != nil {
+		return nil, fmt.Errorf("zone transfer failed: %w", err)
+	}
+
+	records := make(map[string][]string)
+	for envelope := range envelopes {
+		if envelope.Error != nil {
+			return nil, fmt.Errorf("zone transfer failed: %w", envelope.Error)
+		}
+		for _, rr := range envelope.RR {
+			txt, ok := rr.(*dns.TXT)
+			if !ok {
+				continue
+			}
+			if username, ok := recordUsername(zone, txt.Hdr.Name); ok {
+				records[username] = append(records[username], strings.Join(txt.Txt, ""))
+			}
+		}
+	}
+	return records, nil
+}
+
+func (d *Dns) Lookup(username string) ([]string, error) {
+	name := fmt.Sprintf("%s.user.%s", username, d.zone())
+	m := new(dns.Msg)
+	m.SetQuestion(name, dns.TypeTXT)
+	m.RecursionDesired = false
+
+	reply, err := d.exchange(m)
+	if err != nil {
+		return nil, err
+	}
+	if reply.Rcode == dns.RcodeNameError {
+		return nil, nil
+	}
+	if reply.Rcode != dns.RcodeSuccess {
+		return nil, fmt.Errorf("server replied: %s", dns.RcodeToString[reply.Rcode])
+	}
+	var records []string
+	for _, rr := range reply.Answer {
+		if txt, ok := rr.(*dns.TXT); ok {
+			records = append(records, strings.Join(txt.Txt, ""))
+		}
+	}
+	return records, nil
+}
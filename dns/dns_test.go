@@ -0,0 +1,116 @@
+package dns
+
+import (
+	"encoding/base64"
+	"net"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/miekg/dns"
+	"gotest.tools/assert"
+)
+
+var testTsigSecret = base64.StdEncoding.EncodeToString([]byte("0123456789abcdef0123456789abcdef"))
+
+// fakeNameServer accepts the TSIG signed updates of the zone over TCP.
+type fakeNameServer struct {
+	mu      sync.Mutex
+	updates int
+	server  *dns.Server
+}
+
+func newFakeNameServer(t *testing.T, algorithm string) *fakeNameServer {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NilError(t, err)
+	fake := &fakeNameServer{}
+	fake.server = &dns.Server{
+		Listener:   listener,
+		TsigSecret: map[string]string{"bip353.": testTsigSecret},
+		// Updates are rejected by default
+		MsgAcceptFunc: func(dh dns.Header) dns.MsgAcceptAction { return dns.MsgAccept },
+		Handler: dns.HandlerFunc(func(w dns.ResponseWriter, r *dns.Msg) {
+			m := new(dns.Msg)
+			m.SetReply(r)
+			tsig := r.IsTsig()
+			if tsig == nil || tsig.Algorithm != algorithm || w.TsigStatus() != nil {
+				m.Rcode = dns.RcodeNotAuth
+			} else {
+				fake.mu.Lock()
+				fake.updates++
+				fake.mu.Unlock()
+				m.SetTsig(tsig.Hdr.Name, tsig.Algorithm, 300, int64(tsig.TimeSigned))
+			}
+			w.WriteMsg(m)
+		}),
+	}
+	go fake.server.ActivateAndServe()
+	t.Cleanup(func() { fake.server.Shutdown() })
+	return fake
+}
+
+// closedAddress returns an address nothing listens on.
+func closedAddress(t *testing.T) string {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NilError(t, err)
+	address := listener.Addr().String()
+	listener.Close()
+	return address
+}
+
+func newTestDns(t *testing.T, nameServers []string, algorithm string) *Dns {
+	externalURL, _ := url.Parse("https://example.com")
+	service, err := NewDns(externalURL, DnsConfig{
+		NameServers:   nameServers,
+		Protocol:      "tcp",
+		TsigKey:       "bip353",
+		TsigSecret:    testTsigSecret,
+		TsigAlgorithm: algorithm,
+	})
+	assert.NilError(t, err)
+	return service
+}
+
+func TestDnsTsigAlgorithm(t *testing.T) {
+	fake := newFakeNameServer(t, dns.HmacSHA512)
+	address := fake.server.Listener.Addr().String()
+
+	_, err := newTestDns(t, []string{address}, "HMAC-SHA512").Set("alice", TxtRecord("lno1alice", nil))
+	assert.NilError(t, err)
+	_, err = newTestDns(t, []string{address}, "").Set("alice", TxtRecord("lno1alice", nil))
+	assert.ErrorContains(t, err, "NOTAUTH")
+
+	externalURL, _ := url.Parse("https://example.com")
+	_, err = NewDns(externalURL, DnsConfig{NameServers: []string{address}, TsigAlgorithm: "hmac-md5"})
+	assert.ErrorContains(t, err, "unsupported TSIG algorithm")
+}
+
+func TestDnsFailover(t *testing.T) {
+	fake := newFakeNameServer(t, dns.HmacSHA256)
+	down := closedAddress(t)
+	address := fake.server.Listener.Addr().String()
+	service := newTestDns(t, []string{down, address}, "")
+	assert.DeepEqual(t, service.servers(), []string{down, address})
+
+	_, err := service.Set("alice", TxtRecord("lno1alice", nil))
+	assert.NilError(t, err)
+	// The failed name server is tried last
+	assert.DeepEqual(t, service.servers(), []string{address, down})
+
+	// The shared client is safe to use concurrently
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, err := service.Set("alice", TxtRecord("lno1alice", nil))
+			assert.NilError(t, err)
+		}()
+	}
+	wg.Wait()
+	assert.Equal(t, fake.updates, 11)
+
+	_, err = newTestDns(t, []string{down}, "").Set("alice", TxtRecord("lno1alice", nil))
+	assert.ErrorContains(t, err, strings.Split(down, ":")[0])
+}
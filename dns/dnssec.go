@@ -0,0 +1,395 @@
+package dns
+
+import (
+	"context"
+	"crypto"
+	"errors"
+	"fmt"
+	"log"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	dnssec "github.com/breez/breez-lnurl/persist/dnssec"
+	"github.com/miekg/dns"
+)
+
+// The validity of the signatures made when answering
+var SignatureValidity time.Duration = 7 * 24 * time.Hour
+
+// The time between the steps of a key rollover, longer than the TTLs of the
+// DNSKEY records and of the signatures in the caches
+var KeyPropagationDelay time.Duration = 2 * time.Hour
+
+// The interval the keys are reloaded from the store at, picking up the
+// rollovers started by other replicas
+var keyRefreshInterval = time.Minute
+
+// The NXNAME meta type, marking names that don't exist in compact denial of
+// existence (RFC 9824)
+const typeNXNAME = 128
+
+const (
+	kskFlags = 257
+	zskFlags = 256
+)
+
+// KeyInfo describes a DNSSEC key, without its private key.
+type KeyInfo struct {
+	dnssec.Key
+	KeyTag uint16 `json:"keyTag"`
+	// ksk for a key signing key, zsk for a zone signing key
+	Type string `json:"type"`
+}
+
+type signingKey struct {
+	key     dnssec.Key
+	dnskey  *dns.DNSKEY
+	private crypto.Signer
+}
+
+// Signer signs the answers of the embedded zone online, with keys generated
+// and stored on first use. The key signing keys sign the DNSKEY RRset, and
+// the zone signing key the other RRsets.
+type Signer struct {
+	zone  string
+	store dnssec.Store
+
+	mu   sync.RWMutex
+	keys []signingKey
+}
+
+func NewSigner(zone string, store dnssec.Store) *Signer {
+	return &Signer{
+		zone:  dns.Fqdn(zone),
+		store: store,
+	}
+}
+
+// Init generates the keys of the zone when it has none, and loads them.
+func (s *Signer) Init(ctx context.Context) error {
+	var initial []dnssec.Key
+	for _, flags := range []int{kskFlags, zskFlags} {
+		key, err := s.generate(flags, dnssec.KeyActive)
+		if err != nil {
+			return err
+		}
+		initial = append(initial, *key)
+	}
+	keys, err := s.store.InitKeys(ctx, s.zone, initial)
+	if err != nil {
+		return err
+	}
+	return s.setKeys(keys)
+}
+
+// Start advances the rollovers and reloads the keys periodically until the
+// context is done.
+func (s *Signer) Start(ctx context.Context) {
+	for {
+		select {
+		case <-time.After(keyRefreshInterval):
+		case <-ctx.Done():
+			return
+		}
+		if err := s.advance(ctx); err != nil {
+			log.Printf("failed to advance the DNSSEC key rollovers: %v", err)
+		}
+		if err := s.load(ctx); err != nil {
+			log.Printf("failed to load the DNSSEC keys: %v", err)
+		}
+	}
+}
+
+func (s *Signer) generate(flags int, state string) (*dnssec.Key, error) {
+	dnskey := &dns.DNSKEY{
+		Hdr:       dns.RR_Header{Name: s.zone, Rrtype: dns.TypeDNSKEY, Class: dns.ClassINET, Ttl: RecordTTL},
+		Flags:     uint16(flags),
+		Protocol:  3,
+		Algorithm: dns.ECDSAP256SHA256,
+	}
+	private, err := dnskey.Generate(256)
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate a DNSSEC key: %w", err)
+	}
+	return &dnssec.Key{
+		Zone:       s.zone,
+		Flags:      flags,
+		PublicKey:  dnskey.String(),
+		PrivateKey: dnskey.PrivateKeyString(private),
+		State:      state,
+	}, nil
+}
+
+func (s *Signer) load(ctx context.Context) error {
+	keys, err := s.store.ListKeys(ctx, s.zone)
+	if err != nil {
+		return err
+	}
+	return s.setKeys(keys)
+}
+
+func (s *Signer) setKeys(keys []dnssec.Key) error {
+	var signingKeys []signingKey
+	for _, key := range keys {
+		rr, err := dns.NewRR(key.PublicKey)
+		if err != nil {
+			return fmt.Errorf("invalid DNSSEC key %v: %w", key.Id, err)
+		}
+		dnskey, ok := rr.(*dns.DNSKEY)
+		if !ok {
+			return fmt.Errorf("invalid DNSSEC key %v: not a DNSKEY", key.Id)
+		}
+		private, err := dnskey.NewPrivateKey(key.PrivateKey)
+		if err != nil {
+			return fmt.Errorf("invalid DNSSEC private key %v: %w", key.Id, err)
+		}
+		signer, ok := private.(crypto.Signer)
+		if !ok {
+			return fmt.Errorf("invalid DNSSEC private key %v: can't sign", key.Id)
+		}
+		signingKeys = append(signingKeys, signingKey{key: key, dnskey: dnskey, private: signer})
+	}
+	if !slices.ContainsFunc(signingKeys, func(k signingKey) bool { return k.signs(kskFlags) }) ||
+		!slices.ContainsFunc(signingKeys, func(k signingKey) bool { return k.signs(zskFlags) }) {
+		return errors.New("the zone has no active key signing or zone signing key")
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.keys = signingKeys
+	return nil
+}
+
+func (k signingKey) signs(flags int) bool {
+	return k.key.Flags == flags && k.key.State == dnssec.KeyActive
+}
+
+// Rollover starts replacing the active key signing or zone signing key. A
+// new zone signing key is published first, and replaces the active one after
+// KeyPropagationDelay. A new key signing key signs along with the active
+// one right away, until the active one is retired once the parent zone's DS
+// record is updated.
+func (s *Signer) Rollover(ctx context.Context, ksk bool) (*KeyInfo, error) {
+	flags, state := zskFlags, dnssec.KeyPublished
+	if ksk {
+		flags, state = kskFlags, dnssec.KeyActive
+	}
+	activeKsks := 0
+	for _, key := range s.snapshot() {
+		if key.key.Flags == zskFlags && !ksk && key.key.State == dnssec.KeyPublished {
+			return nil, errors.New("a zone signing key rollover is in progress")
+		}
+		if key.signs(kskFlags) {
+			activeKsks++
+		}
+	}
+	if ksk && activeKsks > 1 {
+		return nil, errors.New("a key signing key rollover is in progress")
+	}
+	key, err := s.generate(flags, state)
+	if err != nil {
+		return nil, err
+	}
+	added, err := s.store.AddKey(ctx, *key)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.load(ctx); err != nil {
+		return nil, err
+	}
+	for _, info := range s.Keys() {
+		if info.Id == added.Id {
+			return &info, nil
+		}
+	}
+	return nil, fmt.Errorf("key %v not found", added.Id)
+}
+
+// Retire retires an active key signing key, once the parent zone's DS record
+// points to another active one. It is removed after KeyPropagationDelay.
+func (s *Signer) Retire(ctx context.Context, id int64) error {
+	keys := s.snapshot()
+	index := slices.IndexFunc(keys, func(k signingKey) bool { return k.key.Id == id })
+	if index == -1 {
+		return fmt.Errorf("key %v not found", id)
+	}
+	if !keys[index].signs(kskFlags) {
+		return fmt.Errorf("key %v isn't an active key signing key", id)
+	}
+	if !slices.ContainsFunc(keys, func(k signingKey) bool { return k.key.Id != id && k.signs(kskFlags) }) {
+		return fmt.Errorf("key %v is the only active key signing key", id)
+	}
+	if _, err := s.store.SetKeyState(ctx, id, dnssec.KeyActive, dnssec.KeyRetired); err != nil {
+		return err
+	}
+	return s.load(ctx)
+}
+
+// advance activates the published zone signing keys, retiring the ones they
+// replace, and removes the retired keys, each after KeyPropagationDelay.
+func (s *Signer) advance(ctx context.Context) error {
+	due := time.Now().Add(-KeyPropagationDelay)
+	keys := s.snapshot()
+	for _, key := range keys {
+		if key.key.UpdatedAt.After(due) {
+			continue
+		}
+		switch {
+		case key.key.Flags == zskFlags && key.key.State == dnssec.KeyPublished:
+			activated, err := s.store.SetKeyState(ctx, key.key.Id, dnssec.KeyPublished, dnssec.KeyActive)
+			if err != nil {
+				return err
+			}
+			if !activated {
+				continue
+			}
+			for _, replaced := range keys {
+				if replaced.signs(zskFlags) {
+					if _, err := s.store.SetKeyState(ctx, replaced.key.Id, dnssec.KeyActive, dnssec.KeyRetired); err != nil {
+						return err
+					}
+				}
+			}
+			log.Printf("activated the DNSSEC zone signing key %v", key.dnskey.KeyTag())
+		case key.key.State == dnssec.KeyRetired:
+			if err := s.store.DeleteKey(ctx, key.key.Id); err != nil {
+				return err
+			}
+			log.Printf("removed the retired DNSSEC key %v", key.dnskey.KeyTag())
+		}
+	}
+	return nil
+}
+
+func (s *Signer) snapshot() []signingKey {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.keys
+}
+
+// Keys returns the keys of the zone.
+func (s *Signer) Keys() []KeyInfo {
+	var infos []KeyInfo
+	for _, key := range s.snapshot() {
+		info := KeyInfo{Key: key.key, KeyTag: key.dnskey.KeyTag(), Type: "zsk"}
+		if key.key.Flags == kskFlags {
+			info.Type = "ksk"
+		}
+		infos = append(infos, info)
+	}
+	return infos
+}
+
+// DS returns the DS records of the active key signing keys, for the parent zone.
+func (s *Signer) DS() []string {
+	var records []string
+	for _, key := range s.snapshot() {
+		if key.signs(kskFlags) {
+			records = append(records, key.dnskey.ToDS(dns.SHA256).String())
+		}
+	}
+	return records
+}
+
+// dnskeys returns the DNSKEY RRset of the zone, including the published
+// and retired keys.
+func (s *Signer) dnskeys(name string) []dns.RR {
+	var records []dns.RR
+	for _, key := range s.snapshot() {
+		dnskey := *key.dnskey
+		dnskey.Hdr.Name = name
+		dnskey.Hdr.Ttl = RecordTTL
+		records = append(records, &dnskey)
+	}
+	return records
+}
+
+// sign returns the RRSIG records of the RRsets in the records.
+func (s *Signer) sign(records []dns.RR) ([]dns.RR, error) {
+	type rrsetKey struct {
+		name   string
+		rrtype uint16
+	}
+	var keys []rrsetKey
+	rrsets := make(map[rrsetKey][]dns.RR)
+	for _, rr := range records {
+		if rr.Header().Rrtype == dns.TypeRRSIG {
+			continue
+		}
+		key := rrsetKey{dns.CanonicalName(rr.Header().Name), rr.Header().Rrtype}
+		if _, ok := rrsets[key]; !ok {
+			keys = append(keys, key)
+		}
+		rrsets[key] = append(rrsets[key], rr)
+	}
+
+	now := time.Now()
+	var signatures []dns.RR
+	for _, key := range keys {
+		flags := zskFlags
+		if key.rrtype == dns.TypeDNSKEY {
+			flags = kskFlags
+		}
+		for _, signing := range s.snapshot() {
+			if !signing.signs(flags) {
+				continue
+			}
+			rrsig := &dns.RRSIG{
+				Hdr:        dns.RR_Header{Ttl: rrsets[key][0].Header().Ttl},
+				Algorithm:  signing.dnskey.Algorithm,
+				KeyTag:     signing.dnskey.KeyTag(),
+				SignerName: s.zone,
+				// Allowing for clock skew
+				Inception:  uint32(now.Add(-time.Hour).Unix()),
+				Expiration: uint32(now.Add(SignatureValidity).Unix()),
+			}
+			if err := rrsig.Sign(signing.private, rrsets[key]); err != nil {
+				return nil, fmt.Errorf("failed to sign %v %v: %w", key.name, dns.TypeToString[key.rrtype], err)
+			}
+			signatures = append(signatures, rrsig)
+		}
+	}
+	return signatures, nil
+}
+
+// nsec returns the NSEC record denying the types missing at the name, with
+// compact denial of existence (RFC 9824): the next name is the immediate
+// successor of the name, so the zone can't be walked and no NSEC3 is needed.
+func nsec(name string, types []uint16) dns.RR {
+	types = append(types, dns.TypeRRSIG, dns.TypeNSEC)
+	slices.Sort(types)
+	return &dns.NSEC{
+		Hdr:        dns.RR_Header{Name: name, Rrtype: dns.TypeNSEC, Class: dns.ClassINET, Ttl: NegativeTTL},
+		NextDomain: "\\000." + name,
+		TypeBitMap: slices.Compact(types),
+	}
+}
+
+// Proof returns the signed DNSKEY and TXT RRsets of the username, the part
+// of its RFC 9102 authentication chain in the zone. Nil when the username has
+// no offer.
+func (d *EmbeddedDns) Proof(ctx context.Context, username string) ([]dns.RR, error) {
+	if d.signer == nil {
+		return nil, errors.New("the zone isn't signed")
+	}
+	name := fmt.Sprintf("%s.user.%s", strings.ToLower(username), d.zone)
+	if _, ok := dns.IsDomainName(name); !ok {
+		return nil, nil
+	}
+	records, _, err := d.rrsets(ctx, name)
+	if err != nil || len(records[dns.TypeTXT]) == 0 {
+		return nil, err
+	}
+	apex, _, err := d.rrsets(ctx, d.zone)
+	if err != nil {
+		return nil, err
+	}
+	proof := slices.Concat(apex[dns.TypeDNSKEY], records[dns.TypeTXT])
+	signatures, err := d.signer.sign(proof)
+	if err != nil {
+		return nil, err
+	}
+	return append(proof, signatures...), nil
+}
@@ -0,0 +1,184 @@
+package dns
+
+import (
+	"context"
+	"net/url"
+	"slices"
+	"testing"
+	"time"
+
+	dnssec "github.com/breez/breez-lnurl/persist/dnssec"
+	lnurl "github.com/breez/breez-lnurl/persist/lnurl"
+	"github.com/miekg/dns"
+	"gotest.tools/assert"
+)
+
+func setupSignedDns(t *testing.T) (*EmbeddedDns, *lnurl.MemoryStore) {
+	store := lnurl.NewMemoryStore()
+	externalURL, _ := url.Parse("https://example.com")
+	server := NewEmbeddedDns(externalURL, store, EmbeddedDnsConfig{
+		Address: "127.0.0.1:0",
+		Keys:    dnssec.NewMemoryStore(),
+	})
+	assert.NilError(t, server.Start())
+	t.Cleanup(func() { server.Shutdown() })
+	return server, store
+}
+
+func querySigned(t *testing.T, server *EmbeddedDns, name string, qtype uint16) *dns.Msg {
+	m := new(dns.Msg)
+	m.SetQuestion(name, qtype)
+	m.SetEdns0(4096, true)
+	client := &dns.Client{Net: "tcp"}
+	reply, _, err := client.Exchange(m, server.Addr())
+	assert.NilError(t, err)
+	return reply
+}
+
+// verify checks the RRsets of the records are signed by one of the keys.
+func verify(t *testing.T, records []dns.RR, keys []*dns.DNSKEY) {
+	var rrsigs []*dns.RRSIG
+	rrsets := make(map[uint16][]dns.RR)
+	for _, rr := range records {
+		if rrsig, ok := rr.(*dns.RRSIG); ok {
+			rrsigs = append(rrsigs, rrsig)
+			continue
+		}
+		rrsets[rr.Header().Rrtype] = append(rrsets[rr.Header().Rrtype], rr)
+	}
+	assert.Assert(t, len(rrsets) > 0)
+	for rrtype, rrset := range rrsets {
+		verified := false
+		for _, rrsig := range rrsigs {
+			for _, key := range keys {
+				if rrsig.TypeCovered == rrtype && rrsig.KeyTag == key.KeyTag() &&
+					rrsig.Verify(key, rrset) == nil && rrsig.ValidityPeriod(time.Now()) {
+					verified = true
+				}
+			}
+		}
+		assert.Assert(t, verified, "%v isn't signed", dns.TypeToString[rrtype])
+	}
+}
+
+func zoneKeys(t *testing.T, server *EmbeddedDns) (ksks []*dns.DNSKEY, zsks []*dns.DNSKEY) {
+	reply := querySigned(t, server, "_bitcoin-payment.example.com.", dns.TypeDNSKEY)
+	var keys []*dns.DNSKEY
+	for _, rr := range reply.Answer {
+		if key, ok := rr.(*dns.DNSKEY); ok {
+			keys = append(keys, key)
+			if key.Flags == kskFlags {
+				ksks = append(ksks, key)
+			} else {
+				zsks = append(zsks, key)
+			}
+		}
+	}
+	verify(t, reply.Answer, ksks)
+	return ksks, zsks
+}
+
+func TestSignedAnswers(t *testing.T) {
+	server, store := setupSignedDns(t)
+	offer := "lno1alice"
+	_, err := store.SetPubkeyDetails(context.Background(), "01", "alice", &offer, nil)
+	assert.NilError(t, err)
+	ksks, zsks := zoneKeys(t, server)
+	assert.Equal(t, len(ksks), 1)
+	assert.Equal(t, len(zsks), 1)
+
+	reply := querySigned(t, server, "alice.user._bitcoin-payment.example.com.", dns.TypeTXT)
+	assert.Assert(t, reply.IsEdns0().Do())
+	verify(t, reply.Answer, zsks)
+
+	// Not signed without the DO bit
+	m := new(dns.Msg)
+	m.SetQuestion("alice.user._bitcoin-payment.example.com.", dns.TypeTXT)
+	unsigned, err := dns.Exchange(m, server.Addr())
+	assert.NilError(t, err)
+	assert.Equal(t, len(unsigned.Answer), 1)
+
+	ds := server.Signer().DS()
+	assert.Equal(t, len(ds), 1)
+	rr, err := dns.NewRR(ds[0])
+	assert.NilError(t, err)
+	assert.Equal(t, rr.(*dns.DS).KeyTag, ksks[0].KeyTag())
+}
+
+func TestSignedDenialOfExistence(t *testing.T) {
+	server, store := setupSignedDns(t)
+	offer := "lno1alice"
+	_, err := store.SetPubkeyDetails(context.Background(), "01", "alice", &offer, nil)
+	assert.NilError(t, err)
+	_, zsks := zoneKeys(t, server)
+
+	for _, test := range []struct {
+		name  string
+		qtype uint16
+		types []uint16
+	}{
+		{"alice.user._bitcoin-payment.example.com.", dns.TypeA, []uint16{dns.TypeTXT, dns.TypeRRSIG, dns.TypeNSEC}},
+		{"bob.user._bitcoin-payment.example.com.", dns.TypeTXT, []uint16{dns.TypeRRSIG, dns.TypeNSEC, typeNXNAME}},
+		{"www._bitcoin-payment.example.com.", dns.TypeTXT, []uint16{dns.TypeRRSIG, dns.TypeNSEC, typeNXNAME}},
+		{"_bitcoin-payment.example.com.", dns.TypeTXT, []uint16{dns.TypeNS, dns.TypeSOA, dns.TypeRRSIG, dns.TypeNSEC, dns.TypeDNSKEY}},
+	} {
+		reply := querySigned(t, server, test.name, test.qtype)
+		// Compact denial answers NODATA for missing names too
+		assert.Equal(t, reply.Rcode, dns.RcodeSuccess)
+		assert.Equal(t, len(reply.Answer), 0)
+		verify(t, reply.Ns, zsks)
+		index := slices.IndexFunc(reply.Ns, func(rr dns.RR) bool { return rr.Header().Rrtype == dns.TypeNSEC })
+		assert.Assert(t, index != -1)
+		nsec := reply.Ns[index].(*dns.NSEC)
+		assert.Equal(t, nsec.NextDomain, "\\000."+test.name)
+		slices.Sort(test.types)
+		assert.DeepEqual(t, nsec.TypeBitMap, test.types)
+	}
+}
+
+func TestKeyRollover(t *testing.T) {
+	server, _ := setupSignedDns(t)
+	signer := server.Signer()
+	ctx := context.Background()
+	delay := KeyPropagationDelay
+	defer func() { KeyPropagationDelay = delay }()
+	oldKsks, oldZsks := zoneKeys(t, server)
+
+	// The new zone signing key is published before it signs
+	zsk, err := signer.Rollover(ctx, false)
+	assert.NilError(t, err)
+	assert.Equal(t, zsk.State, dnssec.KeyPublished)
+	_, err = signer.Rollover(ctx, false)
+	assert.ErrorContains(t, err, "in progress")
+	_, zsks := zoneKeys(t, server)
+	assert.Equal(t, len(zsks), 2)
+	assert.NilError(t, signer.advance(ctx))
+	assert.NilError(t, signer.load(ctx))
+	reply := querySigned(t, server, "_bitcoin-payment.example.com.", dns.TypeSOA)
+	verify(t, reply.Answer, oldZsks)
+
+	KeyPropagationDelay = 0
+	assert.NilError(t, signer.advance(ctx))
+	assert.NilError(t, signer.load(ctx))
+	reply = querySigned(t, server, "_bitcoin-payment.example.com.", dns.TypeSOA)
+	_, zsks = zoneKeys(t, server)
+	verify(t, reply.Answer, []*dns.DNSKEY{zsks[1]})
+	assert.Equal(t, zsks[1].KeyTag(), zsk.KeyTag)
+	// The retired key is removed after the propagation delay
+	assert.NilError(t, signer.advance(ctx))
+	assert.NilError(t, signer.load(ctx))
+	_, zsks = zoneKeys(t, server)
+	assert.Equal(t, len(zsks), 1)
+
+	// The new key signing key signs along with the active one until retired
+	ksk, err := signer.Rollover(ctx, true)
+	assert.NilError(t, err)
+	assert.Equal(t, len(signer.DS()), 2)
+	ksks, _ := zoneKeys(t, server)
+	verify(t, querySigned(t, server, "_bitcoin-payment.example.com.", dns.TypeDNSKEY).Answer, []*dns.DNSKEY{ksks[1]})
+	verify(t, querySigned(t, server, "_bitcoin-payment.example.com.", dns.TypeDNSKEY).Answer, oldKsks)
+	assert.ErrorContains(t, signer.Retire(ctx, zsk.Id), "isn't an active key signing key")
+	assert.NilError(t, signer.Retire(ctx, signer.Keys()[0].Id))
+	assert.ErrorContains(t, signer.Retire(ctx, ksk.Id), "only active")
+	assert.Equal(t, len(signer.DS()), 1)
+}
@@ -0,0 +1,85 @@
+package dns
+
+import (
+	"errors"
+	"fmt"
+	"log"
+)
+
+// MultiDns writes the BIP353 records to a primary provider and secondary
+// providers for redundancy. Updates are applied to every provider, and fail
+// when any provider fails them, so the outbox retries them on all of them.
+// As updates replace the records, applying them again is harmless.
+type MultiDns struct {
+	primary     DnsService
+	secondaries []DnsService
+}
+
+// multiZone reads the records back from the primary provider, so they can be
+// reconciled. The repairs are written to every provider.
+type multiZone struct {
+	*MultiDns
+	zone Zone
+}
+
+// NewMultiDns returns the DNS service writing to the providers, which can
+// read the zone back when the primary provider can.
+func NewMultiDns(primary DnsService, secondaries ...DnsService) DnsService {
+	multi := &MultiDns{
+		primary:     primary,
+		secondaries: secondaries,
+	}
+	if zone, ok := primary.(Zone); ok {
+		return &multiZone{MultiDns: multi, zone: zone}
+	}
+	return multi
+}
+
+// Set returns the TTL of the first provider that applied the update, along
+// with the errors of the others.
+func (m *MultiDns) Set(username, record string) (uint32, error) {
+	var ttl uint32
+	var errs []error
+	primaryTtl, err := m.primary.Set(username, record)
+	if err != nil {
+		log.Printf("failed to set DNS TXT record for %v on primary provider: %v", username, err)
+		errs = append(errs, fmt.Errorf("primary provider: %w", err))
+	} else {
+		ttl = primaryTtl
+	}
+	for i, secondary := range m.secondaries {
+		secondaryTtl, err := secondary.Set(username, record)
+		if err != nil {
+			log.Printf("failed to set DNS TXT record for %v on secondary provider %v: %v", username, i+1, err)
+			errs = append(errs, fmt.Errorf("secondary provider %v: %w", i+1, err))
+			continue
+		}
+		if ttl == 0 {
+			ttl = secondaryTtl
+		}
+	}
+	return ttl, errors.Join(errs...)
+}
+
+func (m *MultiDns) Remove(username string) error {
+	var errs []error
+	if err := m.primary.Remove(username); err != nil {
+		log.Printf("failed to remove DNS TXT record for %v on primary provider: %v", username, err)
+		errs = append(errs, fmt.Errorf("primary provider: %w", err))
+	}
+	for i, secondary := range m.secondaries {
+		if err := secondary.Remove(username); err != nil {
+			log.Printf("failed to remove DNS TXT record for %v on secondary provider %v: %v", username, i+1, err)
+			errs = append(errs, fmt.Errorf("secondary provider %v: %w", i+1, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func (m *multiZone) Records() (map[string][]string, error) {
+	return m.zone.Records()
+}
+
+func (m *multiZone) Lookup(username string) ([]string, error) {
+	return m.zone.Lookup(username)
+}
@@ -0,0 +1,52 @@
+package dns
+
+import (
+	"net/http"
+	"testing"
+
+	"gotest.tools/assert"
+)
+
+func TestMultiDns(t *testing.T) {
+	primary := newFakePowerDns(t)
+	secondary := newFakeRestDns(t)
+	service := NewMultiDns(primary.provider("secret"), secondary.provider())
+	zone, ok := service.(Zone)
+	assert.Assert(t, ok, "reads the zone of the primary provider back")
+	record := TxtRecord("lno1alice", nil)
+
+	_, err := service.Set("alice", record)
+	assert.NilError(t, err)
+	records, err := zone.Records()
+	assert.NilError(t, err)
+	assert.DeepEqual(t, records, map[string][]string{"alice": {record}})
+	assert.DeepEqual(t, secondary.records["alice"].Txt, []string{record})
+
+	// A failing secondary provider fails the update, to retry it
+	secondary.status = http.StatusServiceUnavailable
+	_, err = service.Set("bob", record)
+	assert.ErrorContains(t, err, "secondary provider 1")
+	assert.Equal(t, len(primary.rrsets), 2)
+	secondary.status = 0
+	_, err = service.Set("bob", record)
+	assert.NilError(t, err)
+
+	// A failing primary provider doesn't keep the secondary providers from
+	// being updated
+	failing := NewMultiDns(primary.provider("invalid"), secondary.provider())
+	ttl, err := failing.Set("carol", record)
+	assert.ErrorContains(t, err, "primary provider")
+	assert.Assert(t, ttl > 0, "the TTL of the secondary provider is returned")
+	assert.DeepEqual(t, secondary.records["carol"].Txt, []string{record})
+	assert.ErrorContains(t, failing.Remove("carol"), "primary provider")
+	_, ok = secondary.records["carol"]
+	assert.Assert(t, !ok)
+
+	assert.NilError(t, service.Remove("alice"))
+	assert.Equal(t, len(primary.rrsets), 1)
+	assert.Equal(t, len(secondary.records), 1)
+
+	// Without a zone to read back from the primary provider
+	_, ok = NewMultiDns(NewNoDns(), secondary.provider()).(Zone)
+	assert.Assert(t, !ok)
+}
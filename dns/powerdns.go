@@ -0,0 +1,131 @@
+package dns
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// PowerDnsConfig configures the PowerDNS HTTP API the zone is managed with.
+type PowerDnsConfig struct {
+	// The URL of the API, e.g. "http://localhost:8081"
+	ApiUrl string
+	// The key sent in the X-API-Key header
+	ApiKey string
+	// The server of the zone (optional, "localhost" by default)
+	ServerId string
+}
+
+// PowerDns manages the BIP353 records in the _bitcoin-payment.<domain> zone
+// of a PowerDNS authoritative server, through its HTTP API.
+type PowerDns struct {
+	zone    string
+	zoneUrl string
+	api     *apiClient
+}
+
+type powerDnsRecord struct {
+	Content  string `json:"content"`
+	Disabled bool   `json:"disabled"`
+}
+
+type powerDnsRRset struct {
+	Name       string           `json:"name"`
+	Type       string           `json:"type"`
+	Ttl        uint32           `json:"ttl,omitempty"`
+	ChangeType string           `json:"changetype,omitempty"`
+	Records    []powerDnsRecord `json:"records"`
+}
+
+type powerDnsZone struct {
+	RRsets []powerDnsRRset `json:"rrsets"`
+}
+
+func NewPowerDns(externalURL *url.URL, config PowerDnsConfig) *PowerDns {
+	serverId := config.ServerId
+	if serverId == "" {
+		serverId = "localhost"
+	}
+	zone := fmt.Sprintf("_bitcoin-payment.%s.", externalURL.Host)
+	header := http.Header{}
+	header.Set("X-API-Key", config.ApiKey)
+	return &PowerDns{
+		zone: zone,
+		zoneUrl: fmt.Sprintf("%s/api/v1/servers/%s/zones/%s",
+			strings.TrimSuffix(config.ApiUrl, "/"), url.PathEscape(serverId), url.PathEscape(zone)),
+		api: newApiClient("PowerDNS", header),
+	}
+}
+
+func (p *PowerDns) Set(username, record string) (uint32, error) {
+	ttl := uint32(3600)
+	rrset := powerDnsRRset{
+		Name:       recordName(p.zone, username),
+		Type:       "TXT",
+		Ttl:        ttl,
+		ChangeType: "REPLACE",
+		Records:    []powerDnsRecord{{Content: txtContent(record)}},
+	}
+	if _, err := p.api.do(http.MethodPatch, p.zoneUrl, powerDnsZone{RRsets: []powerDnsRRset{rrset}}, nil); err != nil {
+		log.Printf("DNS update failed: %v", err)
+		return 0, err
+	}
+	return ttl, nil
+}
+
+func (p *PowerDns) Remove(username string) error {
+	rrset := powerDnsRRset{
+		Name:       recordName(p.zone, username),
+		Type:       "TXT",
+		ChangeType: "DELETE",
+		Records:    []powerDnsRecord{},
+	}
+	if _, err := p.api.do(http.MethodPatch, p.zoneUrl, powerDnsZone{RRsets: []powerDnsRRset{rrset}}, nil); err != nil {
+		log.Printf("DNS update failed: %v", err)
+		return err
+	}
+	return nil
+}
+
+func (p *PowerDns) Records() (map[string][]string, error) {
+	return p.records(p.zoneUrl)
+}
+
+func (p *PowerDns) Lookup(username string) ([]string, error) {
+	query := url.Values{}
+	query.Set("rrset_name", recordName(p.zone, username))
+	query.Set("rrset_type", "TXT")
+	records, err := p.records(p.zoneUrl + "?" + query.Encode())
+	if err != nil {
+		return nil, err
+	}
+	return records[strings.ToLower(username)], nil
+}
+
+// records reads the TXT records under user from the zone.
+func (p *PowerDns) records(zoneUrl string) (map[string][]string, error) {
+	var zone powerDnsZone
+	if _, err := p.api.do(http.MethodGet, zoneUrl, nil, &zone); err != nil {
+		return nil, err
+	}
+	records := make(map[string][]string)
+	for _, rrset := range zone.RRsets {
+		username, ok := recordUsername(p.zone, rrset.Name)
+		if rrset.Type != "TXT" || !ok {
+			continue
+		}
+		for _, record := range rrset.Records {
+			if record.Disabled {
+				continue
+			}
+			txt, err := parseTxtContent(rrset.Name, record.Content)
+			if err != nil {
+				return nil, fmt.Errorf("invalid TXT record of %v: %w", rrset.Name, err)
+			}
+			records[username] = append(records[username], txt)
+		}
+	}
+	return records, nil
+}
@@ -0,0 +1,106 @@
+package dns
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+
+	"gotest.tools/assert"
+)
+
+// fakePowerDns serves the zone endpoints of the PowerDNS API from memory.
+type fakePowerDns struct {
+	mu     sync.Mutex
+	rrsets map[string]powerDnsRRset
+	server *httptest.Server
+}
+
+func newFakePowerDns(t *testing.T) *fakePowerDns {
+	fake := &fakePowerDns{rrsets: make(map[string]powerDnsRRset)}
+	fake.server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("X-API-Key") != "secret" {
+			http.Error(w, `{"error": "Unauthorized"}`, http.StatusUnauthorized)
+			return
+		}
+		if r.URL.Path != "/api/v1/servers/localhost/zones/_bitcoin-payment.example.com." {
+			http.Error(w, `{"error": "Could not find domain"}`, http.StatusNotFound)
+			return
+		}
+		fake.mu.Lock()
+		defer fake.mu.Unlock()
+		switch r.Method {
+		case http.MethodPatch:
+			var zone powerDnsZone
+			if err := json.NewDecoder(r.Body).Decode(&zone); err != nil {
+				http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+				return
+			}
+			for _, rrset := range zone.RRsets {
+				key := rrset.Name + "/" + rrset.Type
+				if rrset.ChangeType == "DELETE" {
+					delete(fake.rrsets, key)
+					continue
+				}
+				rrset.ChangeType = ""
+				fake.rrsets[key] = rrset
+			}
+			w.WriteHeader(http.StatusNoContent)
+		case http.MethodGet:
+			name := r.URL.Query().Get("rrset_name")
+			zone := powerDnsZone{RRsets: []powerDnsRRset{}}
+			for _, rrset := range fake.rrsets {
+				if name == "" || rrset.Name == name {
+					zone.RRsets = append(zone.RRsets, rrset)
+				}
+			}
+			json.NewEncoder(w).Encode(zone)
+		default:
+			w.WriteHeader(http.StatusMethodNotAllowed)
+		}
+	}))
+	t.Cleanup(fake.server.Close)
+	return fake
+}
+
+func (f *fakePowerDns) provider(apiKey string) *PowerDns {
+	externalURL, _ := url.Parse("https://example.com")
+	return NewPowerDns(externalURL, PowerDnsConfig{ApiUrl: f.server.URL, ApiKey: apiKey})
+}
+
+func TestPowerDns(t *testing.T) {
+	fake := newFakePowerDns(t)
+	provider := fake.provider("secret")
+	record := TxtRecord("lno1"+strings.Repeat("q", 600), nil)
+
+	ttl, err := provider.Set("alice", record)
+	assert.NilError(t, err)
+	assert.Equal(t, ttl, uint32(3600))
+	rrset := fake.rrsets["alice.user._bitcoin-payment.example.com./TXT"]
+	assert.Equal(t, len(rrset.Records), 1)
+	// Split in quoted strings of 255 characters
+	assert.Equal(t, strings.Count(rrset.Records[0].Content, `"`), 6)
+
+	_, err = provider.Set("bob", TxtRecord("lno1bob", nil))
+	assert.NilError(t, err)
+	records, err := provider.Records()
+	assert.NilError(t, err)
+	assert.DeepEqual(t, records, map[string][]string{
+		"alice": {record},
+		"bob":   {TxtRecord("lno1bob", nil)},
+	})
+	lookup, err := provider.Lookup("alice")
+	assert.NilError(t, err)
+	assert.DeepEqual(t, lookup, []string{record})
+
+	assert.NilError(t, provider.Remove("alice"))
+	lookup, err = provider.Lookup("alice")
+	assert.NilError(t, err)
+	assert.Equal(t, len(lookup), 0)
+
+	_, err = fake.provider("wrong").Set("alice", record)
+	assert.ErrorContains(t, err, "401")
+}
@@ -0,0 +1,110 @@
+package dns
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+// The time a request to the HTTP API of a DNS provider waits for its reply
+var ProviderTimeout = 10 * time.Second
+
+// The longest error reply of an HTTP API kept in the error
+const maxErrorReply = 512
+
+// apiClient sends JSON requests to the HTTP API of a DNS provider.
+type apiClient struct {
+	name   string
+	client *http.Client
+	header http.Header
+}
+
+func newApiClient(name string, header http.Header) *apiClient {
+	return &apiClient{
+		name:   name,
+		client: &http.Client{Timeout: ProviderTimeout},
+		header: header,
+	}
+}
+
+// do sends the request with the body encoded to JSON, and decodes the reply
+// to out when set. The status of the reply is returned along with an error
+// for a status other than 2xx.
+func (c *apiClient) do(method, url string, body any, out any) (int, error) {
+	var reader io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return 0, err
+		}
+		reader = bytes.NewReader(data)
+	}
+	req, err := http.NewRequest(method, url, reader)
+	if err != nil {
+		return 0, err
+	}
+	for key, values := range c.header {
+		req.Header[key] = values
+	}
+	req.Header.Set("Accept", "application/json")
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	res, err := c.client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer res.Body.Close()
+	if res.StatusCode < 200 || res.StatusCode > 299 {
+		reply, _ := io.ReadAll(io.LimitReader(res.Body, maxErrorReply))
+		return res.StatusCode, fmt.Errorf("%v replied %v: %s", c.name, res.Status, strings.TrimSpace(string(reply)))
+	}
+	if out != nil {
+		if err := json.NewDecoder(res.Body).Decode(out); err != nil {
+			return res.StatusCode, fmt.Errorf("invalid %v reply: %w", c.name, err)
+		}
+	}
+	return res.StatusCode, nil
+}
+
+// recordName returns the name of the BIP353 record of the username in the zone.
+func recordName(zone, username string) string {
+	return fmt.Sprintf("%s.user.%s", username, zone)
+}
+
+// recordUsername returns the username of the BIP353 record name in the zone,
+// false when the name isn't a BIP353 record.
+func recordUsername(zone, name string) (string, bool) {
+	suffix := ".user." + strings.ToLower(zone)
+	name = dns.Fqdn(strings.ToLower(name))
+	if !strings.HasSuffix(name, suffix) {
+		return "", false
+	}
+	return strings.TrimSuffix(name, suffix), true
+}
+
+// txtContent returns the TXT record in the zone file format, its strings of
+// 255 characters quoted and escaped.
+func txtContent(record string) string {
+	txt := &dns.TXT{Txt: chunks(record, 255)}
+	return strings.TrimPrefix(txt.String(), txt.Hdr.String())
+}
+
+// parseTxtContent returns the TXT record of the content in the zone file format.
+func parseTxtContent(name, content string) (string, error) {
+	rr, err := dns.NewRR(fmt.Sprintf("%s TXT %s", dns.Fqdn(name), content))
+	if err != nil {
+		return "", err
+	}
+	txt, ok := rr.(*dns.TXT)
+	if !ok {
+		return "", fmt.Errorf("not a TXT record")
+	}
+	return strings.Join(txt.Txt, ""), nil
+}
@@ -0,0 +1,111 @@
+package dns
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// RestDnsConfig configures the REST API the zone is managed with.
+type RestDnsConfig struct {
+	// The base URL of the API, the records are under <url>/records
+	Url string
+	// The bearer token sent in the Authorization header (optional)
+	Token string
+}
+
+// RestDns manages the BIP353 records with a generic REST API, adapting the
+// DNS hosting services without a dedicated provider:
+//   - PUT <url>/records/<username> replaces the TXT record of the username
+//   - DELETE <url>/records/<username> removes it, answering 404 when missing
+//   - GET <url>/records lists the records of the zone
+//   - GET <url>/records/<username> lists the records of the username,
+//     answering 404 when missing
+type RestDns struct {
+	zone string
+	url  string
+	api  *apiClient
+}
+
+// restRecord is a TXT record of the REST API, with the strings of 255
+// characters it is split in.
+type restRecord struct {
+	Name string   `json:"name"`
+	Type string   `json:"type"`
+	Ttl  uint32   `json:"ttl"`
+	Txt  []string `json:"txt"`
+}
+
+func NewRestDns(externalURL *url.URL, config RestDnsConfig) *RestDns {
+	header := http.Header{}
+	if config.Token != "" {
+		header.Set("Authorization", "Bearer "+config.Token)
+	}
+	return &RestDns{
+		zone: fmt.Sprintf("_bitcoin-payment.%s.", externalURL.Host),
+		url:  strings.TrimSuffix(config.Url, "/") + "/records",
+		api:  newApiClient("DNS API", header),
+	}
+}
+
+func (r *RestDns) recordUrl(username string) string {
+	return fmt.Sprintf("%s/%s", r.url, url.PathEscape(username))
+}
+
+func (r *RestDns) Set(username, record string) (uint32, error) {
+	ttl := uint32(3600)
+	body := restRecord{
+		Name: recordName(r.zone, username),
+		Type: "TXT",
+		Ttl:  ttl,
+		Txt:  chunks(record, 255),
+	}
+	if _, err := r.api.do(http.MethodPut, r.recordUrl(username), body, nil); err != nil {
+		log.Printf("DNS update failed: %v", err)
+		return 0, err
+	}
+	return ttl, nil
+}
+
+func (r *RestDns) Remove(username string) error {
+	status, err := r.api.do(http.MethodDelete, r.recordUrl(username), nil, nil)
+	if err != nil && status != http.StatusNotFound {
+		log.Printf("DNS update failed: %v", err)
+		return err
+	}
+	return nil
+}
+
+func (r *RestDns) Records() (map[string][]string, error) {
+	var list []restRecord
+	if _, err := r.api.do(http.MethodGet, r.url, nil, &list); err != nil {
+		return nil, err
+	}
+	records := make(map[string][]string)
+	for _, record := range list {
+		if username, ok := recordUsername(r.zone, record.Name); ok && record.Type == "TXT" {
+			records[username] = append(records[username], strings.Join(record.Txt, ""))
+		}
+	}
+	return records, nil
+}
+
+func (r *RestDns) Lookup(username string) ([]string, error) {
+	var list []restRecord
+	status, err := r.api.do(http.MethodGet, r.recordUrl(username), nil, &list)
+	if status == http.StatusNotFound {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var records []string
+	for _, record := range list {
+		if record.Type == "TXT" {
+			records = append(records, strings.Join(record.Txt, ""))
+		}
+	}
+	return records, nil
+}
@@ -0,0 +1,105 @@
+package dns
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+
+	"gotest.tools/assert"
+)
+
+// fakeRestDns serves the records of the generic REST API from memory.
+type fakeRestDns struct {
+	mu      sync.Mutex
+	records map[string]restRecord
+	// The status replied to the updates, when set
+	status int
+	server *httptest.Server
+}
+
+func newFakeRestDns(t *testing.T) *fakeRestDns {
+	fake := &fakeRestDns{records: make(map[string]restRecord)}
+	fake.server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer secret" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		fake.mu.Lock()
+		defer fake.mu.Unlock()
+		username, single := strings.CutPrefix(r.URL.Path, "/records/")
+		if fake.status != 0 && r.Method != http.MethodGet {
+			w.WriteHeader(fake.status)
+			return
+		}
+		switch {
+		case r.Method == http.MethodPut && single:
+			var record restRecord
+			if err := json.NewDecoder(r.Body).Decode(&record); err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			fake.records[username] = record
+		case r.Method == http.MethodDelete && single:
+			if _, ok := fake.records[username]; !ok {
+				w.WriteHeader(http.StatusNotFound)
+				return
+			}
+			delete(fake.records, username)
+		case r.Method == http.MethodGet && single:
+			record, ok := fake.records[username]
+			if !ok {
+				w.WriteHeader(http.StatusNotFound)
+				return
+			}
+			json.NewEncoder(w).Encode([]restRecord{record})
+		case r.Method == http.MethodGet && r.URL.Path == "/records":
+			list := []restRecord{}
+			for _, record := range fake.records {
+				list = append(list, record)
+			}
+			json.NewEncoder(w).Encode(list)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	t.Cleanup(fake.server.Close)
+	return fake
+}
+
+func (f *fakeRestDns) provider() *RestDns {
+	externalURL, _ := url.Parse("https://example.com")
+	return NewRestDns(externalURL, RestDnsConfig{Url: f.server.URL, Token: "secret"})
+}
+
+func TestRestDns(t *testing.T) {
+	fake := newFakeRestDns(t)
+	provider := fake.provider()
+	record := TxtRecord("lno1"+strings.Repeat("q", 600), nil)
+
+	_, err := provider.Set("alice", record)
+	assert.NilError(t, err)
+	assert.Equal(t, fake.records["alice"].Name, "alice.user._bitcoin-payment.example.com.")
+	assert.Equal(t, len(fake.records["alice"].Txt), 3)
+
+	records, err := provider.Records()
+	assert.NilError(t, err)
+	assert.DeepEqual(t, records, map[string][]string{"alice": {record}})
+	lookup, err := provider.Lookup("alice")
+	assert.NilError(t, err)
+	assert.DeepEqual(t, lookup, []string{record})
+
+	assert.NilError(t, provider.Remove("alice"))
+	// Removing a missing record succeeds
+	assert.NilError(t, provider.Remove("alice"))
+	lookup, err = provider.Lookup("alice")
+	assert.NilError(t, err)
+	assert.Equal(t, len(lookup), 0)
+
+	fake.status = http.StatusServiceUnavailable
+	_, err = provider.Set("alice", record)
+	assert.ErrorContains(t, err, "503")
+}
@@ -0,0 +1,328 @@
+package dns
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net"
+	"net/url"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	dnssec "github.com/breez/breez-lnurl/persist/dnssec"
+	lnurl "github.com/breez/breez-lnurl/persist/lnurl"
+	"github.com/miekg/dns"
+)
+
+// The TTL of the TXT records served by the embedded server
+var RecordTTL uint32 = 3600
+
+// The TTL of negative answers, served as the SOA minimum
+var NegativeTTL uint32 = 300
+
+// The time a query waits for the store
+var queryTimeout = 5 * time.Second
+
+// The largest UDP response, avoiding IP fragmentation
+const maxUdpSize = 1232
+
+type EmbeddedDnsConfig struct {
+	// The address to serve on over UDP and TCP, e.g. ":53"
+	Address string
+	// The host names of the name servers of the zone, served as its NS records
+	NameServers []string
+	// The mailbox of the zone administrator, served in the SOA record
+	Hostmaster string
+	// The store of the DNSSEC keys, the zone is signed when set
+	Keys dnssec.Store
+}
+
+// EmbeddedDns is an authoritative name server for the BIP353 zone
+// _bitcoin-payment.<domain>, answering the TXT queries under user from the
+// offers in the store. Setting and removing records only bumps the SOA serial,
+// as the store is already updated.
+type EmbeddedDns struct {
+	zone   string
+	store  lnurl.Store
+	config EmbeddedDnsConfig
+	serial atomic.Uint32
+	// Nil when the zone isn't signed
+	signer *Signer
+
+	mu      sync.Mutex
+	servers []*dns.Server
+	cancel  context.CancelFunc
+}
+
+func NewEmbeddedDns(externalURL *url.URL, store lnurl.Store, config EmbeddedDnsConfig) *EmbeddedDns {
+	if config.Hostmaster == "" {
+		config.Hostmaster = fmt.Sprintf("hostmaster.%s", externalURL.Hostname())
+	}
+	d := &EmbeddedDns{
+		zone:   strings.ToLower(fmt.Sprintf("_bitcoin-payment.%s.", externalURL.Hostname())),
+		store:  store,
+		config: config,
+	}
+	d.serial.Store(uint32(time.Now().Unix()))
+	if config.Keys != nil {
+		d.signer = NewSigner(d.zone, config.Keys)
+	}
+	return d
+}
+
+// Signer returns the signer of the zone, nil when it isn't signed.
+func (d *EmbeddedDns) Signer() *Signer {
+	return d.signer
+}
+
+func (d *EmbeddedDns) Set(username, record string) (uint32, error) {
+	d.bumpSerial()
+	return RecordTTL, nil
+}
+
+func (d *EmbeddedDns) Remove(username string) error {
+	d.bumpSerial()
+	return nil
+}
+
+// bumpSerial moves the SOA serial to now, or past the last serial if changed
+// in the same second.
+func (d *EmbeddedDns) bumpSerial() {
+	for {
+		last := d.serial.Load()
+		serial := max(uint32(time.Now().Unix()), last+1)
+		if d.serial.CompareAndSwap(last, serial) {
+			return
+		}
+	}
+}
+
+// Start serves the zone over UDP and TCP on the configured address, until Shutdown.
+func (d *EmbeddedDns) Start() error {
+	ctx, cancel := context.WithCancel(context.Background())
+	if d.signer != nil {
+		if err := d.signer.Init(ctx); err != nil {
+			cancel()
+			return fmt.Errorf("failed to initialize the DNSSEC keys: %w", err)
+		}
+		go d.signer.Start(ctx)
+	}
+
+	packetConn, err := net.ListenPacket("udp", d.config.Address)
+	if err != nil {
+		cancel()
+		return fmt.Errorf("failed to listen on udp %v: %w", d.config.Address, err)
+	}
+	// The UDP port is reused, in case the configured one is picked by the system
+	listener, err := net.Listen("tcp", packetConn.LocalAddr().String())
+	if err != nil {
+		packetConn.Close()
+		cancel()
+		return fmt.Errorf("failed to listen on tcp %v: %w", d.config.Address, err)
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.cancel = cancel
+	d.servers = []*dns.Server{
+		{PacketConn: packetConn, Handler: d},
+		{Listener: listener, Handler: d},
+	}
+	for _, server := range d.servers {
+		go func(server *dns.Server) {
+			if err := server.ActivateAndServe(); err != nil {
+				log.Printf("embedded DNS server stopped: %v", err)
+			}
+		}(server)
+	}
+	log.Printf("serving the BIP353 zone %v on %v", d.zone, packetConn.LocalAddr())
+	return nil
+}
+
+// Addr returns the address served on, once started.
+func (d *EmbeddedDns) Addr() string {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if len(d.servers) == 0 {
+		return ""
+	}
+	return d.servers[0].PacketConn.LocalAddr().String()
+}
+
+func (d *EmbeddedDns) Shutdown() error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.cancel != nil {
+		d.cancel()
+	}
+	for _, server := range d.servers {
+		if err := server.Shutdown(); err != nil {
+			return err
+		}
+	}
+	d.servers = nil
+	return nil
+}
+
+func (d *EmbeddedDns) ServeDNS(w dns.ResponseWriter, r *dns.Msg) {
+	ctx, cancel := context.WithTimeout(context.Background(), queryTimeout)
+	defer cancel()
+	m := d.Answer(ctx, r)
+	if w.LocalAddr().Network() == "udp" {
+		size := dns.MinMsgSize
+		if opt := r.IsEdns0(); opt != nil {
+			size = int(min(max(opt.UDPSize(), dns.MinMsgSize), maxUdpSize))
+		}
+		m.Truncate(size)
+	}
+	w.WriteMsg(m)
+}
+
+// Answer returns the response to the query, without truncating it, so it can
+// be served over other transports too.
+func (d *EmbeddedDns) Answer(ctx context.Context, r *dns.Msg) *dns.Msg {
+	m := new(dns.Msg)
+	m.SetReply(r)
+	m.Compress = true
+	if r.Opcode != dns.OpcodeQuery || len(r.Question) != 1 {
+		m.SetRcode(r, dns.RcodeNotImplemented)
+		return m
+	}
+
+	opt := r.IsEdns0()
+	if err := d.answer(ctx, r.Question[0], m, opt != nil && opt.Do()); err != nil {
+		log.Printf("failed to answer DNS query %v: %v", r.Question[0].String(), err)
+		m = new(dns.Msg)
+		m.SetRcode(r, dns.RcodeServerFailure)
+	}
+	if opt != nil {
+		m.SetEdns0(maxUdpSize, opt.Do() && d.signer != nil)
+	}
+	return m
+}
+
+// answer fills the sections of the response to the question, signed when
+// DNSSEC is requested. Missing names under user are answered with NODATA
+// rather than NXDOMAIN, as usernames with dots create empty non-terminals that
+// can't be told apart.
+func (d *EmbeddedDns) answer(ctx context.Context, question dns.Question, m *dns.Msg, dnssecOk bool) error {
+	name := strings.ToLower(question.Name)
+	if !dns.IsSubDomain(d.zone, name) {
+		m.Rcode = dns.RcodeRefused
+		return nil
+	}
+	m.Authoritative = true
+
+	rrsets, exists, err := d.rrsets(ctx, question.Name)
+	if err != nil {
+		return err
+	}
+	var types []uint16
+	for rrtype, rrset := range rrsets {
+		types = append(types, rrtype)
+		if question.Qtype == rrtype || question.Qtype == dns.TypeANY {
+			m.Answer = append(m.Answer, rrset...)
+		}
+	}
+
+	signed := dnssecOk && d.signer != nil
+	if len(m.Answer) == 0 {
+		// Negative answers carry the SOA, for caching them
+		m.Ns = append(m.Ns, d.soa(d.zone, NegativeTTL))
+		if signed {
+			if !exists {
+				types = append(types, typeNXNAME)
+			}
+			m.Ns = append(m.Ns, nsec(name, types))
+		} else if !exists && !dns.IsSubDomain("user."+d.zone, name) {
+			m.Rcode = dns.RcodeNameError
+		}
+	}
+
+	if signed {
+		for _, section := range []*[]dns.RR{&m.Answer, &m.Ns} {
+			signatures, err := d.signer.sign(*section)
+			if err != nil {
+				return err
+			}
+			*section = append(*section, signatures...)
+		}
+	}
+	return nil
+}
+
+// rrsets returns the RRsets at the name, and whether it exists.
+func (d *EmbeddedDns) rrsets(ctx context.Context, name string) (map[uint16][]dns.RR, bool, error) {
+	lowerName := strings.ToLower(name)
+	users := "user." + d.zone
+	rrsets := make(map[uint16][]dns.RR)
+	switch {
+	case lowerName == d.zone:
+		rrsets[dns.TypeSOA] = []dns.RR{d.soa(name, RecordTTL)}
+		rrsets[dns.TypeNS] = d.ns(name)
+		if d.signer != nil {
+			rrsets[dns.TypeDNSKEY] = d.signer.dnskeys(name)
+		}
+		return rrsets, true, nil
+	case lowerName == users:
+		// An empty non-terminal
+		return rrsets, true, nil
+	case dns.IsSubDomain(users, lowerName):
+		record, err := d.record(ctx, strings.TrimSuffix(lowerName, "."+users))
+		if err != nil || record == nil {
+			return rrsets, false, err
+		}
+		rrsets[dns.TypeTXT] = []dns.RR{&dns.TXT{
+			Hdr: dns.RR_Header{Name: name, Rrtype: dns.TypeTXT, Class: dns.ClassINET, Ttl: RecordTTL},
+			Txt: chunks(*record, 255),
+		}}
+		return rrsets, true, nil
+	}
+	return rrsets, false, nil
+}
+
+// record returns the TXT record of the username's offer, nil when it has none.
+func (d *EmbeddedDns) record(ctx context.Context, username string) (*string, error) {
+	details, err := d.store.GetPubkeyDetails(ctx, username)
+	if err != nil {
+		return nil, err
+	}
+	if details == nil || details.Offer == nil || !strings.EqualFold(details.Username, username) {
+		return nil, nil
+	}
+	record := TxtRecord(*details.Offer, details.Bip21Params)
+	return &record, nil
+}
+
+func (d *EmbeddedDns) soa(name string, ttl uint32) dns.RR {
+	return &dns.SOA{
+		Hdr:     dns.RR_Header{Name: name, Rrtype: dns.TypeSOA, Class: dns.ClassINET, Ttl: ttl},
+		Ns:      dns.Fqdn(d.nameServers()[0]),
+		Mbox:    dns.Fqdn(strings.Replace(d.config.Hostmaster, "@", ".", 1)),
+		Serial:  d.serial.Load(),
+		Refresh: 3600,
+		Retry:   600,
+		Expire:  604800,
+		Minttl:  NegativeTTL,
+	}
+}
+
+func (d *EmbeddedDns) ns(name string) []dns.RR {
+	var records []dns.RR
+	for _, nameServer := range d.nameServers() {
+		records = append(records, &dns.NS{
+			Hdr: dns.RR_Header{Name: name, Rrtype: dns.TypeNS, Class: dns.ClassINET, Ttl: RecordTTL},
+			Ns:  dns.Fqdn(nameServer),
+		})
+	}
+	return records
+}
+
+func (d *EmbeddedDns) nameServers() []string {
+	if len(d.config.NameServers) == 0 {
+		return []string{"ns." + strings.TrimPrefix(d.zone, "_bitcoin-payment.")}
+	}
+	return d.config.NameServers
+}
@@ -0,0 +1,99 @@
+package dns
+
+import (
+	"context"
+	"net/url"
+	"strings"
+	"testing"
+
+	lnurl "github.com/breez/breez-lnurl/persist/lnurl"
+	"github.com/miekg/dns"
+	"gotest.tools/assert"
+)
+
+func setupEmbeddedDns(t *testing.T) (*EmbeddedDns, *lnurl.MemoryStore) {
+	store := lnurl.NewMemoryStore()
+	externalURL, _ := url.Parse("https://example.com")
+	server := NewEmbeddedDns(externalURL, store, EmbeddedDnsConfig{
+		Address:     "127.0.0.1:0",
+		NameServers: []string{"ns1.example.com", "ns2.example.com"},
+	})
+	assert.NilError(t, server.Start())
+	t.Cleanup(func() { server.Shutdown() })
+	return server, store
+}
+
+func query(t *testing.T, server *EmbeddedDns, net string, name string, qtype uint16) *dns.Msg {
+	m := new(dns.Msg)
+	m.SetQuestion(name, qtype)
+	client := &dns.Client{Net: net}
+	reply, _, err := client.Exchange(m, server.Addr())
+	assert.NilError(t, err)
+	return reply
+}
+
+func TestEmbeddedDnsAnswersFromStore(t *testing.T) {
+	server, store := setupEmbeddedDns(t)
+	offer := "lno1" + strings.Repeat("q", 600)
+	_, err := store.SetPubkeyDetails(context.Background(), "01", "alice", &offer, nil)
+	assert.NilError(t, err)
+	serial := server.serial.Load()
+	_, err = server.Set("alice", TxtRecord(offer, nil))
+	assert.NilError(t, err)
+	assert.Assert(t, server.serial.Load() > serial)
+
+	for _, net := range []string{"udp", "tcp"} {
+		reply := query(t, server, net, "Alice.user._bitcoin-payment.example.com.", dns.TypeTXT)
+		assert.Equal(t, reply.Rcode, dns.RcodeSuccess)
+		assert.Assert(t, reply.Authoritative)
+		if net == "udp" {
+			// The offer doesn't fit in 512 bytes
+			assert.Assert(t, reply.Truncated)
+			continue
+		}
+		assert.Equal(t, len(reply.Answer), 1)
+		txt := reply.Answer[0].(*dns.TXT)
+		assert.Equal(t, strings.Join(txt.Txt, ""), TxtRecord(offer, nil))
+		assert.Equal(t, txt.Hdr.Ttl, RecordTTL)
+	}
+}
+
+func TestEmbeddedDnsNegativeAnswers(t *testing.T) {
+	server, store := setupEmbeddedDns(t)
+	_, err := store.SetPubkeyDetails(context.Background(), "01", "bob", nil, nil)
+	assert.NilError(t, err)
+
+	for _, name := range []string{
+		"bob.user._bitcoin-payment.example.com.",
+		"carol.user._bitcoin-payment.example.com.",
+		"user._bitcoin-payment.example.com.",
+	} {
+		reply := query(t, server, "udp", name, dns.TypeTXT)
+		assert.Equal(t, reply.Rcode, dns.RcodeSuccess, name)
+		assert.Equal(t, len(reply.Answer), 0)
+		soa := reply.Ns[0].(*dns.SOA)
+		assert.Equal(t, soa.Hdr.Ttl, NegativeTTL)
+		assert.Equal(t, soa.Minttl, NegativeTTL)
+	}
+
+	reply := query(t, server, "udp", "www._bitcoin-payment.example.com.", dns.TypeTXT)
+	assert.Equal(t, reply.Rcode, dns.RcodeNameError)
+	assert.Equal(t, len(reply.Ns), 1)
+
+	reply = query(t, server, "udp", "example.org.", dns.TypeTXT)
+	assert.Equal(t, reply.Rcode, dns.RcodeRefused)
+}
+
+func TestEmbeddedDnsApex(t *testing.T) {
+	server, _ := setupEmbeddedDns(t)
+
+	reply := query(t, server, "udp", "_bitcoin-payment.example.com.", dns.TypeSOA)
+	assert.Equal(t, len(reply.Answer), 1)
+	soa := reply.Answer[0].(*dns.SOA)
+	assert.Equal(t, soa.Ns, "ns1.example.com.")
+	assert.Equal(t, soa.Mbox, "hostmaster.example.com.")
+
+	reply = query(t, server, "tcp", "_bitcoin-payment.example.com.", dns.TypeNS)
+	assert.Equal(t, len(reply.Answer), 2)
+	assert.Equal(t, reply.Answer[1].(*dns.NS).Ns, "ns2.example.com.")
+}
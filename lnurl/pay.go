@@ -1,6 +1,7 @@
 package lnurl
 
 import (
+	"context"
 	"encoding/hex"
 	"encoding/json"
 	"errors"
@@ -15,6 +16,7 @@ import (
 
 	"log"
 
+	"github.com/breez/breez-lnurl/bip353"
 	"github.com/breez/breez-lnurl/cache"
 	"github.com/breez/breez-lnurl/channel"
 	"github.com/breez/breez-lnurl/constant"
@@ -30,13 +32,19 @@ type RegisterLnurlPayRequest struct {
 	WebhookUrl string  `json:"webhook_url"`
 	Username   *string `json:"username"`
 	Offer      *string `json:"offer"`
-	Signature  string  `json:"signature"`
+	// The additional BIP21 parameters of the BIP353 record, along with the offer
+	Bip21Params *lnurl.PaymentParams `json:"bip21_params"`
+	Signature   string               `json:"signature"`
 }
 
 type RegisterRecoverLnurlPayResponse struct {
 	Lnurl            string  `json:"lnurl"`
 	LightningAddress *string `json:"lightning_address,omitempty"`
 	BIP353Address    *string `json:"bip353_address,omitempty"`
+	// The state of the BIP353 DNS TXT record: pending, published or failed
+	BIP353State *string `json:"bip353_state,omitempty"`
+	// The BIP21 URI of the BIP353 DNS TXT record
+	BIP353Uri *string `json:"bip353_uri,omitempty"`
 }
 
 func (w *RegisterLnurlPayRequest) Verify(pubkey string) error {
@@ -61,6 +69,14 @@ func (w *RegisterLnurlPayRequest) Verify(pubkey string) error {
 				return fmt.Errorf("invalid offer %v", offer)
 			}
 			messageToVerify = fmt.Sprintf("%v-%v", messageToVerify, offer)
+			// Validate with the BIP21 parameters if present
+			if w.Bip21Params != nil {
+				params := w.Bip21Params
+				if err := dns.ValidatePaymentParams(offer, params); err != nil {
+					return err
+				}
+				messageToVerify = fmt.Sprintf("%v-%v-%v-%v", messageToVerify, params.Address, params.SilentPayment, params.Liquid)
+			}
 		}
 	}
 	verifiedPubkey, err := lightning.VerifyMessage([]byte(messageToVerify), w.Signature)
@@ -119,16 +135,14 @@ func NewLnurlPayOkResponse(reason string) LnurlPayStatus {
 
 type LnurlPayRouter struct {
 	store   *persist.Store
-	dns     dns.DnsService
 	cache   cache.CacheService
 	channel channel.WebhookChannel
 	rootURL *url.URL
 }
 
-func RegisterLnurlPayRouter(router *mux.Router, rootURL *url.URL, store *persist.Store, dns dns.DnsService, cache cache.CacheService, channel channel.WebhookChannel) {
+func RegisterLnurlPayRouter(router *mux.Router, rootURL *url.URL, store *persist.Store, cache cache.CacheService, channel channel.WebhookChannel) {
 	lnurlPayRouter := &LnurlPayRouter{
 		store:   store,
-		dns:     dns,
 		cache:   cache,
 		channel: channel,
 		rootURL: rootURL,
@@ -185,7 +199,7 @@ func (s *LnurlPayRouter) Recover(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 	lnurlUri := fmt.Sprintf("%v/lnurlp/%v", s.rootURL, pubkey)
-	body, err := marshalRegisterRecoverLnurlPayResponse(lnurlUri, webhook.Username, webhook.Offer, s.rootURL.Host)
+	body, err := s.marshalRegisterRecoverLnurlPayResponse(r.Context(), lnurlUri, webhook.Username, webhook.Offer, webhook.Bip21Params)
 	if err != nil {
 		w.WriteHeader(http.StatusInternalServerError)
 		return
@@ -219,19 +233,23 @@ func (s *LnurlPayRouter) Register(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Get the last updated webhook for the pubkey to use it to check if the offer has changed
-	var lastOffer *string
-	lastWebhook, _ := s.store.LnUrl.GetLastUpdated(r.Context(), pubkey)
-	if lastWebhook != nil && lastWebhook.Offer != nil {
-		lastOffer = lastWebhook.Offer
+	// The offer is only changed along with the username. The BIP353 DNS TXT
+	// records are updated from the outbox the store queues the changes to.
+	var offer *string
+	var bip21Params *lnurl.PaymentParams
+	if addRequest.Username != nil {
+		offer = addRequest.Offer
+		if offer != nil && addRequest.Bip21Params != nil && *addRequest.Bip21Params != (lnurl.PaymentParams{}) {
+			bip21Params = addRequest.Bip21Params
+		}
 	}
-
+	lastWebhook, _ := s.store.LnUrl.GetLastUpdated(r.Context(), pubkey)
 	updatedWebhook, err := s.store.LnUrl.Set(r.Context(), lnurl.Webhook{
-		Pubkey:   pubkey,
-		Url:      addRequest.WebhookUrl,
-		Username: addRequest.Username,
-		// Keep the offer set with the last valid offer
-		Offer: lastOffer,
+		Pubkey:      pubkey,
+		Url:         addRequest.WebhookUrl,
+		Username:    addRequest.Username,
+		Offer:       offer,
+		Bip21Params: bip21Params,
 	})
 
 	if err != nil {
@@ -250,50 +268,18 @@ func (s *LnurlPayRouter) Register(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Update the BIP353 DNS TXT records
-	if addRequest.Username != nil && addRequest.Offer != nil {
-		// If the username and offer are set, we need to check if we need to update the DNS TXT record
-		shouldSetOffer := lastWebhook == nil || lastWebhook.Offer == nil
-		username := *addRequest.Username
-		offer := *addRequest.Offer
-
-		if lastWebhook != nil && lastWebhook.Username != nil && lastWebhook.Offer != nil {
-			// If the last webhook exists, we need to check if the username or offer has changed
-			lastUsername := *lastWebhook.Username
-			lastOffer := *lastWebhook.Offer
-			shouldSetOffer = username != lastUsername || offer != lastOffer
-
-			if shouldSetOffer {
-				if err = s.dns.Remove(lastUsername); err != nil {
-					log.Printf("failed to remove DNS TXT record for %v: %v", lastUsername, err)
-				}
-			}
-		}
-
-		if shouldSetOffer {
-			ttl, err := s.dns.Set(username, offer)
-			if err != nil {
-				log.Printf("failed to set DNS TXT record for %v, %v: %v", username, offer, err)
-			}
-			if ttl != 0 {
-				// Only set the offer if the DNS service returns a TTL
-				s.store.LnUrl.SetPubkeyDetails(r.Context(), pubkey, username, &offer)
-			}
-		}
-	} else if addRequest.Offer == nil {
-		// If the offer is not set, we need to remove the DNS TXT record
-		if lastWebhook != nil && lastWebhook.Username != nil && lastWebhook.Offer != nil {
-			lastUsername := *lastWebhook.Username
-			if err = s.dns.Remove(lastUsername); err != nil {
-				log.Printf("failed to remove DNS TXT record for %v: %v", lastUsername, err)
-			}
-			s.store.LnUrl.SetPubkeyDetails(r.Context(), pubkey, lastUsername, nil)
+	// If the offer is not set, it is removed from the last username
+	if addRequest.Username == nil && addRequest.Offer == nil && lastWebhook != nil && lastWebhook.Username != nil && lastWebhook.Offer != nil {
+		if _, err = s.store.LnUrl.SetPubkeyDetails(r.Context(), pubkey, *lastWebhook.Username, nil, nil); err != nil {
+			log.Printf("failed to remove the offer of pubkey %v: %v", pubkey, err)
+			w.WriteHeader(http.StatusInternalServerError)
+			return
 		}
 	}
 
 	log.Printf("registration added: pubkey:%v\n", pubkey)
 	lnurlUri := fmt.Sprintf("%v/lnurlp/%v", s.rootURL, pubkey)
-	body, err := marshalRegisterRecoverLnurlPayResponse(lnurlUri, updatedWebhook.Username, updatedWebhook.Offer, s.rootURL.Host)
+	body, err := s.marshalRegisterRecoverLnurlPayResponse(r.Context(), lnurlUri, updatedWebhook.Username, updatedWebhook.Offer, updatedWebhook.Bip21Params)
 	if err != nil {
 		w.WriteHeader(http.StatusInternalServerError)
 		return
@@ -346,13 +332,12 @@ func (s *LnurlPayRouter) Unregister(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Remove the DNS TXT record for this username/offer
+	// Remove the offer, queueing the removal of its DNS TXT record
 	if webhook.Username != nil {
 		username := *webhook.Username
-		if err = s.dns.Remove(username); err != nil {
-			log.Printf("failed to remove DNS TXT record for %v: %v", username, err)
+		if _, err = s.store.LnUrl.SetPubkeyDetails(r.Context(), pubkey, username, nil, nil); err != nil {
+			log.Printf("failed to remove the offer of pubkey %v: %v", pubkey, err)
 		}
-		s.store.LnUrl.SetPubkeyDetails(r.Context(), pubkey, username, nil)
 	}
 
 	log.Printf("registration removed: pubkey:%v url: %v\n", pubkey, removeRequest.WebhookUrl)
@@ -519,23 +504,31 @@ func (l *LnurlPayRouter) HandleVerify(w http.ResponseWriter, r *http.Request) {
 }
 
 /* helper methods */
-func marshalRegisterRecoverLnurlPayResponse(lnurlUri string, username *string, offer *string, host string) ([]byte, error) {
-	lnurl, err := encodeLnurl(lnurlUri)
+func (s *LnurlPayRouter) marshalRegisterRecoverLnurlPayResponse(ctx context.Context, lnurlUri string, username *string, offer *string, params *lnurl.PaymentParams) ([]byte, error) {
+	encodedLnurl, err := encodeLnurl(lnurlUri)
 	if err != nil {
 		return nil, err
 	}
-	var lightningAddress, bip353Address *string
+	var lightningAddress, bip353Address, bip353State, bip353Uri *string
 	if username != nil {
-		lnAddr := fmt.Sprintf("%v@%v", *username, host)
+		lnAddr := fmt.Sprintf("%v@%v", *username, s.rootURL.Host)
 		lightningAddress = &lnAddr
 		if offer != nil {
-			bip353Address = &lnAddr
+			state := bip353.RecordState(ctx, s.store.LnUrl, *username)
+			bip353State = &state
+			if state != lnurl.DnsRecordFailed {
+				uri := dns.TxtRecord(*offer, params)
+				bip353Address = &lnAddr
+				bip353Uri = &uri
+			}
 		}
 	}
 	return json.Marshal(RegisterRecoverLnurlPayResponse{
-		Lnurl:            lnurl,
+		Lnurl:            encodedLnurl,
 		LightningAddress: lightningAddress,
 		BIP353Address:    bip353Address,
+		BIP353State:      bip353State,
+		BIP353Uri:        bip353Uri,
 	})
 }
 
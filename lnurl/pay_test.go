@@ -8,6 +8,7 @@ import (
 	"testing"
 	"time"
 
+	lnurl "github.com/breez/breez-lnurl/persist/lnurl"
 	"github.com/breez/lspd/lightning"
 	"github.com/btcsuite/btcd/btcec/v2/ecdsa"
 	"github.com/decred/dcrd/dcrec/secp256k1/v4"
@@ -178,3 +179,50 @@ func TestPayRegisterLnurlPayRequestInvalidOffers(t *testing.T) {
 		assert.ErrorContains(t, err, "invalid offer")
 	}
 }
+
+func TestPayRegisterLnurlPayRequestBip21Params(t *testing.T) {
+	domain := "lnurl.domain"
+	url := fmt.Sprintf("http://%v/callback", domain)
+	time := time.Now().Unix()
+	username := "testuser"
+	offer := "lno1zzfq9ktw4h4r67qpq3zf4jjujdrpeenuz4jw9cwhxgjl5e7a8wvh5cqcqvet65ahjawgr0r0uk0xznn0d5hrlpn2pqkqpeauwd4lxn33kjha7qgz4g9uzme8aakpehdzgel76lne3sswk6ducu6ygnsh8d87fqah39psqtqweqrf5actfuucvmmlt3k6snksj9dhsgvscj3aa2prf3p386q7p9kzhek7n0aspfmzxpps793pq0kufnlevx9qtyem0tq5g5lym8xt6zcve2kgqe5wv3gf9fcqkmt2z"
+	privKey, err := secp256k1.GeneratePrivateKey()
+	if err != nil {
+		t.Errorf("failed to generate private key %v", err)
+	}
+	pubkey := privKey.PubKey()
+	serializedPubkey := hex.EncodeToString(pubkey.SerializeCompressed())
+
+	for _, test := range []struct {
+		params lnurl.PaymentParams
+		err    string
+	}{
+		{lnurl.PaymentParams{Address: "bc1qw508d6qejxtdg4y5r3zarvary0c5xw7kv8f3t4"}, ""},
+		{lnurl.PaymentParams{Address: "bc1qw508d6qejxtdg4y5r3zarvary0c5xw7kv8f3t5"}, "invalid address"},
+		{lnurl.PaymentParams{Liquid: "lq1notbech32"}, "invalid liquid address"},
+	} {
+		params := test.params
+		messageToSign := fmt.Sprintf("%v-%v-%v-%v-%v-%v-%v", time, url, username, offer, params.Address, params.SilentPayment, params.Liquid)
+		msg := append(lightning.SignedMsgPrefix, []byte(messageToSign)...)
+		first := sha256.Sum256([]byte(msg))
+		second := sha256.Sum256(first[:])
+		sig, err := ecdsa.SignCompact(privKey, second[:], true)
+		if err != nil {
+			t.Errorf("failed to sign signature %v", err)
+		}
+		payRequest := RegisterLnurlPayRequest{
+			Time:        time,
+			WebhookUrl:  url,
+			Username:    &username,
+			Offer:       &offer,
+			Bip21Params: &params,
+			Signature:   zbase32.EncodeToString(sig),
+		}
+		err = payRequest.Verify(serializedPubkey)
+		if test.err == "" {
+			assert.NilError(t, err, "should be valid parameters")
+		} else {
+			assert.ErrorContains(t, err, test.err)
+		}
+	}
+}
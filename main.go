@@ -1,14 +1,23 @@
 package main
 
 import (
+	"context"
+	"encoding/hex"
+	"fmt"
 	"log"
 	"net/url"
 	"os"
+	"strings"
 	"time"
 
+	"github.com/breez/breez-lnurl/bip353"
 	"github.com/breez/breez-lnurl/cache"
 	"github.com/breez/breez-lnurl/dns"
+	"github.com/breez/breez-lnurl/nwc"
 	"github.com/breez/breez-lnurl/persist"
+	persistNwc "github.com/breez/breez-lnurl/persist/nwc"
+	"github.com/nbd-wtf/go-nostr"
+	"github.com/nbd-wtf/go-nostr/nip19"
 )
 
 func main() {
@@ -23,16 +32,63 @@ func main() {
 		log.Fatalf("failed to parse external server URL %v", err)
 	}
 
+	// Prints the DS records of the embedded zone for the parent zone,
+	// generating its DNSSEC keys on first use
+	if len(os.Args) > 1 && os.Args[1] == "dnssec-ds" {
+		signer := dns.NewEmbeddedDns(externalURL, storage.LnUrl, dns.EmbeddedDnsConfig{Keys: storage.Dnssec}).Signer()
+		if err := signer.Init(context.Background()); err != nil {
+			log.Fatalf("failed to initialize the DNSSEC keys: %v", err)
+		}
+		for _, ds := range signer.DS() {
+			fmt.Println(ds)
+		}
+		return
+	}
+
 	dnsService := dns.NewNoDns()
-	if nameServer := os.Getenv("NAME_SERVER"); nameServer != "" {
-		dnsProtocol := os.Getenv("DNS_PROTOCOL")
-		tsigKey := os.Getenv("TSIG_KEY")
-		tsigSecret := os.Getenv("TSIG_SECRET")
-		if len(tsigKey) == 0 || len(tsigSecret) == 0 {
-			log.Fatalf("TSIG_KEY and TSIG_SECRET must be set when using DNS")
+	dnsProviders := os.Getenv("DNS_PROVIDERS")
+	if dnsProviders == "" && os.Getenv("NAME_SERVER") != "" {
+		dnsProviders = "rfc2136"
+	}
+	if dnsProviders != "" {
+		var providers []dns.DnsService
+		for _, name := range strings.Split(dnsProviders, ",") {
+			providers = append(providers, newDnsProvider(externalURL, name))
+		}
+		dnsService = providers[0]
+		if len(providers) > 1 {
+			dnsService = dns.NewMultiDns(providers[0], providers[1:]...)
 		}
+	}
 
-		dnsService = dns.NewDns(externalURL, nameServer, dnsProtocol, tsigKey, tsigSecret)
+	if dnsServerAddress := os.Getenv("DNS_SERVER_ADDRESS"); dnsServerAddress != "" {
+		if dnsProviders != "" {
+			log.Fatalf("DNS_PROVIDERS or NAME_SERVER and DNS_SERVER_ADDRESS can't both be set")
+		}
+		config := dns.EmbeddedDnsConfig{
+			Address:    dnsServerAddress,
+			Hostmaster: os.Getenv("DNS_SERVER_HOSTMASTER"),
+		}
+		if nameServers := os.Getenv("DNS_SERVER_NAME_SERVERS"); nameServers != "" {
+			config.NameServers = strings.Split(nameServers, ",")
+		}
+		if os.Getenv("DNS_SERVER_DNSSEC") == "true" {
+			config.Keys = storage.Dnssec
+		}
+		embeddedDns := dns.NewEmbeddedDns(externalURL, storage.LnUrl, config)
+		if err := embeddedDns.Start(); err != nil {
+			log.Fatalf("failed to start the embedded DNS server: %v", err)
+		}
+		dnsService = embeddedDns
+	}
+	bip353.ProofChainFile = os.Getenv("DNS_SERVER_PROOF_CHAIN_FILE")
+
+	if reconcileInterval := os.Getenv("DNS_RECONCILE_INTERVAL"); reconcileInterval != "" {
+		duration, err := time.ParseDuration(reconcileInterval)
+		if err != nil {
+			log.Fatalf("failed to parse DNS_RECONCILE_INTERVAL %v", err)
+		}
+		bip353.ReconcileInterval = duration
 	}
 
 	internalURL, err := parseURLFromEnv("SERVER_INTERNAL_URL", "http://localhost:8080")
@@ -40,9 +96,107 @@ func main() {
 		log.Fatalf("failed to parse internal server URL %v", err)
 	}
 
+	if maxBackfill := os.Getenv("NWC_MAX_BACKFILL"); maxBackfill != "" {
+		duration, err := time.ParseDuration(maxBackfill)
+		if err != nil {
+			log.Fatalf("failed to parse NWC_MAX_BACKFILL %v", err)
+		}
+		nwc.MaxBackfillDuration = duration
+	}
+
+	if batchWindow := os.Getenv("NWC_BATCH_WINDOW"); batchWindow != "" {
+		duration, err := time.ParseDuration(batchWindow)
+		if err != nil {
+			log.Fatalf("failed to parse NWC_BATCH_WINDOW %v", err)
+		}
+		nwc.BatchWindow = duration
+	}
+
+	var nwcConfig nwc.Config
+	if authKey := os.Getenv("NWC_AUTH_PRIVATE_KEY"); authKey != "" {
+		nwcConfig.AuthPrivateKey, err = parseNostrPrivateKey(authKey)
+		if err != nil {
+			log.Fatalf("failed to parse NWC_AUTH_PRIVATE_KEY %v", err)
+		}
+		authPubkey, _ := nostr.GetPublicKey(nwcConfig.AuthPrivateKey)
+		log.Printf("authenticating to NWC relays as %v", authPubkey)
+	}
+
+	if discoveryRelays := os.Getenv("NWC_DISCOVERY_RELAYS"); discoveryRelays != "" {
+		for _, relay := range strings.Split(discoveryRelays, ",") {
+			normalized, err := persistNwc.NormalizeRelayUrl(relay)
+			if err != nil {
+				log.Fatalf("failed to parse NWC_DISCOVERY_RELAYS %v", err)
+			}
+			nwcConfig.DiscoveryRelays = append(nwcConfig.DiscoveryRelays, normalized)
+		}
+		log.Printf("discovering NWC app relays from %v", nwcConfig.DiscoveryRelays)
+	}
+
 	cacheService := cache.NewCache(time.Minute)
 
-	NewServer(internalURL, externalURL, storage, dnsService, cacheService).Serve()
+	config := ServerConfig{
+		AdminToken:    os.Getenv("ADMIN_TOKEN"),
+		Nwc:           nwcConfig,
+		EmbeddedRelay: os.Getenv("NWC_EMBEDDED_RELAY") == "true",
+	}
+
+	NewServer(context.Background(), internalURL, externalURL, storage, dnsService, cacheService, config).Serve()
+}
+
+// newDnsProvider returns the DNS provider of the name, configured from its
+// environment variables.
+func newDnsProvider(externalURL *url.URL, name string) dns.DnsService {
+	switch strings.TrimSpace(name) {
+	case "rfc2136":
+		nameServers := os.Getenv("NAME_SERVER")
+		config := dns.DnsConfig{
+			Protocol:      os.Getenv("DNS_PROTOCOL"),
+			TsigKey:       os.Getenv("TSIG_KEY"),
+			TsigSecret:    os.Getenv("TSIG_SECRET"),
+			TsigAlgorithm: os.Getenv("TSIG_ALGORITHM"),
+		}
+		if len(nameServers) == 0 {
+			log.Fatalf("NAME_SERVER must be set when using the rfc2136 DNS provider")
+		}
+		config.NameServers = strings.Split(nameServers, ",")
+		if len(config.TsigKey) == 0 || len(config.TsigSecret) == 0 {
+			log.Fatalf("TSIG_KEY and TSIG_SECRET must be set when using DNS")
+		}
+		if dnsTimeout := os.Getenv("DNS_TIMEOUT"); dnsTimeout != "" {
+			duration, err := time.ParseDuration(dnsTimeout)
+			if err != nil {
+				log.Fatalf("failed to parse DNS_TIMEOUT %v", err)
+			}
+			dns.DnsTimeout = duration
+		}
+		dnsService, err := dns.NewDns(externalURL, config)
+		if err != nil {
+			log.Fatalf("failed to create the rfc2136 DNS provider: %v", err)
+		}
+		return dnsService
+	case "powerdns":
+		config := dns.PowerDnsConfig{
+			ApiUrl:   os.Getenv("POWERDNS_API_URL"),
+			ApiKey:   os.Getenv("POWERDNS_API_KEY"),
+			ServerId: os.Getenv("POWERDNS_SERVER_ID"),
+		}
+		if len(config.ApiUrl) == 0 || len(config.ApiKey) == 0 {
+			log.Fatalf("POWERDNS_API_URL and POWERDNS_API_KEY must be set when using the powerdns DNS provider")
+		}
+		return dns.NewPowerDns(externalURL, config)
+	case "rest":
+		config := dns.RestDnsConfig{
+			Url:   os.Getenv("DNS_REST_URL"),
+			Token: os.Getenv("DNS_REST_TOKEN"),
+		}
+		if len(config.Url) == 0 {
+			log.Fatalf("DNS_REST_URL must be set when using the rest DNS provider")
+		}
+		return dns.NewRestDns(externalURL, config)
+	}
+	log.Fatalf("unknown DNS provider %v in DNS_PROVIDERS", name)
+	return nil
 }
 
 func parseURLFromEnv(envKey string, defaultURL string) (*url.URL, error) {
@@ -52,3 +206,22 @@ func parseURLFromEnv(envKey string, defaultURL string) (*url.URL, error) {
 	}
 	return url.Parse(serverURLStr)
 }
+
+// parseNostrPrivateKey accepts a hex or nsec encoded Nostr private key and
+// returns it hex encoded.
+func parseNostrPrivateKey(key string) (string, error) {
+	if strings.HasPrefix(key, "nsec") {
+		prefix, value, err := nip19.Decode(key)
+		if err != nil {
+			return "", err
+		}
+		if prefix != "nsec" {
+			return "", fmt.Errorf("invalid nsec key")
+		}
+		key = value.(string)
+	}
+	if decoded, err := hex.DecodeString(key); err != nil || len(decoded) != 32 {
+		return "", fmt.Errorf("invalid private key")
+	}
+	return key, nil
+}
@@ -0,0 +1,68 @@
+package nwc
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/nbd-wtf/go-nostr"
+)
+
+// The number of attempts to authenticate to a relay. Relays send their
+// challenge right after connecting, so early attempts may miss it.
+var AuthAttempts = 3
+
+// The delay between attempts to authenticate to a relay
+var AuthRetryInterval time.Duration = 500 * time.Millisecond
+
+// The reason prefix of CLOSED messages from relays requiring NIP-42 authentication
+const authRequiredPrefix = "auth-required:"
+
+var errAuthRequired = errors.New("relay requires authentication")
+
+// authenticate answers the NIP-42 challenge of the relay with the server key,
+// unless already authenticated on the current connection.
+func (nm *NostrManager) authenticate(ctx context.Context, relay *nostr.Relay, health *relayHealth) error {
+	if health.isAuthenticated(relay) {
+		return nil
+	}
+	if nm.config.AuthPrivateKey == "" {
+		err := fmt.Errorf("no auth key configured")
+		log.Printf("failed to authenticate to relay %v: %v", relay.URL, err)
+		health.authFailed(err)
+		return err
+	}
+
+	var err error
+	for attempt := 0; attempt < AuthAttempts; attempt++ {
+		if attempt > 0 {
+			select {
+			case <-time.After(AuthRetryInterval):
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+		}
+		err = relay.Auth(ctx, func(event *nostr.Event) error {
+			return event.Sign(nm.config.AuthPrivateKey)
+		})
+		if err == nil {
+			log.Printf("authenticated to relay %v", relay.URL)
+			health.authenticated(relay)
+			return nil
+		}
+	}
+
+	log.Printf("failed to authenticate to relay %v: %v", relay.URL, err)
+	health.authFailed(err)
+	return err
+}
+
+func closedError(reason string) error {
+	if strings.HasPrefix(reason, authRequiredPrefix) {
+		return fmt.Errorf("%w: %v", errAuthRequired, reason)
+	}
+	return fmt.Errorf("closed by relay: %v", reason)
+}
@@ -0,0 +1,124 @@
+package nwc
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/breez/breez-lnurl/persist"
+	"github.com/gobwas/ws"
+	"github.com/gobwas/ws/wsutil"
+	"github.com/nbd-wtf/go-nostr"
+	"gotest.tools/assert"
+)
+
+// authRelay is a fake relay closing the subscriptions of the unauthenticated
+// connections with auth-required, and rejecting the first AUTH attempt.
+type authRelay struct {
+	mu         sync.Mutex
+	authEvents []nostr.Event
+	closed     int
+	subscribed chan string
+}
+
+func (r *authRelay) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	if req.Header.Get("Upgrade") == "" {
+		http.NotFound(w, req)
+		return
+	}
+	conn, _, _, err := ws.UpgradeHTTP(req, w)
+	if err != nil {
+		return
+	}
+	defer conn.Close()
+
+	challenge := "challenge"
+	send := func(envelope nostr.Envelope) {
+		message, _ := envelope.MarshalJSON()
+		wsutil.WriteServerText(conn, message)
+	}
+
+	authenticated := false
+	for {
+		message, err := wsutil.ReadClientText(conn)
+		if err != nil {
+			return
+		}
+		switch envelope := nostr.ParseMessage(message).(type) {
+		case *nostr.AuthEnvelope:
+			r.mu.Lock()
+			r.authEvents = append(r.authEvents, envelope.Event)
+			attempt := len(r.authEvents)
+			r.mu.Unlock()
+			ok, _ := envelope.Event.CheckSignature()
+			tag := envelope.Event.Tags.GetFirst([]string{"challenge", challenge})
+			if attempt == 1 || !ok || tag == nil {
+				send(&nostr.OKEnvelope{EventID: envelope.Event.ID, OK: false, Reason: "restricted: try again"})
+				continue
+			}
+			authenticated = true
+			send(&nostr.OKEnvelope{EventID: envelope.Event.ID, OK: true})
+		case *nostr.ReqEnvelope:
+			if !authenticated {
+				r.mu.Lock()
+				r.closed++
+				r.mu.Unlock()
+				// The challenge is sent along, as messages sent right after
+				// connecting may be missed by the client
+				send(&nostr.AuthEnvelope{Challenge: &challenge})
+				send(&nostr.ClosedEnvelope{SubscriptionID: envelope.SubscriptionID, Reason: "auth-required: restricted relay"})
+				continue
+			}
+			eose := nostr.EOSEEnvelope(envelope.SubscriptionID)
+			send(&eose)
+			r.subscribed <- envelope.SubscriptionID
+		}
+	}
+}
+
+func TestAuthenticateToRestrictedRelay(t *testing.T) {
+	reconnectInterval, authRetryInterval := RelayReconnectInterval, AuthRetryInterval
+	RelayReconnectInterval, AuthRetryInterval = 10*time.Millisecond, 10*time.Millisecond
+	t.Cleanup(func() { RelayReconnectInterval, AuthRetryInterval = reconnectInterval, authRetryInterval })
+
+	relay := &authRelay{subscribed: make(chan string, 1)}
+	server := httptest.NewServer(relay)
+	t.Cleanup(server.Close)
+	url := "ws" + strings.TrimPrefix(server.URL, "http")
+
+	authKey := nostr.GeneratePrivateKey()
+	authPubkey, err := nostr.GetPublicKey(authKey)
+	assert.NilError(t, err)
+	nm := newTestManager(t, persist.NewMemoryStore())
+	nm.config.AuthPrivateKey = authKey
+	nm.pool = nostr.NewSimplePool(nm.ctx)
+
+	nm.mu.Lock()
+	health := nm.relayHealth(url)
+	nm.mu.Unlock()
+	sub := nm.startRelaySubscription(url, relayTarget{authors: testAuthors(1)}, health)
+	defer sub.cancel()
+
+	select {
+	case <-relay.subscribed:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("not subscribed after authenticating")
+	}
+
+	relay.mu.Lock()
+	defer relay.mu.Unlock()
+	assert.Equal(t, relay.closed, 1, "the subscription is closed until authenticated")
+	assert.Equal(t, len(relay.authEvents), 2, "the rejected AUTH is retried")
+	for _, event := range relay.authEvents {
+		assert.Equal(t, event.Kind, nostr.KindClientAuthentication)
+		assert.Equal(t, event.PubKey, authPubkey)
+	}
+
+	state := health.snapshot()
+	assert.Assert(t, state.AuthRequired)
+	assert.Assert(t, state.Authenticated)
+	assert.Assert(t, state.Reconnects >= 1)
+}
@@ -0,0 +1,174 @@
+package nwc
+
+import (
+	"log"
+	"time"
+
+	"github.com/breez/breez-lnurl/channel"
+	nwc "github.com/breez/breez-lnurl/persist/nwc"
+)
+
+// The time events for the same registration are collected before being
+// delivered together. The first event is delivered right away, so the app
+// still wakes up immediately. Zero delivers every event on its own.
+var BatchWindow time.Duration = 300 * time.Millisecond
+
+// eventBatch collects the events received for a registration while its
+// batching window is open, or while its notification policy holds them.
+type eventBatch struct {
+	// The registration as of the last event, so its latest devices are notified
+	webhook  *nwc.Webhook
+	eventIds []string
+	// Whether the events are held until the policy allows waking the devices
+	held bool
+}
+
+// deliver notifies the webhook of the event, batching it with the other
+// events of the registration if a window is open. Events arriving when the
+// notification policy doesn't allow waking the devices are held or dropped.
+func (nm *NostrManager) deliver(webhook *nwc.Webhook, event relayEvent) {
+	go func() {
+		if err := nm.store.Nwc.MarkEventReceived(nm.ctx, webhook.WalletServicePubkey, webhook.AppPubkey); err != nil {
+			log.Printf("failed to mark event %v as received: %v", event.ID, err)
+		}
+	}()
+
+	key := webhook.WalletServicePubkey + ":" + webhook.AppPubkey
+	nm.mu.Lock()
+	if batch, ok := nm.batches[key]; ok {
+		batch.webhook = webhook
+		batch.eventIds = append(batch.eventIds, event.ID)
+		held := batch.held
+		nm.mu.Unlock()
+		if held {
+			nm.suppressed(webhook, 1)
+		}
+		return
+	}
+
+	now := time.Now()
+	wakeAt, allowed := nm.allowWake(key, webhook.Policy, now)
+	if !allowed {
+		if webhook.Policy.Suppression != nwc.SuppressionDrop {
+			nm.batches[key] = &eventBatch{webhook: webhook, eventIds: []string{event.ID}, held: true}
+			go nm.runBatchWindow(key, wakeAt.Sub(now))
+		}
+		nm.mu.Unlock()
+		log.Printf("notification policy of %v suppressed event %v", webhook.AppPubkey, event.ID)
+		nm.suppressed(webhook, 1)
+		return
+	}
+	if BatchWindow > 0 {
+		nm.batches[key] = &eventBatch{webhook: webhook}
+		go nm.runBatchWindow(key, BatchWindow)
+	}
+	nm.mu.Unlock()
+
+	go nm.sendToDevices(webhook, []string{event.ID}, func(device nwc.Device) channel.WebhookMessage {
+		return eventMessage(webhook, device, event)
+	})
+}
+
+// runBatchWindow delivers the events collected for the registration at the
+// end of each window, until a window ends without events. The window is
+// extended while the notification policy doesn't allow waking the devices.
+func (nm *NostrManager) runBatchWindow(key string, wait time.Duration) {
+	for {
+		select {
+		case <-time.After(wait):
+		case <-nm.ctx.Done():
+			nm.dropHeld(key)
+			return
+		}
+
+		nm.mu.Lock()
+		batch := nm.batches[key]
+		if len(batch.eventIds) == 0 {
+			delete(nm.batches, key)
+			nm.mu.Unlock()
+			return
+		}
+		webhook, eventIds := batch.webhook, batch.eventIds
+
+		now := time.Now()
+		wakeAt, allowed := nm.allowWake(key, webhook.Policy, now)
+		if !allowed {
+			// The events collected during the window weren't counted yet
+			count := len(eventIds)
+			if batch.held {
+				count = 0
+			}
+			if webhook.Policy.Suppression == nwc.SuppressionDrop {
+				count = len(eventIds)
+				batch.eventIds = nil
+				wait = BatchWindow
+			} else {
+				batch.held = true
+				wait = wakeAt.Sub(now)
+			}
+			nm.mu.Unlock()
+			if count > 0 {
+				nm.suppressed(webhook, count)
+			}
+			continue
+		}
+		batch.eventIds = nil
+		batch.held = false
+		wait = BatchWindow
+		nm.mu.Unlock()
+
+		go nm.sendToDevices(webhook, eventIds, func(nwc.Device) channel.WebhookMessage {
+			return batchMessage(eventIds)
+		})
+	}
+}
+
+func batchMessage(eventIds []string) channel.WebhookMessage {
+	return channel.WebhookMessage{
+		Template: "nwc_events",
+		Data: map[string]any{
+			"event_ids": eventIds,
+		},
+	}
+}
+
+// sendToDevices posts the message built for each device to all the devices
+// of the registration or, in the priority delivery, to each device in turn
+// until one accepts it.
+func (nm *NostrManager) sendToDevices(webhook *nwc.Webhook, eventIds []string, message func(device nwc.Device) channel.WebhookMessage) {
+	if webhook.Delivery == nwc.DeliveryPriority {
+		for _, device := range webhook.Devices {
+			if err := nm.send(device.Url, webhook.WalletServicePubkey, webhook.AppPubkey, eventIds, message(device)); err == nil {
+				return
+			}
+		}
+		return
+	}
+	for _, device := range webhook.Devices {
+		go nm.send(device.Url, webhook.WalletServicePubkey, webhook.AppPubkey, eventIds, message(device))
+	}
+}
+
+// send posts the message to the webhook and marks the events it notifies as forwarded.
+func (nm *NostrManager) send(url string, walletServicePubkey string, appPubkey string, eventIds []string, message channel.WebhookMessage) error {
+	log.Printf("forwarding events %v to notify service", eventIds)
+	if err := nm.SendRequest(nm.ctx, url, message); err != nil {
+		log.Printf("failed to send webhook message for events %v: %v", eventIds, err)
+		// Recorded so the app can replay the events it missed
+		for _, id := range eventIds {
+			if err := nm.store.Nwc.MarkEventFailed(nm.ctx, id, walletServicePubkey, appPubkey, url); err != nil {
+				log.Printf("failed to mark event %v as failed: %v", id, err)
+			}
+		}
+		return err
+	}
+	log.Printf("successfully forwarded events %v", eventIds)
+
+	// Mark events as forwarded after successful delivery
+	for _, id := range eventIds {
+		if err := nm.store.Nwc.MarkEventForwarded(nm.ctx, id, walletServicePubkey, appPubkey, url); err != nil {
+			log.Printf("failed to mark event %v as forwarded: %v", id, err)
+		}
+	}
+	return nil
+}
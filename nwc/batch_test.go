@@ -0,0 +1,103 @@
+package nwc
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/breez/breez-lnurl/channel"
+	"github.com/breez/breez-lnurl/persist"
+	nwc "github.com/breez/breez-lnurl/persist/nwc"
+	"github.com/nbd-wtf/go-nostr"
+	"gotest.tools/assert"
+)
+
+func TestDeliverBatchesBursts(t *testing.T) {
+	messages := make(chan channel.WebhookMessage, 10)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var message channel.WebhookMessage
+		json.NewDecoder(r.Body).Decode(&message)
+		messages <- message
+	}))
+	defer server.Close()
+
+	window := BatchWindow
+	BatchWindow = 100 * time.Millisecond
+	defer func() { BatchWindow = window }()
+
+	nm := NewNostrManager(persist.NewMemoryStore(), Config{})
+	nm.ctx, nm.cancel = context.WithCancel(context.Background())
+	defer nm.cancel()
+
+	webhook := &nwc.Webhook{
+		WalletServicePubkey: strings.Repeat("a", 64),
+		AppPubkey:           strings.Repeat("b", 64),
+		Devices:             []nwc.Device{{Url: server.URL}},
+	}
+	for i := 0; i < 3; i++ {
+		nm.deliver(webhook, relayEvent{Event: &nostr.Event{ID: fmt.Sprintf("%064x", i)}})
+	}
+
+	first := <-messages
+	assert.Equal(t, first.Template, "nwc_event", "the first event should be delivered right away")
+	assert.Equal(t, first.Data["event_id"], fmt.Sprintf("%064x", 0))
+
+	batch := <-messages
+	assert.Equal(t, batch.Template, "nwc_events")
+	assert.DeepEqual(t, batch.Data["event_ids"], []any{fmt.Sprintf("%064x", 1), fmt.Sprintf("%064x", 2)})
+
+	// The window closes once it ends without events
+	time.Sleep(3 * BatchWindow)
+	nm.mu.RLock()
+	assert.Equal(t, len(nm.batches), 0)
+	nm.mu.RUnlock()
+}
+
+func TestDeliverPriorityFallsBack(t *testing.T) {
+	failing := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusGone)
+	}))
+	defer failing.Close()
+	messages := make(chan string, 10)
+	handler := func(name string) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			messages <- name
+		}
+	}
+	second := httptest.NewServer(handler("second"))
+	defer second.Close()
+	third := httptest.NewServer(handler("third"))
+	defer third.Close()
+
+	window := BatchWindow
+	BatchWindow = 0
+	defer func() { BatchWindow = window }()
+
+	nm := NewNostrManager(persist.NewMemoryStore(), Config{})
+	nm.ctx, nm.cancel = context.WithCancel(context.Background())
+	defer nm.cancel()
+
+	webhook := &nwc.Webhook{
+		WalletServicePubkey: strings.Repeat("a", 64),
+		AppPubkey:           strings.Repeat("b", 64),
+		Delivery:            nwc.DeliveryPriority,
+		Devices: []nwc.Device{
+			{Id: "first", Url: failing.URL},
+			{Id: "second", Url: second.URL, Priority: 1},
+			{Id: "third", Url: third.URL, Priority: 2},
+		},
+	}
+	nm.deliver(webhook, relayEvent{Event: &nostr.Event{ID: strings.Repeat("c", 64)}})
+
+	assert.Equal(t, <-messages, "second")
+	select {
+	case name := <-messages:
+		t.Fatalf("expected a single delivery, also delivered to %v", name)
+	case <-time.After(100 * time.Millisecond):
+	}
+}
@@ -0,0 +1,186 @@
+package nwc
+
+import (
+	"context"
+	"log"
+	"slices"
+	"time"
+
+	nwc "github.com/breez/breez-lnurl/persist/nwc"
+	"github.com/nbd-wtf/go-nostr"
+)
+
+// The NIP-65 relay list metadata kind
+const relayListKind = 10002
+
+// The time after which the relay list of an app pubkey is fetched again
+var DiscoveryRefreshInterval time.Duration = time.Hour
+
+// The interval to look for app pubkeys whose relay list is due
+var DiscoveryCheckInterval time.Duration = time.Minute
+
+// The time to wait for the bootstrap relays to return the relay lists
+var DiscoveryTimeout time.Duration = 15 * time.Second
+
+// The maximum number of app pubkeys queried in a single filter
+var MaxDiscoveryAuthors = 100
+
+// The maximum number of write relays subscribed to for an app pubkey
+var MaxDiscoveredRelays = 5
+
+// discoveredRelays are the write relays an app pubkey advertises in its
+// relay list.
+type discoveredRelays struct {
+	relays []string
+	// The created_at of the relay list, so older lists don't replace it
+	createdAt nostr.Timestamp
+	fetchedAt time.Time
+}
+
+// StartDiscoveryLoop fetches the relay lists of the registered app pubkeys
+// from the bootstrap relays while leading, so their write relays are
+// subscribed to along with the registered ones.
+func (nm *NostrManager) StartDiscoveryLoop(ctx context.Context) {
+	for {
+		nm.discoverRelays(ctx)
+		select {
+		case <-time.After(DiscoveryCheckInterval):
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+func (nm *NostrManager) discoverRelays(ctx context.Context) {
+	due := nm.discoveryDue(time.Now())
+	if len(due) == 0 {
+		return
+	}
+
+	changed := false
+	for chunk := range slices.Chunk(due, MaxDiscoveryAuthors) {
+		lists, err := nm.fetchRelayLists(ctx, chunk)
+		if err != nil {
+			log.Printf("failed to fetch relay lists: %v", err)
+			return
+		}
+		if nm.setDiscoveredRelays(chunk, lists, time.Now()) {
+			changed = true
+		}
+	}
+	if changed {
+		nm.notifyChanged()
+	}
+}
+
+// discoveryDue returns the registered app pubkeys whose relay list is due,
+// and forgets the relay lists of the app pubkeys no longer registered.
+func (nm *NostrManager) discoveryDue(now time.Time) []string {
+	nm.mu.Lock()
+	defer nm.mu.Unlock()
+	registered := make(map[string]struct{}, len(nm.registrations))
+	var due []string
+	for _, registration := range nm.registrations {
+		if _, ok := registered[registration.AppPubkey]; ok {
+			continue
+		}
+		registered[registration.AppPubkey] = struct{}{}
+		discovered, ok := nm.discovered[registration.AppPubkey]
+		if !ok || now.Sub(discovered.fetchedAt) >= DiscoveryRefreshInterval {
+			due = append(due, registration.AppPubkey)
+		}
+	}
+	for appPubkey := range nm.discovered {
+		if _, ok := registered[appPubkey]; !ok {
+			delete(nm.discovered, appPubkey)
+		}
+	}
+	slices.Sort(due)
+	return due
+}
+
+// fetchRelayLists returns the latest relay list of each of the app pubkeys
+// found on the bootstrap relays.
+func (nm *NostrManager) fetchRelayLists(ctx context.Context, authors []string) (map[string]*nostr.Event, error) {
+	queryCtx, cancel := context.WithTimeout(ctx, DiscoveryTimeout)
+	defer cancel()
+
+	lists := make(map[string]*nostr.Event)
+	filters := nostr.Filters{{Kinds: []int{relayListKind}, Authors: authors}}
+	for incoming := range nm.pool.SubManyEose(queryCtx, nm.config.DiscoveryRelays, filters) {
+		event := incoming.Event
+		if ok, err := event.CheckSignature(); err != nil || !ok {
+			continue
+		}
+		if latest, ok := lists[event.PubKey]; !ok || event.CreatedAt > latest.CreatedAt {
+			lists[event.PubKey] = event
+		}
+	}
+	// The lists returned before the bootstrap relays timed out are kept
+	return lists, ctx.Err()
+}
+
+// setDiscoveredRelays records the fetched relay lists and returns whether
+// the discovered relays changed.
+func (nm *NostrManager) setDiscoveredRelays(authors []string, lists map[string]*nostr.Event, now time.Time) bool {
+	nm.mu.Lock()
+	defer nm.mu.Unlock()
+	changed := false
+	for _, appPubkey := range authors {
+		discovered := nm.discovered[appPubkey]
+		event, ok := lists[appPubkey]
+		if !ok || event.CreatedAt < discovered.createdAt {
+			// Keep the relays known so far, a bootstrap relay may be lagging
+			discovered.fetchedAt = now
+			nm.discovered[appPubkey] = discovered
+			continue
+		}
+
+		relays := writeRelays(event)
+		if !slices.Equal(relays, discovered.relays) {
+			log.Printf("discovered relays of app pubkey %v: %v", appPubkey, relays)
+			changed = true
+		}
+		nm.discovered[appPubkey] = discoveredRelays{
+			relays:    relays,
+			createdAt: event.CreatedAt,
+			fetchedAt: now,
+		}
+	}
+	return changed
+}
+
+// writeRelays returns the sorted, normalized write relays of a relay list.
+// Relays without a marker are both read and write relays.
+func writeRelays(event *nostr.Event) []string {
+	var relays []string
+	for _, tag := range event.Tags {
+		if len(tag) < 2 || tag[0] != "r" || (len(tag) > 2 && tag[2] != "write") {
+			continue
+		}
+		relay, err := nwc.NormalizeRelayUrl(tag[1])
+		if err != nil || slices.Contains(relays, relay) {
+			continue
+		}
+		relays = append(relays, relay)
+		if len(relays) == MaxDiscoveredRelays {
+			break
+		}
+	}
+	slices.Sort(relays)
+	return relays
+}
+
+// DiscoveredRelays returns the relays discovered for the app pubkey that
+// were not registered explicitly.
+func (nm *NostrManager) DiscoveredRelays(appPubkey string, registered []string) []string {
+	nm.mu.RLock()
+	defer nm.mu.RUnlock()
+	relays := []string{}
+	for _, relay := range nm.discovered[appPubkey].relays {
+		if !slices.Contains(registered, relay) {
+			relays = append(relays, relay)
+		}
+	}
+	return relays
+}
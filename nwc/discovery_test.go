@@ -0,0 +1,83 @@
+package nwc
+
+import (
+	"context"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/breez/breez-lnurl/persist"
+	nwc "github.com/breez/breez-lnurl/persist/nwc"
+	persistRelay "github.com/breez/breez-lnurl/persist/relay"
+	"github.com/breez/breez-lnurl/relay"
+	"github.com/nbd-wtf/go-nostr"
+	"gotest.tools/assert"
+)
+
+func relayList(t *testing.T, privateKey string, createdAt nostr.Timestamp, tags nostr.Tags) *nostr.Event {
+	event := &nostr.Event{Kind: relayListKind, CreatedAt: createdAt, Tags: tags}
+	if err := event.Sign(privateKey); err != nil {
+		t.Fatalf("failed to sign relay list: %v", err)
+	}
+	return event
+}
+
+func TestWriteRelays(t *testing.T) {
+	event := relayList(t, nostr.GeneratePrivateKey(), nostr.Now(), nostr.Tags{
+		{"r", "wss://write.example.com/", "write"},
+		{"r", "wss://read.example.com", "read"},
+		{"r", "Relay.Example.com"},
+		{"r", "ftp://invalid.example.com"},
+		{"r", "wss://write.example.com"},
+		{"p", "wss://other.example.com"},
+	})
+	assert.DeepEqual(t, writeRelays(event), []string{"wss://relay.example.com", "wss://write.example.com"})
+}
+
+func TestDiscoverRelays(t *testing.T) {
+	store := persistRelay.NewMemoryStore()
+	server := httptest.NewServer(relay.NewRelay(store))
+	defer server.Close()
+
+	privateKey := nostr.GeneratePrivateKey()
+	appPubkey, _ := nostr.GetPublicKey(privateKey)
+	ctx := context.Background()
+	// Saved straight to the store, as the relay only accepts NIP-47 events
+	for _, event := range []*nostr.Event{
+		relayList(t, privateKey, nostr.Now()-10, nostr.Tags{{"r", "wss://old.example.com"}}),
+		relayList(t, privateKey, nostr.Now(), nostr.Tags{{"r", "wss://registered.example.com"}, {"r", "wss://new.example.com"}}),
+	} {
+		_, err := store.Save(ctx, event)
+		assert.NilError(t, err)
+	}
+
+	nm := NewNostrManager(persist.NewMemoryStore(), Config{
+		DiscoveryRelays: []string{"ws" + strings.TrimPrefix(server.URL, "http")},
+	})
+	nm.ctx, nm.cancel = context.WithCancel(ctx)
+	defer nm.cancel()
+	nm.pool = nostr.NewSimplePool(nm.ctx)
+	nm.registrations[registrationKey(strings.Repeat("a", 64), appPubkey)] = nwc.Webhook{
+		WalletServicePubkey: strings.Repeat("a", 64),
+		AppPubkey:           appPubkey,
+		Relays:              []string{"wss://registered.example.com"},
+	}
+
+	nm.discoverRelays(nm.ctx)
+	assert.DeepEqual(t, nm.DiscoveredRelays(appPubkey, []string{"wss://registered.example.com"}), []string{"wss://new.example.com"})
+	targets := nm.subscriptionTargets()
+	assert.DeepEqual(t, targets["wss://new.example.com"].authors, []string{appPubkey})
+	assert.DeepEqual(t, targets["wss://registered.example.com"].authors, []string{appPubkey})
+	assert.Equal(t, len(targets), 2)
+	select {
+	case <-nm.changed:
+	default:
+		t.Fatalf("discovered relays should trigger a resubscription")
+	}
+
+	// Relay lists are fetched again once due, and dropped with the registration
+	assert.Equal(t, len(nm.discoveryDue(nm.discovered[appPubkey].fetchedAt)), 0)
+	clear(nm.registrations)
+	nm.discoveryDue(nm.discovered[appPubkey].fetchedAt)
+	assert.Equal(t, len(nm.discovered), 0)
+}
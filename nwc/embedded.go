@@ -0,0 +1,107 @@
+package nwc
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"slices"
+	"time"
+
+	"github.com/nbd-wtf/go-nostr"
+)
+
+// The maximum number of stored requests forwarded when the embedded relay
+// feed is (re)started
+var MaxEmbeddedBackfillEvents = 1000
+
+// StartEmbeddedRelayLoop forwards the requests published to the embedded
+// relay, read straight from its store rather than over a relay connection,
+// until the context is done.
+func (nm *NostrManager) StartEmbeddedRelayLoop(ctx context.Context) {
+	url := nm.config.EmbeddedRelayUrl
+	nm.mu.Lock()
+	health := nm.relayHealth(url)
+	nm.mu.Unlock()
+	for {
+		events, err := nm.store.Relay.Listen(ctx)
+		if err != nil {
+			log.Printf("failed to listen to embedded relay events: %v", err)
+			health.failed(err)
+		} else {
+			nm.mu.RLock()
+			health.subscribed(len(nm.embeddedAuthors), 0, 1)
+			nm.mu.RUnlock()
+			// Forward the requests published while not listening
+			nm.backfillEmbeddedRelay(ctx)
+			health.eose()
+			for event := range events {
+				if event.Kind != requestKind || !nm.isEmbeddedAuthor(event.PubKey) {
+					continue
+				}
+				health.event()
+				if !nm.forwardEmbeddedEvent(ctx, event) {
+					break
+				}
+			}
+			if ctx.Err() == nil {
+				health.failed(fmt.Errorf("embedded relay feed interrupted"))
+			}
+		}
+		select {
+		case <-time.After(ListenRetryInterval):
+			health.reconnecting()
+		case <-ctx.Done():
+			health.failed(errNotLeading)
+			return
+		}
+	}
+}
+
+func (nm *NostrManager) backfillEmbeddedRelay(ctx context.Context) {
+	nm.mu.RLock()
+	authors := nm.embeddedAuthors
+	nm.mu.RUnlock()
+	if len(authors) == 0 {
+		return
+	}
+
+	url := nm.config.EmbeddedRelayUrl
+	events, err := nm.store.Relay.Query(ctx, nostr.Filter{
+		Kinds:   []int{requestKind},
+		Authors: authors,
+		Since:   nm.since(ctx, url),
+	}, MaxEmbeddedBackfillEvents)
+	if err != nil {
+		log.Printf("failed to query embedded relay events: %v", err)
+		return
+	}
+
+	// Oldest first, as they would be received from a relay
+	slices.Reverse(events)
+	for _, event := range events {
+		if !nm.forwardEmbeddedEvent(ctx, event) {
+			return
+		}
+	}
+}
+
+func (nm *NostrManager) forwardEmbeddedEvent(ctx context.Context, event *nostr.Event) bool {
+	select {
+	case nm.events <- relayEvent{Event: event, relayUrl: nm.config.EmbeddedRelayUrl}:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+// isEmbeddedAuthor returns whether the app pubkey is registered on the embedded relay.
+func (nm *NostrManager) isEmbeddedAuthor(appPubkey string) bool {
+	nm.mu.RLock()
+	defer nm.mu.RUnlock()
+	_, found := slices.BinarySearch(nm.embeddedAuthors, appPubkey)
+	return found
+}
+
+func (nm *NostrManager) isEmbeddedRelay(url string) bool {
+	return nm.config.EmbeddedRelayUrl != "" && url == nm.config.EmbeddedRelayUrl
+}
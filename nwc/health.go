@@ -0,0 +1,166 @@
+package nwc
+
+import (
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	nwc "github.com/breez/breez-lnurl/persist/nwc"
+	"github.com/nbd-wtf/go-nostr"
+)
+
+// RelayHealth is a snapshot of the state of the subscriptions to a relay.
+type RelayHealth struct {
+	Url                string     `json:"url"`
+	Connected          bool       `json:"connected"`
+	AppPubkeys         int        `json:"appPubkeys"`
+	UnscheduledPubkeys int        `json:"unscheduledPubkeys"`
+	Subscriptions      int        `json:"subscriptions"`
+	ConnectedSince     *time.Time `json:"connectedSince,omitempty"`
+	LastEose           *time.Time `json:"lastEose,omitempty"`
+	LastEvent          *time.Time `json:"lastEvent,omitempty"`
+	Reconnects         int        `json:"reconnects"`
+	LastError          string     `json:"lastError,omitempty"`
+	LastErrorAt        *time.Time `json:"lastErrorAt,omitempty"`
+	AuthRequired       bool       `json:"authRequired"`
+	Authenticated      bool       `json:"authenticated"`
+	LastAuthError      string     `json:"lastAuthError,omitempty"`
+}
+
+// relayHealth tracks the state of a relay across resubscriptions.
+type relayHealth struct {
+	mu    sync.Mutex
+	state RelayHealth
+	// The connection authenticated on, to authenticate again after reconnecting
+	authenticatedRelay *nostr.Relay
+}
+
+func (h *relayHealth) update(f func(state *RelayHealth)) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	f(&h.state)
+}
+
+func (h *relayHealth) snapshot() RelayHealth {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.state
+}
+
+func (h *relayHealth) subscribed(appPubkeys int, unscheduled int, subscriptions int) {
+	now := time.Now()
+	h.update(func(state *RelayHealth) {
+		state.Connected = true
+		state.ConnectedSince = &now
+		state.AppPubkeys = appPubkeys
+		state.UnscheduledPubkeys = unscheduled
+		state.Subscriptions = subscriptions
+	})
+}
+
+func (h *relayHealth) failed(err error) {
+	now := time.Now()
+	h.update(func(state *RelayHealth) {
+		state.Connected = false
+		state.ConnectedSince = nil
+		state.Subscriptions = 0
+		state.LastError = err.Error()
+		state.LastErrorAt = &now
+	})
+}
+
+func (h *relayHealth) requireAuth() {
+	h.update(func(state *RelayHealth) {
+		state.AuthRequired = true
+	})
+}
+
+func (h *relayHealth) authRequired() bool {
+	return h.snapshot().AuthRequired
+}
+
+func (h *relayHealth) authenticated(relay *nostr.Relay) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.authenticatedRelay = relay
+	h.state.Authenticated = true
+	h.state.LastAuthError = ""
+}
+
+func (h *relayHealth) isAuthenticated(relay *nostr.Relay) bool {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.authenticatedRelay == relay && h.state.Authenticated
+}
+
+func (h *relayHealth) authFailed(err error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.authenticatedRelay = nil
+	h.state.Authenticated = false
+	h.state.LastAuthError = err.Error()
+}
+
+func (h *relayHealth) reconnecting() {
+	h.update(func(state *RelayHealth) {
+		state.Reconnects++
+	})
+}
+
+func (h *relayHealth) eose() {
+	now := time.Now()
+	h.update(func(state *RelayHealth) {
+		state.LastEose = &now
+	})
+}
+
+func (h *relayHealth) event() {
+	now := time.Now()
+	h.update(func(state *RelayHealth) {
+		state.LastEvent = &now
+	})
+}
+
+// relayHealth returns the health tracker of the relay, creating it if needed.
+// Must be called with the lock held.
+func (nm *NostrManager) relayHealth(url string) *relayHealth {
+	health, ok := nm.health[url]
+	if !ok {
+		health = &relayHealth{state: RelayHealth{Url: url}}
+		nm.health[url] = health
+	}
+	return health
+}
+
+// RelayHealth returns the health of all the relays subscribed to, sorted by url.
+func (nm *NostrManager) RelayHealth() []RelayHealth {
+	nm.mu.RLock()
+	defer nm.mu.RUnlock()
+	result := make([]RelayHealth, 0, len(nm.health))
+	for _, health := range nm.health {
+		result = append(result, health.snapshot())
+	}
+	slices.SortFunc(result, func(a, b RelayHealth) int {
+		return strings.Compare(a.Url, b.Url)
+	})
+	return result
+}
+
+// ConnectedRelays returns the given relays the manager is currently subscribed to.
+func (nm *NostrManager) ConnectedRelays(relays []string) []string {
+	nm.mu.RLock()
+	defer nm.mu.RUnlock()
+	connected := []string{}
+	for _, relay := range relays {
+		normalized, err := nwc.NormalizeRelayUrl(relay)
+		if err != nil {
+			continue
+		}
+		health, ok := nm.health[normalized]
+		if ok && health.snapshot().Connected {
+			connected = append(connected, relay)
+		}
+	}
+	return connected
+}
@@ -0,0 +1,112 @@
+package nwc
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/breez/breez-lnurl/persist"
+	"gotest.tools/assert"
+)
+
+func TestRelayHealthTransitions(t *testing.T) {
+	nm := newTestManager(t, persist.NewMemoryStore())
+	nm.mu.Lock()
+	health := nm.relayHealth("wss://relay.example.com")
+	assert.Equal(t, nm.relayHealth("wss://relay.example.com"), health, "the tracker is kept across resubscriptions")
+	nm.mu.Unlock()
+
+	health.subscribed(3, 1, 2)
+	state := health.snapshot()
+	assert.Assert(t, state.Connected)
+	assert.Assert(t, state.ConnectedSince != nil)
+	assert.Equal(t, state.AppPubkeys, 3)
+	assert.Equal(t, state.UnscheduledPubkeys, 1)
+	assert.Equal(t, state.Subscriptions, 2)
+
+	health.eose()
+	health.event()
+	state = health.snapshot()
+	assert.Assert(t, state.LastEose != nil)
+	assert.Assert(t, state.LastEvent != nil)
+
+	health.failed(errors.New("connection closed"))
+	state = health.snapshot()
+	assert.Assert(t, !state.Connected)
+	assert.Assert(t, state.ConnectedSince == nil)
+	assert.Equal(t, state.Subscriptions, 0)
+	assert.Equal(t, state.LastError, "connection closed")
+	assert.Assert(t, state.LastErrorAt != nil)
+
+	health.reconnecting()
+	health.failed(errors.New("connection refused"))
+	health.reconnecting()
+	health.subscribed(3, 0, 1)
+	state = health.snapshot()
+	assert.Assert(t, state.Connected)
+	assert.Equal(t, state.Reconnects, 2)
+	assert.Equal(t, state.LastError, "connection refused", "the last error is kept once connected again")
+}
+
+func TestReconnectBackoff(t *testing.T) {
+	interval := RelayReconnectInterval
+	var intervals []time.Duration
+	for i := 0; i < 20; i++ {
+		intervals = append(intervals, interval)
+		interval = nextReconnectInterval(interval)
+	}
+	assert.Equal(t, intervals[0], 3*time.Second)
+	assert.Equal(t, intervals[1], 5100*time.Millisecond)
+	for i := 1; i < len(intervals); i++ {
+		assert.Assert(t, intervals[i] > intervals[i-1] || intervals[i] == RelayMaxReconnectInterval)
+	}
+	assert.Equal(t, intervals[len(intervals)-1], RelayMaxReconnectInterval)
+}
+
+func TestRelayHealthEndpoint(t *testing.T) {
+	store := persist.NewMemoryStore()
+	router, nwcRouter := newTestRouter(store)
+	nm := nwcRouter.manager
+	nm.mu.Lock()
+	connected := nm.relayHealth("wss://connected.example.com")
+	failed := nm.relayHealth("wss://failed.example.com")
+	nm.mu.Unlock()
+	connected.subscribed(1, 0, 1)
+	failed.failed(errors.New("connection refused"))
+	failed.reconnecting()
+
+	res := httptest.NewRecorder()
+	router.ServeHTTP(res, httptest.NewRequest(http.MethodGet, "/admin/nwc/relays", nil))
+	assert.Equal(t, res.Code, http.StatusOK)
+	var relays []RelayHealth
+	assert.NilError(t, json.NewDecoder(res.Body).Decode(&relays))
+	assert.Equal(t, len(relays), 2)
+	assert.Equal(t, relays[0].Url, "wss://connected.example.com")
+	assert.Assert(t, relays[0].Connected)
+	assert.Equal(t, relays[0].AppPubkeys, 1)
+	assert.Equal(t, relays[1].Url, "wss://failed.example.com")
+	assert.Assert(t, !relays[1].Connected)
+	assert.Equal(t, relays[1].Reconnects, 1)
+	assert.Equal(t, relays[1].LastError, "connection refused")
+
+	// The registrations list the relays connected to, as registered
+	signer := newTestSigner(t)
+	walletServicePubkey, appPubkey := newTestSigner(t).pubkey, newTestSigner(t).pubkey
+	webhookUrl := "https://example.com/hook"
+	registered := []string{"wss://Connected.example.com/", "wss://failed.example.com", "wss://unknown.example.com"}
+	res = post(t, router, "/nwc/"+signer.pubkey, RegisterNostrEventsRequest{
+		WebhookUrl:          webhookUrl,
+		WalletServicePubkey: walletServicePubkey,
+		AppPubkey:           appPubkey,
+		Relays:              registered,
+		Signature:           signer.sign(t, fmt.Sprintf("%v-%v-%v-%v", webhookUrl, walletServicePubkey, appPubkey, registered)),
+	})
+	assert.Equal(t, res.Code, http.StatusOK)
+	var response RegisterNostrEventsResponse
+	assert.NilError(t, json.NewDecoder(res.Body).Decode(&response))
+	assert.DeepEqual(t, response.ConnectedRelays, []string{"wss://Connected.example.com/"})
+}
@@ -0,0 +1,70 @@
+package nwc
+
+import (
+	"errors"
+	"log"
+	"time"
+
+	nwc "github.com/breez/breez-lnurl/persist/nwc"
+)
+
+// The time to wait before trying to lead again after the leader lock failed
+var LeaderRetryInterval time.Duration = 5 * time.Second
+
+var errNotLeading = errors.New("not leading the subscriptions")
+
+// StartLeaderLoop subscribes to the relays while this instance leads the
+// NWC subscriptions. With several server instances, a single one
+// subscribes and forwards the events, and another takes over when it stops.
+func (nm *NostrManager) StartLeaderLoop() {
+	for {
+		leaderCtx, err := nm.store.Nwc.Lead(nm.ctx, nwc.NwcLeaderLock)
+		if err != nil {
+			if nm.ctx.Err() != nil {
+				return
+			}
+			log.Printf("failed to take the NWC leadership: %v", err)
+			select {
+			case <-time.After(LeaderRetryInterval):
+				continue
+			case <-nm.ctx.Done():
+				return
+			}
+		}
+
+		log.Printf("leading the NWC relay subscriptions")
+		nm.setLeading(true)
+		if nm.config.EmbeddedRelayUrl != "" {
+			go nm.StartEmbeddedRelayLoop(leaderCtx)
+		}
+		if len(nm.config.DiscoveryRelays) > 0 {
+			go nm.StartDiscoveryLoop(leaderCtx)
+		}
+
+		<-leaderCtx.Done()
+		if nm.ctx.Err() != nil {
+			return
+		}
+		log.Printf("lost the NWC leadership, unsubscribing")
+		nm.setLeading(false)
+	}
+}
+
+func (nm *NostrManager) setLeading(leading bool) {
+	nm.mu.Lock()
+	nm.leading = leading
+	if leading {
+		// The cursors may have been advanced by another leader since
+		clear(nm.cursors)
+		clear(nm.dirtyCursors)
+	}
+	nm.mu.Unlock()
+
+	if err := nm.Resubscribe(); err != nil {
+		log.Printf("failed to resubscribe to events: %v", err)
+	}
+	if !leading {
+		// The next leader resumes from the persisted cursors
+		nm.flushCursors(nm.ctx)
+	}
+}
@@ -0,0 +1,91 @@
+package nwc
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/breez/breez-lnurl/persist"
+	nwc "github.com/breez/breez-lnurl/persist/nwc"
+	"github.com/nbd-wtf/go-nostr"
+	"gotest.tools/assert"
+)
+
+// sharedLeaderStore is a memory store shared by several managers, leading
+// one at a time like the Postgres advisory lock.
+type sharedLeaderStore struct {
+	*nwc.MemoryStore
+	lock chan struct{}
+}
+
+func (s *sharedLeaderStore) Lead(ctx context.Context, lock nwc.LeaderLock) (context.Context, error) {
+	select {
+	case s.lock <- struct{}{}:
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+	go func() {
+		<-ctx.Done()
+		<-s.lock
+	}()
+	return ctx, nil
+}
+
+func startTestManager(t *testing.T, store *persist.Store) *NostrManager {
+	nm := newTestManager(t, store)
+	nm.pool = nostr.NewSimplePool(nm.ctx)
+	nm.isRunning = true
+	assert.NilError(t, nm.Reconcile())
+	go nm.StartLeaderLoop()
+	return nm
+}
+
+func (nm *NostrManager) subscribedRelays() []string {
+	nm.mu.RLock()
+	defer nm.mu.RUnlock()
+	var relays []string
+	for url := range nm.subs {
+		relays = append(relays, url)
+	}
+	return relays
+}
+
+func waitFor(t *testing.T, condition func() bool, message string) {
+	deadline := time.Now().Add(5 * time.Second)
+	for !condition() {
+		if time.Now().After(deadline) {
+			t.Fatal(message)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestSingleLeaderSubscribes(t *testing.T) {
+	// Nothing listens on the relay, the subscriptions are retried until canceled
+	const relay = "ws://127.0.0.1:1"
+	shared := &sharedLeaderStore{MemoryStore: nwc.NewMemoryStore(), lock: make(chan struct{}, 1)}
+	assert.NilError(t, shared.Set(context.Background(), testWebhook("app", relay)))
+	newStore := func() *persist.Store {
+		store := persist.NewMemoryStore()
+		store.Nwc = shared
+		return store
+	}
+
+	leader := startTestManager(t, newStore())
+	waitFor(t, func() bool { return len(leader.subscribedRelays()) == 1 }, "the leader did not subscribe")
+	assert.DeepEqual(t, leader.subscribedRelays(), []string{relay})
+
+	follower := startTestManager(t, newStore())
+	time.Sleep(100 * time.Millisecond)
+	follower.mu.RLock()
+	assert.Assert(t, !follower.leading)
+	follower.mu.RUnlock()
+	assert.Equal(t, len(follower.subscribedRelays()), 0, "only the leader subscribes")
+
+	// The follower takes over when the leader stops
+	leader.cancel()
+	waitFor(t, func() bool { return len(follower.subscribedRelays()) == 1 }, "the follower did not take over")
+	follower.mu.RLock()
+	assert.Assert(t, follower.leading)
+	follower.mu.RUnlock()
+}
@@ -0,0 +1,132 @@
+package nwc
+
+import (
+	"context"
+	"expvar"
+	"log"
+	"time"
+
+	"github.com/nbd-wtf/go-nostr"
+	"github.com/nbd-wtf/go-nostr/nip11"
+)
+
+// The maximum number of authors in a single filter, for relays that don't
+// advertise a message length limit
+var MaxAuthorsPerFilter = 500
+
+// The maximum number of filters in a single subscription, for relays that
+// don't advertise a filters limit
+var MaxFiltersPerSubscription = 10
+
+// The interval to refresh the NIP-11 relay information documents
+var RelayInfoRefreshInterval time.Duration = 1 * time.Hour
+
+// The approximate size of a REQ message without its authors and of each
+// quoted, comma separated author
+const (
+	reqMessageOverhead = 128
+	authorMessageSize  = 67
+)
+
+// The length of the shortest subscription ID, a colon and a single digit
+// counter, as go-nostr numbers the subscriptions without a label
+const minSubidLength = 2
+
+// The number of app pubkeys per relay that could not be scheduled within the relay limits
+var unscheduledPubkeys = expvar.NewMap("nwc_unscheduled_pubkeys")
+
+type relayLimits struct {
+	limitation *nip11.RelayLimitationDocument
+	fetchedAt  time.Time
+}
+
+// relayLimitation returns the limits advertised by the relay, fetching its
+// NIP-11 information document if not known or outdated.
+func (nm *NostrManager) relayLimitation(ctx context.Context, url string) *nip11.RelayLimitationDocument {
+	nm.mu.RLock()
+	limits, ok := nm.limits[url]
+	nm.mu.RUnlock()
+	if ok && time.Since(limits.fetchedAt) < RelayInfoRefreshInterval {
+		return limits.limitation
+	}
+
+	info, err := nip11.Fetch(ctx, url)
+	if err != nil {
+		log.Printf("failed to fetch relay information for %v: %v", url, err)
+		if ok {
+			return limits.limitation
+		}
+		// Retry on the next subscription, assuming no limits until then
+		return nil
+	}
+
+	nm.mu.Lock()
+	nm.limits[url] = relayLimits{
+		limitation: info.Limitation,
+		fetchedAt:  time.Now(),
+	}
+	nm.mu.Unlock()
+	return info.Limitation
+}
+
+// chunkAuthors splits the authors into subscriptions of filters that fit the
+// relay limitation. It also returns the number of authors that did not fit.
+func chunkAuthors(authors []string, limitation *nip11.RelayLimitationDocument) ([]nostr.Filters, int) {
+	if limitation != nil && limitation.MaxSubidLength > 0 && limitation.MaxSubidLength < minSubidLength {
+		// No subscription ID fits the relay
+		return nil, len(authors)
+	}
+
+	authorsPerFilter := MaxAuthorsPerFilter
+	filtersPerSubscription := MaxFiltersPerSubscription
+	maxSubscriptions := 0
+	if limitation != nil {
+		if limitation.MaxFilters > 0 {
+			filtersPerSubscription = limitation.MaxFilters
+		}
+		if limitation.MaxSubscriptions > 0 {
+			maxSubscriptions = limitation.MaxSubscriptions
+		}
+		if limitation.MaxMessageLength > 0 {
+			// All the filters of a subscription are sent in a single message
+			authorsPerMessage := max((limitation.MaxMessageLength-reqMessageOverhead)/authorMessageSize, 1)
+			authorsPerFilter = min(authorsPerFilter, authorsPerMessage)
+			filtersPerSubscription = max(min(filtersPerSubscription, authorsPerMessage/authorsPerFilter), 1)
+		}
+	}
+
+	var subscriptions []nostr.Filters
+	for start := 0; start < len(authors); {
+		if maxSubscriptions > 0 && len(subscriptions) == maxSubscriptions {
+			return subscriptions, len(authors) - start
+		}
+		var filters nostr.Filters
+		for len(filters) < filtersPerSubscription && start < len(authors) {
+			end := min(start+authorsPerFilter, len(authors))
+			filters = append(filters, nostr.Filter{Authors: authors[start:end]})
+			start = end
+		}
+		subscriptions = append(subscriptions, filters)
+	}
+	return subscriptions, 0
+}
+
+// subidFits returns whether the subscription ID fits the relay limitation.
+func subidFits(id string, limitation *nip11.RelayLimitationDocument) bool {
+	return limitation == nil || limitation.MaxSubidLength <= 0 || len(id) <= limitation.MaxSubidLength
+}
+
+// countAuthors returns the number of authors in the filters.
+func countAuthors(filters nostr.Filters) int {
+	count := 0
+	for _, filter := range filters {
+		count += len(filter.Authors)
+	}
+	return count
+}
+
+func setUnscheduledPubkeys(url string, count int) {
+	gauge := new(expvar.Int)
+	gauge.Set(int64(count))
+	unscheduledPubkeys.Set(url, gauge)
+}
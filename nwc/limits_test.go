@@ -0,0 +1,89 @@
+package nwc
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/nbd-wtf/go-nostr"
+	"github.com/nbd-wtf/go-nostr/nip11"
+	"gotest.tools/assert"
+)
+
+func testAuthors(count int) []string {
+	authors := make([]string, count)
+	for i := range authors {
+		authors[i] = fmt.Sprintf("%064x", i)
+	}
+	return authors
+}
+
+func countChunkAuthors(chunks []nostr.Filters) int {
+	count := 0
+	for _, filters := range chunks {
+		count += countAuthors(filters)
+	}
+	return count
+}
+
+func TestChunkAuthorsWithoutLimits(t *testing.T) {
+	authors := testAuthors(2*MaxAuthorsPerFilter + 1)
+	chunks, unscheduled := chunkAuthors(authors, nil)
+	assert.Equal(t, unscheduled, 0)
+	assert.Equal(t, len(chunks), 1, "all filters should fit a single subscription")
+	assert.Equal(t, len(chunks[0]), 3)
+	assert.Equal(t, countChunkAuthors(chunks), len(authors))
+}
+
+func TestChunkAuthorsMaxFilters(t *testing.T) {
+	authors := testAuthors(5 * MaxAuthorsPerFilter)
+	chunks, unscheduled := chunkAuthors(authors, &nip11.RelayLimitationDocument{
+		MaxFilters: 2,
+	})
+	assert.Equal(t, unscheduled, 0)
+	assert.Equal(t, len(chunks), 3)
+	for _, filters := range chunks {
+		assert.Assert(t, len(filters) <= 2, "too many filters in subscription")
+	}
+	assert.Equal(t, countChunkAuthors(chunks), len(authors))
+}
+
+func TestChunkAuthorsMaxMessageLength(t *testing.T) {
+	authors := testAuthors(100)
+	chunks, unscheduled := chunkAuthors(authors, &nip11.RelayLimitationDocument{
+		MaxMessageLength: reqMessageOverhead + 10*authorMessageSize,
+	})
+	assert.Equal(t, unscheduled, 0)
+	assert.Equal(t, len(chunks), 10)
+	for _, filters := range chunks {
+		assert.Equal(t, len(filters), 1)
+		assert.Equal(t, len(filters[0].Authors), 10)
+	}
+}
+
+func TestChunkAuthorsMaxSubscriptions(t *testing.T) {
+	authors := testAuthors(100)
+	chunks, unscheduled := chunkAuthors(authors, &nip11.RelayLimitationDocument{
+		MaxMessageLength: reqMessageOverhead + 10*authorMessageSize,
+		MaxSubscriptions: 3,
+	})
+	assert.Equal(t, len(chunks), 3)
+	assert.Equal(t, unscheduled, 70, "authors beyond the subscriptions limit should be unscheduled")
+	assert.Equal(t, countChunkAuthors(chunks), 30)
+}
+
+func TestChunkAuthorsMaxSubidLength(t *testing.T) {
+	authors := testAuthors(100)
+	chunks, unscheduled := chunkAuthors(authors, &nip11.RelayLimitationDocument{
+		MaxSubidLength: 1,
+	})
+	assert.Equal(t, len(chunks), 0)
+	assert.Equal(t, unscheduled, 100, "authors should be unscheduled when no subscription ID fits")
+
+	limitation := &nip11.RelayLimitationDocument{MaxSubidLength: 4}
+	chunks, unscheduled = chunkAuthors(authors, limitation)
+	assert.Equal(t, unscheduled, 0)
+	assert.Equal(t, countChunkAuthors(chunks), 100)
+	assert.Assert(t, subidFits(":999", limitation))
+	assert.Assert(t, !subidFits(":1000", limitation), "subscription IDs beyond the limit should not fit")
+	assert.Assert(t, subidFits(":1000", nil))
+}
@@ -11,122 +11,345 @@ import (
 	"sync"
 	"time"
 
+	"github.com/breez/breez-lnurl/cache"
 	"github.com/breez/breez-lnurl/channel"
 	"github.com/breez/breez-lnurl/persist"
+	nwc "github.com/breez/breez-lnurl/persist/nwc"
 	"github.com/nbd-wtf/go-nostr"
 )
 
-type Subscription struct {
-	ctx          context.Context
-	cancel       context.CancelFunc
-	eventChannel chan nostr.IncomingEvent
+// Config holds the optional settings of the NWC service.
+type Config struct {
+	// The hex encoded Nostr private key used to answer NIP-42 AUTH challenges
+	// of relays. Relays requiring authentication are not served when empty.
+	AuthPrivateKey string
+	// The url of the embedded relay, whose events are read from the store
+	// instead of subscribing to it. Empty when the relay is not served.
+	EmbeddedRelayUrl string
+	// The relays the NIP-65 relay lists of the app pubkeys are fetched from,
+	// to also subscribe to their write relays. Disabled when empty.
+	DiscoveryRelays []string
 }
 
 type NostrManager struct {
-	pool           *nostr.SimplePool
-	ctx            context.Context
-	cancel         context.CancelFunc
-	mu             sync.RWMutex
-	isRunning      bool
-	sub            *Subscription
-	store          *persist.Store
-	lastAppPubkeys []string
+	pool          *nostr.SimplePool
+	ctx           context.Context
+	cancel        context.CancelFunc
+	mu            sync.RWMutex
+	isRunning     bool
+	store         *persist.Store
+	config        Config
+	registrations map[string]nwc.Webhook
+	// Orders the pushed changes and the full reconciliations by when they
+	// read the store, so older reads don't overwrite newer ones: the last
+	// sequence number taken, the one of the last reconciliation and the
+	// one of the last change applied to each registration since
+	changeSeq     uint64
+	reconciledSeq uint64
+	changedSeq    map[string]uint64
+	changed       chan struct{}
+	subs          map[string]*relaySubscription
+	events        chan relayEvent
+	seen          cache.CacheService
+	cursors       map[string]int64
+	// The relays whose cursor advanced since it was last persisted
+	dirtyCursors map[string]struct{}
+	limits       map[string]relayLimits
+	health       map[string]*relayHealth
+	batches      map[string]*eventBatch
+	// The times the devices of each registration were woken in the last
+	// hour, for the registrations with wake limits
+	wakes map[string][]time.Time
+	// The write relays of the app pubkeys, from their NIP-65 relay lists
+	discovered map[string]discoveredRelays
+	// The sorted app pubkeys registered on the embedded relay
+	embeddedAuthors []string
+	// Whether this instance leads the relay subscriptions
+	leading bool
 }
 
-func NewNostrManager(store *persist.Store) *NostrManager {
+func NewNostrManager(store *persist.Store, config Config) *NostrManager {
 	return &NostrManager{
-		isRunning: false,
-		store:     store,
+		isRunning:     false,
+		store:         store,
+		config:        config,
+		registrations: make(map[string]nwc.Webhook),
+		changedSeq:    make(map[string]uint64),
+		changed:       make(chan struct{}, 1),
+		subs:          make(map[string]*relaySubscription),
+		events:        make(chan relayEvent),
+		seen:          cache.NewCache(SeenEventsDuration),
+		cursors:       make(map[string]int64),
+		dirtyCursors:  make(map[string]struct{}),
+		limits:        make(map[string]relayLimits),
+		health:        make(map[string]*relayHealth),
+		batches:       make(map[string]*eventBatch),
+		wakes:         make(map[string][]time.Time),
+		discovered:    make(map[string]discoveredRelays),
 	}
 }
 
-// The interval to check if resubscription is needed
-// Only resubscribe if pubkeys have changed to avoid rate limiting
+// The interval of the full reconciliation of registrations against the store.
+// Changes are normally pushed by the store, so this is only a safety net.
 var ResubscribeInterval time.Duration = 1 * time.Minute
 
+// The time to wait after a pushed change before resubscribing, so bursts
+// of registrations result in a single subscription update
+var ChangeDebounceInterval time.Duration = 500 * time.Millisecond
+
+// The time to wait before listening again after the change feed was interrupted
+var ListenRetryInterval time.Duration = 5 * time.Second
+
 func (nm *NostrManager) StartResubscriptionLoop() {
+	nm.reconcileAndResubscribe()
+	// Started once the registrations are known, to backfill them when leading
+	go nm.StartLeaderLoop()
+	ticker := time.NewTicker(ResubscribeInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			nm.reconcileAndResubscribe()
+		case <-nm.changed:
+			select {
+			case <-time.After(ChangeDebounceInterval):
+			case <-nm.ctx.Done():
+				return
+			}
+			if err := nm.Resubscribe(); err != nil {
+				log.Printf("failed to resubscribe to events: %v", err)
+			}
+		case <-nm.ctx.Done():
+			return
+		}
+	}
+}
+
+func (nm *NostrManager) reconcileAndResubscribe() {
+	if err := nm.Reconcile(); err != nil {
+		log.Printf("failed to reconcile registrations: %v", err)
+	}
+	if err := nm.Resubscribe(); err != nil {
+		log.Printf("failed to resubscribe to events: %v", err)
+	}
+}
+
+// StartListenLoop applies registration changes pushed by the store as they happen.
+func (nm *NostrManager) StartListenLoop() {
 	for {
-		if err := nm.Resubscribe(); err != nil {
-			log.Printf("failed to resubscribe to events: %v", err)
+		changes, err := nm.store.Nwc.Listen(nm.ctx)
+		if err != nil {
+			log.Printf("failed to listen to registration changes: %v", err)
+		} else {
+			for change := range changes {
+				nm.applyChange(change)
+			}
 		}
 		select {
-		case <-time.After(ResubscribeInterval):
-			continue
+		case <-time.After(ListenRetryInterval):
+			// Changes may have been missed while not listening
+			if err := nm.Reconcile(); err != nil {
+				log.Printf("failed to reconcile registrations: %v", err)
+			}
+			nm.notifyChanged()
 		case <-nm.ctx.Done():
 			return
 		}
 	}
 }
 
-func (nm *NostrManager) Resubscribe() error {
+func (nm *NostrManager) applyChange(change nwc.WebhookChange) {
+	key := registrationKey(change.WalletServicePubkey, change.AppPubkey)
+	seq := nm.nextChangeSeq()
+	var webhook *nwc.Webhook
+	if change.Op != nwc.WebhookDeleted {
+		var err error
+		webhook, err = nm.store.Nwc.Get(nm.ctx, change.WalletServicePubkey, change.AppPubkey)
+		if err != nil {
+			log.Printf("failed to retrieve changed registration %v: %v", key, err)
+			return
+		}
+	}
+
 	nm.mu.Lock()
-	defer nm.mu.Unlock()
+	if seq < max(nm.reconciledSeq, nm.changedSeq[key]) {
+		// A newer read of the registration was already applied
+		nm.mu.Unlock()
+		return
+	}
+	if webhook != nil {
+		nm.registrations[key] = *webhook
+	} else {
+		delete(nm.registrations, key)
+	}
+	nm.changedSeq[key] = seq
+	nm.mu.Unlock()
 
-	if !nm.isRunning {
-		return fmt.Errorf("manager not running")
+	log.Printf("registration %v: app pubkey %v", change.Op, change.AppPubkey)
+	nm.notifyChanged()
+}
+
+func (nm *NostrManager) notifyChanged() {
+	select {
+	case nm.changed <- struct{}{}:
+	default:
 	}
+}
+
+// nextChangeSeq returns the sequence number of a read of the registrations
+// from the store, taken before reading them.
+func (nm *NostrManager) nextChangeSeq() uint64 {
+	nm.mu.Lock()
+	defer nm.mu.Unlock()
+	nm.changeSeq++
+	return nm.changeSeq
+}
 
-	appPubkeys, err := nm.store.Nwc.GetAppPubkeys(nm.ctx)
+// Reconcile replaces the known registrations with the full set from the
+// store, except the ones changed since it was read.
+func (nm *NostrManager) Reconcile() error {
+	seq := nm.nextChangeSeq()
+	webhooks, err := nm.store.Nwc.GetAll(nm.ctx)
 	if err != nil {
 		return err
 	}
+	nm.replaceRegistrations(seq, webhooks)
+	return nil
+}
 
-	// Only resubscribe if we have pubkeys to subscribe to
-	if len(appPubkeys) == 0 {
-		log.Printf("No active app pubkeys. Waiting for registrations...")
-		return nil
+func (nm *NostrManager) replaceRegistrations(seq uint64, webhooks []nwc.Webhook) {
+	registrations := make(map[string]nwc.Webhook, len(webhooks))
+	for _, webhook := range webhooks {
+		registrations[registrationKey(webhook.WalletServicePubkey, webhook.AppPubkey)] = webhook
+	}
+
+	nm.mu.Lock()
+	defer nm.mu.Unlock()
+	if seq < nm.reconciledSeq {
+		return
+	}
+	for key, changedSeq := range nm.changedSeq {
+		if changedSeq < seq {
+			delete(nm.changedSeq, key)
+			continue
+		}
+		// Keep the newer change, or deletion, of the registration
+		if registration, ok := nm.registrations[key]; ok {
+			registrations[key] = registration
+		} else {
+			delete(registrations, key)
+		}
+	}
+	nm.registrations = registrations
+	nm.reconciledSeq = seq
+}
+
+func registrationKey(walletServicePubkey string, appPubkey string) string {
+	return walletServicePubkey + ":" + appPubkey
+}
+
+// subscriptionTargets returns the sorted, unique app pubkeys registered on
+// each relay, or advertising it in their relay list, and whether any
+// registration requires authenticating to it. The store normalizes the
+// relay urls.
+func (nm *NostrManager) subscriptionTargets() map[string]relayTarget {
+	relayAuthors := make(map[string]map[string]struct{})
+	authRelays := make(map[string]bool)
+	for _, registration := range nm.registrations {
+		relays := registration.Relays
+		if discovered := nm.discovered[registration.AppPubkey].relays; len(discovered) > 0 {
+			relays = append(slices.Clone(relays), discovered...)
+		}
+		for _, relay := range relays {
+			if relayAuthors[relay] == nil {
+				relayAuthors[relay] = make(map[string]struct{})
+			}
+			relayAuthors[relay][registration.AppPubkey] = struct{}{}
+			if slices.Contains(registration.AuthRelays, relay) {
+				authRelays[relay] = true
+			}
+		}
 	}
 
-	// Only resubscribe if pubkeys have changed to avoid rate limiting
-	if nm.lastAppPubkeys != nil && slices.Compare(nm.lastAppPubkeys, appPubkeys) == 0 {
-		return nil
+	targets := make(map[string]relayTarget, len(relayAuthors))
+	for relay, authorSet := range relayAuthors {
+		authors := make([]string, 0, len(authorSet))
+		for author := range authorSet {
+			authors = append(authors, author)
+		}
+		slices.Sort(authors)
+		targets[relay] = relayTarget{
+			authors:      authors,
+			authRequired: authRelays[relay],
+		}
 	}
+	return targets
+}
 
-	relays, err := nm.store.Nwc.GetRelays(nm.ctx)
+func (nm *NostrManager) Resubscribe() error {
+	nm.mu.Lock()
+	defer nm.mu.Unlock()
 
-	filters := nostr.Filters{
-		{
-			Authors: appPubkeys,
-		},
+	if !nm.isRunning {
+		return fmt.Errorf("manager not running")
 	}
 
-	prevSub := nm.sub
-	subCtx, subCancel := context.WithCancel(nm.ctx)
-	nm.sub = &Subscription{
-		eventChannel: nm.pool.SubMany(nm.ctx, relays, filters),
-		ctx:          subCtx,
-		cancel:       subCancel,
+	// Only the leader subscribes, the other instances drop their subscriptions
+	targets := make(map[string]relayTarget)
+	if nm.leading {
+		targets = nm.subscriptionTargets()
+	}
+	if nm.config.EmbeddedRelayUrl != "" {
+		nm.embeddedAuthors = targets[nm.config.EmbeddedRelayUrl].authors
+		delete(targets, nm.config.EmbeddedRelayUrl)
+	}
+	if nm.leading && len(targets) == 0 && len(nm.embeddedAuthors) == 0 {
+		log.Printf("No active app pubkeys. Waiting for registrations...")
 	}
-	go nm.forwardToNotify()
 
-	if prevSub != nil {
-		prevSub.cancel()
-		prevSub.eventChannel = nil
+	// Only resubscribe on relays where the app pubkeys have changed to avoid rate limiting
+	for url, sub := range nm.subs {
+		if target, ok := targets[url]; !ok || !target.equal(sub.relayTarget) {
+			sub.cancel()
+			delete(nm.subs, url)
+			if !ok {
+				unscheduledPubkeys.Delete(url)
+				delete(nm.health, url)
+			}
+		}
+	}
+
+	resubscribed := 0
+	for url, target := range targets {
+		if _, ok := nm.subs[url]; ok {
+			continue
+		}
+		nm.subs[url] = nm.startRelaySubscription(url, target, nm.relayHealth(url))
+		resubscribed++
 	}
 
-	nm.lastAppPubkeys = appPubkeys
-	log.Printf("Resubscribed to %d relays for %d app pubkeys using SimplePool.SubMany", len(relays), len(appPubkeys))
+	if resubscribed > 0 {
+		log.Printf("Resubscribed to %d of %d relays", resubscribed, len(targets))
+	}
 	return nil
 }
 
 func (nm *NostrManager) forwardToNotify() {
-	sub := nm.sub
-	if sub == nil {
-		return
-	}
-
 	for {
 		select {
-		case incomingEvent := <-sub.eventChannel:
-			if incomingEvent.Event == nil {
-				return
-			}
-
+		case incomingEvent := <-nm.events:
 			log.Printf("got incoming event: %v", incomingEvent.Event.String())
 			if _, err := incomingEvent.CheckSignature(); err != nil {
 				log.Printf("failed to verify signature for event %v: %v", incomingEvent.ID, err)
 				continue
 			}
+			nm.advanceCursor(incomingEvent.relayUrl, incomingEvent.CreatedAt)
+
+			// The same event is usually received from several relays
+			if nm.seen.Get(incomingEvent.ID) != nil {
+				continue
+			}
+			nm.seen.Set(incomingEvent.ID, []byte{}, SeenEventsDuration)
 
 			pTag := incomingEvent.Tags.GetFirst([]string{"p"})
 			if pTag == nil {
@@ -136,7 +359,7 @@ func (nm *NostrManager) forwardToNotify() {
 
 			walletServicePubkey := pTag.Value()
 			// Check if event has already been forwarded (deduplication)
-			alreadyForwarded, err := nm.store.Nwc.IsEventForwarded(sub.ctx, incomingEvent.Event.ID)
+			alreadyForwarded, err := nm.store.Nwc.IsEventForwarded(nm.ctx, incomingEvent.Event.ID)
 			if err != nil {
 				log.Printf("failed to check if event %v was already forwarded: %v", incomingEvent.ID, err)
 				continue
@@ -146,7 +369,7 @@ func (nm *NostrManager) forwardToNotify() {
 				continue
 			}
 
-			webhook, err := nm.store.Nwc.Get(sub.ctx, walletServicePubkey, incomingEvent.PubKey)
+			webhook, err := nm.store.Nwc.Get(nm.ctx, walletServicePubkey, incomingEvent.PubKey)
 			if err != nil {
 				log.Printf("failed to retrieve webhook for event %v: %v", incomingEvent.ID, err)
 				continue
@@ -156,35 +379,17 @@ func (nm *NostrManager) forwardToNotify() {
 				continue
 			}
 
-			go func(url string, id string, walletServicePk string, appPk string) {
-				log.Printf("forwarding event %s to notify service", id)
-				err = nm.SendRequest(sub.ctx, url, id)
-				if err != nil {
-					log.Printf("failed to send webhook message for event %v: %v", id, err)
-					return
-				}
-
-				// Mark event as forwarded after successful delivery
-				err = nm.store.Nwc.MarkEventForwarded(sub.ctx, id, walletServicePk, appPk, url)
-				if err != nil {
-					log.Printf("failed to mark event %v as forwarded: %v", id, err)
-				}
-			}(webhook.Url, incomingEvent.Event.ID, walletServicePubkey, incomingEvent.PubKey)
-		case <-sub.ctx.Done():
-			return
+			if nm.answerFromCache(webhook, incomingEvent) {
+				continue
+			}
+			nm.deliver(webhook, incomingEvent)
 		case <-nm.ctx.Done():
 			return
 		}
 	}
 }
 
-func (nm *NostrManager) SendRequest(ctx context.Context, url string, eventId string) error {
-	message := channel.WebhookMessage{
-		Template: "nwc_event",
-		Data: map[string]any{
-			"event_id": eventId,
-		},
-	}
+func (nm *NostrManager) SendRequest(ctx context.Context, url string, message channel.WebhookMessage) error {
 	jsonBytes, err := json.Marshal(message)
 	if err != nil {
 		return err
@@ -205,8 +410,6 @@ func (nm *NostrManager) SendRequest(ctx context.Context, url string, eventId str
 	if res.StatusCode < 200 || res.StatusCode >= 300 {
 		return fmt.Errorf("webhook returned status: %d", res.StatusCode)
 	}
-
-	log.Printf("successfully forwarded event %s", eventId)
 	return nil
 }
 
@@ -214,6 +417,7 @@ func (nm *NostrManager) Start() {
 	nm.mu.Lock()
 
 	if nm.isRunning {
+		nm.mu.Unlock()
 		return
 	}
 	nm.ctx, nm.cancel = context.WithCancel(context.Background())
@@ -222,6 +426,9 @@ func (nm *NostrManager) Start() {
 	log.Printf("NostrManager started with SimplePool")
 
 	nm.mu.Unlock()
+	go nm.forwardToNotify()
+	go nm.StartCursorFlushLoop()
+	go nm.StartListenLoop()
 	go nm.StartResubscriptionLoop()
 }
 
@@ -233,8 +440,9 @@ func (nm *NostrManager) Stop() {
 		return
 	}
 
-	if nm.sub != nil {
-		nm.cancelSubscription()
+	for url, sub := range nm.subs {
+		sub.cancel()
+		delete(nm.subs, url)
 	}
 
 	if nm.cancel != nil {
@@ -244,10 +452,3 @@ func (nm *NostrManager) Stop() {
 	nm.isRunning = false
 	log.Printf("NostrManager stopped")
 }
-
-func (nm *NostrManager) cancelSubscription() {
-	nm.sub.cancel()
-	close(nm.sub.eventChannel)
-	nm.sub.eventChannel = nil
-	nm.sub = nil
-}
@@ -0,0 +1,106 @@
+package nwc
+
+import (
+	"context"
+	"testing"
+
+	"github.com/breez/breez-lnurl/persist"
+	nwc "github.com/breez/breez-lnurl/persist/nwc"
+	"gotest.tools/assert"
+)
+
+func newTestManager(t *testing.T, store *persist.Store) *NostrManager {
+	nm := NewNostrManager(store, Config{})
+	nm.ctx, nm.cancel = context.WithCancel(context.Background())
+	t.Cleanup(nm.cancel)
+	return nm
+}
+
+func testWebhook(appPubkey string, relays ...string) nwc.Webhook {
+	return nwc.Webhook{
+		WalletServicePubkey: "wallet",
+		AppPubkey:           appPubkey,
+		Devices:             []nwc.Device{{Url: "https://example.com/hook"}},
+		Relays:              relays,
+	}
+}
+
+func (nm *NostrManager) registration(appPubkey string) (nwc.Webhook, bool) {
+	nm.mu.RLock()
+	defer nm.mu.RUnlock()
+	registration, ok := nm.registrations[registrationKey("wallet", appPubkey)]
+	return registration, ok
+}
+
+func TestApplyChanges(t *testing.T) {
+	store := persist.NewMemoryStore()
+	nm := newTestManager(t, store)
+	changes, err := store.Nwc.Listen(nm.ctx)
+	assert.NilError(t, err)
+	ctx := context.Background()
+
+	assert.NilError(t, store.Nwc.Set(ctx, testWebhook("app", "wss://relay1.example.com")))
+	change := <-changes
+	assert.Equal(t, change.Op, nwc.WebhookInserted)
+	nm.applyChange(change)
+	registration, ok := nm.registration("app")
+	assert.Assert(t, ok)
+	assert.DeepEqual(t, registration.Relays, []string{"wss://relay1.example.com"})
+	assert.Equal(t, len(nm.changed), 1, "the change triggers a resubscription")
+
+	assert.NilError(t, store.Nwc.Set(ctx, testWebhook("app", "wss://relay2.example.com")))
+	change = <-changes
+	assert.Equal(t, change.Op, nwc.WebhookUpdated)
+	nm.applyChange(change)
+	registration, _ = nm.registration("app")
+	assert.DeepEqual(t, registration.Relays, []string{"wss://relay2.example.com"})
+
+	assert.NilError(t, store.Nwc.Delete(ctx, "owner", "wallet", "app"))
+	change = <-changes
+	assert.Equal(t, change.Op, nwc.WebhookDeleted)
+	nm.applyChange(change)
+	_, ok = nm.registration("app")
+	assert.Assert(t, !ok)
+}
+
+func TestReconcileKeepsNewerChanges(t *testing.T) {
+	store := persist.NewMemoryStore()
+	nm := newTestManager(t, store)
+	ctx := context.Background()
+	assert.NilError(t, store.Nwc.Set(ctx, testWebhook("updated", "wss://relay1.example.com")))
+	assert.NilError(t, store.Nwc.Set(ctx, testWebhook("deleted", "wss://relay1.example.com")))
+	assert.NilError(t, nm.Reconcile())
+
+	// A reconciliation reads the store, then changes are applied before it
+	// replaces the registrations
+	seq := nm.nextChangeSeq()
+	stale, err := store.Nwc.GetAll(ctx)
+	assert.NilError(t, err)
+
+	assert.NilError(t, store.Nwc.Set(ctx, testWebhook("updated", "wss://relay2.example.com")))
+	nm.applyChange(nwc.WebhookChange{Op: nwc.WebhookUpdated, WalletServicePubkey: "wallet", AppPubkey: "updated"})
+	assert.NilError(t, store.Nwc.Set(ctx, testWebhook("added", "wss://relay1.example.com")))
+	nm.applyChange(nwc.WebhookChange{Op: nwc.WebhookInserted, WalletServicePubkey: "wallet", AppPubkey: "added"})
+	assert.NilError(t, store.Nwc.Delete(ctx, "owner", "wallet", "deleted"))
+	nm.applyChange(nwc.WebhookChange{Op: nwc.WebhookDeleted, WalletServicePubkey: "wallet", AppPubkey: "deleted"})
+
+	nm.replaceRegistrations(seq, stale)
+	registration, ok := nm.registration("updated")
+	assert.Assert(t, ok)
+	assert.DeepEqual(t, registration.Relays, []string{"wss://relay2.example.com"})
+	_, ok = nm.registration("added")
+	assert.Assert(t, ok)
+	_, ok = nm.registration("deleted")
+	assert.Assert(t, !ok)
+
+	// A newer reconciliation replaces them all
+	assert.NilError(t, nm.Reconcile())
+	assert.Equal(t, len(nm.changedSeq), 0)
+	assert.Equal(t, len(nm.registrations), 2)
+
+	// A reconciliation read before the last one is stale
+	seq = nm.nextChangeSeq()
+	assert.NilError(t, nm.Reconcile())
+	nm.replaceRegistrations(seq, nil)
+	assert.Equal(t, len(nm.registrations), 2)
+}
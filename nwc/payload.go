@@ -0,0 +1,70 @@
+package nwc
+
+import (
+	"encoding/json"
+
+	"github.com/breez/breez-lnurl/channel"
+	nwc "github.com/breez/breez-lnurl/persist/nwc"
+)
+
+// The maximum size of the webhook payloads relayed by each push provider.
+// Full payloads exceeding it fall back to the event id.
+var PushProviderPayloadLimits = map[string]int{
+	"apns": 4096,
+	"fcm":  4096,
+}
+
+// The maximum size of the webhook payloads for registrations without a known push provider
+var DefaultPayloadLimit = 4096
+
+// The room left in the payload for the push provider envelope added by the notification service
+const pushEnvelopeOverhead = 512
+
+// IsPushProvider returns whether the push provider has a known payload limit.
+func IsPushProvider(provider string) bool {
+	_, ok := PushProviderPayloadLimits[provider]
+	return ok
+}
+
+func payloadLimit(provider string) int {
+	if limit, ok := PushProviderPayloadLimits[provider]; ok {
+		return limit
+	}
+	return DefaultPayloadLimit
+}
+
+// eventMessage builds the webhook message notifying the device of the event,
+// including the event itself if the registration opted in and the push
+// provider of the device allows.
+func eventMessage(webhook *nwc.Webhook, device nwc.Device, event relayEvent) channel.WebhookMessage {
+	message := channel.WebhookMessage{
+		Template: "nwc_event",
+		Data: map[string]any{
+			"event_id": event.ID,
+		},
+	}
+	if webhook.PayloadMode != nwc.PayloadModeFull {
+		return message
+	}
+
+	message.Data["relay"] = event.relayUrl
+	message.Data["wallet_service_pubkey"] = webhook.WalletServicePubkey
+	message.Data["kind"] = event.Kind
+	// The signed event stays encrypted to the wallet service
+	message.Data["event"] = event.Event.String()
+	if fitsPayloadLimit(message, device.PushProvider) {
+		return message
+	}
+
+	delete(message.Data, "event")
+	message.Data["event_omitted"] = true
+	return message
+}
+
+func fitsPayloadLimit(message channel.WebhookMessage, provider string) bool {
+	payload, err := json.Marshal(message)
+	if err != nil {
+		return false
+	}
+	return len(payload)+pushEnvelopeOverhead <= payloadLimit(provider)
+}
@@ -0,0 +1,48 @@
+package nwc
+
+import (
+	"strings"
+	"testing"
+
+	nwc "github.com/breez/breez-lnurl/persist/nwc"
+	"github.com/nbd-wtf/go-nostr"
+	"gotest.tools/assert"
+)
+
+func testRelayEvent(content string) relayEvent {
+	return relayEvent{
+		Event: &nostr.Event{
+			ID:      strings.Repeat("a", 64),
+			PubKey:  strings.Repeat("b", 64),
+			Kind:    23194,
+			Content: content,
+		},
+		relayUrl: "wss://relay.example.com",
+	}
+}
+
+func TestEventMessageIdMode(t *testing.T) {
+	message := eventMessage(&nwc.Webhook{PayloadMode: nwc.PayloadModeId}, nwc.Device{}, testRelayEvent("encrypted"))
+	assert.DeepEqual(t, message.Data, map[string]any{"event_id": strings.Repeat("a", 64)})
+}
+
+func TestEventMessageFullMode(t *testing.T) {
+	webhook := &nwc.Webhook{
+		WalletServicePubkey: strings.Repeat("c", 64),
+		PayloadMode:         nwc.PayloadModeFull,
+	}
+	message := eventMessage(webhook, nwc.Device{PushProvider: "apns"}, testRelayEvent("encrypted"))
+	assert.Equal(t, message.Data["relay"], "wss://relay.example.com")
+	assert.Equal(t, message.Data["wallet_service_pubkey"], webhook.WalletServicePubkey)
+	assert.Equal(t, message.Data["kind"], 23194)
+	assert.Assert(t, strings.Contains(message.Data["event"].(string), "encrypted"))
+}
+
+func TestEventMessageExceedingPayloadLimit(t *testing.T) {
+	webhook := &nwc.Webhook{PayloadMode: nwc.PayloadModeFull}
+	message := eventMessage(webhook, nwc.Device{PushProvider: "fcm"}, testRelayEvent(strings.Repeat("x", PushProviderPayloadLimits["fcm"])))
+	_, ok := message.Data["event"]
+	assert.Assert(t, !ok, "the event should be omitted")
+	assert.Equal(t, message.Data["event_omitted"], true)
+	assert.Equal(t, message.Data["event_id"], strings.Repeat("a", 64))
+}
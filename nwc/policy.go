@@ -0,0 +1,161 @@
+package nwc
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+
+	nwc "github.com/breez/breez-lnurl/persist/nwc"
+)
+
+// validatePolicy checks the notification policy can be enforced.
+func validatePolicy(policy nwc.NotificationPolicy) error {
+	if (policy.QuietHoursStart == "") != (policy.QuietHoursEnd == "") {
+		return fmt.Errorf("quiet hours need a start and an end")
+	}
+	if policy.QuietHoursStart != "" {
+		if _, err := minuteOfDay(policy.QuietHoursStart); err != nil {
+			return err
+		}
+		if _, err := minuteOfDay(policy.QuietHoursEnd); err != nil {
+			return err
+		}
+	}
+	if _, err := time.LoadLocation(policy.TimeZone); err != nil {
+		return fmt.Errorf("invalid time zone %v", policy.TimeZone)
+	}
+	if policy.MaxWakesPerHour < 0 || policy.MinIntervalSeconds < 0 {
+		return fmt.Errorf("invalid wake limits")
+	}
+	switch policy.Suppression {
+	case "", nwc.SuppressionHold, nwc.SuppressionDrop:
+	default:
+		return fmt.Errorf("invalid suppression %v", policy.Suppression)
+	}
+	return nil
+}
+
+// minuteOfDay parses a "HH:MM" time of day.
+func minuteOfDay(value string) (int, error) {
+	t, err := time.Parse("15:04", value)
+	if err != nil {
+		return 0, fmt.Errorf("invalid time of day %v", value)
+	}
+	return t.Hour()*60 + t.Minute(), nil
+}
+
+// nextWake returns the earliest time from now the policy allows waking the
+// devices, given the previous wakes in chronological order.
+func nextWake(policy nwc.NotificationPolicy, wakes []time.Time, now time.Time) time.Time {
+	at := now
+	// Each constraint may postpone the wake into another one, which is
+	// settled in a few rounds as the constraints only postpone
+	for range 4 {
+		next := at
+		if policy.MinIntervalSeconds > 0 && len(wakes) > 0 {
+			next = later(next, wakes[len(wakes)-1].Add(time.Duration(policy.MinIntervalSeconds)*time.Second))
+		}
+		if policy.MaxWakesPerHour > 0 && len(wakes) >= policy.MaxWakesPerHour {
+			// The wake leaving the hour long window makes room for another one
+			next = later(next, wakes[len(wakes)-policy.MaxWakesPerHour].Add(time.Hour))
+		}
+		next = quietHoursEnd(policy, next)
+		if next.Equal(at) {
+			break
+		}
+		at = next
+	}
+	return at
+}
+
+// quietHoursEnd returns the end of the quiet hours t falls in, or t itself.
+func quietHoursEnd(policy nwc.NotificationPolicy, t time.Time) time.Time {
+	if policy.QuietHoursStart == "" {
+		return t
+	}
+	start, err := minuteOfDay(policy.QuietHoursStart)
+	if err != nil {
+		return t
+	}
+	end, err := minuteOfDay(policy.QuietHoursEnd)
+	if err != nil || start == end {
+		return t
+	}
+	location, err := time.LoadLocation(policy.TimeZone)
+	if err != nil {
+		return t
+	}
+
+	local := t.In(location)
+	minute := local.Hour()*60 + local.Minute()
+	midnight := time.Date(local.Year(), local.Month(), local.Day(), 0, 0, 0, 0, location)
+	switch {
+	case start < end && minute >= start && minute < end:
+		return midnight.Add(time.Duration(end) * time.Minute)
+	case start > end && minute >= start:
+		return midnight.AddDate(0, 0, 1).Add(time.Duration(end) * time.Minute)
+	case start > end && minute < end:
+		return midnight.Add(time.Duration(end) * time.Minute)
+	}
+	return t
+}
+
+func later(a time.Time, b time.Time) time.Time {
+	if b.After(a) {
+		return b
+	}
+	return a
+}
+
+// allowWake returns when the registration may be woken next, recording a
+// wake when it may be woken now. Must be called with the lock held.
+func (nm *NostrManager) allowWake(key string, policy nwc.NotificationPolicy, now time.Time) (time.Time, bool) {
+	// Only the wakes of the last hour matter
+	wakes := nm.wakes[key]
+	for len(wakes) > 0 && now.Sub(wakes[0]) >= time.Hour {
+		wakes = wakes[1:]
+	}
+
+	at := nextWake(policy, wakes, now)
+	if at.After(now) {
+		nm.wakes[key] = wakes
+		return at, false
+	}
+	if policy.MinIntervalSeconds > 0 || policy.MaxWakesPerHour > 0 {
+		nm.wakes[key] = append(wakes, now)
+	} else {
+		delete(nm.wakes, key)
+	}
+	return now, true
+}
+
+// suppressed records the events the policy of the registration held back or dropped.
+func (nm *NostrManager) suppressed(webhook *nwc.Webhook, count int) {
+	dropped := webhook.Policy.Suppression == nwc.SuppressionDrop
+	go func() {
+		if err := nm.store.Nwc.MarkEventsSuppressed(nm.ctx, webhook.WalletServicePubkey, webhook.AppPubkey, count, dropped); err != nil {
+			log.Printf("failed to mark %d events of %v as suppressed: %v", count, webhook.AppPubkey, err)
+		}
+	}()
+}
+
+// dropHeld counts the events still held for the registration as dropped when
+// the manager stops. Held events are only kept in memory, so holding them is
+// best effort: they are lost on restart.
+func (nm *NostrManager) dropHeld(key string) {
+	nm.mu.Lock()
+	batch := nm.batches[key]
+	delete(nm.batches, key)
+	nm.mu.Unlock()
+	if batch == nil || !batch.held || len(batch.eventIds) == 0 {
+		return
+	}
+
+	webhook := batch.webhook
+	log.Printf("dropping %d held events of %v on stop", len(batch.eventIds), webhook.AppPubkey)
+	// The manager context is done
+	if err := nm.store.Nwc.MarkEventsSuppressed(context.Background(), webhook.WalletServicePubkey, webhook.AppPubkey, len(batch.eventIds), true); err != nil {
+		log.Printf("failed to mark %d events of %v as dropped: %v", len(batch.eventIds), webhook.AppPubkey, err)
+	}
+}
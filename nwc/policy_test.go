@@ -0,0 +1,142 @@
+package nwc
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/breez/breez-lnurl/channel"
+	"github.com/breez/breez-lnurl/persist"
+	nwc "github.com/breez/breez-lnurl/persist/nwc"
+	"github.com/nbd-wtf/go-nostr"
+	"gotest.tools/assert"
+)
+
+func TestNextWakeQuietHours(t *testing.T) {
+	policy := nwc.NotificationPolicy{QuietHoursStart: "22:00", QuietHoursEnd: "07:30"}
+	date := func(day int, hour int, minute int) time.Time {
+		return time.Date(2026, 1, day, hour, minute, 0, 0, time.UTC)
+	}
+
+	assert.Equal(t, nextWake(policy, nil, date(1, 12, 0)), date(1, 12, 0))
+	assert.Equal(t, nextWake(policy, nil, date(1, 23, 0)), date(2, 7, 30), "quiet hours span midnight")
+	assert.Equal(t, nextWake(policy, nil, date(2, 3, 0)), date(2, 7, 30))
+	assert.Equal(t, nextWake(policy, nil, date(2, 7, 30)), date(2, 7, 30))
+}
+
+func TestNextWakeLimits(t *testing.T) {
+	now := time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC)
+	wakes := []time.Time{now.Add(-50 * time.Minute), now.Add(-20 * time.Minute), now.Add(-time.Minute)}
+
+	policy := nwc.NotificationPolicy{MinIntervalSeconds: 300}
+	assert.Equal(t, nextWake(policy, wakes, now), now.Add(4*time.Minute))
+
+	policy = nwc.NotificationPolicy{MaxWakesPerHour: 3}
+	assert.Equal(t, nextWake(policy, wakes, now), now.Add(10*time.Minute), "the oldest wake leaves the window")
+
+	// Waiting for the wake limit ends in quiet hours, which then postpone the wake
+	policy = nwc.NotificationPolicy{MaxWakesPerHour: 3, QuietHoursStart: "12:05", QuietHoursEnd: "13:00"}
+	assert.Equal(t, nextWake(policy, wakes, now), now.Add(time.Hour))
+}
+
+func TestValidatePolicy(t *testing.T) {
+	assert.NilError(t, validatePolicy(nwc.NotificationPolicy{}))
+	assert.NilError(t, validatePolicy(nwc.NotificationPolicy{QuietHoursStart: "22:00", QuietHoursEnd: "07:00", TimeZone: "Europe/Paris", Suppression: nwc.SuppressionDrop}))
+	assert.Assert(t, validatePolicy(nwc.NotificationPolicy{QuietHoursStart: "22:00"}) != nil)
+	assert.Assert(t, validatePolicy(nwc.NotificationPolicy{QuietHoursStart: "25:00", QuietHoursEnd: "07:00"}) != nil)
+	assert.Assert(t, validatePolicy(nwc.NotificationPolicy{TimeZone: "Mars/Olympus"}) != nil)
+	assert.Assert(t, validatePolicy(nwc.NotificationPolicy{MaxWakesPerHour: -1}) != nil)
+	assert.Assert(t, validatePolicy(nwc.NotificationPolicy{Suppression: "later"}) != nil)
+}
+
+func TestDeliverHoldsAndDropsSuppressedEvents(t *testing.T) {
+	messages := make(chan channel.WebhookMessage, 10)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var message channel.WebhookMessage
+		json.NewDecoder(r.Body).Decode(&message)
+		messages <- message
+	}))
+	defer server.Close()
+
+	window := BatchWindow
+	BatchWindow = 0
+	defer func() { BatchWindow = window }()
+
+	store := persist.NewMemoryStore()
+	nm := NewNostrManager(store, Config{})
+	nm.ctx, nm.cancel = context.WithCancel(context.Background())
+	defer nm.cancel()
+
+	webhook := &nwc.Webhook{
+		WalletServicePubkey: strings.Repeat("a", 64),
+		AppPubkey:           strings.Repeat("b", 64),
+		Devices:             []nwc.Device{{Url: server.URL}},
+		Policy:              nwc.NotificationPolicy{MinIntervalSeconds: 1},
+	}
+	assert.NilError(t, store.Nwc.Set(nm.ctx, *webhook))
+	event := func(id string) relayEvent {
+		return relayEvent{Event: &nostr.Event{ID: strings.Repeat(id, 64)}}
+	}
+
+	nm.deliver(webhook, event("1"))
+	assert.Equal(t, (<-messages).Template, "nwc_event")
+
+	// Held until the interval elapsed, then delivered together
+	nm.deliver(webhook, event("2"))
+	nm.deliver(webhook, event("3"))
+	select {
+	case <-messages:
+		t.Fatalf("held events delivered before the interval elapsed")
+	case <-time.After(500 * time.Millisecond):
+	}
+	held := <-messages
+	assert.Equal(t, held.Template, "nwc_events")
+	assert.DeepEqual(t, held.Data["event_ids"], []any{strings.Repeat("2", 64), strings.Repeat("3", 64)})
+
+	webhook.Policy.Suppression = nwc.SuppressionDrop
+	nm.deliver(webhook, event("4"))
+	select {
+	case <-messages:
+		t.Fatalf("dropped event delivered")
+	case <-time.After(1500 * time.Millisecond):
+	}
+
+	time.Sleep(100 * time.Millisecond)
+	statuses, err := store.Nwc.List(nm.ctx, "", webhook.WalletServicePubkey)
+	assert.NilError(t, err)
+	assert.Equal(t, len(statuses), 1)
+	assert.Equal(t, statuses[0].HeldEvents, int64(2))
+	assert.Equal(t, statuses[0].DroppedEvents, int64(1))
+}
+
+func TestHeldEventsDroppedOnStop(t *testing.T) {
+	store := persist.NewMemoryStore()
+	nm := NewNostrManager(store, Config{})
+	nm.ctx, nm.cancel = context.WithCancel(context.Background())
+	defer nm.cancel()
+
+	webhook := &nwc.Webhook{
+		WalletServicePubkey: strings.Repeat("a", 64),
+		AppPubkey:           strings.Repeat("b", 64),
+		Devices:             []nwc.Device{{Url: "http://127.0.0.1:1"}},
+		Policy:              nwc.NotificationPolicy{MaxWakesPerHour: 1},
+	}
+	assert.NilError(t, store.Nwc.Set(nm.ctx, *webhook))
+	nm.allowWake(webhook.WalletServicePubkey+":"+webhook.AppPubkey, webhook.Policy, time.Now())
+
+	nm.deliver(webhook, relayEvent{Event: &nostr.Event{ID: strings.Repeat("1", 64)}})
+	nm.deliver(webhook, relayEvent{Event: &nostr.Event{ID: strings.Repeat("2", 64)}})
+	nm.cancel()
+
+	waitFor(t, func() bool {
+		statuses, err := store.Nwc.List(context.Background(), "", webhook.WalletServicePubkey)
+		return err == nil && len(statuses) == 1 && statuses[0].DroppedEvents == 2
+	}, "held events not counted as dropped")
+	nm.mu.RLock()
+	defer nm.mu.RUnlock()
+	assert.Equal(t, len(nm.batches), 0)
+}
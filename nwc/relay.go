@@ -0,0 +1,284 @@
+package nwc
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"slices"
+	"time"
+
+	"github.com/nbd-wtf/go-nostr"
+)
+
+// The delay before reconnecting to a relay after a subscription was
+// interrupted. It grows after every failed attempt.
+var RelayReconnectInterval time.Duration = 3 * time.Second
+
+// The maximum delay between reconnection attempts to a relay
+var RelayMaxReconnectInterval time.Duration = 5 * time.Minute
+
+// Resumed subscriptions start this long before the last processed event,
+// to tolerate clock skew and out of order delivery. The overlap is absorbed
+// by the event deduplication.
+var SinceSafetyMargin time.Duration = 10 * time.Minute
+
+// The maximum time to backfill events for when resuming a subscription.
+// Zero means no limit.
+var MaxBackfillDuration time.Duration = 0
+
+// The interval the advanced relay cursors are persisted at, rather than on
+// every event. The advances lost on a crash are covered by SinceSafetyMargin.
+var CursorFlushInterval time.Duration = 5 * time.Second
+
+// The time an event id is remembered to drop duplicates received from several relays
+var SeenEventsDuration time.Duration = 10 * time.Minute
+
+// relaySubscription keeps a subscription to the app pubkeys registered on a
+// single relay open until it is canceled.
+type relaySubscription struct {
+	relayTarget
+	url    string
+	health *relayHealth
+	cancel context.CancelFunc
+}
+
+// relayEvent is an event received from a relay
+type relayEvent struct {
+	*nostr.Event
+	// The relay url, as registered
+	relayUrl string
+}
+
+// relayTarget is what to subscribe to on a relay
+type relayTarget struct {
+	authors      []string
+	authRequired bool
+}
+
+func (t relayTarget) equal(other relayTarget) bool {
+	return t.authRequired == other.authRequired && slices.Equal(t.authors, other.authors)
+}
+
+func (nm *NostrManager) startRelaySubscription(url string, target relayTarget, health *relayHealth) *relaySubscription {
+	ctx, cancel := context.WithCancel(nm.ctx)
+	if target.authRequired {
+		health.requireAuth()
+	}
+	sub := &relaySubscription{
+		relayTarget: target,
+		url:         url,
+		health:      health,
+		cancel:      cancel,
+	}
+	go nm.runRelaySubscription(ctx, sub)
+	return sub
+}
+
+func (nm *NostrManager) runRelaySubscription(ctx context.Context, sub *relaySubscription) {
+	interval := RelayReconnectInterval
+	for {
+		subscribed, err := nm.subscribeRelay(ctx, sub)
+		if ctx.Err() != nil {
+			return
+		}
+		if subscribed {
+			// reset the interval after a good subscription
+			interval = RelayReconnectInterval
+		}
+		log.Printf("subscription to relay %v interrupted: %v", sub.url, err)
+		sub.health.failed(err)
+		if errors.Is(err, errAuthRequired) {
+			// Authenticate before subscribing again
+			sub.health.requireAuth()
+		}
+
+		select {
+		case <-time.After(interval):
+			interval = nextReconnectInterval(interval)
+			sub.health.reconnecting()
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// nextReconnectInterval returns the delay before the reconnection attempt
+// following a failed one.
+func nextReconnectInterval(interval time.Duration) time.Duration {
+	return min(interval*17/10, RelayMaxReconnectInterval)
+}
+
+// subscribeRelay forwards the events of the subscriptions to the relay
+// until one of them ends. It returns whether the subscriptions were established.
+func (nm *NostrManager) subscribeRelay(ctx context.Context, sub *relaySubscription) (bool, error) {
+	relay, err := nm.pool.EnsureRelay(sub.url)
+	if err != nil {
+		return false, err
+	}
+
+	limitation := nm.relayLimitation(ctx, sub.url)
+	if limitation != nil && limitation.AuthRequired {
+		sub.health.requireAuth()
+	}
+	if sub.health.authRequired() {
+		if err := nm.authenticate(ctx, relay, sub.health); err != nil {
+			return false, fmt.Errorf("failed to authenticate: %w", err)
+		}
+	}
+
+	chunks, unscheduled := chunkAuthors(sub.authors, limitation)
+
+	// Ending any of the subscriptions ends them all
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	since := nm.since(ctx, sub.url)
+	ended := make(chan error, len(chunks))
+	subscriptions := 0
+	for _, filters := range chunks {
+		for i := range filters {
+			filters[i].Since = since
+		}
+		relaySub := relay.PrepareSubscription(ctx, filters)
+		if !subidFits(relaySub.GetID(), limitation) {
+			// The subscription counter outgrew the relay subscription ID limit
+			relaySub.Unsub()
+			unscheduled += countAuthors(filters)
+			continue
+		}
+		if err := relaySub.Fire(); err != nil {
+			return false, fmt.Errorf("couldn't subscribe to %v at %s: %w", filters, sub.url, err)
+		}
+		subscriptions++
+		go func() {
+			ended <- nm.forwardRelayEvents(ctx, sub.url, relay, relaySub, sub.health)
+		}()
+	}
+	setUnscheduledPubkeys(sub.url, unscheduled)
+	if unscheduled > 0 {
+		log.Printf("relay %v limits exceeded: %d of %d app pubkeys not subscribed", sub.url, unscheduled, len(sub.authors))
+	}
+	sub.health.subscribed(len(sub.authors), unscheduled, subscriptions)
+
+	select {
+	case err := <-ended:
+		return true, err
+	case <-ctx.Done():
+		return true, nil
+	}
+}
+
+func (nm *NostrManager) forwardRelayEvents(ctx context.Context, url string, relay *nostr.Relay, relaySub *nostr.Subscription, health *relayHealth) error {
+	defer relaySub.Unsub()
+	eose := relaySub.EndOfStoredEvents
+	for {
+		select {
+		case <-eose:
+			health.eose()
+			// The channel stays closed after the first EOSE
+			eose = nil
+		case evt, more := <-relaySub.Events:
+			if !more {
+				if relay.ConnectionError != nil {
+					return fmt.Errorf("connection closed: %w", relay.ConnectionError)
+				}
+				return fmt.Errorf("connection closed")
+			}
+			health.event()
+			select {
+			case nm.events <- relayEvent{Event: evt, relayUrl: url}:
+			case <-ctx.Done():
+				return nil
+			}
+		case reason := <-relaySub.ClosedReason:
+			return closedError(reason)
+		case <-ctx.Done():
+			return nil
+		}
+	}
+}
+
+// since returns the timestamp to resume a subscription to the relay from.
+func (nm *NostrManager) since(ctx context.Context, url string) *nostr.Timestamp {
+	nm.mu.RLock()
+	cursor, ok := nm.cursors[url]
+	nm.mu.RUnlock()
+	if !ok {
+		var err error
+		cursor, err = nm.store.Nwc.GetRelayCursor(ctx, url)
+		if err != nil {
+			log.Printf("failed to get cursor for relay %v: %v", url, err)
+		}
+		nm.mu.Lock()
+		if cursor > nm.cursors[url] {
+			nm.cursors[url] = cursor
+		}
+		nm.mu.Unlock()
+	}
+
+	now := time.Now()
+	from := now
+	if cursor > 0 {
+		from = time.Unix(cursor, 0)
+	}
+	from = from.Add(-SinceSafetyMargin)
+	if MaxBackfillDuration > 0 && from.Before(now.Add(-MaxBackfillDuration)) {
+		from = now.Add(-MaxBackfillDuration)
+	}
+
+	since := nostr.Timestamp(from.Unix())
+	return &since
+}
+
+// advanceCursor records the created_at of an event processed from the
+// relay, persisted by the next flush.
+func (nm *NostrManager) advanceCursor(url string, createdAt nostr.Timestamp) {
+	// Don't let events created in the future move the cursor ahead of time
+	cursor := min(int64(createdAt), time.Now().Unix())
+
+	nm.mu.Lock()
+	defer nm.mu.Unlock()
+	if cursor <= nm.cursors[url] {
+		return
+	}
+	nm.cursors[url] = cursor
+	nm.dirtyCursors[url] = struct{}{}
+}
+
+// StartCursorFlushLoop persists the advanced relay cursors periodically,
+// and a last time when the manager stops.
+func (nm *NostrManager) StartCursorFlushLoop() {
+	ticker := time.NewTicker(CursorFlushInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			nm.flushCursors(nm.ctx)
+		case <-nm.ctx.Done():
+			nm.flushCursors(context.Background())
+			return
+		}
+	}
+}
+
+// flushCursors persists the cursors advanced since the last flush. The
+// failed ones are retried by the next flush.
+func (nm *NostrManager) flushCursors(ctx context.Context) {
+	nm.mu.Lock()
+	cursors := make(map[string]int64, len(nm.dirtyCursors))
+	for url := range nm.dirtyCursors {
+		cursors[url] = nm.cursors[url]
+	}
+	clear(nm.dirtyCursors)
+	nm.mu.Unlock()
+
+	for url, cursor := range cursors {
+		if err := nm.store.Nwc.SetRelayCursor(ctx, url, cursor); err != nil {
+			log.Printf("failed to set cursor for relay %v: %v", url, err)
+			nm.mu.Lock()
+			nm.dirtyCursors[url] = struct{}{}
+			nm.mu.Unlock()
+		}
+	}
+}
@@ -0,0 +1,95 @@
+package nwc
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/breez/breez-lnurl/persist"
+	"github.com/nbd-wtf/go-nostr"
+	"gotest.tools/assert"
+)
+
+func TestSince(t *testing.T) {
+	maxBackfillDuration := MaxBackfillDuration
+	t.Cleanup(func() { MaxBackfillDuration = maxBackfillDuration })
+
+	const url = "wss://relay.example.com"
+	now := time.Now()
+	tests := []struct {
+		name        string
+		cursor      time.Time
+		maxBackfill time.Duration
+		expected    time.Time
+	}{
+		{
+			name:     "no cursor",
+			expected: now.Add(-SinceSafetyMargin),
+		},
+		{
+			name:     "recent cursor",
+			cursor:   now.Add(-time.Hour),
+			expected: now.Add(-time.Hour - SinceSafetyMargin),
+		},
+		{
+			name:        "recent cursor within the max backfill",
+			cursor:      now.Add(-time.Hour),
+			maxBackfill: 6 * time.Hour,
+			expected:    now.Add(-time.Hour - SinceSafetyMargin),
+		},
+		{
+			name:     "old cursor without max backfill",
+			cursor:   now.Add(-48 * time.Hour),
+			expected: now.Add(-48*time.Hour - SinceSafetyMargin),
+		},
+		{
+			name:        "cursor older than the max backfill",
+			cursor:      now.Add(-48 * time.Hour),
+			maxBackfill: 6 * time.Hour,
+			expected:    now.Add(-6 * time.Hour),
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			MaxBackfillDuration = test.maxBackfill
+			store := persist.NewMemoryStore()
+			if !test.cursor.IsZero() {
+				assert.NilError(t, store.Nwc.SetRelayCursor(context.Background(), url, test.cursor.Unix()))
+			}
+			nm := newTestManager(t, store)
+
+			since := nm.since(context.Background(), url)
+			// Allow for the seconds elapsed since now
+			assert.Assert(t, since.Time().Unix() >= test.expected.Unix()-1 && since.Time().Unix() <= test.expected.Unix()+1,
+				"expected %v, got %v", test.expected, since.Time())
+		})
+	}
+}
+
+func TestAdvanceCursor(t *testing.T) {
+	store := persist.NewMemoryStore()
+	nm := newTestManager(t, store)
+	ctx := context.Background()
+	const url = "wss://relay.example.com"
+	cursor := time.Now().Add(-time.Hour).Unix()
+
+	nm.advanceCursor(url, nostr.Timestamp(cursor))
+	nm.advanceCursor(url, nostr.Timestamp(cursor-60))
+	stored, err := store.Nwc.GetRelayCursor(ctx, url)
+	assert.NilError(t, err)
+	assert.Equal(t, stored, int64(0), "the cursor is only persisted by the flush")
+	assert.Equal(t, nm.since(ctx, url).Time().Unix(), time.Unix(cursor, 0).Add(-SinceSafetyMargin).Unix())
+
+	nm.flushCursors(ctx)
+	stored, err = store.Nwc.GetRelayCursor(ctx, url)
+	assert.NilError(t, err)
+	assert.Equal(t, stored, cursor)
+	assert.Equal(t, len(nm.dirtyCursors), 0)
+
+	// Events created in the future don't move the cursor ahead of time
+	nm.advanceCursor(url, nostr.Timestamp(time.Now().Add(time.Hour).Unix()))
+	nm.flushCursors(ctx)
+	stored, err = store.Nwc.GetRelayCursor(ctx, url)
+	assert.NilError(t, err)
+	assert.Assert(t, stored <= time.Now().Unix())
+}
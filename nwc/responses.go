@@ -0,0 +1,108 @@
+package nwc
+
+import (
+	"context"
+	"encoding/json"
+	"expvar"
+	"log"
+	"slices"
+	"time"
+
+	nwc "github.com/breez/breez-lnurl/persist/nwc"
+	"github.com/nbd-wtf/go-nostr"
+)
+
+// The NIP-47 request and response event kinds
+const (
+	requestKind  = 23194
+	responseKind = 23195
+)
+
+// The maximum validity period of a cached response
+var MaxCachedResponseValidity time.Duration = 24 * time.Hour
+
+// The time to wait for a relay to accept a cached response
+var PublishTimeout time.Duration = 10 * time.Second
+
+// The number of requests answered with a cached response
+var cachedResponsesServed = expvar.NewInt("nwc_cached_responses_served")
+
+// answerFromCache publishes the cached response matching the request, if any.
+// Each response is claimed by a single request, the later ones and the
+// requests whose response can't be published are notified to the webhook.
+func (nm *NostrManager) answerFromCache(webhook *nwc.Webhook, request relayEvent) bool {
+	responses, err := nm.store.Nwc.GetCachedResponses(nm.ctx, webhook.WalletServicePubkey, webhook.AppPubkey)
+	if err != nil {
+		log.Printf("failed to get cached responses for event %v: %v", request.ID, err)
+		return false
+	}
+
+	for _, response := range responses {
+		if !matchesFingerprint(response.Fingerprint, request.Event) {
+			continue
+		}
+		var event nostr.Event
+		if err := json.Unmarshal([]byte(response.Event), &event); err != nil {
+			log.Printf("failed to decode cached response %v: %v", response.Name, err)
+			continue
+		}
+		claimed, err := nm.store.Nwc.ClaimCachedResponse(nm.ctx, webhook.WalletServicePubkey, webhook.AppPubkey, response.Name)
+		if err != nil {
+			log.Printf("failed to claim cached response %v for event %v: %v", response.Name, request.ID, err)
+			return false
+		}
+		if !claimed {
+			// Answered another request since
+			continue
+		}
+
+		go func(name string) {
+			if err := nm.publish(request.relayUrl, event); err != nil {
+				log.Printf("failed to publish cached response %v for event %v: %v", name, request.ID, err)
+				nm.deliver(webhook, request)
+				return
+			}
+			log.Printf("answered event %v with cached response %v", request.ID, name)
+			cachedResponsesServed.Add(1)
+		}(response.Name)
+		return true
+	}
+	return false
+}
+
+func (nm *NostrManager) publish(url string, event nostr.Event) error {
+	if nm.isEmbeddedRelay(url) {
+		_, err := nm.store.Relay.Save(nm.ctx, &event)
+		return err
+	}
+	relay, err := nm.pool.EnsureRelay(url)
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(nm.ctx, PublishTimeout)
+	defer cancel()
+	return relay.Publish(ctx, event)
+}
+
+// matchesFingerprint returns whether the request event has the kind, content
+// length and tags of the fingerprint.
+func matchesFingerprint(fingerprint nwc.RequestFingerprint, event *nostr.Event) bool {
+	kind := fingerprint.Kind
+	if kind == 0 {
+		kind = requestKind
+	}
+	if event.Kind != kind {
+		return false
+	}
+	if fingerprint.ContentLength > 0 && len(event.Content) != fingerprint.ContentLength {
+		return false
+	}
+	for _, tag := range fingerprint.Tags {
+		if !slices.ContainsFunc(event.Tags, func(eventTag nostr.Tag) bool {
+			return len(eventTag) >= len(tag) && slices.Equal(eventTag[:len(tag)], tag)
+		}) {
+			return false
+		}
+	}
+	return true
+}
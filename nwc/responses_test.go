@@ -0,0 +1,115 @@
+package nwc
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/breez/breez-lnurl/channel"
+	"github.com/breez/breez-lnurl/persist"
+	nwc "github.com/breez/breez-lnurl/persist/nwc"
+	"github.com/nbd-wtf/go-nostr"
+	"gotest.tools/assert"
+)
+
+func TestMatchesFingerprint(t *testing.T) {
+	request := &nostr.Event{
+		Kind:    requestKind,
+		Content: "0123456789",
+		Tags:    nostr.Tags{{"p", "wallet"}, {"encryption", "nip44_v2"}},
+	}
+
+	assert.Assert(t, matchesFingerprint(nwc.RequestFingerprint{}, request), "an empty fingerprint matches any request")
+	assert.Assert(t, matchesFingerprint(nwc.RequestFingerprint{
+		ContentLength: 10,
+		Tags:          [][]string{{"encryption", "nip44_v2"}},
+	}, request))
+	assert.Assert(t, matchesFingerprint(nwc.RequestFingerprint{
+		Tags: [][]string{{"encryption"}},
+	}, request), "a tag name alone matches any value")
+
+	assert.Assert(t, !matchesFingerprint(nwc.RequestFingerprint{Kind: responseKind}, request))
+	assert.Assert(t, !matchesFingerprint(nwc.RequestFingerprint{ContentLength: 11}, request))
+	assert.Assert(t, !matchesFingerprint(nwc.RequestFingerprint{
+		Tags: [][]string{{"encryption", "nip44"}},
+	}, request), "tag values must match exactly")
+}
+
+func signedEvent(t *testing.T, key string, kind int, content string, tags nostr.Tags) *nostr.Event {
+	pubkey, err := nostr.GetPublicKey(key)
+	assert.NilError(t, err)
+	event := &nostr.Event{PubKey: pubkey, CreatedAt: nostr.Now(), Kind: kind, Content: content, Tags: tags}
+	assert.NilError(t, event.Sign(key))
+	return event
+}
+
+func TestCachedResponseAnswersOnce(t *testing.T) {
+	messages := make(chan channel.WebhookMessage, 10)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var message channel.WebhookMessage
+		json.NewDecoder(r.Body).Decode(&message)
+		messages <- message
+	}))
+	defer server.Close()
+
+	window := BatchWindow
+	BatchWindow = 0
+	defer func() { BatchWindow = window }()
+
+	const relayUrl = "wss://relay.example.com"
+	store := persist.NewMemoryStore()
+	nm := newTestManager(t, store)
+	nm.config.EmbeddedRelayUrl = relayUrl
+	go nm.forwardToNotify()
+
+	ctx := context.Background()
+	walletKey, appKey := nostr.GeneratePrivateKey(), nostr.GeneratePrivateKey()
+	walletPubkey, err := nostr.GetPublicKey(walletKey)
+	assert.NilError(t, err)
+	appPubkey, err := nostr.GetPublicKey(appKey)
+	assert.NilError(t, err)
+	assert.NilError(t, store.Nwc.Set(ctx, nwc.Webhook{
+		WalletServicePubkey: walletPubkey,
+		AppPubkey:           appPubkey,
+		Devices:             []nwc.Device{{Url: server.URL}},
+		Relays:              []string{relayUrl},
+	}))
+
+	response := signedEvent(t, walletKey, responseKind, "cached", nostr.Tags{{"p", appPubkey}})
+	encoded, err := json.Marshal(response)
+	assert.NilError(t, err)
+	assert.NilError(t, store.Nwc.SetCachedResponse(ctx, nwc.CachedResponse{
+		WalletServicePubkey: walletPubkey,
+		AppPubkey:           appPubkey,
+		Name:                "get_info",
+		Event:               string(encoded),
+		ExpiresAt:           time.Now().Add(time.Hour),
+	}))
+
+	first := signedEvent(t, appKey, requestKind, "first", nostr.Tags{{"p", walletPubkey}})
+	second := signedEvent(t, appKey, requestKind, "second", nostr.Tags{{"p", walletPubkey}})
+	nm.events <- relayEvent{Event: first, relayUrl: relayUrl}
+	nm.events <- relayEvent{Event: second, relayUrl: relayUrl}
+
+	// The first request is answered, the response is used up for the second
+	select {
+	case message := <-messages:
+		assert.Equal(t, message.Data["event_id"], second.ID)
+	case <-time.After(5 * time.Second):
+		t.Fatal("the second request did not reach the webhook")
+	}
+	published, err := store.Relay.Get(ctx, response.ID)
+	assert.NilError(t, err)
+	assert.Assert(t, published != nil, "the cached response was not published")
+	responses, err := store.Nwc.GetCachedResponses(ctx, walletPubkey, appPubkey)
+	assert.NilError(t, err)
+	assert.Equal(t, len(responses), 0)
+	select {
+	case message := <-messages:
+		t.Fatalf("unexpected webhook message for event %v", message.Data["event_id"])
+	case <-time.After(100 * time.Millisecond):
+	}
+}
@@ -3,15 +3,21 @@ package nwc
 import (
 	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
+	"math"
 	"net/http"
 	"net/url"
+	"slices"
+	"time"
 
+	"github.com/breez/breez-lnurl/constant"
 	"github.com/breez/breez-lnurl/persist"
 	nwc "github.com/breez/breez-lnurl/persist/nwc"
 	"github.com/breez/lspd/lightning"
 	"github.com/gorilla/mux"
+	"github.com/nbd-wtf/go-nostr"
 )
 
 type NostrEventsRouter struct {
@@ -20,15 +26,33 @@ type NostrEventsRouter struct {
 	rootURL *url.URL
 }
 
-func RegisterNostrEventsRouter(router *mux.Router, rootURL *url.URL, store *persist.Store, cleanupService *nwc.CleanupService) {
+func RegisterNostrEventsRouter(router *mux.Router, adminRouter *mux.Router, rootURL *url.URL, store *persist.Store, cleanupService *nwc.CleanupService, config Config) {
 	NostrEventsRouter := &NostrEventsRouter{
 		store:   store,
-		manager: NewNostrManager(store),
+		manager: NewNostrManager(store, config),
 		rootURL: rootURL,
 	}
 	NostrEventsRouter.manager.Start()
 	router.HandleFunc("/nwc/{pubkey}", NostrEventsRouter.Register).Methods("POST")
 	router.HandleFunc("/nwc/{pubkey}", NostrEventsRouter.Unregister).Methods("DELETE")
+	router.HandleFunc("/nwc/{pubkey}/list", NostrEventsRouter.List).Methods("POST")
+	router.HandleFunc("/nwc/{pubkey}/refresh", NostrEventsRouter.Refresh).Methods("POST")
+	router.HandleFunc("/nwc/{pubkey}/inspect", NostrEventsRouter.Inspect).Methods("POST")
+	router.HandleFunc("/nwc/{pubkey}/responses", NostrEventsRouter.SetCachedResponse).Methods("POST")
+	router.HandleFunc("/nwc/{pubkey}/responses", NostrEventsRouter.DeleteCachedResponse).Methods("DELETE")
+	router.HandleFunc("/nwc/{pubkey}/events", NostrEventsRouter.ReplayEvents).Methods("POST")
+	router.HandleFunc("/nwc/{pubkey}/events/ack", NostrEventsRouter.AcknowledgeEvents).Methods("POST")
+	adminRouter.HandleFunc("/nwc/relays", NostrEventsRouter.RelayHealth).Methods("GET")
+}
+
+type RegisterNostrEventsResponse struct {
+	// The registered relays the server is currently connected to
+	ConnectedRelays []string `json:"connectedRelays"`
+	// When the registration expires unless refreshed
+	ExpiresAt time.Time `json:"expiresAt"`
+	// The url of the relay served by this server, to include in the
+	// connection URIs. Omitted when not served.
+	EmbeddedRelay string `json:"embeddedRelay,omitempty"`
 }
 
 type RegisterNostrEventsRequest struct {
@@ -36,11 +60,64 @@ type RegisterNostrEventsRequest struct {
 	WalletServicePubkey string   `json:"walletServicePubkey"`
 	AppPubkey           string   `json:"appPubkey"`
 	Relays              []string `json:"relays"`
-	Signature           string   `json:"signature"`
+	// The relays requiring the server to authenticate (NIP-42)
+	AuthRelays []string `json:"authRelays,omitempty"`
+	// "full" to include the event in the webhook payloads, "id" by default
+	PayloadMode string `json:"payloadMode,omitempty"`
+	// The push provider delivering the webhook payloads, to respect its size limit
+	PushProvider string `json:"pushProvider,omitempty"`
+	// Identifies the device among the devices registered for the connection
+	DeviceId string `json:"deviceId,omitempty"`
+	// Devices are tried by ascending priority in the priority delivery
+	Priority int `json:"priority,omitempty"`
+	// "priority" to deliver the events to the first device accepting them, "all" by default
+	Delivery string `json:"delivery,omitempty"`
+	// Limits how often the devices are woken
+	Policy    *nwc.NotificationPolicy `json:"policy,omitempty"`
+	Signature string                  `json:"signature"`
+}
+
+// The maximum length of a device identifier
+const maxDeviceIdLength = 64
+
+// Validate checks the settings of the registration, before its signature.
+func (w *RegisterNostrEventsRequest) Validate() error {
+	switch w.PayloadMode {
+	case "", nwc.PayloadModeId, nwc.PayloadModeFull:
+	default:
+		return fmt.Errorf("invalid payload mode %v", w.PayloadMode)
+	}
+	if w.PushProvider != "" && !IsPushProvider(w.PushProvider) {
+		return fmt.Errorf("invalid push provider %v", w.PushProvider)
+	}
+	switch w.Delivery {
+	case "", nwc.DeliveryAll, nwc.DeliveryPriority:
+	default:
+		return fmt.Errorf("invalid delivery %v", w.Delivery)
+	}
+	if len(w.DeviceId) > maxDeviceIdLength {
+		return fmt.Errorf("invalid device id")
+	}
+	if w.Policy != nil {
+		return validatePolicy(*w.Policy)
+	}
+	return nil
 }
 
 func (w *RegisterNostrEventsRequest) Verify(pubkey string) error {
 	messageToVerify := fmt.Sprintf("%v-%v-%v-%v", w.WebhookUrl, w.WalletServicePubkey, w.AppPubkey, w.Relays)
+	if len(w.AuthRelays) > 0 {
+		messageToVerify = fmt.Sprintf("%v-%v", messageToVerify, w.AuthRelays)
+	}
+	if w.PayloadMode != "" || w.PushProvider != "" {
+		messageToVerify = fmt.Sprintf("%v-%v-%v", messageToVerify, w.PayloadMode, w.PushProvider)
+	}
+	if w.DeviceId != "" || w.Priority != 0 || w.Delivery != "" {
+		messageToVerify = fmt.Sprintf("%v-%v-%v-%v", messageToVerify, w.DeviceId, w.Priority, w.Delivery)
+	}
+	if p := w.Policy; p != nil {
+		messageToVerify = fmt.Sprintf("%v-%v-%v-%v-%v-%v-%v", messageToVerify, p.QuietHoursStart, p.QuietHoursEnd, p.TimeZone, p.MaxWakesPerHour, p.MinIntervalSeconds, p.Suppression)
+	}
 	verifiedPubkey, err := lightning.VerifyMessage([]byte(messageToVerify), w.Signature)
 	if err != nil {
 		return err
@@ -52,7 +129,8 @@ func (w *RegisterNostrEventsRequest) Verify(pubkey string) error {
 }
 
 /*
-Register adds a registration for a given pubkey, overwriting it if already present
+Register adds a registration for a given pubkey, overwriting it if already
+present and made by the same pubkey
 */
 func (s *NostrEventsRouter) Register(w http.ResponseWriter, r *http.Request) {
 	var registerRequest RegisterNostrEventsRequest
@@ -69,18 +147,45 @@ func (s *NostrEventsRouter) Register(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	if err := registerRequest.Validate(); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
 	if err := registerRequest.Verify(pubkey); err != nil {
 		log.Printf("failed to verify registration request: %v", err)
 		http.Error(w, "invalid signature", http.StatusUnauthorized)
 		return
 	}
 
+	var policy nwc.NotificationPolicy
+	if registerRequest.Policy != nil {
+		policy = *registerRequest.Policy
+	}
 	err := s.store.Nwc.Set(r.Context(), nwc.Webhook{
 		WalletServicePubkey: registerRequest.WalletServicePubkey,
-		Url:                 registerRequest.WebhookUrl,
 		AppPubkey:           registerRequest.AppPubkey,
-		Relays:              registerRequest.Relays,
+		Devices: []nwc.Device{{
+			Id:           registerRequest.DeviceId,
+			Url:          registerRequest.WebhookUrl,
+			PushProvider: registerRequest.PushProvider,
+			Priority:     registerRequest.Priority,
+		}},
+		Delivery:    registerRequest.Delivery,
+		Relays:      registerRequest.Relays,
+		AuthRelays:  registerRequest.AuthRelays,
+		OwnerPubkey: pubkey,
+		PayloadMode: registerRequest.PayloadMode,
+		Policy:      policy,
 	})
+	if errors.Is(err, nwc.ErrInvalidRelay) || errors.Is(err, nwc.ErrTooManyRelays) {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if errors.Is(err, nwc.ErrWebhookOwned) {
+		http.Error(w, err.Error(), http.StatusForbidden)
+		return
+	}
 	if err != nil {
 		log.Printf("failed to persist nwc details: %v", err)
 		w.WriteHeader(http.StatusInternalServerError)
@@ -88,18 +193,30 @@ func (s *NostrEventsRouter) Register(w http.ResponseWriter, r *http.Request) {
 	}
 
 	log.Printf("registration added: pubkey:%v\n", registerRequest.WalletServicePubkey)
-	w.Write([]byte("Pubkey registered successfully"))
+	writeJson(w, RegisterNostrEventsResponse{
+		ConnectedRelays: s.manager.ConnectedRelays(registerRequest.Relays),
+		ExpiresAt:       time.Now().Add(nwc.ExpiryDuration),
+		EmbeddedRelay:   s.manager.config.EmbeddedRelayUrl,
+	})
 }
 
 type UnregisterNostrEventsRequest struct {
 	Time                int64  `json:"time"`
 	WalletServicePubkey string `json:"walletServicePubkey"`
 	AppPubkey           string `json:"appPubkey"`
-	Signature           string `json:"signature"`
+	// Only unregisters this device when set
+	DeviceId  string `json:"deviceId,omitempty"`
+	Signature string `json:"signature"`
 }
 
 func (w *UnregisterNostrEventsRequest) Verify(pubkey string) error {
+	if math.Abs(float64(time.Now().Unix()-w.Time)) > constant.ACCEPTABLE_TIME_DIFF {
+		return errors.New("invalid time")
+	}
 	messageToVerify := fmt.Sprintf("%v-%v-%v", w.Time, w.WalletServicePubkey, w.AppPubkey)
+	if w.DeviceId != "" {
+		messageToVerify = fmt.Sprintf("%v-%v", messageToVerify, w.DeviceId)
+	}
 	verifiedPubkey, err := lightning.VerifyMessage([]byte(messageToVerify), w.Signature)
 	if err != nil {
 		return err
@@ -131,7 +248,16 @@ func (s *NostrEventsRouter) Unregister(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	err := s.store.Nwc.Delete(r.Context(), req.WalletServicePubkey, req.AppPubkey)
+	var err error
+	if req.DeviceId != "" {
+		err = s.store.Nwc.DeleteDevice(r.Context(), pubkey, req.WalletServicePubkey, req.AppPubkey, req.DeviceId)
+	} else {
+		err = s.store.Nwc.Delete(r.Context(), pubkey, req.WalletServicePubkey, req.AppPubkey)
+	}
+	if errors.Is(err, nwc.ErrWebhookOwned) {
+		http.Error(w, err.Error(), http.StatusForbidden)
+		return
+	}
 	if err != nil {
 		log.Printf("failed to delete nwc webhook: %v", err)
 		w.WriteHeader(http.StatusInternalServerError)
@@ -141,3 +267,547 @@ func (s *NostrEventsRouter) Unregister(w http.ResponseWriter, r *http.Request) {
 	log.Printf("registration deleted: pubkey:%v\n", req.WalletServicePubkey)
 	w.Write([]byte("Pubkey unregistered successfully"))
 }
+
+type NostrRegistration struct {
+	AppPubkey  string        `json:"appPubkey"`
+	Devices    []NostrDevice `json:"devices"`
+	Delivery   string        `json:"delivery"`
+	Relays     []string      `json:"relays"`
+	AuthRelays []string      `json:"authRelays"`
+	// The write relays of the app pubkey's NIP-65 relay list not in relays
+	DiscoveredRelays []string   `json:"discoveredRelays"`
+	PayloadMode      string     `json:"payloadMode"`
+	ConnectedRelays  []string   `json:"connectedRelays"`
+	UpdatedAt        time.Time  `json:"updatedAt"`
+	ExpiresAt        time.Time  `json:"expiresAt"`
+	LastEventAt      *time.Time `json:"lastEventAt,omitempty"`
+	LastDeliveryAt   *time.Time `json:"lastDeliveryAt,omitempty"`
+	// The notification policy and the events it delayed or dropped
+	Policy           nwc.NotificationPolicy `json:"policy"`
+	HeldEvents       int64                  `json:"heldEvents"`
+	DroppedEvents    int64                  `json:"droppedEvents"`
+	LastSuppressedAt *time.Time             `json:"lastSuppressedAt,omitempty"`
+}
+
+type NostrDevice struct {
+	DeviceId     string    `json:"deviceId"`
+	WebhookUrl   string    `json:"webhookUrl"`
+	PushProvider string    `json:"pushProvider,omitempty"`
+	Priority     int       `json:"priority"`
+	UpdatedAt    time.Time `json:"updatedAt"`
+	ExpiresAt    time.Time `json:"expiresAt"`
+}
+
+type NostrRegistrationsResponse struct {
+	Registrations []NostrRegistration `json:"registrations"`
+}
+
+type ListNostrEventsRequest struct {
+	Time                int64  `json:"time"`
+	WalletServicePubkey string `json:"walletServicePubkey"`
+	Signature           string `json:"signature"`
+}
+
+// Verify checks the request is signed for the action, so that signatures
+// can't be replayed on other endpoints.
+func (w *ListNostrEventsRequest) Verify(pubkey string, action string) error {
+	if math.Abs(float64(time.Now().Unix()-w.Time)) > constant.ACCEPTABLE_TIME_DIFF {
+		return errors.New("invalid time")
+	}
+	messageToVerify := fmt.Sprintf("%v-%v-%v", w.Time, w.WalletServicePubkey, action)
+	verifiedPubkey, err := lightning.VerifyMessage([]byte(messageToVerify), w.Signature)
+	if err != nil {
+		return err
+	}
+	if pubkey != hex.EncodeToString(verifiedPubkey.SerializeCompressed()) {
+		return fmt.Errorf("invalid signature")
+	}
+	return nil
+}
+
+type InspectNostrEventsRequest struct {
+	Time                int64  `json:"time"`
+	WalletServicePubkey string `json:"walletServicePubkey"`
+	AppPubkey           string `json:"appPubkey"`
+	Signature           string `json:"signature"`
+}
+
+func (w *InspectNostrEventsRequest) Verify(pubkey string) error {
+	if math.Abs(float64(time.Now().Unix()-w.Time)) > constant.ACCEPTABLE_TIME_DIFF {
+		return errors.New("invalid time")
+	}
+	messageToVerify := fmt.Sprintf("%v-%v-%v-inspect", w.Time, w.WalletServicePubkey, w.AppPubkey)
+	verifiedPubkey, err := lightning.VerifyMessage([]byte(messageToVerify), w.Signature)
+	if err != nil {
+		return err
+	}
+	if pubkey != hex.EncodeToString(verifiedPubkey.SerializeCompressed()) {
+		return fmt.Errorf("invalid signature")
+	}
+	return nil
+}
+
+/*
+List returns the registrations of a wallet service made by the given pubkey
+*/
+func (s *NostrEventsRouter) List(w http.ResponseWriter, r *http.Request) {
+	var req ListNostrEventsRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		log.Printf("json.NewDecoder.Decode error: %v", err)
+		http.Error(w, "invalid json", http.StatusBadRequest)
+		return
+	}
+
+	pubkey := mux.Vars(r)["pubkey"]
+	if err := req.Verify(pubkey, "list"); err != nil {
+		log.Printf("failed to verify list request: %v", err)
+		http.Error(w, "invalid signature", http.StatusUnauthorized)
+		return
+	}
+
+	s.writeRegistrations(w, r, pubkey, req.WalletServicePubkey)
+}
+
+/*
+Refresh postpones the expiry of all the registrations of a wallet service made by the given pubkey
+*/
+func (s *NostrEventsRouter) Refresh(w http.ResponseWriter, r *http.Request) {
+	var req ListNostrEventsRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		log.Printf("json.NewDecoder.Decode error: %v", err)
+		http.Error(w, "invalid json", http.StatusBadRequest)
+		return
+	}
+
+	pubkey := mux.Vars(r)["pubkey"]
+	if err := req.Verify(pubkey, "refresh"); err != nil {
+		log.Printf("failed to verify refresh request: %v", err)
+		http.Error(w, "invalid signature", http.StatusUnauthorized)
+		return
+	}
+
+	refreshed, err := s.store.Nwc.Refresh(r.Context(), pubkey, req.WalletServicePubkey)
+	if err != nil {
+		log.Printf("failed to refresh nwc webhooks: %v", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	log.Printf("registrations refreshed: pubkey:%v count:%v\n", req.WalletServicePubkey, refreshed)
+	s.writeRegistrations(w, r, pubkey, req.WalletServicePubkey)
+}
+
+/*
+Inspect returns a single registration made by the given pubkey
+*/
+func (s *NostrEventsRouter) Inspect(w http.ResponseWriter, r *http.Request) {
+	var req InspectNostrEventsRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		log.Printf("json.NewDecoder.Decode error: %v", err)
+		http.Error(w, "invalid json", http.StatusBadRequest)
+		return
+	}
+
+	pubkey := mux.Vars(r)["pubkey"]
+	if err := req.Verify(pubkey); err != nil {
+		log.Printf("failed to verify inspect request: %v", err)
+		http.Error(w, "invalid signature", http.StatusUnauthorized)
+		return
+	}
+
+	registrations, err := s.registrations(r, pubkey, req.WalletServicePubkey)
+	if err != nil {
+		log.Printf("failed to list nwc webhooks: %v", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	for _, registration := range registrations {
+		if registration.AppPubkey == req.AppPubkey {
+			writeJson(w, registration)
+			return
+		}
+	}
+	http.Error(w, "registration not found", http.StatusNotFound)
+}
+
+func (s *NostrEventsRouter) registrations(r *http.Request, ownerPubkey string, walletServicePubkey string) ([]NostrRegistration, error) {
+	statuses, err := s.store.Nwc.List(r.Context(), ownerPubkey, walletServicePubkey)
+	if err != nil {
+		return nil, err
+	}
+	registrations := make([]NostrRegistration, 0, len(statuses))
+	for _, status := range statuses {
+		devices := make([]NostrDevice, 0, len(status.Devices))
+		for _, device := range status.Devices {
+			devices = append(devices, NostrDevice{
+				DeviceId:     device.Id,
+				WebhookUrl:   device.Url,
+				PushProvider: device.PushProvider,
+				Priority:     device.Priority,
+				UpdatedAt:    device.UpdatedAt,
+				ExpiresAt:    device.UpdatedAt.Add(nwc.ExpiryDuration),
+			})
+		}
+		discovered := s.manager.DiscoveredRelays(status.AppPubkey, status.Relays)
+		registrations = append(registrations, NostrRegistration{
+			AppPubkey:        status.AppPubkey,
+			Devices:          devices,
+			Delivery:         status.Delivery,
+			Relays:           status.Relays,
+			AuthRelays:       status.AuthRelays,
+			DiscoveredRelays: discovered,
+			PayloadMode:      status.PayloadMode,
+			ConnectedRelays:  s.manager.ConnectedRelays(append(slices.Clone(status.Relays), discovered...)),
+			UpdatedAt:        status.UpdatedAt,
+			ExpiresAt:        status.UpdatedAt.Add(nwc.ExpiryDuration),
+			LastEventAt:      status.LastEventAt,
+			LastDeliveryAt:   status.LastDeliveryAt,
+			Policy:           status.Policy,
+			HeldEvents:       status.HeldEvents,
+			DroppedEvents:    status.DroppedEvents,
+			LastSuppressedAt: status.LastSuppressedAt,
+		})
+	}
+	return registrations, nil
+}
+
+func (s *NostrEventsRouter) writeRegistrations(w http.ResponseWriter, r *http.Request, ownerPubkey string, walletServicePubkey string) {
+	registrations, err := s.registrations(r, ownerPubkey, walletServicePubkey)
+	if err != nil {
+		log.Printf("failed to list nwc webhooks: %v", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	writeJson(w, NostrRegistrationsResponse{Registrations: registrations})
+}
+
+func writeJson(w http.ResponseWriter, value any) {
+	body, err := json.Marshal(value)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	w.Header().Add("Content-Type", "application/json")
+	w.Write(body)
+}
+
+type SetCachedResponseRequest struct {
+	Time                int64                  `json:"time"`
+	WalletServicePubkey string                 `json:"walletServicePubkey"`
+	AppPubkey           string                 `json:"appPubkey"`
+	Name                string                 `json:"name"`
+	Fingerprint         nwc.RequestFingerprint `json:"fingerprint"`
+	Event               nostr.Event            `json:"event"`
+	// In seconds since epoch
+	ExpiresAt int64  `json:"expiresAt"`
+	Signature string `json:"signature"`
+}
+
+func (w *SetCachedResponseRequest) Verify(pubkey string) error {
+	if math.Abs(float64(time.Now().Unix()-w.Time)) > constant.ACCEPTABLE_TIME_DIFF {
+		return errors.New("invalid time")
+	}
+	if w.Name == "" {
+		return errors.New("missing name")
+	}
+	if expiresAt := time.Unix(w.ExpiresAt, 0); time.Until(expiresAt) <= 0 || time.Until(expiresAt) > MaxCachedResponseValidity {
+		return fmt.Errorf("invalid expiry %v", w.ExpiresAt)
+	}
+	if w.Event.Kind != responseKind || w.Event.PubKey != w.WalletServicePubkey {
+		return errors.New("invalid response event")
+	}
+	if pTag := w.Event.Tags.GetFirst([]string{"p", w.AppPubkey}); pTag == nil {
+		return errors.New("response event not addressed to the app")
+	}
+	if ok, err := w.Event.CheckSignature(); !ok || err != nil {
+		return errors.New("invalid response event signature")
+	}
+	messageToVerify := fmt.Sprintf("%v-%v-%v-%v-%v-%v-%v-%v-%v", w.Time, w.WalletServicePubkey, w.AppPubkey, w.Name,
+		w.Fingerprint.Kind, w.Fingerprint.ContentLength, w.Fingerprint.Tags, w.Event.ID, w.ExpiresAt)
+	verifiedPubkey, err := lightning.VerifyMessage([]byte(messageToVerify), w.Signature)
+	if err != nil {
+		return err
+	}
+	if pubkey != hex.EncodeToString(verifiedPubkey.SerializeCompressed()) {
+		return fmt.Errorf("invalid signature")
+	}
+	return nil
+}
+
+type DeleteCachedResponseRequest struct {
+	Time                int64  `json:"time"`
+	WalletServicePubkey string `json:"walletServicePubkey"`
+	AppPubkey           string `json:"appPubkey"`
+	Name                string `json:"name"`
+	Signature           string `json:"signature"`
+}
+
+func (w *DeleteCachedResponseRequest) Verify(pubkey string) error {
+	if math.Abs(float64(time.Now().Unix()-w.Time)) > constant.ACCEPTABLE_TIME_DIFF {
+		return errors.New("invalid time")
+	}
+	messageToVerify := fmt.Sprintf("%v-%v-%v-%v", w.Time, w.WalletServicePubkey, w.AppPubkey, w.Name)
+	verifiedPubkey, err := lightning.VerifyMessage([]byte(messageToVerify), w.Signature)
+	if err != nil {
+		return err
+	}
+	if pubkey != hex.EncodeToString(verifiedPubkey.SerializeCompressed()) {
+		return fmt.Errorf("invalid signature")
+	}
+	return nil
+}
+
+/*
+SetCachedResponse stores a response answering the next matching request of a registration
+made by the given pubkey, until it expires
+*/
+func (s *NostrEventsRouter) SetCachedResponse(w http.ResponseWriter, r *http.Request) {
+	var req SetCachedResponseRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		log.Printf("json.NewDecoder.Decode error: %v", err)
+		http.Error(w, "invalid json", http.StatusBadRequest)
+		return
+	}
+
+	pubkey := mux.Vars(r)["pubkey"]
+	if err := req.Verify(pubkey); err != nil {
+		log.Printf("failed to verify cached response request: %v", err)
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+		return
+	}
+	if !s.isOwner(w, r, pubkey, req.WalletServicePubkey, req.AppPubkey) {
+		return
+	}
+
+	event, err := json.Marshal(req.Event)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	err = s.store.Nwc.SetCachedResponse(r.Context(), nwc.CachedResponse{
+		WalletServicePubkey: req.WalletServicePubkey,
+		AppPubkey:           req.AppPubkey,
+		Name:                req.Name,
+		Fingerprint:         req.Fingerprint,
+		Event:               string(event),
+		ExpiresAt:           time.Unix(req.ExpiresAt, 0),
+	})
+	if errors.Is(err, nwc.ErrWebhookNotFound) {
+		http.Error(w, "registration not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		log.Printf("failed to persist cached response: %v", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	log.Printf("cached response set: pubkey:%v name:%v\n", req.WalletServicePubkey, req.Name)
+	w.Write([]byte("Cached response set successfully"))
+}
+
+func (s *NostrEventsRouter) DeleteCachedResponse(w http.ResponseWriter, r *http.Request) {
+	var req DeleteCachedResponseRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		log.Printf("json.NewDecoder.Decode error: %v", err)
+		http.Error(w, "invalid json", http.StatusBadRequest)
+		return
+	}
+
+	pubkey := mux.Vars(r)["pubkey"]
+	if err := req.Verify(pubkey); err != nil {
+		log.Printf("failed to verify cached response request: %v", err)
+		http.Error(w, "invalid signature", http.StatusUnauthorized)
+		return
+	}
+	if !s.isOwner(w, r, pubkey, req.WalletServicePubkey, req.AppPubkey) {
+		return
+	}
+
+	err := s.store.Nwc.DeleteCachedResponse(r.Context(), req.WalletServicePubkey, req.AppPubkey, req.Name)
+	if err != nil {
+		log.Printf("failed to delete cached response: %v", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	log.Printf("cached response deleted: pubkey:%v name:%v\n", req.WalletServicePubkey, req.Name)
+	w.Write([]byte("Cached response deleted successfully"))
+}
+
+// The maximum number of events replayed or acknowledged by a request
+var MaxReplayEvents = 500
+
+type ReplayNostrEventsRequest struct {
+	Time                int64  `json:"time"`
+	WalletServicePubkey string `json:"walletServicePubkey"`
+	// In seconds since epoch
+	Since     int64  `json:"since"`
+	Signature string `json:"signature"`
+}
+
+func (w *ReplayNostrEventsRequest) Verify(pubkey string) error {
+	if math.Abs(float64(time.Now().Unix()-w.Time)) > constant.ACCEPTABLE_TIME_DIFF {
+		return errors.New("invalid time")
+	}
+	messageToVerify := fmt.Sprintf("%v-%v-%v-events", w.Time, w.WalletServicePubkey, w.Since)
+	verifiedPubkey, err := lightning.VerifyMessage([]byte(messageToVerify), w.Signature)
+	if err != nil {
+		return err
+	}
+	if pubkey != hex.EncodeToString(verifiedPubkey.SerializeCompressed()) {
+		return fmt.Errorf("invalid signature")
+	}
+	return nil
+}
+
+type NostrForwardedEvent struct {
+	EventId        string     `json:"eventId"`
+	AppPubkey      string     `json:"appPubkey"`
+	WebhookUrl     string     `json:"webhookUrl"`
+	Status         string     `json:"status"`
+	Attempts       int        `json:"attempts"`
+	ForwardedAt    time.Time  `json:"forwardedAt"`
+	AcknowledgedAt *time.Time `json:"acknowledgedAt,omitempty"`
+}
+
+type ReplayNostrEventsResponse struct {
+	Events []NostrForwardedEvent `json:"events"`
+	// The events forwarded before are no longer kept
+	RetainedSince time.Time `json:"retainedSince"`
+	// More events follow the last one returned
+	HasMore bool `json:"hasMore"`
+}
+
+/*
+ReplayEvents returns the events forwarded or attempted since the given time
+for the registrations of a wallet service made by the given pubkey
+*/
+func (s *NostrEventsRouter) ReplayEvents(w http.ResponseWriter, r *http.Request) {
+	var req ReplayNostrEventsRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		log.Printf("json.NewDecoder.Decode error: %v", err)
+		http.Error(w, "invalid json", http.StatusBadRequest)
+		return
+	}
+
+	pubkey := mux.Vars(r)["pubkey"]
+	if err := req.Verify(pubkey); err != nil {
+		log.Printf("failed to verify replay request: %v", err)
+		http.Error(w, "invalid signature", http.StatusUnauthorized)
+		return
+	}
+
+	retainedSince := time.Now().Add(-nwc.ForwardedEventsRetentionDuration)
+	since := time.Unix(req.Since, 0)
+	if since.Before(retainedSince) {
+		since = retainedSince
+	}
+	// One more event tells whether there are more
+	events, err := s.store.Nwc.ListForwardedEvents(r.Context(), pubkey, req.WalletServicePubkey, since, MaxReplayEvents+1)
+	if err != nil {
+		log.Printf("failed to list forwarded events: %v", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	response := ReplayNostrEventsResponse{
+		Events:        make([]NostrForwardedEvent, 0, len(events)),
+		RetainedSince: retainedSince,
+		HasMore:       len(events) > MaxReplayEvents,
+	}
+	if response.HasMore {
+		events = events[:MaxReplayEvents]
+	}
+	for _, event := range events {
+		response.Events = append(response.Events, NostrForwardedEvent{
+			EventId:        event.EventId,
+			AppPubkey:      event.AppPubkey,
+			WebhookUrl:     event.WebhookUrl,
+			Status:         event.Status,
+			Attempts:       event.Attempts,
+			ForwardedAt:    event.ForwardedAt,
+			AcknowledgedAt: event.AcknowledgedAt,
+		})
+	}
+	writeJson(w, response)
+}
+
+type AcknowledgeNostrEventsRequest struct {
+	Time                int64    `json:"time"`
+	WalletServicePubkey string   `json:"walletServicePubkey"`
+	EventIds            []string `json:"eventIds"`
+	Signature           string   `json:"signature"`
+}
+
+func (w *AcknowledgeNostrEventsRequest) Verify(pubkey string) error {
+	if math.Abs(float64(time.Now().Unix()-w.Time)) > constant.ACCEPTABLE_TIME_DIFF {
+		return errors.New("invalid time")
+	}
+	if len(w.EventIds) == 0 || len(w.EventIds) > MaxReplayEvents {
+		return fmt.Errorf("invalid number of event ids %v", len(w.EventIds))
+	}
+	messageToVerify := fmt.Sprintf("%v-%v-%v-ack", w.Time, w.WalletServicePubkey, w.EventIds)
+	verifiedPubkey, err := lightning.VerifyMessage([]byte(messageToVerify), w.Signature)
+	if err != nil {
+		return err
+	}
+	if pubkey != hex.EncodeToString(verifiedPubkey.SerializeCompressed()) {
+		return fmt.Errorf("invalid signature")
+	}
+	return nil
+}
+
+type AcknowledgeNostrEventsResponse struct {
+	Acknowledged int64 `json:"acknowledged"`
+}
+
+/*
+AcknowledgeEvents marks forwarded events as processed by the app
+*/
+func (s *NostrEventsRouter) AcknowledgeEvents(w http.ResponseWriter, r *http.Request) {
+	var req AcknowledgeNostrEventsRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		log.Printf("json.NewDecoder.Decode error: %v", err)
+		http.Error(w, "invalid json", http.StatusBadRequest)
+		return
+	}
+
+	pubkey := mux.Vars(r)["pubkey"]
+	if err := req.Verify(pubkey); err != nil {
+		log.Printf("failed to verify acknowledge request: %v", err)
+		http.Error(w, "invalid signature", http.StatusUnauthorized)
+		return
+	}
+
+	acknowledged, err := s.store.Nwc.AcknowledgeEvents(r.Context(), pubkey, req.WalletServicePubkey, req.EventIds)
+	if err != nil {
+		log.Printf("failed to acknowledge events: %v", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	writeJson(w, AcknowledgeNostrEventsResponse{Acknowledged: acknowledged})
+}
+
+// isOwner checks the registration exists and was made by the given pubkey,
+// writing the error response otherwise.
+func (s *NostrEventsRouter) isOwner(w http.ResponseWriter, r *http.Request, pubkey string, walletServicePubkey string, appPubkey string) bool {
+	webhook, err := s.store.Nwc.Get(r.Context(), walletServicePubkey, appPubkey)
+	if err != nil {
+		log.Printf("failed to get nwc webhook: %v", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return false
+	}
+	if webhook == nil || webhook.OwnerPubkey != pubkey {
+		http.Error(w, "registration not found", http.StatusNotFound)
+		return false
+	}
+	return true
+}
+
+/*
+RelayHealth lists the state of the relays the server is subscribed to.
+*/
+func (s *NostrEventsRouter) RelayHealth(w http.ResponseWriter, r *http.Request) {
+	writeJson(w, s.manager.RelayHealth())
+}
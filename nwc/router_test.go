@@ -0,0 +1,279 @@
+package nwc
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/breez/breez-lnurl/persist"
+	nwc "github.com/breez/breez-lnurl/persist/nwc"
+	"github.com/breez/lspd/lightning"
+	"github.com/btcsuite/btcd/btcec/v2/ecdsa"
+	"github.com/decred/dcrd/dcrec/secp256k1/v4"
+	"github.com/gorilla/mux"
+	"github.com/tv42/zbase32"
+	"gotest.tools/assert"
+)
+
+// testSigner signs the requests of a node pubkey.
+type testSigner struct {
+	key    *secp256k1.PrivateKey
+	pubkey string
+}
+
+func newTestSigner(t *testing.T) *testSigner {
+	key, err := secp256k1.GeneratePrivateKey()
+	assert.NilError(t, err)
+	return &testSigner{key: key, pubkey: hex.EncodeToString(key.PubKey().SerializeCompressed())}
+}
+
+func (s *testSigner) sign(t *testing.T, message string) string {
+	first := sha256.Sum256(append(lightning.SignedMsgPrefix, []byte(message)...))
+	second := sha256.Sum256(first[:])
+	sig, err := ecdsa.SignCompact(s.key, second[:], true)
+	assert.NilError(t, err)
+	return zbase32.EncodeToString(sig)
+}
+
+func newTestRouter(store *persist.Store) (*mux.Router, *NostrEventsRouter) {
+	rootURL, _ := url.Parse("https://example.com")
+	nwcRouter := &NostrEventsRouter{
+		store:   store,
+		manager: NewNostrManager(store, Config{}),
+		rootURL: rootURL,
+	}
+	router := mux.NewRouter()
+	router.HandleFunc("/nwc/{pubkey}", nwcRouter.Register).Methods("POST")
+	router.HandleFunc("/nwc/{pubkey}", nwcRouter.Unregister).Methods("DELETE")
+	router.HandleFunc("/nwc/{pubkey}/list", nwcRouter.List).Methods("POST")
+	router.HandleFunc("/nwc/{pubkey}/refresh", nwcRouter.Refresh).Methods("POST")
+	router.HandleFunc("/nwc/{pubkey}/inspect", nwcRouter.Inspect).Methods("POST")
+	router.HandleFunc("/admin/nwc/relays", nwcRouter.RelayHealth).Methods("GET")
+	return router, nwcRouter
+}
+
+func post(t *testing.T, router *mux.Router, path string, body any) *httptest.ResponseRecorder {
+	return request(t, router, http.MethodPost, path, body)
+}
+
+func request(t *testing.T, router *mux.Router, method string, path string, body any) *httptest.ResponseRecorder {
+	payload, err := json.Marshal(body)
+	assert.NilError(t, err)
+	res := httptest.NewRecorder()
+	router.ServeHTTP(res, httptest.NewRequest(method, path, bytes.NewReader(payload)))
+	return res
+}
+
+func register(t *testing.T, router *mux.Router, signer *testSigner, walletServicePubkey string, appPubkey string, webhookUrl string) *httptest.ResponseRecorder {
+	relays := []string{"wss://relay.example.com"}
+	return post(t, router, "/nwc/"+signer.pubkey, RegisterNostrEventsRequest{
+		WebhookUrl:          webhookUrl,
+		WalletServicePubkey: walletServicePubkey,
+		AppPubkey:           appPubkey,
+		Relays:              relays,
+		Signature:           signer.sign(t, fmt.Sprintf("%v-%v-%v-%v", webhookUrl, walletServicePubkey, appPubkey, relays)),
+	})
+}
+
+func TestRegisterOwnedByAnotherKey(t *testing.T) {
+	store := persist.NewMemoryStore()
+	router, _ := newTestRouter(store)
+	owner, other := newTestSigner(t), newTestSigner(t)
+	walletServicePubkey, appPubkey := newTestSigner(t).pubkey, newTestSigner(t).pubkey
+
+	res := register(t, router, owner, walletServicePubkey, appPubkey, "https://owner.example.com/hook")
+	assert.Equal(t, res.Code, http.StatusOK)
+
+	res = register(t, router, other, walletServicePubkey, appPubkey, "https://other.example.com/hook")
+	assert.Equal(t, res.Code, http.StatusForbidden)
+
+	webhook, err := store.Nwc.Get(context.Background(), walletServicePubkey, appPubkey)
+	assert.NilError(t, err)
+	assert.Equal(t, webhook.OwnerPubkey, owner.pubkey)
+	assert.Equal(t, len(webhook.Devices), 1)
+	assert.Equal(t, webhook.Devices[0].Url, "https://owner.example.com/hook")
+
+	// The owner still updates its registration
+	res = register(t, router, owner, walletServicePubkey, appPubkey, "https://owner.example.com/hook2")
+	assert.Equal(t, res.Code, http.StatusOK)
+}
+
+func TestRegisterValidatesSettings(t *testing.T) {
+	store := persist.NewMemoryStore()
+	router, _ := newTestRouter(store)
+	signer := newTestSigner(t)
+	walletServicePubkey, appPubkey := newTestSigner(t).pubkey, newTestSigner(t).pubkey
+	tests := []struct {
+		name    string
+		request RegisterNostrEventsRequest
+		reason  string
+	}{
+		{"payload mode", RegisterNostrEventsRequest{PayloadMode: "event"}, "invalid payload mode"},
+		{"push provider", RegisterNostrEventsRequest{PushProvider: "pager"}, "invalid push provider"},
+		{"delivery", RegisterNostrEventsRequest{Delivery: "first"}, "invalid delivery"},
+		{"device id", RegisterNostrEventsRequest{DeviceId: strings.Repeat("d", maxDeviceIdLength+1)}, "invalid device id"},
+		{"quiet hours", RegisterNostrEventsRequest{Policy: &nwc.NotificationPolicy{QuietHoursStart: "22:00"}}, "quiet hours"},
+		{"time zone", RegisterNostrEventsRequest{Policy: &nwc.NotificationPolicy{TimeZone: "Mars/Olympus"}}, "invalid time zone"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			request := test.request
+			request.WebhookUrl = "https://example.com/hook"
+			request.WalletServicePubkey = walletServicePubkey
+			request.AppPubkey = appPubkey
+			request.Relays = []string{"wss://relay.example.com"}
+			request.Signature = signer.sign(t, "invalid")
+			res := post(t, router, "/nwc/"+signer.pubkey, request)
+			assert.Equal(t, res.Code, http.StatusBadRequest)
+			assert.Assert(t, strings.Contains(res.Body.String(), test.reason), res.Body.String())
+		})
+	}
+
+	// Valid settings with an invalid signature
+	res := post(t, router, "/nwc/"+signer.pubkey, RegisterNostrEventsRequest{
+		WebhookUrl:          "https://example.com/hook",
+		WalletServicePubkey: walletServicePubkey,
+		AppPubkey:           appPubkey,
+		Relays:              []string{"wss://relay.example.com"},
+		Policy:              &nwc.NotificationPolicy{TimeZone: "Europe/Paris"},
+		Signature:           signer.sign(t, "invalid"),
+	})
+	assert.Equal(t, res.Code, http.StatusUnauthorized)
+}
+
+func unregister(t *testing.T, router *mux.Router, signer *testSigner, requestTime time.Time, walletServicePubkey string, appPubkey string, deviceId string) *httptest.ResponseRecorder {
+	message := fmt.Sprintf("%v-%v-%v", requestTime.Unix(), walletServicePubkey, appPubkey)
+	if deviceId != "" {
+		message = fmt.Sprintf("%v-%v", message, deviceId)
+	}
+	return request(t, router, http.MethodDelete, "/nwc/"+signer.pubkey, UnregisterNostrEventsRequest{
+		Time:                requestTime.Unix(),
+		WalletServicePubkey: walletServicePubkey,
+		AppPubkey:           appPubkey,
+		DeviceId:            deviceId,
+		Signature:           signer.sign(t, message),
+	})
+}
+
+func TestUnregisterOwnedByAnotherKey(t *testing.T) {
+	store := persist.NewMemoryStore()
+	router, _ := newTestRouter(store)
+	owner, other := newTestSigner(t), newTestSigner(t)
+	walletServicePubkey, appPubkey := newTestSigner(t).pubkey, newTestSigner(t).pubkey
+	assert.Equal(t, register(t, router, owner, walletServicePubkey, appPubkey, "https://owner.example.com/hook").Code, http.StatusOK)
+	webhook, err := store.Nwc.Get(context.Background(), walletServicePubkey, appPubkey)
+	assert.NilError(t, err)
+	deviceId := webhook.Devices[0].Id
+
+	res := unregister(t, router, other, time.Now(), walletServicePubkey, appPubkey, "")
+	assert.Equal(t, res.Code, http.StatusForbidden)
+	res = unregister(t, router, other, time.Now(), walletServicePubkey, appPubkey, deviceId)
+	assert.Equal(t, res.Code, http.StatusForbidden)
+	webhook, err = store.Nwc.Get(context.Background(), walletServicePubkey, appPubkey)
+	assert.NilError(t, err)
+	assert.Assert(t, webhook != nil, "the registration of another key was removed")
+	assert.Equal(t, len(webhook.Devices), 1)
+
+	res = unregister(t, router, owner, time.Now(), walletServicePubkey, appPubkey, "")
+	assert.Equal(t, res.Code, http.StatusOK)
+	webhook, err = store.Nwc.Get(context.Background(), walletServicePubkey, appPubkey)
+	assert.NilError(t, err)
+	assert.Assert(t, webhook == nil)
+}
+
+func TestUnregisterChecksTime(t *testing.T) {
+	store := persist.NewMemoryStore()
+	router, _ := newTestRouter(store)
+	owner := newTestSigner(t)
+	walletServicePubkey, appPubkey := newTestSigner(t).pubkey, newTestSigner(t).pubkey
+	assert.Equal(t, register(t, router, owner, walletServicePubkey, appPubkey, "https://owner.example.com/hook").Code, http.StatusOK)
+
+	// A captured request can't be replayed later
+	res := unregister(t, router, owner, time.Now().Add(-time.Hour), walletServicePubkey, appPubkey, "")
+	assert.Equal(t, res.Code, http.StatusUnauthorized)
+	webhook, err := store.Nwc.Get(context.Background(), walletServicePubkey, appPubkey)
+	assert.NilError(t, err)
+	assert.Assert(t, webhook != nil)
+}
+
+func listRequest(t *testing.T, signer *testSigner, walletServicePubkey string, action string) ListNostrEventsRequest {
+	now := time.Now().Unix()
+	return ListNostrEventsRequest{
+		Time:                now,
+		WalletServicePubkey: walletServicePubkey,
+		Signature:           signer.sign(t, fmt.Sprintf("%v-%v-%v", now, walletServicePubkey, action)),
+	}
+}
+
+func decodeRegistrations(t *testing.T, res *httptest.ResponseRecorder) []NostrRegistration {
+	assert.Equal(t, res.Code, http.StatusOK)
+	var response NostrRegistrationsResponse
+	assert.NilError(t, json.NewDecoder(res.Body).Decode(&response))
+	return response.Registrations
+}
+
+func TestListRefreshInspect(t *testing.T) {
+	store := persist.NewMemoryStore()
+	router, _ := newTestRouter(store)
+	owner, other := newTestSigner(t), newTestSigner(t)
+	walletServicePubkey := newTestSigner(t).pubkey
+	ownerApp, otherApp := newTestSigner(t).pubkey, newTestSigner(t).pubkey
+	assert.Equal(t, register(t, router, owner, walletServicePubkey, ownerApp, "https://owner.example.com/hook").Code, http.StatusOK)
+	assert.Equal(t, register(t, router, other, walletServicePubkey, otherApp, "https://other.example.com/hook").Code, http.StatusOK)
+
+	// Only the registrations of the owner are listed
+	registrations := decodeRegistrations(t, post(t, router, "/nwc/"+owner.pubkey+"/list", listRequest(t, owner, walletServicePubkey, "list")))
+	assert.Equal(t, len(registrations), 1)
+	listed := registrations[0]
+	assert.Equal(t, listed.AppPubkey, ownerApp)
+	assert.Equal(t, listed.ExpiresAt, listed.UpdatedAt.Add(nwc.ExpiryDuration))
+	assert.Equal(t, len(listed.Devices), 1)
+	assert.Equal(t, listed.Devices[0].WebhookUrl, "https://owner.example.com/hook")
+	assert.Equal(t, listed.Devices[0].ExpiresAt, listed.Devices[0].UpdatedAt.Add(nwc.ExpiryDuration))
+
+	// Signatures are checked, and not accepted for another action
+	invalid := listRequest(t, other, walletServicePubkey, "list")
+	assert.Equal(t, post(t, router, "/nwc/"+owner.pubkey+"/list", invalid).Code, http.StatusUnauthorized)
+	replayed := listRequest(t, owner, walletServicePubkey, "list")
+	assert.Equal(t, post(t, router, "/nwc/"+owner.pubkey+"/refresh", replayed).Code, http.StatusUnauthorized)
+
+	otherBefore := decodeRegistrations(t, post(t, router, "/nwc/"+other.pubkey+"/list", listRequest(t, other, walletServicePubkey, "list")))
+	assert.Equal(t, len(otherBefore), 1)
+
+	// Refreshing postpones the expiry of the owner's registrations only
+	time.Sleep(10 * time.Millisecond)
+	refreshed := decodeRegistrations(t, post(t, router, "/nwc/"+owner.pubkey+"/refresh", listRequest(t, owner, walletServicePubkey, "refresh")))
+	assert.Equal(t, len(refreshed), 1)
+	assert.Equal(t, refreshed[0].AppPubkey, ownerApp)
+	assert.Assert(t, refreshed[0].ExpiresAt.After(listed.ExpiresAt))
+	assert.Assert(t, refreshed[0].Devices[0].ExpiresAt.After(listed.Devices[0].ExpiresAt))
+	otherAfter := decodeRegistrations(t, post(t, router, "/nwc/"+other.pubkey+"/list", listRequest(t, other, walletServicePubkey, "list")))
+	assert.Equal(t, otherAfter[0].ExpiresAt, otherBefore[0].ExpiresAt)
+
+	// Only the registrations of the owner are inspected
+	inspect := func(signer *testSigner, appPubkey string) *httptest.ResponseRecorder {
+		now := time.Now().Unix()
+		return post(t, router, "/nwc/"+signer.pubkey+"/inspect", InspectNostrEventsRequest{
+			Time:                now,
+			WalletServicePubkey: walletServicePubkey,
+			AppPubkey:           appPubkey,
+			Signature:           signer.sign(t, fmt.Sprintf("%v-%v-%v-inspect", now, walletServicePubkey, appPubkey)),
+		})
+	}
+	res := inspect(owner, ownerApp)
+	assert.Equal(t, res.Code, http.StatusOK)
+	var inspected NostrRegistration
+	assert.NilError(t, json.NewDecoder(res.Body).Decode(&inspected))
+	assert.Equal(t, inspected.AppPubkey, ownerApp)
+	assert.Equal(t, inspected.ExpiresAt, refreshed[0].ExpiresAt)
+	assert.Equal(t, inspect(owner, otherApp).Code, http.StatusNotFound)
+}
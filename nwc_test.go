@@ -244,8 +244,8 @@ func TestNwcRegistrationOverwrite(t *testing.T) {
 	if webhook == nil {
 		t.Errorf("Expected webhook to be registered")
 	}
-	if webhook != nil && webhook.Url != "http://localhost:8080/callback2" {
-		t.Errorf("Expected webhook URL to be overwritten, got %v", webhook.Url)
+	if webhook != nil && (len(webhook.Devices) != 1 || webhook.Devices[0].Url != "http://localhost:8080/callback2") {
+		t.Errorf("Expected webhook URL to be overwritten, got %v", webhook.Devices)
 	}
 	if webhook != nil && len(webhook.Relays) != 1 {
 		t.Errorf("Expected 1 relay, got %d", len(webhook.Relays))
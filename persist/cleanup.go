@@ -4,21 +4,25 @@ import (
 	"context"
 	lnurl "github.com/breez/breez-lnurl/persist/lnurl"
 	nwc "github.com/breez/breez-lnurl/persist/nwc"
+	relay "github.com/breez/breez-lnurl/persist/relay"
 )
 
 type CleanupService struct {
 	Lnurl *lnurl.CleanupService
 	Nwc   *nwc.CleanupService
+	Relay *relay.CleanupService
 }
 
 func NewCleanupService(store *Store) *CleanupService {
 	return &CleanupService{
 		Lnurl: lnurl.NewCleanupService(store.LnUrl),
 		Nwc:   nwc.NewCleanupService(store.Nwc),
+		Relay: relay.NewCleanupService(store.Relay),
 	}
 }
 
 func (c *CleanupService) Start(ctx context.Context) {
 	go c.Lnurl.Start(ctx)
 	go c.Nwc.Start(ctx)
+	go c.Relay.Start(ctx)
 }
@@ -0,0 +1,77 @@
+package persist
+
+import (
+	"context"
+	"slices"
+	"sync"
+	"time"
+)
+
+type MemoryStore struct {
+	mu     sync.Mutex
+	keys   []Key
+	lastId int64
+}
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{}
+}
+
+func (m *MemoryStore) ListKeys(ctx context.Context, zone string) ([]Key, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var keys []Key
+	for _, key := range m.keys {
+		if key.Zone == zone {
+			keys = append(keys, key)
+		}
+	}
+	return keys, nil
+}
+
+func (m *MemoryStore) InitKeys(ctx context.Context, zone string, keys []Key) ([]Key, error) {
+	m.mu.Lock()
+	if !slices.ContainsFunc(m.keys, func(key Key) bool { return key.Zone == zone }) {
+		for _, key := range keys {
+			m.add(key)
+		}
+	}
+	m.mu.Unlock()
+	return m.ListKeys(ctx, zone)
+}
+
+func (m *MemoryStore) AddKey(ctx context.Context, key Key) (*Key, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	added := m.add(key)
+	return &added, nil
+}
+
+func (m *MemoryStore) add(key Key) Key {
+	m.lastId++
+	key.Id = m.lastId
+	key.CreatedAt = time.Now()
+	key.UpdatedAt = key.CreatedAt
+	m.keys = append(m.keys, key)
+	return key
+}
+
+func (m *MemoryStore) SetKeyState(ctx context.Context, id int64, from string, to string) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for i, key := range m.keys {
+		if key.Id == id && key.State == from {
+			m.keys[i].State = to
+			m.keys[i].UpdatedAt = time.Now()
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+func (m *MemoryStore) DeleteKey(ctx context.Context, id int64) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.keys = slices.DeleteFunc(m.keys, func(key Key) bool { return key.Id == id })
+	return nil
+}
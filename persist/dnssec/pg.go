@@ -0,0 +1,119 @@
+package persist
+
+import (
+	"context"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+type PgStore struct {
+	pool *pgxpool.Pool
+}
+
+func NewPgStore(pool *pgxpool.Pool) *PgStore {
+	return &PgStore{
+		pool,
+	}
+}
+
+func (s *PgStore) ListKeys(ctx context.Context, zone string) ([]Key, error) {
+	rows, err := s.pool.Query(
+		ctx,
+		`SELECT id, zone, flags, public_key, private_key, state, created_at, updated_at
+		 FROM public.dnssec_keys
+		 WHERE zone = $1
+		 ORDER BY id`,
+		zone,
+	)
+	if err != nil {
+		return nil, err
+	}
+	return pgx.CollectRows(rows, pgx.RowToStructByName[Key])
+}
+
+func (s *PgStore) InitKeys(ctx context.Context, zone string, keys []Key) ([]Key, error) {
+	tx, err := s.pool.Begin(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback(ctx)
+
+	// Serializes the replicas initializing the zone at the same time
+	if _, err = tx.Exec(ctx, `LOCK TABLE public.dnssec_keys IN EXCLUSIVE MODE`); err != nil {
+		return nil, err
+	}
+	var exists bool
+	err = tx.QueryRow(ctx, `SELECT EXISTS (SELECT 1 FROM public.dnssec_keys WHERE zone = $1)`, zone).Scan(&exists)
+	if err != nil {
+		return nil, err
+	}
+	if !exists {
+		for _, key := range keys {
+			_, err = tx.Exec(
+				ctx,
+				`INSERT INTO public.dnssec_keys (zone, flags, public_key, private_key, state)
+				 VALUES ($1, $2, $3, $4, $5)`,
+				zone,
+				key.Flags,
+				key.PublicKey,
+				key.PrivateKey,
+				key.State,
+			)
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+	if err = tx.Commit(ctx); err != nil {
+		return nil, err
+	}
+	return s.ListKeys(ctx, zone)
+}
+
+func (s *PgStore) AddKey(ctx context.Context, key Key) (*Key, error) {
+	rows, err := s.pool.Query(
+		ctx,
+		`INSERT INTO public.dnssec_keys (zone, flags, public_key, private_key, state)
+		 VALUES ($1, $2, $3, $4, $5)
+		 RETURNING id, zone, flags, public_key, private_key, state, created_at, updated_at`,
+		key.Zone,
+		key.Flags,
+		key.PublicKey,
+		key.PrivateKey,
+		key.State,
+	)
+	if err != nil {
+		return nil, err
+	}
+	added, err := pgx.CollectExactlyOneRow(rows, pgx.RowToStructByName[Key])
+	if err != nil {
+		return nil, err
+	}
+	return &added, nil
+}
+
+func (s *PgStore) SetKeyState(ctx context.Context, id int64, from string, to string) (bool, error) {
+	res, err := s.pool.Exec(
+		ctx,
+		`UPDATE public.dnssec_keys
+		 SET state = $3, updated_at = NOW()
+		 WHERE id = $1 AND state = $2`,
+		id,
+		from,
+		to,
+	)
+	if err != nil {
+		return false, err
+	}
+	return res.RowsAffected() == 1, nil
+}
+
+func (s *PgStore) DeleteKey(ctx context.Context, id int64) error {
+	_, err := s.pool.Exec(
+		ctx,
+		`DELETE FROM public.dnssec_keys WHERE id = $1`,
+		id,
+	)
+	return err
+}
@@ -0,0 +1,44 @@
+package persist
+
+import (
+	"context"
+	"time"
+)
+
+const (
+	// The key is in the DNSKEY RRset, but doesn't sign yet
+	KeyPublished = "published"
+	KeyActive    = "active"
+	// The key is still in the DNSKEY RRset, until the signatures it made
+	// expire from the caches
+	KeyRetired = "retired"
+)
+
+// Key is a DNSSEC key of a zone.
+type Key struct {
+	Id   int64  `json:"id" db:"id"`
+	Zone string `json:"zone" db:"zone"`
+	// 257 for a key signing key, 256 for a zone signing key
+	Flags int `json:"flags" db:"flags"`
+	// The DNSKEY record, in the zone file format
+	PublicKey string `json:"publicKey" db:"public_key"`
+	// The private key, in the BIND private key format
+	PrivateKey string    `json:"-" db:"private_key"`
+	State      string    `json:"state" db:"state"`
+	CreatedAt  time.Time `json:"createdAt" db:"created_at"`
+	// When the key moved to its state
+	UpdatedAt time.Time `json:"updatedAt" db:"updated_at"`
+}
+
+type Store interface {
+	// ListKeys returns the keys of the zone, oldest first.
+	ListKeys(ctx context.Context, zone string) ([]Key, error)
+	// InitKeys adds the keys to the zone unless it has keys already, and
+	// returns the keys of the zone.
+	InitKeys(ctx context.Context, zone string, keys []Key) ([]Key, error)
+	AddKey(ctx context.Context, key Key) (*Key, error)
+	// SetKeyState moves the key to a state, returning whether it was in the
+	// from state.
+	SetKeyState(ctx context.Context, id int64, from string, to string) (bool, error)
+	DeleteKey(ctx context.Context, id int64) error
+}
@@ -3,19 +3,28 @@ package persist
 import (
 	"time"
 	"context"
+	"slices"
+	"sync"
 )
 
 type MemoryStore struct {
-	webhooks []Webhook
+	// Guards webhooks and dnsRecords, which the DNS servers and worker read
+	// concurrently
+	mu         sync.RWMutex
+	webhooks   []Webhook
+	dnsRecords map[string]*DnsRecord
 }
 
 func NewMemoryStore() *MemoryStore {
 	return &MemoryStore {
 		webhooks: []Webhook{},
+		dnsRecords: make(map[string]*DnsRecord),
 	}
 }
 
 func (m *MemoryStore) Set(ctx context.Context, webhook Webhook) (*Webhook, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
 	var hooks []Webhook
 	for _, hook := range m.webhooks {
 		if hook.Pubkey == webhook.Pubkey && hook.Url == webhook.Url {
@@ -27,11 +36,17 @@ func (m *MemoryStore) Set(ctx context.Context, webhook Webhook) (*Webhook, error
 	return &webhook, nil
 }
 
-func (m *MemoryStore) SetPubkeyDetails(ctx context.Context, pubkey string, username string, offer *string) (*PubkeyDetails, error) {
+func (m *MemoryStore) SetPubkeyDetails(ctx context.Context, pubkey string, username string, offer *string, params *PaymentParams) (*PubkeyDetails, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
 	var hooks []Webhook
 	var webhook Webhook
+	var last *PubkeyDetails
 	for _, hook := range m.webhooks {
 		if hook.Pubkey == pubkey {
+			if last == nil && hook.Username != nil {
+				last = &PubkeyDetails{Pubkey: pubkey, Username: *hook.Username, Offer: hook.Offer, Bip21Params: hook.Bip21Params}
+			}
 			webhook = hook
 			continue
 		}
@@ -41,15 +56,40 @@ func (m *MemoryStore) SetPubkeyDetails(ctx context.Context, pubkey string, usern
 	webhook.Pubkey = pubkey
 	webhook.Username = &username
 	webhook.Offer = offer
+	webhook.Bip21Params = params
 	m.webhooks = append([]Webhook{webhook}, hooks...)
-	return &PubkeyDetails{
-		Pubkey:   webhook.Pubkey,
-		Username: username,
-		Offer:    offer,
-	}, nil
+	details := PubkeyDetails{
+		Pubkey:      webhook.Pubkey,
+		Username:    username,
+		Offer:       offer,
+		Bip21Params: params,
+	}
+
+	updates := dnsUpdates(last, details)
+	for _, update := range updates {
+		version := int64(1)
+		if record, ok := m.dnsRecords[update.Username]; ok {
+			version = record.Version + 1
+		}
+		update.State = DnsRecordPending
+		update.NextAttemptAt = time.Now()
+		update.Version = version
+		update.UpdatedAt = time.Now()
+		m.dnsRecords[update.Username] = &update
+	}
+	if record, ok := m.dnsRecords[username]; ok && len(updates) == 0 && offer != nil && record.State == DnsRecordFailed {
+		record.State = DnsRecordPending
+		record.Attempts = 0
+		record.NextAttemptAt = time.Now()
+		record.Version++
+		record.UpdatedAt = time.Now()
+	}
+	return &details, nil
 }
 
 func (m *MemoryStore) GetLastUpdated(ctx context.Context, identifier string) (*Webhook, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
 	for _, hook := range m.webhooks {
 		if hook.Compare(identifier) {
 			return &hook, nil
@@ -59,13 +99,16 @@ func (m *MemoryStore) GetLastUpdated(ctx context.Context, identifier string) (*W
 }
 
 func (m *MemoryStore) GetPubkeyDetails(ctx context.Context, identifier string) (*PubkeyDetails, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
 	for _, hook := range m.webhooks {
 		if hook.Compare(identifier) {
 			if hook.Username != nil {
 				return &PubkeyDetails{
-					Pubkey:   hook.Pubkey,
-					Username: *hook.Username,
-					Offer:    hook.Offer,
+					Pubkey:      hook.Pubkey,
+					Username:    *hook.Username,
+					Offer:       hook.Offer,
+					Bip21Params: hook.Bip21Params,
 				}, nil
 			}
 		}
@@ -73,7 +116,27 @@ func (m *MemoryStore) GetPubkeyDetails(ctx context.Context, identifier string) (
 	return nil, nil
 }
 
+func (m *MemoryStore) ListPubkeyDetails(ctx context.Context) ([]PubkeyDetails, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	var details []PubkeyDetails
+	for _, hook := range m.webhooks {
+		if hook.Username == nil || slices.ContainsFunc(details, func(d PubkeyDetails) bool { return d.Pubkey == hook.Pubkey }) {
+			continue
+		}
+		details = append(details, PubkeyDetails{
+			Pubkey:      hook.Pubkey,
+			Username:    *hook.Username,
+			Offer:       hook.Offer,
+			Bip21Params: hook.Bip21Params,
+		})
+	}
+	return details, nil
+}
+
 func (m *MemoryStore) Remove(ctx context.Context, pubkey, url string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
 	var hooks []Webhook
 	for _, hook := range m.webhooks {
 		if hook.Pubkey == pubkey && hook.Url == url {
@@ -85,6 +148,71 @@ func (m *MemoryStore) Remove(ctx context.Context, pubkey, url string) error {
 	return nil
 }
 
+func (m *MemoryStore) GetDnsRecord(ctx context.Context, username string) (*DnsRecord, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	record, ok := m.dnsRecords[username]
+	if !ok {
+		return nil, nil
+	}
+	r := *record
+	return &r, nil
+}
+
+func (m *MemoryStore) ClaimDnsRecords(ctx context.Context, now time.Time, leaseUntil time.Time, limit int) ([]DnsRecord, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var records []DnsRecord
+	for _, record := range m.dnsRecords {
+		if len(records) == limit {
+			break
+		}
+		if record.State != DnsRecordPending || record.NextAttemptAt.After(now) {
+			continue
+		}
+		record.NextAttemptAt = leaseUntil
+		records = append(records, *record)
+	}
+	return records, nil
+}
+
+func (m *MemoryStore) MarkDnsRecordPublished(ctx context.Context, username string, version int64) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	record, ok := m.dnsRecords[username]
+	if !ok || record.Version != version {
+		return nil
+	}
+	if record.Offer == nil {
+		delete(m.dnsRecords, username)
+		return nil
+	}
+	record.State = DnsRecordPublished
+	record.Attempts++
+	record.LastError = nil
+	record.UpdatedAt = time.Now()
+	return nil
+}
+
+func (m *MemoryStore) MarkDnsRecordFailed(ctx context.Context, username string, version int64, lastError string, retryAt *time.Time) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	record, ok := m.dnsRecords[username]
+	if !ok || record.Version != version {
+		return nil
+	}
+	record.State = DnsRecordFailed
+	record.NextAttemptAt = time.Now()
+	if retryAt != nil {
+		record.State = DnsRecordPending
+		record.NextAttemptAt = *retryAt
+	}
+	record.Attempts++
+	record.LastError = &lastError
+	record.UpdatedAt = time.Now()
+	return nil
+}
+
 func (m *MemoryStore) DeleteExpired(ctx context.Context, before time.Time) error {
 	return nil
 }
@@ -28,7 +28,7 @@ func (s *PgStore) Set(ctx context.Context, webhook Webhook) (*Webhook, error) {
 	}
 	if webhook.Username != nil {
 		username := strings.ToLower(*webhook.Username)
-		_, err := s.SetPubkeyDetails(ctx, webhook.Pubkey, username, webhook.Offer)
+		_, err := s.SetPubkeyDetails(ctx, webhook.Pubkey, username, webhook.Offer, webhook.Bip21Params)
 		if err != nil {
 			return nil, err
 		}
@@ -55,20 +55,48 @@ func (s *PgStore) Set(ctx context.Context, webhook Webhook) (*Webhook, error) {
 	return &webhook, err
 }
 
-func (s *PgStore) SetPubkeyDetails(ctx context.Context, pubkey string, username string, offer *string) (*PubkeyDetails, error) {
+func (s *PgStore) SetPubkeyDetails(ctx context.Context, pubkey string, username string, offer *string, params *PaymentParams) (*PubkeyDetails, error) {
 	pk, err := hex.DecodeString(pubkey)
 	if err != nil {
 		return nil, err
 	}
 	username = strings.ToLower(username)
-	res, err := s.pool.Exec(
+	tx, err := s.pool.Begin(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback(ctx)
+
+	// Lock the last details, so concurrent updates queue their DNS updates in order
+	var last *PubkeyDetails
+	rows, err := tx.Query(
 		ctx,
-		`INSERT INTO public.pubkey_details (pubkey, username, offer) 
-		 values ($1, $2, $3)
-		 ON CONFLICT (pubkey) DO UPDATE SET username = $2, offer = $3`,
+		`SELECT encode(pubkey, 'hex') pubkey, username, offer, bip21_params
+		 FROM public.pubkey_details
+		 WHERE pubkey = $1
+		 FOR UPDATE`,
+		pk,
+	)
+	if err != nil {
+		return nil, err
+	}
+	lastDetails, err := pgx.CollectRows(rows, pgx.RowToStructByName[PubkeyDetails])
+	if err != nil {
+		return nil, err
+	}
+	if len(lastDetails) == 1 {
+		last = &lastDetails[0]
+	}
+
+	res, err := tx.Exec(
+		ctx,
+		`INSERT INTO public.pubkey_details (pubkey, username, offer, bip21_params) 
+		 values ($1, $2, $3, $4)
+		 ON CONFLICT (pubkey) DO UPDATE SET username = $2, offer = $3, bip21_params = $4`,
 		pk,
 		username,
 		offer,
+		params,
 	)
 	if err != nil {
 		return nil, NewErrorUsernameConflict(username, err)
@@ -77,11 +105,47 @@ func (s *PgStore) SetPubkeyDetails(ctx context.Context, pubkey string, username
 	if res.RowsAffected() == 0 {
 		return nil, fmt.Errorf("failed to set offer for pubkey: %v", pubkey)
 	}
-	return &PubkeyDetails{
-		Pubkey:   pubkey,
-		Username: username,
-		Offer:    offer,
-	}, nil
+	details := PubkeyDetails{
+		Pubkey:      pubkey,
+		Username:    username,
+		Offer:       offer,
+		Bip21Params: params,
+	}
+	updates := dnsUpdates(last, details)
+	for _, update := range updates {
+		_, err = tx.Exec(
+			ctx,
+			`INSERT INTO public.dns_records (username, pubkey, offer, bip21_params)
+			 values ($1, $2, $3, $4)
+			 ON CONFLICT (username) DO UPDATE SET pubkey = $2, offer = $3, bip21_params = $4, state = 'pending', attempts = 0,
+			 next_attempt_at = NOW(), last_error = NULL, version = dns_records.version + 1, updated_at = NOW()`,
+			update.Username,
+			pk,
+			update.Offer,
+			update.Bip21Params,
+		)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if len(updates) == 0 && offer != nil {
+		// Registering the offer again retries its failed update
+		_, err = tx.Exec(
+			ctx,
+			`UPDATE public.dns_records
+			 SET state = 'pending', attempts = 0, next_attempt_at = NOW(), version = version + 1, updated_at = NOW()
+			 WHERE username = $1 AND state = 'failed'`,
+			username,
+		)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if err = tx.Commit(ctx); err != nil {
+		return nil, err
+	}
+	return &details, nil
 }
 
 func (s *PgStore) GetLastUpdated(ctx context.Context, identifier string) (*Webhook, error) {
@@ -90,7 +154,7 @@ func (s *PgStore) GetLastUpdated(ctx context.Context, identifier string) (*Webho
 	// Get the webhook record by the identifier which can either a decoded pubkey or username.
 	rows, err := s.pool.Query(
 		ctx,
-		`SELECT encode(lw.pubkey, 'hex') pubkey, lw.url, lpu.username, lpu.offer
+		`SELECT encode(lw.pubkey, 'hex') pubkey, lw.url, lpu.username, lpu.offer, lpu.bip21_params
 		 FROM public.lnurl_webhooks lw
          LEFT JOIN public.pubkey_details lpu ON lw.pubkey = lpu.pubkey
 		 WHERE lw.pubkey = $1 OR lpu.username = $2
@@ -119,7 +183,7 @@ func (s *PgStore) GetPubkeyDetails(ctx context.Context, identifier string) (*Pub
 	// Get the pubkey usernames record by the identifier which can either a decoded pubkey or username.
 	rows, err := s.pool.Query(
 		ctx,
-		`SELECT encode(lpu.pubkey, 'hex') pubkey, lpu.username, lpu.offer 
+		`SELECT encode(lpu.pubkey, 'hex') pubkey, lpu.username, lpu.offer, lpu.bip21_params
 		 FROM public.pubkey_details lpu
 		 WHERE lpu.pubkey = $1 OR lpu.username = $2
 		 LIMIT 1`,
@@ -135,12 +199,29 @@ func (s *PgStore) GetPubkeyDetails(ctx context.Context, identifier string) (*Pub
 	if err != nil {
 		return nil, err
 	}
+	if len(PubkeyDetailss) == 0 {
+		return nil, nil
+	}
 	if len(PubkeyDetailss) != 1 {
 		return nil, fmt.Errorf("unexpected pubkey usernames count for: %v count: %v", identifier, len(PubkeyDetailss))
 	}
 	return &PubkeyDetailss[0], nil
 }
 
+func (s *PgStore) ListPubkeyDetails(ctx context.Context) ([]PubkeyDetails, error) {
+	rows, err := s.pool.Query(
+		ctx,
+		`SELECT encode(lpu.pubkey, 'hex') pubkey, lpu.username, lpu.offer, lpu.bip21_params
+		 FROM public.pubkey_details lpu
+		 ORDER BY lpu.username`,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	return pgx.CollectRows(rows, pgx.RowToStructByName[PubkeyDetails])
+}
+
 func (s *PgStore) Remove(ctx context.Context, pubkey, url string) error {
 	pk, err := hex.DecodeString(pubkey)
 	if err != nil {
@@ -173,6 +254,91 @@ func (s *PgStore) DeleteExpired(
 	return err
 }
 
+func (s *PgStore) GetDnsRecord(ctx context.Context, username string) (*DnsRecord, error) {
+	rows, err := s.pool.Query(
+		ctx,
+		`SELECT username, encode(pubkey, 'hex') pubkey, offer, bip21_params, state, attempts, next_attempt_at, last_error, version, updated_at
+		 FROM public.dns_records
+		 WHERE username = $1`,
+		strings.ToLower(username),
+	)
+	if err != nil {
+		return nil, err
+	}
+	records, err := pgx.CollectRows(rows, pgx.RowToStructByName[DnsRecord])
+	if err != nil {
+		return nil, err
+	}
+	if len(records) == 0 {
+		return nil, nil
+	}
+	return &records[0], nil
+}
+
+func (s *PgStore) ClaimDnsRecords(ctx context.Context, now time.Time, leaseUntil time.Time, limit int) ([]DnsRecord, error) {
+	rows, err := s.pool.Query(
+		ctx,
+		`UPDATE public.dns_records SET next_attempt_at = to_timestamp($2)
+		 WHERE username IN (
+			SELECT username FROM public.dns_records
+			WHERE state = 'pending' AND next_attempt_at <= to_timestamp($1)
+			ORDER BY next_attempt_at
+			LIMIT $3
+			FOR UPDATE SKIP LOCKED)
+		 RETURNING username, encode(pubkey, 'hex') pubkey, offer, bip21_params, state, attempts, next_attempt_at, last_error, version, updated_at`,
+		now.Unix(),
+		leaseUntil.Unix(),
+		limit,
+	)
+	if err != nil {
+		return nil, err
+	}
+	return pgx.CollectRows(rows, pgx.RowToStructByName[DnsRecord])
+}
+
+func (s *PgStore) MarkDnsRecordPublished(ctx context.Context, username string, version int64) error {
+	_, err := s.pool.Exec(
+		ctx,
+		`DELETE FROM public.dns_records
+		 WHERE username = $1 AND version = $2 AND offer IS NULL`,
+		username,
+		version,
+	)
+	if err != nil {
+		return err
+	}
+	_, err = s.pool.Exec(
+		ctx,
+		`UPDATE public.dns_records
+		 SET state = 'published', attempts = attempts + 1, last_error = NULL, updated_at = NOW()
+		 WHERE username = $1 AND version = $2`,
+		username,
+		version,
+	)
+	return err
+}
+
+func (s *PgStore) MarkDnsRecordFailed(ctx context.Context, username string, version int64, lastError string, retryAt *time.Time) error {
+	state := DnsRecordFailed
+	nextAttemptAt := time.Now().Unix()
+	if retryAt != nil {
+		state = DnsRecordPending
+		nextAttemptAt = retryAt.Unix()
+	}
+	_, err := s.pool.Exec(
+		ctx,
+		`UPDATE public.dns_records
+		 SET state = $3, attempts = attempts + 1, next_attempt_at = to_timestamp($4), last_error = $5, updated_at = NOW()
+		 WHERE username = $1 AND version = $2`,
+		username,
+		version,
+		state,
+		nextAttemptAt,
+		lastError,
+	)
+	return err
+}
+
 func decodeIdentifier(identifier string) *[]byte {
 	pk, err := hex.DecodeString(identifier)
 	if err != nil {
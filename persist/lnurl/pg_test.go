@@ -133,7 +133,7 @@ func TestPgStoreBolt12(t *testing.T) {
 	testuser := "bolt12user"
 	testoffer := "lno1234567890abcdefghijklmnopqrstuvwxyz"
 
-	res, err := pgStore.SetPubkeyDetails(context.Background(), testpubkey, testuser, nil)
+	res, err := pgStore.SetPubkeyDetails(context.Background(), testpubkey, testuser, nil, nil)
 	assert.NilError(t, err, "failed to set")
 	assert.Check(t, res != nil, "should not be nil")
 	assert.Equal(t, res.Username, "bolt12user", "username should be bolt12user")
@@ -149,20 +149,20 @@ func TestPgStoreBolt12(t *testing.T) {
 	differentuser := "differentbolt12user"
 	differentoffer := "lnoabcdefghijklmnopqrstuvwxyz1234567890"
 
-	res, err = pgStore.SetPubkeyDetails(context.Background(), differentpubkey, testuser, &testoffer)
+	res, err = pgStore.SetPubkeyDetails(context.Background(), differentpubkey, testuser, &testoffer, nil)
 	assert.ErrorContains(t, err, "username conflict")
 	assert.ErrorType(t, err, &ErrorUsernameConflict{})
 	assert.Check(t, res == nil, "should be nil")
 
 	// Test that we are able to update the same user registration for the same pubkey.
-	res, err = pgStore.SetPubkeyDetails(context.Background(), testpubkey, testuser, &testoffer)
+	res, err = pgStore.SetPubkeyDetails(context.Background(), testpubkey, testuser, &testoffer, nil)
 	assert.NilError(t, err, "should be able to update the same pubkey")
 	assert.Check(t, res != nil, "should not be nil")
 	assert.Equal(t, res.Username, "bolt12user", "username should be set")
 	assert.Check(t, res.Offer != nil, "offer should be not nil")
 
 	// Test that we are able to update the same user registration with a different username.
-	res, err = pgStore.SetPubkeyDetails(context.Background(), testpubkey, differentuser, &testoffer)
+	res, err = pgStore.SetPubkeyDetails(context.Background(), testpubkey, differentuser, &testoffer, nil)
 	assert.NilError(t, err, "should be able to update the same pubkey")
 	assert.Check(t, res != nil, "should not be nil")
 	assert.Equal(t, res.Username, "differentbolt12user", "username should be differentbolt12user")
@@ -171,13 +171,13 @@ func TestPgStoreBolt12(t *testing.T) {
 	// Test that we are not able to set the same username for different pubkey.
 	thirdpubkey := "045a8c38c823b8648b9890361e3b1d0f0386975e0e11fd5fc9d64c9f8e8eaed0c0"
 
-	res, err = pgStore.SetPubkeyDetails(context.Background(), thirdpubkey, differentuser, &testoffer)
+	res, err = pgStore.SetPubkeyDetails(context.Background(), thirdpubkey, differentuser, &testoffer, nil)
 	assert.ErrorContains(t, err, "username conflict")
 	assert.ErrorType(t, err, &ErrorUsernameConflict{})
 	assert.Check(t, res == nil, "hook should be nil")
 
 	// Test that we are able to update the same user registration with a different offer.
-	res, err = pgStore.SetPubkeyDetails(context.Background(), testpubkey, differentuser, &differentoffer)
+	res, err = pgStore.SetPubkeyDetails(context.Background(), testpubkey, differentuser, &differentoffer, nil)
 	assert.NilError(t, err, "should be able to update the same pubkey")
 	assert.Check(t, res != nil, "should not be nil")
 	assert.Equal(t, res.Username, "differentbolt12user", "username should be differentbolt12user")
@@ -6,16 +6,74 @@ import (
 )
 
 type Webhook struct {
-	Pubkey   string  `json:"pubkey" db:"pubkey"`
-	Url      string  `json:"url" db:"url"`
-	Username *string `json:"username" db:"username"`
-	Offer    *string `json:"offer" db:"offer"`
+	Pubkey      string         `json:"pubkey" db:"pubkey"`
+	Url         string         `json:"url" db:"url"`
+	Username    *string        `json:"username" db:"username"`
+	Offer       *string        `json:"offer" db:"offer"`
+	Bip21Params *PaymentParams `json:"bip21Params" db:"bip21_params"`
 }
 
 type PubkeyDetails struct {
-	Pubkey   string  `json:"pubkey" db:"pubkey"`
-	Username string  `json:"username" db:"username"`
-	Offer    *string `json:"offer" db:"offer"`
+	Pubkey      string         `json:"pubkey" db:"pubkey"`
+	Username    string         `json:"username" db:"username"`
+	Offer       *string        `json:"offer" db:"offer"`
+	Bip21Params *PaymentParams `json:"bip21Params" db:"bip21_params"`
+}
+
+// PaymentParams are the BIP21 parameters published along with the offer in
+// the BIP353 record of the username, as alternatives to pay it.
+type PaymentParams struct {
+	// The on-chain fallback address, the path of the URI
+	Address string `json:"address,omitempty"`
+	// The BIP352 silent payment address
+	SilentPayment string `json:"sp,omitempty"`
+	// The Liquid address
+	Liquid string `json:"liquid,omitempty"`
+}
+
+// sameParams returns whether the parameters publish the same record.
+func sameParams(a, b *PaymentParams) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	return *a == *b
+}
+
+const (
+	// The DNS update is waiting to be applied, or retried
+	DnsRecordPending   = "pending"
+	DnsRecordPublished = "published"
+	// The DNS update was given up on, until the offer is registered again
+	DnsRecordFailed = "failed"
+)
+
+// DnsRecord is a BIP353 DNS update queued in the outbox, applied by a worker.
+type DnsRecord struct {
+	Username string `json:"username" db:"username"`
+	Pubkey   string `json:"pubkey" db:"pubkey"`
+	// The offer to publish, nil when the record is removed
+	Offer         *string        `json:"offer" db:"offer"`
+	Bip21Params   *PaymentParams `json:"bip21Params" db:"bip21_params"`
+	State         string         `json:"state" db:"state"`
+	Attempts      int            `json:"attempts" db:"attempts"`
+	NextAttemptAt time.Time      `json:"nextAttemptAt" db:"next_attempt_at"`
+	LastError     *string        `json:"lastError" db:"last_error"`
+	Version       int64          `json:"version" db:"version"`
+	UpdatedAt     time.Time      `json:"updatedAt" db:"updated_at"`
+}
+
+// dnsUpdates returns the DNS updates to queue when the details of a pubkey
+// change from last, which is nil for a new pubkey.
+func dnsUpdates(last *PubkeyDetails, details PubkeyDetails) []DnsRecord {
+	var updates []DnsRecord
+	if last != nil && last.Offer != nil && (last.Username != details.Username || details.Offer == nil) {
+		updates = append(updates, DnsRecord{Username: last.Username, Pubkey: last.Pubkey})
+	}
+	if details.Offer != nil && (last == nil || last.Offer == nil || last.Username != details.Username || *last.Offer != *details.Offer ||
+		!sameParams(last.Bip21Params, details.Bip21Params)) {
+		updates = append(updates, DnsRecord{Username: details.Username, Pubkey: details.Pubkey, Offer: details.Offer, Bip21Params: details.Bip21Params})
+	}
+	return updates
 }
 
 func (w Webhook) Compare(identifier string) bool {
@@ -32,9 +90,24 @@ func (w Webhook) Compare(identifier string) bool {
 
 type Store interface {
 	Set(ctx context.Context, webhook Webhook) (*Webhook, error)
-	SetPubkeyDetails(ctx context.Context, pubkey string, username string, offer *string) (*PubkeyDetails, error)
+	// SetPubkeyDetails sets the username, offer and BIP21 parameters of the
+	// pubkey, and queues the DNS updates of the change in the same transaction
+	SetPubkeyDetails(ctx context.Context, pubkey string, username string, offer *string, params *PaymentParams) (*PubkeyDetails, error)
 	GetLastUpdated(ctx context.Context, identifier string) (*Webhook, error)
 	GetPubkeyDetails(ctx context.Context, identifier string) (*PubkeyDetails, error)
+	// ListPubkeyDetails returns the details of every pubkey with a username
+	ListPubkeyDetails(ctx context.Context) ([]PubkeyDetails, error)
 	Remove(ctx context.Context, pubkey, url string) error
+	// GetDnsRecord returns the last DNS update of the username, nil when there is none
+	GetDnsRecord(ctx context.Context, username string) (*DnsRecord, error)
+	// ClaimDnsRecords returns the pending DNS updates due at now, leasing them
+	// until leaseUntil so other workers skip them meanwhile
+	ClaimDnsRecords(ctx context.Context, now time.Time, leaseUntil time.Time, limit int) ([]DnsRecord, error)
+	// MarkDnsRecordPublished marks the DNS update applied, unless it was
+	// updated since. Applied removals are deleted.
+	MarkDnsRecordPublished(ctx context.Context, username string, version int64) error
+	// MarkDnsRecordFailed records a failed attempt of the DNS update, unless it
+	// was updated since. It is retried at retryAt, or failed when retryAt is nil.
+	MarkDnsRecordFailed(ctx context.Context, username string, version int64, lastError string, retryAt *time.Time) error
 	DeleteExpired(ctx context.Context, before time.Time) error
 }
@@ -0,0 +1,2 @@
+DROP TRIGGER IF EXISTS nwc_webhooks_notify_trigger ON public.nwc_webhooks;
+DROP FUNCTION IF EXISTS public.nwc_webhooks_notify();
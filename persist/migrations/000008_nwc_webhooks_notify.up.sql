@@ -0,0 +1,23 @@
+-- Notify listeners whenever an nwc webhook is added, updated or removed
+-- so subscriptions can be adjusted without polling the whole table.
+CREATE OR REPLACE FUNCTION public.nwc_webhooks_notify() RETURNS trigger AS $$
+DECLARE
+  row public.nwc_webhooks%ROWTYPE;
+BEGIN
+  IF TG_OP = 'DELETE' THEN
+    row := OLD;
+  ELSE
+    row := NEW;
+  END IF;
+  PERFORM pg_notify('nwc_webhooks', json_build_object(
+    'op', lower(TG_OP),
+    'wallet_service_pubkey', encode(row.wallet_service_pubkey, 'hex'),
+    'app_pubkey', encode(row.app_pubkey, 'hex')
+  )::text);
+  RETURN NULL;
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER nwc_webhooks_notify_trigger
+  AFTER INSERT OR UPDATE OR DELETE ON public.nwc_webhooks
+  FOR EACH ROW EXECUTE FUNCTION public.nwc_webhooks_notify();
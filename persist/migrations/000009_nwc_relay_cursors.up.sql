@@ -0,0 +1,7 @@
+-- The created_at of the last event processed from each relay, used to
+-- resume subscriptions after restarts and reconnects
+CREATE TABLE public.nwc_relay_cursors (
+  relay_url varchar PRIMARY KEY,
+  last_created_at bigint NOT NULL,
+  updated_at timestamp NOT NULL DEFAULT NOW()
+);
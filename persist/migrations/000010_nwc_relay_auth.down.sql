@@ -0,0 +1 @@
+ALTER TABLE public.nwc_webhooks_relays DROP COLUMN auth_required;
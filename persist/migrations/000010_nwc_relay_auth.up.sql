@@ -0,0 +1,2 @@
+-- Whether the registration requires NIP-42 authentication to the relay
+ALTER TABLE public.nwc_webhooks_relays ADD COLUMN auth_required boolean NOT NULL DEFAULT false;
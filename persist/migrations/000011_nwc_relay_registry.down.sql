@@ -0,0 +1,2 @@
+-- The relay url normalization is not reverted
+DROP INDEX public.nwc_webhooks_relays_relay_id_idx;
@@ -0,0 +1,48 @@
+-- Relay ids used to be assigned modulo 10 while the id sequence was never
+-- used, so move the sequence past the existing ids before relying on it.
+SELECT setval(pg_get_serial_sequence('public.nwc_relays', 'id'), COALESCE(MAX(id), 0) + 1, false) FROM public.nwc_relays;
+
+-- Normalize the relay urls as the server does: a ws or wss scheme (wss by
+-- default), a lower case host and no trailing slash.
+CREATE TEMPORARY TABLE nwc_relays_normalized AS
+SELECT id, url, MIN(id) OVER (PARTITION BY url) AS canonical_id
+FROM (
+  SELECT id, rtrim(
+    regexp_replace(lower(substring(url FROM '^[a-zA-Z]+://[^/?#]*')), '^http', 'ws')
+      || substring(url FROM '^[a-zA-Z]+://[^/?#]*(.*)$'),
+    '/') AS url
+  FROM (
+    SELECT id, CASE WHEN trim(url) ~ '^[a-zA-Z]+://' THEN trim(url) ELSE 'wss://' || trim(url) END AS url
+    FROM public.nwc_relays
+  ) relays
+) normalized;
+
+-- Merge the relays with the same normalized url
+INSERT INTO public.nwc_webhooks_relays (webhook_id, relay_id, auth_required)
+SELECT nwr.webhook_id, n.canonical_id, nwr.auth_required
+FROM public.nwc_webhooks_relays nwr
+INNER JOIN nwc_relays_normalized n ON nwr.relay_id = n.id
+WHERE n.id <> n.canonical_id
+ON CONFLICT (webhook_id, relay_id) DO NOTHING;
+
+DELETE FROM public.nwc_webhooks_relays nwr
+USING nwc_relays_normalized n
+WHERE nwr.relay_id = n.id AND n.id <> n.canonical_id;
+
+DELETE FROM public.nwc_relays nr
+USING nwc_relays_normalized n
+WHERE nr.id = n.id AND n.id <> n.canonical_id;
+
+UPDATE public.nwc_relays nr
+SET url = n.url
+FROM nwc_relays_normalized n
+WHERE nr.id = n.id AND nr.url <> n.url;
+
+DROP TABLE nwc_relays_normalized;
+
+-- Garbage collect the relays no webhook links to anymore
+DELETE FROM public.nwc_relays nr
+WHERE NOT EXISTS (SELECT 1 FROM public.nwc_webhooks_relays nwr WHERE nwr.relay_id = nr.id);
+
+-- Index the garbage collection lookups
+CREATE INDEX nwc_webhooks_relays_relay_id_idx ON public.nwc_webhooks_relays (relay_id);
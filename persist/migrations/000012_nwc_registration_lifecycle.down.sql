@@ -0,0 +1,3 @@
+DROP TABLE IF EXISTS public.nwc_webhooks_activity;
+DROP INDEX IF EXISTS public.nwc_webhooks_owner_pubkey_idx;
+ALTER TABLE public.nwc_webhooks DROP COLUMN IF EXISTS owner_pubkey;
@@ -0,0 +1,10 @@
+-- The node pubkey that signed the registration, allowed to list and refresh it
+ALTER TABLE public.nwc_webhooks ADD COLUMN owner_pubkey bytea;
+CREATE INDEX nwc_webhooks_owner_pubkey_idx ON public.nwc_webhooks (owner_pubkey, wallet_service_pubkey);
+
+-- Kept apart from nwc_webhooks so recording activity doesn't notify listeners
+CREATE TABLE public.nwc_webhooks_activity (
+  webhook_id bigint PRIMARY KEY references public.nwc_webhooks(id) ON DELETE CASCADE,
+  last_event_at timestamp,
+  last_delivery_at timestamp
+);
@@ -0,0 +1 @@
+ALTER TABLE public.nwc_webhooks DROP COLUMN IF EXISTS payload_mode;
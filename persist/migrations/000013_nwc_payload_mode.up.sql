@@ -0,0 +1,2 @@
+-- What the webhook payloads include ('id' or 'full')
+ALTER TABLE public.nwc_webhooks ADD COLUMN payload_mode varchar NOT NULL DEFAULT 'id';
@@ -0,0 +1,11 @@
+-- Responses signed and encrypted ahead of time by wallet services, answering
+-- the requests matching their fingerprint without waking the wallet
+CREATE TABLE public.nwc_cached_responses (
+  webhook_id bigint NOT NULL references public.nwc_webhooks(id) ON DELETE CASCADE,
+  name varchar NOT NULL,
+  fingerprint jsonb NOT NULL,
+  event jsonb NOT NULL,
+  expires_at timestamp NOT NULL,
+  PRIMARY KEY (webhook_id, name)
+);
+CREATE INDEX nwc_cached_responses_expires_at_idx ON public.nwc_cached_responses (expires_at);
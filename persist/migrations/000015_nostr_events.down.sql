@@ -0,0 +1,3 @@
+DROP TRIGGER IF EXISTS nostr_events_notify_trigger ON public.nostr_events;
+DROP FUNCTION IF EXISTS public.nostr_events_notify();
+DROP TABLE IF EXISTS public.nostr_events;
@@ -0,0 +1,25 @@
+-- The events of the embedded relay, NIP-47 kinds only, kept for a short retention period
+CREATE TABLE public.nostr_events (
+  id varchar(64) PRIMARY KEY,
+  pubkey varchar(64) NOT NULL,
+  kind integer NOT NULL,
+  created_at bigint NOT NULL,
+  -- The single letter tags as "<name>:<value>", for tag filters
+  tag_values text[] NOT NULL,
+  raw jsonb NOT NULL
+);
+CREATE INDEX nostr_events_pubkey_kind_idx ON public.nostr_events (pubkey, kind, created_at DESC);
+CREATE INDEX nostr_events_created_at_idx ON public.nostr_events (created_at DESC);
+CREATE INDEX nostr_events_tag_values_idx ON public.nostr_events USING gin (tag_values);
+
+-- Notify the relay instances of new events to deliver to live subscriptions
+CREATE OR REPLACE FUNCTION public.nostr_events_notify() RETURNS trigger AS $$
+BEGIN
+  PERFORM pg_notify('nostr_events', NEW.id);
+  RETURN NULL;
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER nostr_events_notify_trigger
+  AFTER INSERT ON public.nostr_events
+  FOR EACH ROW EXECUTE FUNCTION public.nostr_events_notify();
@@ -0,0 +1,16 @@
+ALTER TABLE public.nwc_webhooks ADD COLUMN url varchar;
+
+-- Keep the first device of each registration
+UPDATE public.nwc_webhooks nw
+SET url = nwd.url
+FROM (
+  SELECT DISTINCT ON (webhook_id) webhook_id, url
+  FROM public.nwc_webhook_devices
+  ORDER BY webhook_id, priority, device_id
+) nwd
+WHERE nwd.webhook_id = nw.id;
+DELETE FROM public.nwc_webhooks WHERE url IS NULL;
+ALTER TABLE public.nwc_webhooks ALTER COLUMN url SET NOT NULL;
+
+ALTER TABLE public.nwc_webhooks DROP COLUMN delivery;
+DROP TABLE IF EXISTS public.nwc_webhook_devices;
@@ -0,0 +1,20 @@
+-- The devices notified of the events of a registration, each expiring on its own
+CREATE TABLE public.nwc_webhook_devices (
+  webhook_id bigint NOT NULL references public.nwc_webhooks(id) ON DELETE CASCADE,
+  -- Empty for registrations made without a device identifier
+  device_id varchar NOT NULL,
+  url varchar NOT NULL,
+  -- The push provider delivering the webhook payloads, which bounds their size
+  push_provider varchar NOT NULL DEFAULT '',
+  priority integer NOT NULL DEFAULT 0,
+  updated_at timestamp NOT NULL,
+  PRIMARY KEY (webhook_id, device_id)
+);
+CREATE INDEX nwc_webhook_devices_updated_at_idx ON public.nwc_webhook_devices (updated_at);
+
+INSERT INTO public.nwc_webhook_devices (webhook_id, device_id, url, updated_at)
+SELECT id, '', url, updated_at FROM public.nwc_webhooks;
+
+ALTER TABLE public.nwc_webhooks DROP COLUMN url;
+-- How the events are delivered to the devices ('all' or 'priority')
+ALTER TABLE public.nwc_webhooks ADD COLUMN delivery varchar NOT NULL DEFAULT 'all';
@@ -0,0 +1,4 @@
+ALTER TABLE public.nwc_webhooks_activity DROP COLUMN IF EXISTS last_suppressed_at;
+ALTER TABLE public.nwc_webhooks_activity DROP COLUMN IF EXISTS dropped_events;
+ALTER TABLE public.nwc_webhooks_activity DROP COLUMN IF EXISTS held_events;
+ALTER TABLE public.nwc_webhooks DROP COLUMN IF EXISTS policy;
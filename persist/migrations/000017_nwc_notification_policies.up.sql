@@ -0,0 +1,8 @@
+-- Limits how often the devices of a registration are woken (quiet hours,
+-- wakes per hour, minimum interval and what happens to suppressed events)
+ALTER TABLE public.nwc_webhooks ADD COLUMN policy jsonb NOT NULL DEFAULT '{}';
+
+-- The events the notification policy delayed or dropped
+ALTER TABLE public.nwc_webhooks_activity ADD COLUMN held_events bigint NOT NULL DEFAULT 0;
+ALTER TABLE public.nwc_webhooks_activity ADD COLUMN dropped_events bigint NOT NULL DEFAULT 0;
+ALTER TABLE public.nwc_webhooks_activity ADD COLUMN last_suppressed_at timestamp;
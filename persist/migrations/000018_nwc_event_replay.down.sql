@@ -0,0 +1,5 @@
+DROP INDEX IF EXISTS public.nwc_forwarded_events_wallet_service_pubkey_idx;
+DELETE FROM public.nwc_forwarded_events WHERE status <> 'forwarded';
+ALTER TABLE public.nwc_forwarded_events DROP COLUMN IF EXISTS acknowledged_at;
+ALTER TABLE public.nwc_forwarded_events DROP COLUMN IF EXISTS attempts;
+ALTER TABLE public.nwc_forwarded_events DROP COLUMN IF EXISTS status;
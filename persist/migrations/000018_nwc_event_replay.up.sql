@@ -0,0 +1,8 @@
+-- Failed deliveries are recorded too, so apps can replay the events they missed
+ALTER TABLE public.nwc_forwarded_events ADD COLUMN status varchar NOT NULL DEFAULT 'forwarded';
+ALTER TABLE public.nwc_forwarded_events ADD COLUMN attempts integer NOT NULL DEFAULT 1;
+-- When the app acknowledged processing the event
+ALTER TABLE public.nwc_forwarded_events ADD COLUMN acknowledged_at timestamp;
+
+-- Index for replaying the events of a wallet service
+CREATE INDEX nwc_forwarded_events_wallet_service_pubkey_idx ON public.nwc_forwarded_events (wallet_service_pubkey, forwarded_at);
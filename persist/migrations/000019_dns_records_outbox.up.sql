@@ -0,0 +1,21 @@
+-- The outbox of the BIP353 DNS updates, written in the same transaction as pubkey_details
+-- and applied by a worker. A NULL offer removes the record of the username.
+CREATE TABLE public.dns_records (
+	username varchar NOT NULL,
+	pubkey bytea NOT NULL,
+	offer varchar,
+	-- One of pending, published or failed
+	state varchar NOT NULL DEFAULT 'pending',
+	attempts integer NOT NULL DEFAULT 0,
+	next_attempt_at timestamp NOT NULL DEFAULT NOW(),
+	last_error varchar,
+	-- Incremented on every update, so a worker doesn't overwrite a newer update
+	version bigint NOT NULL DEFAULT 1,
+	updated_at timestamp NOT NULL DEFAULT NOW(),
+	CONSTRAINT dns_records_pkey PRIMARY KEY (username)
+);
+CREATE INDEX dns_records_pending_idx ON public.dns_records (next_attempt_at) WHERE state = 'pending';
+
+-- The records of the existing offers were published synchronously
+INSERT INTO public.dns_records (username, pubkey, offer, state)
+SELECT username, pubkey, offer, 'published' FROM public.pubkey_details WHERE offer IS NOT NULL;
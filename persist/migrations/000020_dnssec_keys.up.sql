@@ -0,0 +1,17 @@
+-- The DNSSEC keys of the embedded BIP353 zone
+CREATE TABLE public.dnssec_keys (
+	id bigserial NOT NULL,
+	zone varchar NOT NULL,
+	-- 257 for a key signing key, 256 for a zone signing key
+	flags integer NOT NULL,
+	-- The DNSKEY record, in the zone file format
+	public_key varchar NOT NULL,
+	-- The private key, in the BIND private key format
+	private_key varchar NOT NULL,
+	-- One of published, active or retired
+	state varchar NOT NULL,
+	created_at timestamp NOT NULL DEFAULT NOW(),
+	updated_at timestamp NOT NULL DEFAULT NOW(),
+	CONSTRAINT dnssec_keys_pkey PRIMARY KEY (id)
+);
+CREATE INDEX dnssec_keys_zone_idx ON public.dnssec_keys (zone);
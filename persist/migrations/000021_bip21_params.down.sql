@@ -0,0 +1,2 @@
+ALTER TABLE public.dns_records DROP COLUMN bip21_params;
+ALTER TABLE public.pubkey_details DROP COLUMN bip21_params;
@@ -0,0 +1,3 @@
+-- The additional BIP21 parameters published with the offer in the BIP353 record
+ALTER TABLE public.pubkey_details ADD COLUMN bip21_params jsonb;
+ALTER TABLE public.dns_records ADD COLUMN bip21_params jsonb;
@@ -27,7 +27,7 @@ func NewCleanupService(store Store) *CleanupService {
 	}
 }
 
-// Periodically cleans up expired NWC uris and old forwarded events
+// Periodically cleans up expired NWC uris, old forwarded events and expired cached responses
 func (c *CleanupService) Start(ctx context.Context) {
 	for {
 		// Cleanup expired webhooks
@@ -44,6 +44,13 @@ func (c *CleanupService) Start(ctx context.Context) {
 			log.Printf("Failed to remove old forwarded events before %v: %v", eventsBefore, err)
 		}
 
+		// Cleanup expired cached responses
+		now := time.Now()
+		err = c.store.DeleteExpiredCachedResponses(ctx, now)
+		if err != nil {
+			log.Printf("Failed to remove cached responses expired before %v: %v", now, err)
+		}
+
 		select {
 		case <-time.After(CleanupInterval):
 			continue
@@ -0,0 +1,61 @@
+package persist
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"gotest.tools/assert"
+)
+
+func TestForwardedEventsReplay(t *testing.T) {
+	ctx := context.Background()
+	store := NewMemoryStore()
+	walletServicePubkey, owner := strings.Repeat("a", 64), strings.Repeat("c", 66)
+	for _, app := range []string{"b", "d"} {
+		assert.NilError(t, store.Set(ctx, Webhook{
+			WalletServicePubkey: walletServicePubkey,
+			AppPubkey:           strings.Repeat(app, 64),
+			Devices:             []Device{{Url: "https://example.com/" + app}},
+			Relays:              []string{"wss://relay.example.com"},
+			OwnerPubkey:         owner,
+		}))
+	}
+	store.webhooks[1].OwnerPubkey = strings.Repeat("e", 66)
+	since := time.Now()
+
+	app := strings.Repeat("b", 64)
+	assert.NilError(t, store.MarkEventFailed(ctx, "1", walletServicePubkey, app, "https://example.com/1"))
+	assert.NilError(t, store.MarkEventForwarded(ctx, "1", walletServicePubkey, app, "https://example.com/2"))
+	// A late failure on another device doesn't undo the delivery
+	assert.NilError(t, store.MarkEventFailed(ctx, "1", walletServicePubkey, app, "https://example.com/1"))
+	assert.NilError(t, store.MarkEventFailed(ctx, "2", walletServicePubkey, app, "https://example.com/1"))
+	assert.NilError(t, store.MarkEventForwarded(ctx, "3", walletServicePubkey, strings.Repeat("d", 64), "https://example.com/d"))
+
+	forwarded, err := store.IsEventForwarded(ctx, "2")
+	assert.NilError(t, err)
+	assert.Assert(t, !forwarded, "failed events are delivered again")
+
+	events, err := store.ListForwardedEvents(ctx, owner, walletServicePubkey, since, 10)
+	assert.NilError(t, err)
+	assert.Equal(t, len(events), 2, "only the events of the owner's registrations are replayed")
+	assert.Equal(t, events[0].EventId, "1")
+	assert.Equal(t, events[0].Status, ForwardStatusForwarded)
+	assert.Equal(t, events[0].Attempts, 2)
+	assert.Equal(t, events[0].WebhookUrl, "https://example.com/2")
+	assert.Equal(t, events[1].Status, ForwardStatusFailed)
+
+	acknowledged, err := store.AcknowledgeEvents(ctx, owner, walletServicePubkey, []string{"1", "3", "4"})
+	assert.NilError(t, err)
+	assert.Equal(t, acknowledged, int64(1))
+	events, err = store.ListForwardedEvents(ctx, owner, walletServicePubkey, since, 1)
+	assert.NilError(t, err)
+	assert.Equal(t, len(events), 1)
+	assert.Assert(t, events[0].AcknowledgedAt != nil)
+
+	assert.NilError(t, store.DeleteOldForwardedEvents(ctx, time.Now().Add(time.Second)))
+	events, err = store.ListForwardedEvents(ctx, owner, walletServicePubkey, since, 10)
+	assert.NilError(t, err)
+	assert.Equal(t, len(events), 0)
+}
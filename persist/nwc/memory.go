@@ -2,52 +2,157 @@ package persist
 
 import (
 	"context"
-	"fmt"
+	"slices"
+	"strings"
+	"sync"
 	"time"
 )
 
 type MemoryStore struct {
+	mu              sync.Mutex
 	webhooks        []Webhook
-	forwardedEvents map[string]bool // eventId -> forwarded
+	forwardedEvents map[string]*ForwardedEvent
+	relayCursors    map[string]int64
+	activity        map[string]*webhookActivity // walletServicePubkey:appPubkey -> activity
+	cachedResponses map[string]CachedResponse   // walletServicePubkey:appPubkey:name -> response
+	listeners       []chan WebhookChange
+}
+
+type webhookActivity struct {
+	updatedAt        time.Time
+	lastEventAt      *time.Time
+	lastDeliveryAt   *time.Time
+	heldEvents       int64
+	droppedEvents    int64
+	lastSuppressedAt *time.Time
 }
 
 func NewMemoryStore() *MemoryStore {
 	return &MemoryStore{
 		webhooks:        []Webhook{},
-		forwardedEvents: make(map[string]bool),
+		forwardedEvents: make(map[string]*ForwardedEvent),
+		relayCursors:    make(map[string]int64),
+		activity:        make(map[string]*webhookActivity),
+		cachedResponses: make(map[string]CachedResponse),
 	}
 }
 
 func (m *MemoryStore) Set(ctx context.Context, webhook Webhook) error {
+	webhook, err := normalizeRelays(webhook)
+	if err != nil {
+		return err
+	}
+	webhook.PayloadMode = payloadMode(webhook)
+	webhook.Delivery = delivery(webhook)
+	now := time.Now()
+	devices := make([]Device, 0, len(webhook.Devices))
+	for _, device := range webhook.Devices {
+		device.UpdatedAt = now
+		devices = append(devices, device)
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
 	for i, hook := range m.webhooks {
 		if hook.Compare(webhook.WalletServicePubkey, webhook.AppPubkey) {
+			if hook.OwnerPubkey != "" && hook.OwnerPubkey != webhook.OwnerPubkey {
+				return ErrWebhookOwned
+			}
+			m.webhookActivity(webhook.WalletServicePubkey, webhook.AppPubkey).updatedAt = now
+			for _, device := range hook.Devices {
+				if !slices.ContainsFunc(devices, func(d Device) bool { return d.Id == device.Id }) {
+					devices = append(devices, device)
+				}
+			}
+			webhook.Devices = sortDevices(devices)
 			m.webhooks[i] = webhook
+			m.notify(WebhookUpdated, webhook)
 			return nil
 		}
 	}
+	m.webhookActivity(webhook.WalletServicePubkey, webhook.AppPubkey).updatedAt = now
+	webhook.Devices = sortDevices(devices)
 	m.webhooks = append(m.webhooks, webhook)
+	m.notify(WebhookInserted, webhook)
 	return nil
 }
 
 func (m *MemoryStore) Get(ctx context.Context, walletServicePubkey string, appPubkey string) (*Webhook, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
 	for _, hook := range m.webhooks {
 		if hook.Compare(walletServicePubkey, appPubkey) {
+			hook.Devices = slices.Clone(hook.Devices)
 			return &hook, nil
 		}
 	}
-	return nil, fmt.Errorf("Webhook not found")
+	return nil, nil
 }
 
-func (m *MemoryStore) Delete(ctx context.Context, walletServicePubkey string, appPubkey string) error {
+func (m *MemoryStore) Delete(ctx context.Context, ownerPubkey string, walletServicePubkey string, appPubkey string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
 	for i, hook := range m.webhooks {
 		if hook.Compare(walletServicePubkey, appPubkey) {
-			m.webhooks = append(m.webhooks[:i], m.webhooks[i+1:]...)
+			if hook.OwnerPubkey != "" && hook.OwnerPubkey != ownerPubkey {
+				return ErrWebhookOwned
+			}
+			m.deleteWebhook(i)
+			break
 		}
 	}
 	return nil
 }
 
+func (m *MemoryStore) DeleteDevice(ctx context.Context, ownerPubkey string, walletServicePubkey string, appPubkey string, deviceId string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for i, hook := range m.webhooks {
+		if hook.Compare(walletServicePubkey, appPubkey) {
+			if hook.OwnerPubkey != "" && hook.OwnerPubkey != ownerPubkey {
+				return ErrWebhookOwned
+			}
+			m.webhooks[i].Devices = slices.DeleteFunc(slices.Clone(hook.Devices), func(device Device) bool {
+				return device.Id == deviceId
+			})
+			if len(m.webhooks[i].Devices) == 0 {
+				m.deleteWebhook(i)
+			} else {
+				m.notify(WebhookUpdated, hook)
+			}
+			break
+		}
+	}
+	return nil
+}
+
+// deleteWebhook must be called with the lock held.
+func (m *MemoryStore) deleteWebhook(i int) {
+	hook := m.webhooks[i]
+	m.webhooks = append(m.webhooks[:i], m.webhooks[i+1:]...)
+	delete(m.activity, activityKey(hook.WalletServicePubkey, hook.AppPubkey))
+	for key, response := range m.cachedResponses {
+		if hook.Compare(response.WalletServicePubkey, response.AppPubkey) {
+			delete(m.cachedResponses, key)
+		}
+	}
+	m.notify(WebhookDeleted, hook)
+}
+
+func (m *MemoryStore) GetAll(ctx context.Context) ([]Webhook, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	webhooks := make([]Webhook, 0, len(m.webhooks))
+	for _, hook := range m.webhooks {
+		hook.Devices = nil
+		webhooks = append(webhooks, hook)
+	}
+	return webhooks, nil
+}
+
 func (m *MemoryStore) GetAppPubkeys(ctx context.Context) ([]string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
 	var pubkeys []string
 	for _, hook := range m.webhooks {
 		pubkeys = append(pubkeys, hook.AppPubkey)
@@ -56,6 +161,8 @@ func (m *MemoryStore) GetAppPubkeys(ctx context.Context) ([]string, error) {
 }
 
 func (m *MemoryStore) GetRelays(ctx context.Context) ([]string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
 	relays := make(map[string]bool)
 	for _, hook := range m.webhooks {
 		for _, relay := range hook.Relays {
@@ -70,19 +177,320 @@ func (m *MemoryStore) GetRelays(ctx context.Context) ([]string, error) {
 }
 
 func (m *MemoryStore) DeleteExpired(ctx context.Context, before time.Time) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for i := len(m.webhooks) - 1; i >= 0; i-- {
+		hook := m.webhooks[i]
+		devices := slices.DeleteFunc(slices.Clone(hook.Devices), func(device Device) bool {
+			return device.UpdatedAt.Before(before)
+		})
+		if len(devices) == 0 {
+			m.deleteWebhook(i)
+		} else if len(devices) < len(hook.Devices) {
+			m.webhooks[i].Devices = devices
+			m.notify(WebhookUpdated, hook)
+		}
+	}
+	return nil
+}
+
+func (m *MemoryStore) List(ctx context.Context, ownerPubkey string, walletServicePubkey string) ([]WebhookStatus, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	statuses := []WebhookStatus{}
+	for _, hook := range m.webhooks {
+		if hook.OwnerPubkey != ownerPubkey || hook.WalletServicePubkey != walletServicePubkey {
+			continue
+		}
+		activity := m.webhookActivity(hook.WalletServicePubkey, hook.AppPubkey)
+		hook.Devices = slices.Clone(hook.Devices)
+		statuses = append(statuses, WebhookStatus{
+			Webhook:          hook,
+			UpdatedAt:        activity.updatedAt,
+			LastEventAt:      activity.lastEventAt,
+			LastDeliveryAt:   activity.lastDeliveryAt,
+			HeldEvents:       activity.heldEvents,
+			DroppedEvents:    activity.droppedEvents,
+			LastSuppressedAt: activity.lastSuppressedAt,
+		})
+	}
+	return statuses, nil
+}
+
+func (m *MemoryStore) Refresh(ctx context.Context, ownerPubkey string, walletServicePubkey string) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var refreshed int64
+	now := time.Now()
+	for i, hook := range m.webhooks {
+		if hook.OwnerPubkey == ownerPubkey && hook.WalletServicePubkey == walletServicePubkey {
+			m.webhookActivity(hook.WalletServicePubkey, hook.AppPubkey).updatedAt = now
+			m.webhooks[i].Devices = slices.Clone(hook.Devices)
+			for j := range m.webhooks[i].Devices {
+				m.webhooks[i].Devices[j].UpdatedAt = now
+			}
+			m.notify(WebhookUpdated, hook)
+			refreshed++
+		}
+	}
+	return refreshed, nil
+}
+
+func (m *MemoryStore) SetCachedResponse(ctx context.Context, response CachedResponse) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if !slices.ContainsFunc(m.webhooks, func(hook Webhook) bool {
+		return hook.Compare(response.WalletServicePubkey, response.AppPubkey)
+	}) {
+		return ErrWebhookNotFound
+	}
+	m.cachedResponses[cachedResponseKey(response.WalletServicePubkey, response.AppPubkey, response.Name)] = response
+	return nil
+}
+
+func (m *MemoryStore) DeleteCachedResponse(ctx context.Context, walletServicePubkey string, appPubkey string, name string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.cachedResponses, cachedResponseKey(walletServicePubkey, appPubkey, name))
+	return nil
+}
+
+func (m *MemoryStore) GetCachedResponses(ctx context.Context, walletServicePubkey string, appPubkey string) ([]CachedResponse, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var responses []CachedResponse
+	now := time.Now()
+	for _, response := range m.cachedResponses {
+		if response.WalletServicePubkey == walletServicePubkey && response.AppPubkey == appPubkey && response.ExpiresAt.After(now) {
+			responses = append(responses, response)
+		}
+	}
+	slices.SortFunc(responses, func(a, b CachedResponse) int {
+		return strings.Compare(a.Name, b.Name)
+	})
+	return responses, nil
+}
+
+func (m *MemoryStore) ClaimCachedResponse(ctx context.Context, walletServicePubkey string, appPubkey string, name string) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	key := cachedResponseKey(walletServicePubkey, appPubkey, name)
+	response, ok := m.cachedResponses[key]
+	if !ok || !response.ExpiresAt.After(time.Now()) {
+		return false, nil
+	}
+	delete(m.cachedResponses, key)
+	return true, nil
+}
+
+func (m *MemoryStore) DeleteExpiredCachedResponses(ctx context.Context, before time.Time) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for key, response := range m.cachedResponses {
+		if response.ExpiresAt.Before(before) {
+			delete(m.cachedResponses, key)
+		}
+	}
+	return nil
+}
+
+func cachedResponseKey(walletServicePubkey string, appPubkey string, name string) string {
+	return walletServicePubkey + ":" + appPubkey + ":" + name
+}
+
+func activityKey(walletServicePubkey string, appPubkey string) string {
+	return walletServicePubkey + ":" + appPubkey
+}
+
+// webhookActivity must be called with the lock held.
+func (m *MemoryStore) webhookActivity(walletServicePubkey string, appPubkey string) *webhookActivity {
+	key := activityKey(walletServicePubkey, appPubkey)
+	activity, ok := m.activity[key]
+	if !ok {
+		activity = &webhookActivity{}
+		m.activity[key] = activity
+	}
+	return activity
+}
+
+func (m *MemoryStore) Listen(ctx context.Context) (<-chan WebhookChange, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	listener := make(chan WebhookChange, 100)
+	m.listeners = append(m.listeners, listener)
+	go func() {
+		<-ctx.Done()
+		m.mu.Lock()
+		defer m.mu.Unlock()
+		for i, l := range m.listeners {
+			if l == listener {
+				m.listeners = append(m.listeners[:i], m.listeners[i+1:]...)
+				break
+			}
+		}
+		close(listener)
+	}()
+	return listener, nil
+}
+
+// Lead returns immediately, as the memory store is not shared between instances.
+func (m *MemoryStore) Lead(ctx context.Context, lock LeaderLock) (context.Context, error) {
+	return ctx, nil
+}
+
+// notify must be called with the lock held. Slow listeners miss changes,
+// which is fine as consumers periodically reconcile the full state.
+func (m *MemoryStore) notify(op string, webhook Webhook) {
+	change := WebhookChange{
+		Op:                  op,
+		WalletServicePubkey: webhook.WalletServicePubkey,
+		AppPubkey:           webhook.AppPubkey,
+	}
+	for _, listener := range m.listeners {
+		select {
+		case listener <- change:
+		default:
+		}
+	}
+}
+
+func (m *MemoryStore) GetRelayCursor(ctx context.Context, relayUrl string) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.relayCursors[relayUrl], nil
+}
+
+func (m *MemoryStore) SetRelayCursor(ctx context.Context, relayUrl string, createdAt int64) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if createdAt > m.relayCursors[relayUrl] {
+		m.relayCursors[relayUrl] = createdAt
+	}
+	return nil
+}
+
+func (m *MemoryStore) MarkEventReceived(ctx context.Context, walletServicePubkey string, appPubkey string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	now := time.Now()
+	m.webhookActivity(walletServicePubkey, appPubkey).lastEventAt = &now
+	return nil
+}
+
+func (m *MemoryStore) MarkEventsSuppressed(ctx context.Context, walletServicePubkey string, appPubkey string, count int, dropped bool) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	now := time.Now()
+	activity := m.webhookActivity(walletServicePubkey, appPubkey)
+	if dropped {
+		activity.droppedEvents += int64(count)
+	} else {
+		activity.heldEvents += int64(count)
+	}
+	activity.lastSuppressedAt = &now
 	return nil
 }
 
 func (m *MemoryStore) IsEventForwarded(ctx context.Context, eventId string) (bool, error) {
-	return m.forwardedEvents[eventId], nil
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	event, ok := m.forwardedEvents[eventId]
+	return ok && event.Status == ForwardStatusForwarded, nil
 }
 
 func (m *MemoryStore) MarkEventForwarded(ctx context.Context, eventId string, walletServicePubkey string, appPubkey string, webhookUrl string) error {
-	m.forwardedEvents[eventId] = true
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	now := time.Now()
+	m.markEvent(eventId, walletServicePubkey, appPubkey, webhookUrl, ForwardStatusForwarded, now)
+	m.webhookActivity(walletServicePubkey, appPubkey).lastDeliveryAt = &now
 	return nil
 }
 
+func (m *MemoryStore) MarkEventFailed(ctx context.Context, eventId string, walletServicePubkey string, appPubkey string, webhookUrl string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.markEvent(eventId, walletServicePubkey, appPubkey, webhookUrl, ForwardStatusFailed, time.Now())
+	return nil
+}
+
+// markEvent records a delivery attempt, unless the event was already forwarded.
+func (m *MemoryStore) markEvent(eventId string, walletServicePubkey string, appPubkey string, webhookUrl string, status string, now time.Time) {
+	event, ok := m.forwardedEvents[eventId]
+	if !ok {
+		m.forwardedEvents[eventId] = &ForwardedEvent{
+			EventId:             eventId,
+			WalletServicePubkey: walletServicePubkey,
+			AppPubkey:           appPubkey,
+			WebhookUrl:          webhookUrl,
+			Status:              status,
+			Attempts:            1,
+			ForwardedAt:         now,
+		}
+		return
+	}
+	if event.Status == ForwardStatusForwarded {
+		return
+	}
+	event.WebhookUrl = webhookUrl
+	event.Status = status
+	event.Attempts++
+	event.ForwardedAt = now
+}
+
+func (m *MemoryStore) ListForwardedEvents(ctx context.Context, ownerPubkey string, walletServicePubkey string, since time.Time, limit int) ([]ForwardedEvent, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	events := []ForwardedEvent{}
+	for _, event := range m.forwardedEvents {
+		if event.WalletServicePubkey == walletServicePubkey && !event.ForwardedAt.Before(since) &&
+			m.isOwner(ownerPubkey, walletServicePubkey, event.AppPubkey) {
+			events = append(events, *event)
+		}
+	}
+	slices.SortFunc(events, func(a, b ForwardedEvent) int {
+		if c := a.ForwardedAt.Compare(b.ForwardedAt); c != 0 {
+			return c
+		}
+		return strings.Compare(a.EventId, b.EventId)
+	})
+	if len(events) > limit {
+		events = events[:limit]
+	}
+	return events, nil
+}
+
+func (m *MemoryStore) AcknowledgeEvents(ctx context.Context, ownerPubkey string, walletServicePubkey string, eventIds []string) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	now := time.Now()
+	var acknowledged int64
+	for _, id := range eventIds {
+		event, ok := m.forwardedEvents[id]
+		if !ok || event.WalletServicePubkey != walletServicePubkey || event.AcknowledgedAt != nil ||
+			!m.isOwner(ownerPubkey, walletServicePubkey, event.AppPubkey) {
+			continue
+		}
+		event.AcknowledgedAt = &now
+		acknowledged++
+	}
+	return acknowledged, nil
+}
+
+// isOwner returns whether the owner made the registration. Must be called with the lock held.
+func (m *MemoryStore) isOwner(ownerPubkey string, walletServicePubkey string, appPubkey string) bool {
+	return slices.ContainsFunc(m.webhooks, func(hook Webhook) bool {
+		return hook.Compare(walletServicePubkey, appPubkey) && hook.OwnerPubkey == ownerPubkey
+	})
+}
+
 func (m *MemoryStore) DeleteOldForwardedEvents(ctx context.Context, before time.Time) error {
-	// In-memory implementation doesn't need cleanup as it's temporary
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for id, event := range m.forwardedEvents {
+		if event.ForwardedAt.Before(before) {
+			delete(m.forwardedEvents, id)
+		}
+	}
 	return nil
 }
@@ -3,11 +3,14 @@ package persist
 import (
 	"context"
 	"encoding/hex"
+	"encoding/json"
 	"errors"
 	"fmt"
+	"log"
+	"slices"
+	"strings"
 	"time"
 
-	"github.com/breez/breez-lnurl/constant"
 	"github.com/jackc/pgx/v5"
 	"github.com/jackc/pgx/v5/pgxpool"
 )
@@ -23,6 +26,10 @@ func NewPgStore(pool *pgxpool.Pool) *PgStore {
 }
 
 func (s *PgStore) Set(ctx context.Context, webhook Webhook) error {
+	webhook, err := normalizeRelays(webhook)
+	if err != nil {
+		return err
+	}
 	walletServicePubkey, err := hex.DecodeString(webhook.WalletServicePubkey)
 	if err != nil {
 		return err
@@ -31,6 +38,10 @@ func (s *PgStore) Set(ctx context.Context, webhook Webhook) error {
 	if err != nil {
 		return err
 	}
+	ownerPubkey, err := hex.DecodeString(webhook.OwnerPubkey)
+	if err != nil {
+		return err
+	}
 
 	tx, err := s.pool.Begin(ctx)
 	if err != nil {
@@ -38,55 +49,87 @@ func (s *PgStore) Set(ctx context.Context, webhook Webhook) error {
 	}
 	defer tx.Rollback(ctx)
 
+	// Only the owner updates an existing webhook, the webhooks registered
+	// before owners were recorded are claimed by their next registration
 	var webhookId int64
 	err = tx.QueryRow(
 		ctx,
-		`INSERT INTO public.nwc_webhooks (url, wallet_service_pubkey, app_pubkey, updated_at)
-		 VALUES ($1, $2, $3, NOW())
-		 ON CONFLICT (wallet_service_pubkey, app_pubkey) DO UPDATE SET url = $1, updated_at = NOW()
+		`INSERT INTO public.nwc_webhooks (wallet_service_pubkey, app_pubkey, owner_pubkey, payload_mode, delivery, policy, updated_at)
+		 VALUES ($1, $2, $3, $4, $5, $6, NOW())
+		 ON CONFLICT (wallet_service_pubkey, app_pubkey) DO UPDATE
+		 SET owner_pubkey = $3, payload_mode = $4, delivery = $5, policy = $6, updated_at = NOW()
+		 WHERE nwc_webhooks.owner_pubkey IS NULL OR nwc_webhooks.owner_pubkey = EXCLUDED.owner_pubkey
 		 RETURNING id`,
-		webhook.Url,
 		walletServicePubkey,
 		appPubkey,
+		ownerPubkey,
+		payloadMode(webhook),
+		delivery(webhook),
+		webhook.Policy,
 	).Scan(&webhookId)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return ErrWebhookOwned
+	}
 	if err != nil {
 		return fmt.Errorf("failed to insert/update webhook: %w", err)
 	}
 
-	relays, err := getRelaysByUrl(ctx, tx)
+	// The other devices of the registration are kept
+	for _, device := range webhook.Devices {
+		_, err = tx.Exec(
+			ctx,
+			`INSERT INTO public.nwc_webhook_devices (webhook_id, device_id, url, push_provider, priority, updated_at)
+			 VALUES ($1, $2, $3, $4, $5, NOW())
+			 ON CONFLICT (webhook_id, device_id) DO UPDATE
+			 SET url = $3, push_provider = $4, priority = $5, updated_at = NOW()`,
+			webhookId,
+			device.Id,
+			device.Url,
+			device.PushProvider,
+			device.Priority,
+		)
+		if err != nil {
+			return fmt.Errorf("failed to insert/update webhook device: %w", err)
+		}
+	}
+
+	// The registration replaces the relays previously linked to the webhook
+	_, err = tx.Exec(ctx, `DELETE FROM public.nwc_webhooks_relays WHERE webhook_id = $1`, webhookId)
 	if err != nil {
-		return err
+		return fmt.Errorf("failed to unlink webhook relays: %w", err)
 	}
 
 	for _, relayUrl := range webhook.Relays {
-		if _, exists := relays[relayUrl]; exists {
-			continue
-		}
-
-		newRelayId := len(relays) % constant.NWC_MAX_RELAYS_LENGTH
-		_, err = tx.Exec(
+		// Updating the existing relay locks it until commit, so it cannot be
+		// garbage collected before it is linked.
+		var relayId int64
+		err = tx.QueryRow(
 			ctx,
-			`INSERT INTO public.nwc_relays (id, url) 
-             VALUES ($1, $2) 
-             ON CONFLICT (id) DO UPDATE SET url = EXCLUDED.url`,
-			newRelayId, relayUrl,
-		)
+			`INSERT INTO public.nwc_relays (url)
+			 VALUES ($1)
+			 ON CONFLICT (url) DO UPDATE SET url = EXCLUDED.url
+			 RETURNING id`,
+			relayUrl,
+		).Scan(&relayId)
 		if err != nil {
 			return fmt.Errorf("failed to insert relay: %w", err)
 		}
 		_, err = tx.Exec(
 			ctx,
-			`INSERT INTO public.nwc_webhooks_relays (webhook_id, relay_id)
-		 	 VALUES ($1, $2) ON CONFLICT DO NOTHING`,
+			`INSERT INTO public.nwc_webhooks_relays (webhook_id, relay_id, auth_required)
+			 VALUES ($1, $2, $3)`,
 			webhookId,
-			newRelayId,
+			relayId,
+			slices.Contains(webhook.AuthRelays, relayUrl),
 		)
 		if err != nil {
 			return fmt.Errorf("failed to link webhook and relay: %w", err)
 		}
-		relays[relayUrl] = newRelayId
 	}
 
+	if err := deleteUnreferencedRelays(ctx, tx); err != nil {
+		return err
+	}
 	return tx.Commit(ctx)
 }
 
@@ -107,15 +150,16 @@ func (s *PgStore) Get(ctx context.Context, walletServicePubkey string, appPubkey
 	defer tx.Rollback(ctx)
 
 	var webhookId int64
-	var url string
+	var ownerPubkey, payloadMode, delivery string
+	var policy NotificationPolicy
 	err = tx.QueryRow(
 		ctx,
-		`SELECT id, url 
+		`SELECT id, COALESCE(encode(owner_pubkey, 'hex'), ''), payload_mode, delivery, policy
 		 FROM public.nwc_webhooks 
 		 WHERE wallet_service_pubkey = $1 AND app_pubkey = $2`,
 		walletServicePubkeyBytes,
 		appPubkeyBytes,
-	).Scan(&webhookId, &url)
+	).Scan(&webhookId, &ownerPubkey, &payloadMode, &delivery, &policy)
 
 	if err != nil {
 		if errors.Is(err, pgx.ErrNoRows) {
@@ -126,7 +170,7 @@ func (s *PgStore) Get(ctx context.Context, walletServicePubkey string, appPubkey
 
 	rows, err := tx.Query(
 		ctx,
-		`SELECT nr.url
+		`SELECT nr.url, nwr.auth_required
 		 FROM public.nwc_webhooks_relays nwr
 		 INNER JOIN public.nwc_relays nr ON nwr.relay_id = nr.id
 		 WHERE nwr.webhook_id = $1`,
@@ -137,42 +181,216 @@ func (s *PgStore) Get(ctx context.Context, walletServicePubkey string, appPubkey
 	}
 	defer rows.Close()
 
-	var relays []string
+	var relays, authRelays []string
 	for rows.Next() {
 		var relayUrl string
-		if err := rows.Scan(&relayUrl); err != nil {
+		var authRequired bool
+		if err := rows.Scan(&relayUrl, &authRequired); err != nil {
 			return nil, err
 		}
 		relays = append(relays, relayUrl)
+		if authRequired {
+			authRelays = append(authRelays, relayUrl)
+		}
 	}
 
 	if err := rows.Err(); err != nil {
 		return nil, err
 	}
 
+	devices, err := getDevices(ctx, tx, []int64{webhookId})
+	if err != nil {
+		return nil, err
+	}
+
 	if err := tx.Commit(ctx); err != nil {
 		return nil, err
 	}
 
 	return &Webhook{
 		Relays:              relays,
+		AuthRelays:          authRelays,
 		AppPubkey:           appPubkey,
 		WalletServicePubkey: walletServicePubkey,
-		Url:                 url,
+		Devices:             devices[webhookId],
+		Delivery:            delivery,
+		OwnerPubkey:         ownerPubkey,
+		PayloadMode:         payloadMode,
+		Policy:              policy,
 	}, nil
 }
 
-func (s *PgStore) Delete(ctx context.Context, walletServicePubkey string, appPubkey string) error {
-	_, err := s.pool.Exec(
+type querier interface {
+	Query(ctx context.Context, sql string, args ...any) (pgx.Rows, error)
+}
+
+// getDevices returns the devices of the webhooks by webhook id, by ascending priority.
+func getDevices(ctx context.Context, q querier, webhookIds []int64) (map[int64][]Device, error) {
+	rows, err := q.Query(
+		ctx,
+		`SELECT webhook_id, device_id, url, push_provider, priority, updated_at
+		 FROM public.nwc_webhook_devices
+		 WHERE webhook_id = ANY($1)
+		 ORDER BY priority, device_id`,
+		webhookIds,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("querying devices: %w", err)
+	}
+	defer rows.Close()
+
+	devices := make(map[int64][]Device)
+	for rows.Next() {
+		var webhookId int64
+		var device Device
+		if err := rows.Scan(&webhookId, &device.Id, &device.Url, &device.PushProvider, &device.Priority, &device.UpdatedAt); err != nil {
+			return nil, err
+		}
+		devices[webhookId] = append(devices[webhookId], device)
+	}
+	return devices, rows.Err()
+}
+
+func payloadMode(webhook Webhook) string {
+	if webhook.PayloadMode == "" {
+		return PayloadModeId
+	}
+	return webhook.PayloadMode
+}
+
+func delivery(webhook Webhook) string {
+	if webhook.Delivery == "" {
+		return DeliveryAll
+	}
+	return webhook.Delivery
+}
+
+// sortDevices orders the devices by ascending priority, then by id.
+func sortDevices(devices []Device) []Device {
+	slices.SortFunc(devices, func(a, b Device) int {
+		if a.Priority != b.Priority {
+			return a.Priority - b.Priority
+		}
+		return strings.Compare(a.Id, b.Id)
+	})
+	return devices
+}
+
+func (s *PgStore) Delete(ctx context.Context, ownerPubkey string, walletServicePubkey string, appPubkey string) error {
+	ownerPubkeyBytes, err := hex.DecodeString(ownerPubkey)
+	if err != nil {
+		return fmt.Errorf("invalid owner pubkey: %w", err)
+	}
+	walletServicePubkeyBytes, err := hex.DecodeString(walletServicePubkey)
+	if err != nil {
+		return fmt.Errorf("invalid wallet service pubkey: %w", err)
+	}
+	appPubkeyBytes, err := hex.DecodeString(appPubkey)
+	if err != nil {
+		return fmt.Errorf("invalid app pubkey: %w", err)
+	}
+
+	tx, err := s.pool.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	if err := checkWebhookOwner(ctx, tx, ownerPubkeyBytes, walletServicePubkeyBytes, appPubkeyBytes); err != nil {
+		return err
+	}
+	_, err = tx.Exec(
 		ctx,
 		`DELETE FROM public.nwc_webhooks WHERE wallet_service_pubkey = $1 AND app_pubkey = $2`,
-		walletServicePubkey,
-		appPubkey,
+		walletServicePubkeyBytes,
+		appPubkeyBytes,
 	)
 	if err != nil {
 		return err
 	}
-	return nil
+	if err := deleteUnreferencedRelays(ctx, tx); err != nil {
+		return err
+	}
+	return tx.Commit(ctx)
+}
+
+func (s *PgStore) DeleteDevice(ctx context.Context, ownerPubkey string, walletServicePubkey string, appPubkey string, deviceId string) error {
+	ownerPubkeyBytes, err := hex.DecodeString(ownerPubkey)
+	if err != nil {
+		return fmt.Errorf("invalid owner pubkey: %w", err)
+	}
+	walletServicePubkeyBytes, err := hex.DecodeString(walletServicePubkey)
+	if err != nil {
+		return fmt.Errorf("invalid wallet service pubkey: %w", err)
+	}
+	appPubkeyBytes, err := hex.DecodeString(appPubkey)
+	if err != nil {
+		return fmt.Errorf("invalid app pubkey: %w", err)
+	}
+
+	tx, err := s.pool.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	if err := checkWebhookOwner(ctx, tx, ownerPubkeyBytes, walletServicePubkeyBytes, appPubkeyBytes); err != nil {
+		return err
+	}
+	_, err = tx.Exec(
+		ctx,
+		`DELETE FROM public.nwc_webhook_devices nwd
+		 USING public.nwc_webhooks nw
+		 WHERE nwd.webhook_id = nw.id AND nw.wallet_service_pubkey = $1 AND nw.app_pubkey = $2 AND nwd.device_id = $3`,
+		walletServicePubkeyBytes,
+		appPubkeyBytes,
+		deviceId,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to delete webhook device: %w", err)
+	}
+	_, err = tx.Exec(
+		ctx,
+		`DELETE FROM public.nwc_webhooks nw
+		 WHERE nw.wallet_service_pubkey = $1 AND nw.app_pubkey = $2
+		 AND NOT EXISTS (SELECT 1 FROM public.nwc_webhook_devices nwd WHERE nwd.webhook_id = nw.id)`,
+		walletServicePubkeyBytes,
+		appPubkeyBytes,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to delete webhook without devices: %w", err)
+	}
+	if err := deleteUnreferencedRelays(ctx, tx); err != nil {
+		return err
+	}
+	return tx.Commit(ctx)
+}
+
+func (s *PgStore) GetAll(ctx context.Context) ([]Webhook, error) {
+	rows, err := s.pool.Query(
+		ctx,
+		`SELECT encode(nw.wallet_service_pubkey, 'hex'), encode(nw.app_pubkey, 'hex'),
+		        COALESCE(array_agg(nr.url) FILTER (WHERE nr.url IS NOT NULL), '{}'),
+		        COALESCE(array_agg(nr.url) FILTER (WHERE nwr.auth_required), '{}')
+		 FROM public.nwc_webhooks nw
+		 LEFT JOIN public.nwc_webhooks_relays nwr ON nwr.webhook_id = nw.id
+		 LEFT JOIN public.nwc_relays nr ON nwr.relay_id = nr.id
+		 GROUP BY nw.id`,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("querying webhooks: %w", err)
+	}
+	defer rows.Close()
+
+	var webhooks []Webhook
+	for rows.Next() {
+		var webhook Webhook
+		if err := rows.Scan(&webhook.WalletServicePubkey, &webhook.AppPubkey, &webhook.Relays, &webhook.AuthRelays); err != nil {
+			return nil, err
+		}
+		webhooks = append(webhooks, webhook)
+	}
+	return webhooks, rows.Err()
 }
 
 func (s *PgStore) GetAppPubkeys(ctx context.Context) ([]string, error) {
@@ -194,33 +412,434 @@ func (s *PgStore) GetRelays(ctx context.Context) ([]string, error) {
 }
 
 func (s *PgStore) DeleteExpired(ctx context.Context, before time.Time) error {
+	tx, err := s.pool.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
 	beforeUnix := before.Unix()
-	_, err := s.pool.Exec(
+	_, err = tx.Exec(
 		ctx,
-		`DELETE FROM public.nwc_webhooks
+		`DELETE FROM public.nwc_webhook_devices
 		 WHERE updated_at < to_timestamp($1)`,
 		beforeUnix)
-	return err
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec(
+		ctx,
+		`DELETE FROM public.nwc_webhooks nw
+		 WHERE NOT EXISTS (SELECT 1 FROM public.nwc_webhook_devices nwd WHERE nwd.webhook_id = nw.id)`,
+	)
+	if err != nil {
+		return err
+	}
+	if err := deleteUnreferencedRelays(ctx, tx); err != nil {
+		return err
+	}
+	return tx.Commit(ctx)
 }
 
-func getRelaysByUrl(ctx context.Context, con pgx.Tx) (map[string]int, error) {
-	rows, err := con.Query(ctx, `SELECT id, url FROM public.nwc_relays`)
+func (s *PgStore) List(ctx context.Context, ownerPubkey string, walletServicePubkey string) ([]WebhookStatus, error) {
+	ownerPubkeyBytes, err := hex.DecodeString(ownerPubkey)
 	if err != nil {
-		return nil, err
+		return nil, fmt.Errorf("invalid owner pubkey: %w", err)
+	}
+	walletServicePubkeyBytes, err := hex.DecodeString(walletServicePubkey)
+	if err != nil {
+		return nil, fmt.Errorf("invalid wallet service pubkey: %w", err)
+	}
+
+	rows, err := s.pool.Query(
+		ctx,
+		`SELECT nw.id, encode(nw.app_pubkey, 'hex'), nw.payload_mode, nw.delivery, nw.policy,
+		        nw.updated_at, nwa.last_event_at, nwa.last_delivery_at,
+		        COALESCE(nwa.held_events, 0), COALESCE(nwa.dropped_events, 0), nwa.last_suppressed_at,
+		        COALESCE(array_agg(nr.url) FILTER (WHERE nr.url IS NOT NULL), '{}'),
+		        COALESCE(array_agg(nr.url) FILTER (WHERE nwr.auth_required), '{}')
+		 FROM public.nwc_webhooks nw
+		 LEFT JOIN public.nwc_webhooks_activity nwa ON nwa.webhook_id = nw.id
+		 LEFT JOIN public.nwc_webhooks_relays nwr ON nwr.webhook_id = nw.id
+		 LEFT JOIN public.nwc_relays nr ON nwr.relay_id = nr.id
+		 WHERE nw.owner_pubkey = $1 AND nw.wallet_service_pubkey = $2
+		 GROUP BY nw.id, nwa.webhook_id
+		 ORDER BY nw.id`,
+		ownerPubkeyBytes,
+		walletServicePubkeyBytes,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("querying webhooks: %w", err)
 	}
 	defer rows.Close()
 
-	var id int
-	var url string
-	relays := make(map[string]int)
+	statuses := []WebhookStatus{}
+	var webhookIds []int64
 	for rows.Next() {
-		err := rows.Scan(&id, &url)
+		status := WebhookStatus{
+			Webhook: Webhook{
+				WalletServicePubkey: walletServicePubkey,
+				OwnerPubkey:         ownerPubkey,
+			},
+		}
+		var webhookId int64
+		err := rows.Scan(
+			&webhookId,
+			&status.AppPubkey,
+			&status.PayloadMode,
+			&status.Delivery,
+			&status.Policy,
+			&status.UpdatedAt,
+			&status.LastEventAt,
+			&status.LastDeliveryAt,
+			&status.HeldEvents,
+			&status.DroppedEvents,
+			&status.LastSuppressedAt,
+			&status.Relays,
+			&status.AuthRelays,
+		)
 		if err != nil {
 			return nil, err
 		}
-		relays[url] = id
+		statuses = append(statuses, status)
+		webhookIds = append(webhookIds, webhookId)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	rows.Close()
+
+	devices, err := getDevices(ctx, s.pool, webhookIds)
+	if err != nil {
+		return nil, err
+	}
+	for i, webhookId := range webhookIds {
+		statuses[i].Devices = devices[webhookId]
+	}
+	return statuses, nil
+}
+
+func (s *PgStore) Refresh(ctx context.Context, ownerPubkey string, walletServicePubkey string) (int64, error) {
+	ownerPubkeyBytes, err := hex.DecodeString(ownerPubkey)
+	if err != nil {
+		return 0, fmt.Errorf("invalid owner pubkey: %w", err)
+	}
+	walletServicePubkeyBytes, err := hex.DecodeString(walletServicePubkey)
+	if err != nil {
+		return 0, fmt.Errorf("invalid wallet service pubkey: %w", err)
+	}
+
+	tx, err := s.pool.Begin(ctx)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback(ctx)
+
+	tag, err := tx.Exec(
+		ctx,
+		`UPDATE public.nwc_webhooks
+		 SET updated_at = NOW()
+		 WHERE owner_pubkey = $1 AND wallet_service_pubkey = $2`,
+		ownerPubkeyBytes,
+		walletServicePubkeyBytes,
+	)
+	if err != nil {
+		return 0, fmt.Errorf("failed to refresh webhooks: %w", err)
+	}
+	_, err = tx.Exec(
+		ctx,
+		`UPDATE public.nwc_webhook_devices nwd
+		 SET updated_at = NOW()
+		 FROM public.nwc_webhooks nw
+		 WHERE nwd.webhook_id = nw.id AND nw.owner_pubkey = $1 AND nw.wallet_service_pubkey = $2`,
+		ownerPubkeyBytes,
+		walletServicePubkeyBytes,
+	)
+	if err != nil {
+		return 0, fmt.Errorf("failed to refresh webhook devices: %w", err)
+	}
+	return tag.RowsAffected(), tx.Commit(ctx)
+}
+
+func (s *PgStore) SetCachedResponse(ctx context.Context, response CachedResponse) error {
+	walletServicePubkey, err := hex.DecodeString(response.WalletServicePubkey)
+	if err != nil {
+		return fmt.Errorf("invalid wallet service pubkey: %w", err)
+	}
+	appPubkey, err := hex.DecodeString(response.AppPubkey)
+	if err != nil {
+		return fmt.Errorf("invalid app pubkey: %w", err)
+	}
+
+	tag, err := s.pool.Exec(
+		ctx,
+		`INSERT INTO public.nwc_cached_responses (webhook_id, name, fingerprint, event, expires_at)
+		 SELECT id, $3, $4, $5, to_timestamp($6) FROM public.nwc_webhooks
+		 WHERE wallet_service_pubkey = $1 AND app_pubkey = $2
+		 ON CONFLICT (webhook_id, name) DO UPDATE
+		 SET fingerprint = EXCLUDED.fingerprint, event = EXCLUDED.event, expires_at = EXCLUDED.expires_at`,
+		walletServicePubkey,
+		appPubkey,
+		response.Name,
+		response.Fingerprint,
+		response.Event,
+		response.ExpiresAt.Unix(),
+	)
+	if err != nil {
+		return fmt.Errorf("failed to set cached response: %w", err)
+	}
+	if tag.RowsAffected() == 0 {
+		return ErrWebhookNotFound
+	}
+	return nil
+}
+
+func (s *PgStore) DeleteCachedResponse(ctx context.Context, walletServicePubkey string, appPubkey string, name string) error {
+	walletServicePubkeyBytes, err := hex.DecodeString(walletServicePubkey)
+	if err != nil {
+		return fmt.Errorf("invalid wallet service pubkey: %w", err)
+	}
+	appPubkeyBytes, err := hex.DecodeString(appPubkey)
+	if err != nil {
+		return fmt.Errorf("invalid app pubkey: %w", err)
+	}
+
+	_, err = s.pool.Exec(
+		ctx,
+		`DELETE FROM public.nwc_cached_responses ncr
+		 USING public.nwc_webhooks nw
+		 WHERE ncr.webhook_id = nw.id AND nw.wallet_service_pubkey = $1 AND nw.app_pubkey = $2 AND ncr.name = $3`,
+		walletServicePubkeyBytes,
+		appPubkeyBytes,
+		name,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to delete cached response: %w", err)
+	}
+	return nil
+}
+
+func (s *PgStore) GetCachedResponses(ctx context.Context, walletServicePubkey string, appPubkey string) ([]CachedResponse, error) {
+	walletServicePubkeyBytes, err := hex.DecodeString(walletServicePubkey)
+	if err != nil {
+		return nil, fmt.Errorf("invalid wallet service pubkey: %w", err)
+	}
+	appPubkeyBytes, err := hex.DecodeString(appPubkey)
+	if err != nil {
+		return nil, fmt.Errorf("invalid app pubkey: %w", err)
+	}
+
+	rows, err := s.pool.Query(
+		ctx,
+		`SELECT ncr.name, ncr.fingerprint, ncr.event::text, ncr.expires_at
+		 FROM public.nwc_cached_responses ncr
+		 INNER JOIN public.nwc_webhooks nw ON ncr.webhook_id = nw.id
+		 WHERE nw.wallet_service_pubkey = $1 AND nw.app_pubkey = $2 AND ncr.expires_at > NOW()
+		 ORDER BY ncr.name`,
+		walletServicePubkeyBytes,
+		appPubkeyBytes,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("querying cached responses: %w", err)
+	}
+	defer rows.Close()
+
+	var responses []CachedResponse
+	for rows.Next() {
+		response := CachedResponse{
+			WalletServicePubkey: walletServicePubkey,
+			AppPubkey:           appPubkey,
+		}
+		if err := rows.Scan(&response.Name, &response.Fingerprint, &response.Event, &response.ExpiresAt); err != nil {
+			return nil, err
+		}
+		responses = append(responses, response)
 	}
-	return relays, nil
+	return responses, rows.Err()
+}
+
+func (s *PgStore) ClaimCachedResponse(ctx context.Context, walletServicePubkey string, appPubkey string, name string) (bool, error) {
+	walletServicePubkeyBytes, err := hex.DecodeString(walletServicePubkey)
+	if err != nil {
+		return false, fmt.Errorf("invalid wallet service pubkey: %w", err)
+	}
+	appPubkeyBytes, err := hex.DecodeString(appPubkey)
+	if err != nil {
+		return false, fmt.Errorf("invalid app pubkey: %w", err)
+	}
+
+	tag, err := s.pool.Exec(
+		ctx,
+		`DELETE FROM public.nwc_cached_responses ncr
+		 USING public.nwc_webhooks nw
+		 WHERE ncr.webhook_id = nw.id AND nw.wallet_service_pubkey = $1 AND nw.app_pubkey = $2 AND ncr.name = $3
+		   AND ncr.expires_at > NOW()`,
+		walletServicePubkeyBytes,
+		appPubkeyBytes,
+		name,
+	)
+	if err != nil {
+		return false, fmt.Errorf("failed to claim cached response: %w", err)
+	}
+	return tag.RowsAffected() > 0, nil
+}
+
+func (s *PgStore) DeleteExpiredCachedResponses(ctx context.Context, before time.Time) error {
+	_, err := s.pool.Exec(
+		ctx,
+		`DELETE FROM public.nwc_cached_responses
+		 WHERE expires_at < to_timestamp($1)`,
+		before.Unix(),
+	)
+	return err
+}
+
+// checkWebhookOwner returns ErrWebhookOwned when the webhook exists with
+// another owner, locking it until the transaction ends.
+func checkWebhookOwner(ctx context.Context, tx pgx.Tx, ownerPubkey []byte, walletServicePubkey []byte, appPubkey []byte) error {
+	var owned bool
+	err := tx.QueryRow(
+		ctx,
+		`SELECT owner_pubkey IS NOT NULL AND owner_pubkey <> $1
+		 FROM public.nwc_webhooks
+		 WHERE wallet_service_pubkey = $2 AND app_pubkey = $3
+		 FOR UPDATE`,
+		ownerPubkey,
+		walletServicePubkey,
+		appPubkey,
+	).Scan(&owned)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to check webhook owner: %w", err)
+	}
+	if owned {
+		return ErrWebhookOwned
+	}
+	return nil
+}
+
+// deleteUnreferencedRelays garbage collects the relays no webhook links to.
+// Relays locked by a concurrent registration are skipped.
+func deleteUnreferencedRelays(ctx context.Context, tx pgx.Tx) error {
+	_, err := tx.Exec(
+		ctx,
+		`DELETE FROM public.nwc_relays
+		 WHERE id IN (
+		   SELECT nr.id FROM public.nwc_relays nr
+		   WHERE NOT EXISTS (SELECT 1 FROM public.nwc_webhooks_relays nwr WHERE nwr.relay_id = nr.id)
+		   FOR UPDATE SKIP LOCKED
+		 )`,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to delete unreferenced relays: %w", err)
+	}
+	return nil
+}
+
+// The channel nwc_webhooks changes are published on (see the nwc_webhooks_notify trigger)
+const webhooksNotifyChannel = "nwc_webhooks"
+
+func (s *PgStore) Listen(ctx context.Context) (<-chan WebhookChange, error) {
+	conn, err := s.pool.Acquire(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to acquire listen connection: %w", err)
+	}
+	if _, err := conn.Exec(ctx, "LISTEN "+webhooksNotifyChannel); err != nil {
+		conn.Release()
+		return nil, fmt.Errorf("failed to listen on %v: %w", webhooksNotifyChannel, err)
+	}
+
+	changes := make(chan WebhookChange)
+	go func() {
+		defer close(changes)
+		// The connection is in LISTEN state, so don't return it to the pool
+		defer conn.Hijack().Close(context.Background())
+
+		for {
+			notification, err := conn.Conn().WaitForNotification(ctx)
+			if err != nil {
+				if ctx.Err() == nil {
+					log.Printf("failed to wait for %v notification: %v", webhooksNotifyChannel, err)
+				}
+				return
+			}
+
+			var change WebhookChange
+			if err := json.Unmarshal([]byte(notification.Payload), &change); err != nil {
+				log.Printf("failed to decode %v notification %v: %v", webhooksNotifyChannel, notification.Payload, err)
+				continue
+			}
+
+			select {
+			case changes <- change:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return changes, nil
+}
+
+// The interval at which the other instances try to take the leadership
+var LeaderPollInterval time.Duration = 2 * time.Second
+
+// The interval at which the leader checks its lock connection is alive
+var LeaderCheckInterval time.Duration = 2 * time.Second
+
+// The time the leader waits for a lock connection check before stepping down
+var LeaderCheckTimeout time.Duration = 2 * time.Second
+
+func (s *PgStore) Lead(ctx context.Context, lock LeaderLock) (context.Context, error) {
+	conn, err := s.pool.Acquire(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to acquire leader connection: %w", err)
+	}
+	// The advisory lock belongs to the session, so the connection must not
+	// return to the pool. Closing it releases the lock.
+	lockConn := conn.Hijack()
+
+	for {
+		var locked bool
+		err := lockConn.QueryRow(ctx, "SELECT pg_try_advisory_lock($1)", int64(lock)).Scan(&locked)
+		if err != nil {
+			lockConn.Close(context.Background())
+			return nil, fmt.Errorf("failed to take the leader lock: %w", err)
+		}
+		if locked {
+			break
+		}
+		select {
+		case <-time.After(LeaderPollInterval):
+		case <-ctx.Done():
+			lockConn.Close(context.Background())
+			return nil, ctx.Err()
+		}
+	}
+
+	leaderCtx, cancel := context.WithCancel(ctx)
+	go func() {
+		defer cancel()
+		defer lockConn.Close(context.Background())
+		for {
+			select {
+			case <-time.After(LeaderCheckInterval):
+			case <-leaderCtx.Done():
+				return
+			}
+			checkCtx, checkCancel := context.WithTimeout(leaderCtx, LeaderCheckTimeout)
+			err := lockConn.Ping(checkCtx)
+			checkCancel()
+			if err != nil {
+				if leaderCtx.Err() == nil {
+					log.Printf("lost the leader lock connection: %v", err)
+				}
+				return
+			}
+		}
+	}()
+	return leaderCtx, nil
 }
 
 func rowsToArray(rows pgx.Rows) []string {
@@ -233,11 +852,102 @@ func rowsToArray(rows pgx.Rows) []string {
 	return arr
 }
 
+func (s *PgStore) GetRelayCursor(ctx context.Context, relayUrl string) (int64, error) {
+	var createdAt int64
+	err := s.pool.QueryRow(
+		ctx,
+		`SELECT last_created_at FROM public.nwc_relay_cursors WHERE relay_url = $1`,
+		relayUrl,
+	).Scan(&createdAt)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return 0, nil
+		}
+		return 0, fmt.Errorf("failed to get relay cursor: %w", err)
+	}
+	return createdAt, nil
+}
+
+func (s *PgStore) SetRelayCursor(ctx context.Context, relayUrl string, createdAt int64) error {
+	_, err := s.pool.Exec(
+		ctx,
+		`INSERT INTO public.nwc_relay_cursors (relay_url, last_created_at, updated_at)
+		 VALUES ($1, $2, NOW())
+		 ON CONFLICT (relay_url) DO UPDATE
+		 SET last_created_at = GREATEST(nwc_relay_cursors.last_created_at, EXCLUDED.last_created_at), updated_at = NOW()`,
+		relayUrl,
+		createdAt,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to set relay cursor: %w", err)
+	}
+	return nil
+}
+
+func (s *PgStore) MarkEventReceived(ctx context.Context, walletServicePubkey string, appPubkey string) error {
+	walletServicePubkeyBytes, err := hex.DecodeString(walletServicePubkey)
+	if err != nil {
+		return fmt.Errorf("failed to decode wallet service pubkey: %w", err)
+	}
+	appPubkeyBytes, err := hex.DecodeString(appPubkey)
+	if err != nil {
+		return fmt.Errorf("failed to decode app pubkey: %w", err)
+	}
+
+	_, err = s.pool.Exec(
+		ctx,
+		`INSERT INTO public.nwc_webhooks_activity (webhook_id, last_event_at)
+		 SELECT id, NOW() FROM public.nwc_webhooks
+		 WHERE wallet_service_pubkey = $1 AND app_pubkey = $2
+		 ON CONFLICT (webhook_id) DO UPDATE SET last_event_at = EXCLUDED.last_event_at`,
+		walletServicePubkeyBytes,
+		appPubkeyBytes,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to mark event as received: %w", err)
+	}
+	return nil
+}
+
+func (s *PgStore) MarkEventsSuppressed(ctx context.Context, walletServicePubkey string, appPubkey string, count int, dropped bool) error {
+	walletServicePubkeyBytes, err := hex.DecodeString(walletServicePubkey)
+	if err != nil {
+		return fmt.Errorf("failed to decode wallet service pubkey: %w", err)
+	}
+	appPubkeyBytes, err := hex.DecodeString(appPubkey)
+	if err != nil {
+		return fmt.Errorf("failed to decode app pubkey: %w", err)
+	}
+
+	held, droppedCount := count, 0
+	if dropped {
+		held, droppedCount = 0, count
+	}
+	_, err = s.pool.Exec(
+		ctx,
+		`INSERT INTO public.nwc_webhooks_activity (webhook_id, held_events, dropped_events, last_suppressed_at)
+		 SELECT id, $3, $4, NOW() FROM public.nwc_webhooks
+		 WHERE wallet_service_pubkey = $1 AND app_pubkey = $2
+		 ON CONFLICT (webhook_id) DO UPDATE
+		 SET held_events = nwc_webhooks_activity.held_events + EXCLUDED.held_events,
+		     dropped_events = nwc_webhooks_activity.dropped_events + EXCLUDED.dropped_events,
+		     last_suppressed_at = EXCLUDED.last_suppressed_at`,
+		walletServicePubkeyBytes,
+		appPubkeyBytes,
+		held,
+		droppedCount,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to mark events as suppressed: %w", err)
+	}
+	return nil
+}
+
 func (s *PgStore) IsEventForwarded(ctx context.Context, eventId string) (bool, error) {
 	var exists bool
 	err := s.pool.QueryRow(
 		ctx,
-		`SELECT EXISTS(SELECT 1 FROM public.nwc_forwarded_events WHERE event_id = $1)`,
+		`SELECT EXISTS(SELECT 1 FROM public.nwc_forwarded_events WHERE event_id = $1 AND status = 'forwarded')`,
 		eventId,
 	).Scan(&exists)
 
@@ -259,11 +969,19 @@ func (s *PgStore) MarkEventForwarded(ctx context.Context, eventId string, wallet
 		return fmt.Errorf("failed to decode app pubkey: %w", err)
 	}
 
-	_, err = s.pool.Exec(
+	tx, err := s.pool.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	_, err = tx.Exec(
 		ctx,
-		`INSERT INTO public.nwc_forwarded_events (event_id, wallet_service_pubkey, app_pubkey, webhook_url, forwarded_at)
-		 VALUES ($1, $2, $3, $4, NOW())
-		 ON CONFLICT (event_id) DO NOTHING`,
+		`INSERT INTO public.nwc_forwarded_events (event_id, wallet_service_pubkey, app_pubkey, webhook_url, status, forwarded_at)
+		 VALUES ($1, $2, $3, $4, 'forwarded', NOW())
+		 ON CONFLICT (event_id) DO UPDATE
+		 SET webhook_url = $4, status = 'forwarded', attempts = nwc_forwarded_events.attempts + 1, forwarded_at = NOW()
+		 WHERE nwc_forwarded_events.status = 'failed'`,
 		eventId,
 		walletServicePubkeyBytes,
 		appPubkeyBytes,
@@ -274,9 +992,126 @@ func (s *PgStore) MarkEventForwarded(ctx context.Context, eventId string, wallet
 		return fmt.Errorf("failed to mark event as forwarded: %w", err)
 	}
 
+	_, err = tx.Exec(
+		ctx,
+		`INSERT INTO public.nwc_webhooks_activity (webhook_id, last_delivery_at)
+		 SELECT id, NOW() FROM public.nwc_webhooks
+		 WHERE wallet_service_pubkey = $1 AND app_pubkey = $2
+		 ON CONFLICT (webhook_id) DO UPDATE SET last_delivery_at = EXCLUDED.last_delivery_at`,
+		walletServicePubkeyBytes,
+		appPubkeyBytes,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to record webhook delivery: %w", err)
+	}
+
+	return tx.Commit(ctx)
+}
+
+func (s *PgStore) MarkEventFailed(ctx context.Context, eventId string, walletServicePubkey string, appPubkey string, webhookUrl string) error {
+	walletServicePubkeyBytes, err := hex.DecodeString(walletServicePubkey)
+	if err != nil {
+		return fmt.Errorf("failed to decode wallet service pubkey: %w", err)
+	}
+
+	appPubkeyBytes, err := hex.DecodeString(appPubkey)
+	if err != nil {
+		return fmt.Errorf("failed to decode app pubkey: %w", err)
+	}
+
+	_, err = s.pool.Exec(
+		ctx,
+		`INSERT INTO public.nwc_forwarded_events (event_id, wallet_service_pubkey, app_pubkey, webhook_url, status, forwarded_at)
+		 VALUES ($1, $2, $3, $4, 'failed', NOW())
+		 ON CONFLICT (event_id) DO UPDATE
+		 SET webhook_url = $4, attempts = nwc_forwarded_events.attempts + 1, forwarded_at = NOW()
+		 WHERE nwc_forwarded_events.status = 'failed'`,
+		eventId,
+		walletServicePubkeyBytes,
+		appPubkeyBytes,
+		webhookUrl,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to mark event as failed: %w", err)
+	}
 	return nil
 }
 
+func (s *PgStore) ListForwardedEvents(ctx context.Context, ownerPubkey string, walletServicePubkey string, since time.Time, limit int) ([]ForwardedEvent, error) {
+	ownerPubkeyBytes, err := hex.DecodeString(ownerPubkey)
+	if err != nil {
+		return nil, fmt.Errorf("invalid owner pubkey: %w", err)
+	}
+	walletServicePubkeyBytes, err := hex.DecodeString(walletServicePubkey)
+	if err != nil {
+		return nil, fmt.Errorf("invalid wallet service pubkey: %w", err)
+	}
+
+	rows, err := s.pool.Query(
+		ctx,
+		`SELECT fe.event_id, encode(fe.app_pubkey, 'hex'), fe.webhook_url, fe.status, fe.attempts, fe.forwarded_at, fe.acknowledged_at
+		 FROM public.nwc_forwarded_events fe
+		 JOIN public.nwc_webhooks nw ON nw.wallet_service_pubkey = fe.wallet_service_pubkey AND nw.app_pubkey = fe.app_pubkey
+		 WHERE nw.owner_pubkey = $1 AND fe.wallet_service_pubkey = $2 AND fe.forwarded_at >= to_timestamp($3)
+		 ORDER BY fe.forwarded_at, fe.event_id
+		 LIMIT $4`,
+		ownerPubkeyBytes,
+		walletServicePubkeyBytes,
+		since.Unix(),
+		limit,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("querying forwarded events: %w", err)
+	}
+	defer rows.Close()
+
+	events := []ForwardedEvent{}
+	for rows.Next() {
+		event := ForwardedEvent{WalletServicePubkey: walletServicePubkey}
+		if err := rows.Scan(
+			&event.EventId,
+			&event.AppPubkey,
+			&event.WebhookUrl,
+			&event.Status,
+			&event.Attempts,
+			&event.ForwardedAt,
+			&event.AcknowledgedAt,
+		); err != nil {
+			return nil, fmt.Errorf("scanning forwarded event: %w", err)
+		}
+		events = append(events, event)
+	}
+	return events, rows.Err()
+}
+
+func (s *PgStore) AcknowledgeEvents(ctx context.Context, ownerPubkey string, walletServicePubkey string, eventIds []string) (int64, error) {
+	ownerPubkeyBytes, err := hex.DecodeString(ownerPubkey)
+	if err != nil {
+		return 0, fmt.Errorf("invalid owner pubkey: %w", err)
+	}
+	walletServicePubkeyBytes, err := hex.DecodeString(walletServicePubkey)
+	if err != nil {
+		return 0, fmt.Errorf("invalid wallet service pubkey: %w", err)
+	}
+
+	tag, err := s.pool.Exec(
+		ctx,
+		`UPDATE public.nwc_forwarded_events fe
+		 SET acknowledged_at = NOW()
+		 FROM public.nwc_webhooks nw
+		 WHERE nw.wallet_service_pubkey = fe.wallet_service_pubkey AND nw.app_pubkey = fe.app_pubkey
+		   AND nw.owner_pubkey = $1 AND fe.wallet_service_pubkey = $2
+		   AND fe.event_id = ANY($3) AND fe.acknowledged_at IS NULL`,
+		ownerPubkeyBytes,
+		walletServicePubkeyBytes,
+		eventIds,
+	)
+	if err != nil {
+		return 0, fmt.Errorf("failed to acknowledge events: %w", err)
+	}
+	return tag.RowsAffected(), nil
+}
+
 func (s *PgStore) DeleteOldForwardedEvents(ctx context.Context, before time.Time) error {
 	beforeUnix := before.Unix()
 	_, err := s.pool.Exec(
@@ -0,0 +1,85 @@
+package persist
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"slices"
+	"strings"
+
+	"github.com/breez/breez-lnurl/constant"
+)
+
+var (
+	ErrInvalidRelay  = errors.New("invalid relay url")
+	ErrTooManyRelays = fmt.Errorf("more than %d relays", constant.NWC_MAX_RELAYS_LENGTH)
+)
+
+// NormalizeRelayUrl returns the canonical form of a relay url, so the same
+// relay is registered once however wallets spell it: a ws or wss scheme
+// (wss by default), a lower case host and no trailing slash.
+func NormalizeRelayUrl(relay string) (string, error) {
+	relay = strings.TrimSpace(relay)
+	if !strings.Contains(relay, "://") {
+		relay = "wss://" + relay
+	}
+	u, err := url.Parse(relay)
+	if err != nil {
+		return "", fmt.Errorf("%w: %v", ErrInvalidRelay, relay)
+	}
+
+	switch u.Scheme {
+	case "ws", "wss":
+	case "http":
+		u.Scheme = "ws"
+	case "https":
+		u.Scheme = "wss"
+	default:
+		return "", fmt.Errorf("%w: %v", ErrInvalidRelay, relay)
+	}
+	if u.Host == "" || u.User != nil {
+		return "", fmt.Errorf("%w: %v", ErrInvalidRelay, relay)
+	}
+
+	u.Host = strings.ToLower(u.Host)
+	u.Path = strings.TrimRight(u.Path, "/")
+	u.RawPath = ""
+	u.Fragment = ""
+	return u.String(), nil
+}
+
+// normalizeRelays normalizes and deduplicates the relays of the webhook and
+// enforces the per-registration relays limit.
+func normalizeRelays(webhook Webhook) (Webhook, error) {
+	relays := make([]string, 0, len(webhook.Relays))
+	for _, relay := range webhook.Relays {
+		normalized, err := NormalizeRelayUrl(relay)
+		if err != nil {
+			return webhook, err
+		}
+		if !slices.Contains(relays, normalized) {
+			relays = append(relays, normalized)
+		}
+	}
+	if len(relays) > constant.NWC_MAX_RELAYS_LENGTH {
+		return webhook, ErrTooManyRelays
+	}
+
+	var authRelays []string
+	for _, relay := range webhook.AuthRelays {
+		normalized, err := NormalizeRelayUrl(relay)
+		if err != nil {
+			return webhook, err
+		}
+		if !slices.Contains(relays, normalized) {
+			return webhook, fmt.Errorf("%w: auth relay %v not in relays", ErrInvalidRelay, relay)
+		}
+		if !slices.Contains(authRelays, normalized) {
+			authRelays = append(authRelays, normalized)
+		}
+	}
+
+	webhook.Relays = relays
+	webhook.AuthRelays = authRelays
+	return webhook, nil
+}
@@ -0,0 +1,55 @@
+package persist
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/breez/breez-lnurl/constant"
+	"gotest.tools/assert"
+)
+
+func TestNormalizeRelayUrl(t *testing.T) {
+	tests := map[string]string{
+		"wss://relay.example.com":         "wss://relay.example.com",
+		"wss://Relay.Example.com/":        "wss://relay.example.com",
+		"WSS://relay.example.com//":       "wss://relay.example.com",
+		"relay.example.com":               "wss://relay.example.com",
+		" ws://relay.example.com:7777 ":   "ws://relay.example.com:7777",
+		"https://relay.example.com/Nostr": "wss://relay.example.com/Nostr",
+		"http://relay.example.com/nostr/": "ws://relay.example.com/nostr",
+	}
+	for relay, expected := range tests {
+		normalized, err := NormalizeRelayUrl(relay)
+		assert.NilError(t, err, relay)
+		assert.Equal(t, normalized, expected)
+	}
+
+	for _, relay := range []string{"ftp://relay.example.com", "wss://", "wss://user@relay.example.com"} {
+		_, err := NormalizeRelayUrl(relay)
+		assert.Assert(t, errors.Is(err, ErrInvalidRelay), relay)
+	}
+}
+
+func TestNormalizeRelays(t *testing.T) {
+	webhook, err := normalizeRelays(Webhook{
+		Relays:     []string{"wss://relay.example.com/", "wss://RELAY.example.com", "wss://other.example.com"},
+		AuthRelays: []string{"wss://other.example.com/"},
+	})
+	assert.NilError(t, err)
+	assert.DeepEqual(t, webhook.Relays, []string{"wss://relay.example.com", "wss://other.example.com"})
+	assert.DeepEqual(t, webhook.AuthRelays, []string{"wss://other.example.com"})
+
+	_, err = normalizeRelays(Webhook{
+		Relays:     []string{"wss://relay.example.com"},
+		AuthRelays: []string{"wss://other.example.com"},
+	})
+	assert.Assert(t, errors.Is(err, ErrInvalidRelay))
+
+	var relays []string
+	for i := 0; i <= constant.NWC_MAX_RELAYS_LENGTH; i++ {
+		relays = append(relays, fmt.Sprintf("wss://relay%d.example.com", i))
+	}
+	_, err = normalizeRelays(Webhook{Relays: relays})
+	assert.Assert(t, errors.Is(err, ErrTooManyRelays))
+}
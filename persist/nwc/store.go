@@ -2,29 +2,231 @@ package persist
 
 import (
 	"context"
+	"errors"
 	"time"
 )
 
+var ErrWebhookNotFound = errors.New("webhook not found")
+
+// ErrWebhookOwned is returned when registering a webhook registered by
+// another owner pubkey, which alone may update it
+var ErrWebhookOwned = errors.New("webhook owned by another key")
+
 type Webhook struct {
-	WalletServicePubkey string   `json:"walletServicePubkey" db:"wallet_service_pubkey"`
-	AppPubkey           string   `json:"appPubkey" db:"app_pubkey"`
-	Url                 string   `json:"url" db:"url"`
-	Relays              []string `json:"relays" db:"relays"`
+	WalletServicePubkey string `json:"walletServicePubkey" db:"wallet_service_pubkey"`
+	AppPubkey           string `json:"appPubkey" db:"app_pubkey"`
+	// The devices notified of the events, by ascending priority
+	Devices []Device `json:"devices"`
+	// How the events are delivered to the devices, one of the Delivery values
+	Delivery string   `json:"delivery" db:"delivery"`
+	Relays   []string `json:"relays" db:"relays"`
+	// The relays requiring NIP-42 authentication, a subset of Relays
+	AuthRelays []string `json:"authRelays" db:"auth_relays"`
+	// The node pubkey that signed the registration and may manage it
+	OwnerPubkey string `json:"ownerPubkey" db:"owner_pubkey"`
+	// What the webhook payloads include, one of the PayloadMode values
+	PayloadMode string `json:"payloadMode" db:"payload_mode"`
+	// Limits how often the devices are woken
+	Policy NotificationPolicy `json:"policy" db:"policy"`
+}
+
+// NotificationPolicy limits how often the devices of a registration are
+// woken. The zero value doesn't limit them.
+type NotificationPolicy struct {
+	// The daily period, as "HH:MM" in the time zone, the devices are not
+	// woken during. It spans midnight when the start is after the end.
+	QuietHoursStart string `json:"quietHoursStart,omitempty"`
+	QuietHoursEnd   string `json:"quietHoursEnd,omitempty"`
+	// The IANA time zone of the quiet hours, UTC when empty
+	TimeZone string `json:"timeZone,omitempty"`
+	// The maximum number of times the devices are woken in an hour
+	MaxWakesPerHour int `json:"maxWakesPerHour,omitempty"`
+	// The minimum number of seconds between two wakes
+	MinIntervalSeconds int `json:"minIntervalSeconds,omitempty"`
+	// What happens to the events the policy suppresses, one of the
+	// Suppression values
+	Suppression string `json:"suppression,omitempty"`
+}
+
+// Device is a webhook of a registration. Each device expires on its own.
+type Device struct {
+	// The device identifier, empty for registrations made without one
+	Id  string `json:"id" db:"device_id"`
+	Url string `json:"url" db:"url"`
+	// The push provider delivering the webhook payloads, which bounds their size
+	PushProvider string `json:"pushProvider" db:"push_provider"`
+	// Devices are tried by ascending priority in the priority delivery
+	Priority  int       `json:"priority" db:"priority"`
+	UpdatedAt time.Time `json:"updatedAt" db:"updated_at"`
+}
+
+// CachedResponse is a response event signed and encrypted ahead of time by the
+// wallet service, answering the requests matching its fingerprint while valid.
+type CachedResponse struct {
+	WalletServicePubkey string `json:"walletServicePubkey"`
+	AppPubkey           string `json:"appPubkey"`
+	// The wallet defined name of the response, e.g. "get_info"
+	Name        string             `json:"name"`
+	Fingerprint RequestFingerprint `json:"fingerprint"`
+	// The JSON encoded response event
+	Event     string    `json:"event"`
+	ExpiresAt time.Time `json:"expiresAt"`
 }
 
+// RequestFingerprint describes requests by the parts of their events visible
+// to the server, as their content is encrypted.
+type RequestFingerprint struct {
+	// The request event kind, the NIP-47 request kind when zero
+	Kind int `json:"kind,omitempty"`
+	// The length of the encrypted content, any when zero
+	ContentLength int `json:"contentLength,omitempty"`
+	// The tags the request event must have
+	Tags [][]string `json:"tags,omitempty"`
+}
+
+const (
+	// The events are delivered to all the devices
+	DeliveryAll = "all"
+	// The events are delivered to the first device accepting them
+	DeliveryPriority = "priority"
+)
+
+const (
+	// Suppressed events are delivered once the policy allows, the default
+	SuppressionHold = "hold"
+	// Suppressed events are not delivered
+	SuppressionDrop = "drop"
+)
+
+const (
+	// The payloads only include the event id
+	PayloadModeId = "id"
+	// The payloads include the full signed event and where it was received
+	PayloadModeFull = "full"
+)
+
+// WebhookStatus is a webhook with its refresh time and delivery activity.
+type WebhookStatus struct {
+	Webhook
+	UpdatedAt      time.Time
+	LastEventAt    *time.Time
+	LastDeliveryAt *time.Time
+	// The events the notification policy delayed or dropped
+	HeldEvents       int64
+	DroppedEvents    int64
+	LastSuppressedAt *time.Time
+}
+
+// ForwardedEvent records the delivery of an event to a webhook.
+type ForwardedEvent struct {
+	EventId             string
+	WalletServicePubkey string
+	AppPubkey           string
+	// The webhook of the last delivery attempt
+	WebhookUrl string
+	// One of the ForwardStatus values
+	Status string
+	// The number of delivery attempts
+	Attempts int
+	// When the event was last forwarded or attempted
+	ForwardedAt    time.Time
+	AcknowledgedAt *time.Time
+}
+
+const (
+	// The event was delivered to a webhook
+	ForwardStatusForwarded = "forwarded"
+	// Every delivery of the event failed so far
+	ForwardStatusFailed = "failed"
+)
+
+// WebhookChange describes a webhook that was inserted, updated or deleted.
+type WebhookChange struct {
+	Op                  string `json:"op"`
+	WalletServicePubkey string `json:"wallet_service_pubkey"`
+	AppPubkey           string `json:"app_pubkey"`
+}
+
+const (
+	WebhookInserted = "insert"
+	WebhookUpdated  = "update"
+	WebhookDeleted  = "delete"
+)
+
+// LeaderLock is an advisory lock held by a single server instance at a time
+type LeaderLock int64
+
+const (
+	// Held by the instance leading the NWC relay subscriptions
+	NwcLeaderLock LeaderLock = 0x6e7763
+	// Held by the instance reconciling the BIP353 records
+	Bip353LeaderLock LeaderLock = 0x626970
+)
+
 func (w Webhook) Compare(walletServicePubkey string, appPubkey string) bool {
 	return w.AppPubkey == appPubkey && w.WalletServicePubkey == walletServicePubkey
 }
 
 type Store interface {
+	// Set adds or updates the registration and its given devices, keeping
+	// its other devices. Returns ErrWebhookOwned when the registration
+	// exists with another owner.
 	Set(ctx context.Context, webhook Webhook) error
 	Get(ctx context.Context, walletServicePubkey string, appPubkey string) (*Webhook, error)
-	Delete(ctx context.Context, walletServicePubkey string, appPubkey string) error
+	// Delete removes the registration with all its devices. Returns
+	// ErrWebhookOwned when the registration has another owner.
+	Delete(ctx context.Context, ownerPubkey string, walletServicePubkey string, appPubkey string) error
+	// DeleteDevice removes a device, and the registration along with its
+	// last device. Returns ErrWebhookOwned when the registration has another owner.
+	DeleteDevice(ctx context.Context, ownerPubkey string, walletServicePubkey string, appPubkey string, deviceId string) error
+	// GetAll returns the registrations without their devices
+	GetAll(ctx context.Context) ([]Webhook, error)
 	GetAppPubkeys(ctx context.Context) ([]string, error)
 	GetRelays(ctx context.Context) ([]string, error)
+	// DeleteExpired removes the devices not updated since before, and the
+	// registrations left without devices
 	DeleteExpired(ctx context.Context, before time.Time) error
+	// List returns the webhooks of the wallet service registered by the owner
+	List(ctx context.Context, ownerPubkey string, walletServicePubkey string) ([]WebhookStatus, error)
+	// Refresh postpones the expiry of the webhooks of the wallet service
+	// registered by the owner, with all their devices, and returns how many
+	// were refreshed
+	Refresh(ctx context.Context, ownerPubkey string, walletServicePubkey string) (int64, error)
+	// Listen streams webhook changes until the context is done or the
+	// underlying connection fails, in which case the channel is closed.
+	Listen(ctx context.Context) (<-chan WebhookChange, error)
+	// Lead blocks until this instance holds the leader lock or the context
+	// is done. The returned context is done when the leadership is lost, so
+	// a single server instance leads at a time.
+	Lead(ctx context.Context, lock LeaderLock) (context.Context, error)
+	// Relay cursors hold the created_at of the last event processed from a relay
+	GetRelayCursor(ctx context.Context, relayUrl string) (int64, error)
+	SetRelayCursor(ctx context.Context, relayUrl string, createdAt int64) error
+	// Cached responses are removed along with their webhook
+	SetCachedResponse(ctx context.Context, response CachedResponse) error
+	DeleteCachedResponse(ctx context.Context, walletServicePubkey string, appPubkey string, name string) error
+	// GetCachedResponses returns the responses of the webhook that did not expire
+	GetCachedResponses(ctx context.Context, walletServicePubkey string, appPubkey string) ([]CachedResponse, error)
+	// ClaimCachedResponse deletes the response, returning whether it was
+	// still cached. A response answers a single request, as relays drop the
+	// events they already have.
+	ClaimCachedResponse(ctx context.Context, walletServicePubkey string, appPubkey string, name string) (bool, error)
+	DeleteExpiredCachedResponses(ctx context.Context, before time.Time) error
+	// MarkEventReceived records an event was received for the webhook
+	MarkEventReceived(ctx context.Context, walletServicePubkey string, appPubkey string) error
+	// MarkEventsSuppressed records events the notification policy of the
+	// webhook held back or dropped
+	MarkEventsSuppressed(ctx context.Context, walletServicePubkey string, appPubkey string, count int, dropped bool) error
 	// Event deduplication methods
 	IsEventForwarded(ctx context.Context, eventId string) (bool, error)
 	MarkEventForwarded(ctx context.Context, eventId string, walletServicePubkey string, appPubkey string, webhookUrl string) error
+	// MarkEventFailed records a failed delivery of an event not forwarded yet
+	MarkEventFailed(ctx context.Context, eventId string, walletServicePubkey string, appPubkey string, webhookUrl string) error
+	// ListForwardedEvents returns up to limit events forwarded or attempted
+	// since the given time for the registrations made by the owner, oldest first
+	ListForwardedEvents(ctx context.Context, ownerPubkey string, walletServicePubkey string, since time.Time, limit int) ([]ForwardedEvent, error)
+	// AcknowledgeEvents marks the events of the registrations made by the
+	// owner as processed and returns how many were
+	AcknowledgeEvents(ctx context.Context, ownerPubkey string, walletServicePubkey string, eventIds []string) (int64, error)
 	DeleteOldForwardedEvents(ctx context.Context, before time.Time) error
 }
@@ -0,0 +1,40 @@
+package persist
+
+import (
+	"context"
+	"log"
+	"time"
+)
+
+type CleanupService struct {
+	store Store
+}
+
+// The interval to clean old relay events
+var CleanupInterval time.Duration = 10 * time.Minute
+
+// The time the relay keeps events for
+var RetentionDuration time.Duration = time.Hour
+
+func NewCleanupService(store Store) *CleanupService {
+	return &CleanupService{
+		store: store,
+	}
+}
+
+// Periodically cleans up the events older than the retention period.
+func (c *CleanupService) Start(ctx context.Context) {
+	for {
+		before := time.Now().Add(-RetentionDuration)
+		err := c.store.DeleteOld(ctx, before)
+		if err != nil {
+			log.Printf("Failed to remove relay events before %v: %v", before, err)
+		}
+		select {
+		case <-time.After(CleanupInterval):
+			continue
+		case <-ctx.Done():
+			return
+		}
+	}
+}
@@ -0,0 +1,115 @@
+package persist
+
+import (
+	"context"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/nbd-wtf/go-nostr"
+)
+
+type MemoryStore struct {
+	mu        sync.Mutex
+	events    []*nostr.Event
+	listeners []chan *nostr.Event
+}
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{}
+}
+
+func (m *MemoryStore) Save(ctx context.Context, event *nostr.Event) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, stored := range m.events {
+		if stored.ID == event.ID {
+			return false, nil
+		}
+	}
+	if IsReplaceableKind(event.Kind) {
+		for _, stored := range m.events {
+			if stored.PubKey == event.PubKey && stored.Kind == event.Kind && stored.CreatedAt >= event.CreatedAt {
+				return false, nil
+			}
+		}
+		m.events = slices.DeleteFunc(m.events, func(stored *nostr.Event) bool {
+			return stored.PubKey == event.PubKey && stored.Kind == event.Kind
+		})
+	}
+	m.events = append(m.events, event)
+	for _, listener := range m.listeners {
+		select {
+		case listener <- event:
+		default:
+		}
+	}
+	return true, nil
+}
+
+func (m *MemoryStore) Get(ctx context.Context, id string) (*nostr.Event, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, stored := range m.events {
+		if stored.ID == id {
+			return stored, nil
+		}
+	}
+	return nil, nil
+}
+
+func (m *MemoryStore) Query(ctx context.Context, filter nostr.Filter, limit int) ([]*nostr.Event, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var events []*nostr.Event
+	for _, stored := range m.events {
+		if filter.Matches(stored) {
+			events = append(events, stored)
+		}
+	}
+	slices.SortFunc(events, func(a, b *nostr.Event) int {
+		return int(b.CreatedAt - a.CreatedAt)
+	})
+	if len(events) > limit {
+		events = events[:limit]
+	}
+	return events, nil
+}
+
+func (m *MemoryStore) DeleteOld(ctx context.Context, before time.Time) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.events = slices.DeleteFunc(m.events, func(stored *nostr.Event) bool {
+		return !IsReplaceableKind(stored.Kind) && stored.CreatedAt.Time().Before(before)
+	})
+	return nil
+}
+
+func (m *MemoryStore) CountByAuthor(ctx context.Context, pubkey string) (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	count := 0
+	for _, stored := range m.events {
+		if stored.PubKey == pubkey {
+			count++
+		}
+	}
+	return count, nil
+}
+
+func (m *MemoryStore) Listen(ctx context.Context) (<-chan *nostr.Event, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	listener := make(chan *nostr.Event, 100)
+	m.listeners = append(m.listeners, listener)
+	go func() {
+		<-ctx.Done()
+		m.mu.Lock()
+		defer m.mu.Unlock()
+		m.listeners = slices.DeleteFunc(m.listeners, func(l chan *nostr.Event) bool {
+			return l == listener
+		})
+		close(listener)
+	}()
+	return listener, nil
+}
@@ -0,0 +1,229 @@
+package persist
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/nbd-wtf/go-nostr"
+)
+
+type PgStore struct {
+	pool *pgxpool.Pool
+}
+
+func NewPgStore(pool *pgxpool.Pool) *PgStore {
+	return &PgStore{
+		pool,
+	}
+}
+
+func (s *PgStore) Save(ctx context.Context, event *nostr.Event) (bool, error) {
+	raw, err := json.Marshal(event)
+	if err != nil {
+		return false, err
+	}
+
+	tx, err := s.pool.Begin(ctx)
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback(ctx)
+
+	if IsReplaceableKind(event.Kind) {
+		var newer bool
+		err = tx.QueryRow(
+			ctx,
+			`SELECT EXISTS(SELECT 1 FROM public.nostr_events WHERE pubkey = $1 AND kind = $2 AND created_at >= $3)`,
+			event.PubKey,
+			event.Kind,
+			int64(event.CreatedAt),
+		).Scan(&newer)
+		if err != nil {
+			return false, fmt.Errorf("failed to check replaceable event: %w", err)
+		}
+		if newer {
+			return false, nil
+		}
+		_, err = tx.Exec(
+			ctx,
+			`DELETE FROM public.nostr_events WHERE pubkey = $1 AND kind = $2`,
+			event.PubKey,
+			event.Kind,
+		)
+		if err != nil {
+			return false, fmt.Errorf("failed to replace event: %w", err)
+		}
+	}
+
+	tag, err := tx.Exec(
+		ctx,
+		`INSERT INTO public.nostr_events (id, pubkey, kind, created_at, tag_values, raw)
+		 VALUES ($1, $2, $3, $4, $5, $6)
+		 ON CONFLICT (id) DO NOTHING`,
+		event.ID,
+		event.PubKey,
+		event.Kind,
+		int64(event.CreatedAt),
+		tagValues(event.Tags),
+		string(raw),
+	)
+	if err != nil {
+		return false, fmt.Errorf("failed to insert event: %w", err)
+	}
+	return tag.RowsAffected() > 0, tx.Commit(ctx)
+}
+
+func (s *PgStore) Get(ctx context.Context, id string) (*nostr.Event, error) {
+	var raw string
+	err := s.pool.QueryRow(ctx, `SELECT raw::text FROM public.nostr_events WHERE id = $1`, id).Scan(&raw)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to get event: %w", err)
+	}
+	var event nostr.Event
+	if err := json.Unmarshal([]byte(raw), &event); err != nil {
+		return nil, err
+	}
+	return &event, nil
+}
+
+func (s *PgStore) Query(ctx context.Context, filter nostr.Filter, limit int) ([]*nostr.Event, error) {
+	var conditions []string
+	var args []any
+	arg := func(value any) string {
+		args = append(args, value)
+		return fmt.Sprintf("$%d", len(args))
+	}
+
+	if len(filter.IDs) > 0 {
+		conditions = append(conditions, "id = ANY("+arg(filter.IDs)+")")
+	}
+	if len(filter.Authors) > 0 {
+		conditions = append(conditions, "pubkey = ANY("+arg(filter.Authors)+")")
+	}
+	if len(filter.Kinds) > 0 {
+		conditions = append(conditions, "kind = ANY("+arg(filter.Kinds)+")")
+	}
+	for name, values := range filter.Tags {
+		prefixed := make([]string, 0, len(values))
+		for _, value := range values {
+			prefixed = append(prefixed, name+":"+value)
+		}
+		conditions = append(conditions, "tag_values && "+arg(prefixed))
+	}
+	if filter.Since != nil {
+		conditions = append(conditions, "created_at >= "+arg(int64(*filter.Since)))
+	}
+	if filter.Until != nil {
+		conditions = append(conditions, "created_at <= "+arg(int64(*filter.Until)))
+	}
+
+	query := `SELECT raw::text FROM public.nostr_events`
+	if len(conditions) > 0 {
+		query += " WHERE " + strings.Join(conditions, " AND ")
+	}
+	query += " ORDER BY created_at DESC LIMIT " + arg(limit)
+
+	rows, err := s.pool.Query(ctx, query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("querying events: %w", err)
+	}
+	defer rows.Close()
+
+	var events []*nostr.Event
+	for rows.Next() {
+		var raw string
+		if err := rows.Scan(&raw); err != nil {
+			return nil, err
+		}
+		var event nostr.Event
+		if err := json.Unmarshal([]byte(raw), &event); err != nil {
+			return nil, err
+		}
+		events = append(events, &event)
+	}
+	return events, rows.Err()
+}
+
+func (s *PgStore) DeleteOld(ctx context.Context, before time.Time) error {
+	_, err := s.pool.Exec(
+		ctx,
+		`DELETE FROM public.nostr_events
+		 WHERE created_at < $1 AND NOT (kind IN (0, 3) OR kind BETWEEN 10000 AND 19999)`,
+		before.Unix(),
+	)
+	return err
+}
+
+func (s *PgStore) CountByAuthor(ctx context.Context, pubkey string) (int, error) {
+	var count int
+	err := s.pool.QueryRow(ctx, `SELECT COUNT(*) FROM public.nostr_events WHERE pubkey = $1`, pubkey).Scan(&count)
+	if err != nil {
+		return 0, fmt.Errorf("failed to count events: %w", err)
+	}
+	return count, nil
+}
+
+// The channel the ids of saved events are published on (see the nostr_events_notify trigger)
+const eventsNotifyChannel = "nostr_events"
+
+func (s *PgStore) Listen(ctx context.Context) (<-chan *nostr.Event, error) {
+	conn, err := s.pool.Acquire(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to acquire listen connection: %w", err)
+	}
+	if _, err := conn.Exec(ctx, "LISTEN "+eventsNotifyChannel); err != nil {
+		conn.Release()
+		return nil, fmt.Errorf("failed to listen on %v: %w", eventsNotifyChannel, err)
+	}
+
+	events := make(chan *nostr.Event)
+	go func() {
+		defer close(events)
+		// The connection is in LISTEN state, so don't return it to the pool
+		defer conn.Hijack().Close(context.Background())
+
+		for {
+			notification, err := conn.Conn().WaitForNotification(ctx)
+			if err != nil {
+				if ctx.Err() == nil {
+					log.Printf("failed to wait for %v notification: %v", eventsNotifyChannel, err)
+				}
+				return
+			}
+
+			event, err := s.Get(ctx, notification.Payload)
+			if err != nil || event == nil {
+				log.Printf("failed to get notified event %v: %v", notification.Payload, err)
+				continue
+			}
+
+			select {
+			case events <- event:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return events, nil
+}
+
+// tagValues indexes the single letter tags of the event as "<name>:<value>".
+func tagValues(tags nostr.Tags) []string {
+	values := []string{}
+	for _, tag := range tags {
+		if len(tag) >= 2 && len(tag[0]) == 1 {
+			values = append(values, tag[0]+":"+tag[1])
+		}
+	}
+	return values
+}
@@ -0,0 +1,31 @@
+package persist
+
+import (
+	"context"
+	"time"
+
+	"github.com/nbd-wtf/go-nostr"
+)
+
+type Store interface {
+	// Save stores the event and returns whether it was new. Replaceable
+	// events replace the older ones of the same pubkey and kind.
+	Save(ctx context.Context, event *nostr.Event) (bool, error)
+	Get(ctx context.Context, id string) (*nostr.Event, error)
+	// Query returns the events matching the filter, newest first, up to the
+	// filter limit if set.
+	Query(ctx context.Context, filter nostr.Filter, limit int) ([]*nostr.Event, error)
+	// DeleteOld deletes the events created before the given time, except
+	// replaceable events.
+	DeleteOld(ctx context.Context, before time.Time) error
+	// CountByAuthor returns the number of stored events of the pubkey
+	CountByAuthor(ctx context.Context, pubkey string) (int, error)
+	// Listen streams the saved events until the context is done or the
+	// underlying connection fails, in which case the channel is closed.
+	Listen(ctx context.Context) (<-chan *nostr.Event, error)
+}
+
+// IsReplaceableKind returns whether only the latest event of the kind is kept per pubkey (NIP-01).
+func IsReplaceableKind(kind int) bool {
+	return kind == 0 || kind == 3 || (kind >= 10000 && kind < 20000)
+}
@@ -5,19 +5,26 @@ import (
 	"fmt"
 	"github.com/jackc/pgx/v5/pgxpool"
 
+	dnssec "github.com/breez/breez-lnurl/persist/dnssec"
 	lnurl "github.com/breez/breez-lnurl/persist/lnurl"
 	nwc "github.com/breez/breez-lnurl/persist/nwc"
+	relay "github.com/breez/breez-lnurl/persist/relay"
 )
 
 type Store struct {
 	LnUrl lnurl.Store
 	Nwc   nwc.Store
+	Relay relay.Store
+	// The DNSSEC keys of the embedded BIP353 zone
+	Dnssec dnssec.Store
 }
 
 func NewMemoryStore() *Store {
 	return &Store{
-		LnUrl: lnurl.NewMemoryStore(),
-		Nwc:   nwc.NewMemoryStore(),
+		LnUrl:  lnurl.NewMemoryStore(),
+		Nwc:    nwc.NewMemoryStore(),
+		Relay:  relay.NewMemoryStore(),
+		Dnssec: dnssec.NewMemoryStore(),
 	}
 }
 
@@ -27,8 +34,10 @@ func NewPgStore(databaseUrl string) (*Store, error) {
 		return nil, fmt.Errorf("pgConnect() error: %v", err)
 	}
 	return &Store{
-		LnUrl: lnurl.NewPgStore(pool),
-		Nwc:   nwc.NewPgStore(pool),
+		LnUrl:  lnurl.NewPgStore(pool),
+		Nwc:    nwc.NewPgStore(pool),
+		Relay:  relay.NewPgStore(pool),
+		Dnssec: dnssec.NewPgStore(pool),
 	}, nil
 }
 
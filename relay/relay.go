@@ -0,0 +1,474 @@
+package relay
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	nwc "github.com/breez/breez-lnurl/persist/nwc"
+	relay "github.com/breez/breez-lnurl/persist/relay"
+	"github.com/gobwas/ws"
+	"github.com/gobwas/ws/wsutil"
+	"github.com/gorilla/mux"
+	"github.com/nbd-wtf/go-nostr"
+	"github.com/nbd-wtf/go-nostr/nip11"
+)
+
+// The path the relay is served on
+const RelayPath = "/nostr"
+
+// The event kinds the relay accepts: the NIP-47 info, request, response and
+// notification events
+var AcceptedKinds = []int{13194, 23194, 23195, 23196, 23197}
+
+// The relay limits, advertised in its NIP-11 information document
+var (
+	MaxMessageLength = 128 * 1024
+	MaxSubscriptions = 20
+	MaxFilters       = 10
+	MaxLimit         = 500
+	MaxSubidLength   = 64
+	MaxEventTags     = 100
+	MaxContentLength = 64 * 1024
+)
+
+// How far in the future the created_at of accepted events may be
+var MaxFutureDrift time.Duration = 15 * time.Minute
+
+// The time to wait before listening again after the event feed was interrupted
+var ListenRetryInterval time.Duration = 5 * time.Second
+
+// The time to wait for a client to accept a message
+var WriteTimeout time.Duration = 10 * time.Second
+
+// The maximum number of live events waiting to be written to a connection.
+// Clients reading slower than they are sent events are disconnected.
+var MaxQueuedMessages = 1000
+
+// The limits of the events accepted, as the relay is open to anyone
+var (
+	// The maximum number of events a connection publishes in a minute
+	MaxEventsPerMinute = 120
+	// The maximum number of events of a pubkey stored at a time, not
+	// counting replaceable events
+	MaxEventsPerAuthor = 1000
+)
+
+// Relay is a NIP-01 relay storing NIP-47 events only. Live subscriptions
+// are fed by the store, so events saved through any server instance reach
+// the clients of every instance.
+type Relay struct {
+	store relay.Store
+	mu    sync.Mutex
+	conns map[*connection]struct{}
+}
+
+// EmbeddedRelayUrl returns the normalized websocket url of the relay served
+// under the external server url.
+func EmbeddedRelayUrl(externalURL *url.URL) (string, error) {
+	return nwc.NormalizeRelayUrl(strings.TrimRight(externalURL.String(), "/") + RelayPath)
+}
+
+func NewRelay(store relay.Store) *Relay {
+	return &Relay{
+		store: store,
+		conns: make(map[*connection]struct{}),
+	}
+}
+
+func RegisterRelayRouter(router *mux.Router, store relay.Store) {
+	r := NewRelay(store)
+	go r.Start(context.Background())
+	router.Handle(RelayPath, r)
+}
+
+// Start delivers the saved events to the live subscriptions until the
+// context is done.
+func (r *Relay) Start(ctx context.Context) {
+	for {
+		events, err := r.store.Listen(ctx)
+		if err != nil {
+			log.Printf("relay: failed to listen to events: %v", err)
+		} else {
+			for event := range events {
+				r.broadcast(event)
+			}
+		}
+		select {
+		case <-time.After(ListenRetryInterval):
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+func (r *Relay) broadcast(event *nostr.Event) {
+	r.mu.Lock()
+	conns := make([]*connection, 0, len(r.conns))
+	for conn := range r.conns {
+		conns = append(conns, conn)
+	}
+	r.mu.Unlock()
+
+	for _, conn := range conns {
+		for _, id := range conn.matchingSubscriptions(event) {
+			conn.push(&nostr.EventEnvelope{SubscriptionID: &id, Event: *event})
+		}
+	}
+}
+
+func (r *Relay) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	if req.Header.Get("Upgrade") == "" {
+		if req.Header.Get("Accept") == "application/nostr+json" {
+			r.serveInformation(w)
+			return
+		}
+		http.Error(w, "Please use a Nostr client to connect", http.StatusBadRequest)
+		return
+	}
+
+	netConn, _, _, err := ws.UpgradeHTTP(req, w)
+	if err != nil {
+		log.Printf("relay: failed to upgrade connection: %v", err)
+		return
+	}
+	conn := newConnection(netConn)
+	go conn.writeLoop()
+
+	r.mu.Lock()
+	r.conns[conn] = struct{}{}
+	r.mu.Unlock()
+	defer func() {
+		r.mu.Lock()
+		delete(r.conns, conn)
+		r.mu.Unlock()
+		conn.close()
+	}()
+
+	r.serveConnection(req.Context(), conn)
+}
+
+func (r *Relay) serveInformation(w http.ResponseWriter) {
+	w.Header().Set("Content-Type", "application/nostr+json")
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	json.NewEncoder(w).Encode(nip11.RelayInformationDocument{
+		Name:          "Breez NWC relay",
+		Description:   "Relays Nostr Wallet Connect (NIP-47) events only, for a short period",
+		SupportedNIPs: []int{1, 11},
+		Software:      "https://github.com/breez/breez-lnurl",
+		Limitation: &nip11.RelayLimitationDocument{
+			MaxMessageLength: MaxMessageLength,
+			MaxSubscriptions: MaxSubscriptions,
+			MaxFilters:       MaxFilters,
+			MaxLimit:         MaxLimit,
+			MaxSubidLength:   MaxSubidLength,
+			MaxEventTags:     MaxEventTags,
+			MaxContentLength: MaxContentLength,
+		},
+	})
+}
+
+func (r *Relay) serveConnection(ctx context.Context, conn *connection) {
+	reader := &wsutil.Reader{
+		Source:         conn.conn,
+		State:          ws.StateServerSide,
+		CheckUTF8:      true,
+		MaxFrameSize:   int64(MaxMessageLength),
+		OnIntermediate: wsutil.ControlFrameHandler(conn, ws.StateServerSide),
+	}
+	controlHandler := wsutil.ControlFrameHandler(conn, ws.StateServerSide)
+
+	for {
+		header, err := reader.NextFrame()
+		if err != nil {
+			return
+		}
+		if header.OpCode.IsControl() {
+			if err := controlHandler(header, reader); err != nil {
+				return
+			}
+			continue
+		}
+		if header.OpCode != ws.OpText {
+			if err := reader.Discard(); err != nil {
+				return
+			}
+			continue
+		}
+
+		// The reader joins the frames of fragmented messages, which are only
+		// limited one by one
+		message, err := io.ReadAll(io.LimitReader(reader, int64(MaxMessageLength)+1))
+		if err != nil {
+			return
+		}
+		if len(message) > MaxMessageLength {
+			conn.closeWith(ws.StatusMessageTooBig, "message too big")
+			return
+		}
+		r.handleMessage(ctx, conn, message)
+	}
+}
+
+func (r *Relay) handleMessage(ctx context.Context, conn *connection, message []byte) {
+	switch envelope := nostr.ParseMessage(message).(type) {
+	case *nostr.EventEnvelope:
+		r.handleEvent(ctx, conn, &envelope.Event)
+	case *nostr.ReqEnvelope:
+		r.handleReq(ctx, conn, envelope)
+	case *nostr.CloseEnvelope:
+		conn.unsubscribe(string(*envelope))
+	default:
+		notice := nostr.NoticeEnvelope("unsupported message")
+		conn.send(&notice)
+	}
+}
+
+func (r *Relay) handleEvent(ctx context.Context, conn *connection, event *nostr.Event) {
+	if !conn.allowEvent(time.Now()) {
+		conn.send(&nostr.OKEnvelope{EventID: event.ID, OK: false, Reason: "rate-limited: slow down"})
+		return
+	}
+	if err := validateEvent(event); err != nil {
+		conn.send(&nostr.OKEnvelope{EventID: event.ID, OK: false, Reason: err.Error()})
+		return
+	}
+	if !relay.IsReplaceableKind(event.Kind) {
+		stored, err := r.store.CountByAuthor(ctx, event.PubKey)
+		if err != nil {
+			log.Printf("relay: failed to count the events of %v: %v", event.PubKey, err)
+			conn.send(&nostr.OKEnvelope{EventID: event.ID, OK: false, Reason: "error: failed to save event"})
+			return
+		}
+		if stored >= MaxEventsPerAuthor {
+			conn.send(&nostr.OKEnvelope{EventID: event.ID, OK: false, Reason: "rate-limited: too many stored events"})
+			return
+		}
+	}
+
+	saved, err := r.store.Save(ctx, event)
+	if err != nil {
+		log.Printf("relay: failed to save event %v: %v", event.ID, err)
+		conn.send(&nostr.OKEnvelope{EventID: event.ID, OK: false, Reason: "error: failed to save event"})
+		return
+	}
+	reason := ""
+	if !saved {
+		reason = "duplicate: already have this event"
+	}
+	conn.send(&nostr.OKEnvelope{EventID: event.ID, OK: true, Reason: reason})
+}
+
+// validateEvent returns the NIP-01 prefixed reason an event is rejected for.
+func validateEvent(event *nostr.Event) error {
+	if !slices.Contains(AcceptedKinds, event.Kind) {
+		return fmt.Errorf("blocked: only NIP-47 events are accepted")
+	}
+	if event.GetID() != event.ID {
+		return fmt.Errorf("invalid: event id does not match")
+	}
+	if ok, err := event.CheckSignature(); err != nil || !ok {
+		return fmt.Errorf("invalid: bad signature")
+	}
+	if len(event.Content) > MaxContentLength || len(event.Tags) > MaxEventTags {
+		return fmt.Errorf("invalid: event too large")
+	}
+	if event.CreatedAt.Time().After(time.Now().Add(MaxFutureDrift)) {
+		return fmt.Errorf("invalid: created_at too far in the future")
+	}
+	return nil
+}
+
+func (r *Relay) handleReq(ctx context.Context, conn *connection, req *nostr.ReqEnvelope) {
+	id := req.SubscriptionID
+	if id == "" || len(id) > MaxSubidLength {
+		conn.send(&nostr.ClosedEnvelope{SubscriptionID: id, Reason: "invalid: bad subscription id"})
+		return
+	}
+	if len(req.Filters) > MaxFilters {
+		conn.send(&nostr.ClosedEnvelope{SubscriptionID: id, Reason: "invalid: too many filters"})
+		return
+	}
+	if !conn.subscribe(id, req.Filters) {
+		conn.send(&nostr.ClosedEnvelope{SubscriptionID: id, Reason: "blocked: too many subscriptions"})
+		return
+	}
+
+	// The subscription is live before the stored events are queried, so no
+	// event is missed in between. Clients drop the duplicates.
+	for _, filter := range req.Filters {
+		limit := MaxLimit
+		if filter.Limit > 0 && filter.Limit < limit {
+			limit = filter.Limit
+		}
+		events, err := r.store.Query(ctx, filter, limit)
+		if err != nil {
+			log.Printf("relay: failed to query events: %v", err)
+			conn.unsubscribe(id)
+			conn.send(&nostr.ClosedEnvelope{SubscriptionID: id, Reason: "error: failed to query events"})
+			return
+		}
+		for _, event := range events {
+			conn.send(&nostr.EventEnvelope{SubscriptionID: &id, Event: *event})
+		}
+	}
+	eose := nostr.EOSEEnvelope(id)
+	conn.send(&eose)
+}
+
+// connection is a client websocket connection and its subscriptions. Its
+// messages are written by a single writer goroutine, in order.
+type connection struct {
+	conn    net.Conn
+	writeMu sync.Mutex
+	// The messages waiting to be written
+	queue     chan []byte
+	closed    chan struct{}
+	closeOnce sync.Once
+	mu        sync.Mutex
+	subs      map[string]nostr.Filters
+	// The start of the current minute of published events and their number
+	eventsSince time.Time
+	events      int
+}
+
+func newConnection(conn net.Conn) *connection {
+	return &connection{
+		conn:   conn,
+		queue:  make(chan []byte, MaxQueuedMessages),
+		closed: make(chan struct{}),
+		subs:   make(map[string]nostr.Filters),
+	}
+}
+
+// close closes the connection, which ends its read loop and writer.
+func (c *connection) close() {
+	c.closeOnce.Do(func() {
+		close(c.closed)
+		c.conn.Close()
+	})
+}
+
+// closeWith sends a close frame with the status, then closes the connection.
+func (c *connection) closeWith(status ws.StatusCode, reason string) {
+	c.writeMu.Lock()
+	c.conn.SetWriteDeadline(time.Now().Add(WriteTimeout))
+	ws.WriteFrame(c.conn, ws.NewCloseFrame(ws.NewCloseFrameBody(status, reason)))
+	c.writeMu.Unlock()
+	c.close()
+}
+
+// writeLoop writes the queued messages until the connection is closed.
+func (c *connection) writeLoop() {
+	for {
+		select {
+		case message := <-c.queue:
+			c.writeMu.Lock()
+			c.conn.SetWriteDeadline(time.Now().Add(WriteTimeout))
+			err := wsutil.WriteServerText(c.conn, message)
+			c.writeMu.Unlock()
+			if err != nil {
+				if !errors.Is(err, net.ErrClosed) {
+					log.Printf("relay: failed to send message: %v", err)
+				}
+				c.close()
+				return
+			}
+		case <-c.closed:
+			return
+		}
+	}
+}
+
+// Write writes control frames, such as pongs, without interleaving them with messages.
+func (c *connection) Write(p []byte) (int, error) {
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+	return c.conn.Write(p)
+}
+
+// send queues a reply to the client, waiting for room in the queue, so
+// clients are not read from faster than they read.
+func (c *connection) send(envelope nostr.Envelope) {
+	message, err := envelope.MarshalJSON()
+	if err != nil {
+		log.Printf("relay: failed to encode %v message: %v", envelope.Label(), err)
+		return
+	}
+	select {
+	case c.queue <- message:
+	case <-c.closed:
+	}
+}
+
+// push queues a live event without waiting, and closes the connection when
+// its queue is full.
+func (c *connection) push(envelope nostr.Envelope) {
+	message, err := envelope.MarshalJSON()
+	if err != nil {
+		log.Printf("relay: failed to encode %v message: %v", envelope.Label(), err)
+		return
+	}
+	select {
+	case c.queue <- message:
+	case <-c.closed:
+	default:
+		log.Printf("relay: closing a connection reading too slowly")
+		c.close()
+	}
+}
+
+// allowEvent returns whether the client may publish another event this minute.
+func (c *connection) allowEvent(now time.Time) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if now.Sub(c.eventsSince) >= time.Minute {
+		c.eventsSince = now
+		c.events = 0
+	}
+	if c.events >= MaxEventsPerMinute {
+		return false
+	}
+	c.events++
+	return true
+}
+
+// subscribe sets the filters of the subscription, replacing the existing
+// ones, and returns false when the connection has too many subscriptions.
+func (c *connection) subscribe(id string, filters nostr.Filters) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if _, ok := c.subs[id]; !ok && len(c.subs) >= MaxSubscriptions {
+		return false
+	}
+	c.subs[id] = filters
+	return true
+}
+
+func (c *connection) unsubscribe(id string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.subs, id)
+}
+
+func (c *connection) matchingSubscriptions(event *nostr.Event) []string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	var ids []string
+	for id, filters := range c.subs {
+		if filters.Match(event) {
+			ids = append(ids, id)
+		}
+	}
+	return ids
+}
@@ -0,0 +1,232 @@
+package relay
+
+import (
+	"context"
+	"net"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	relay "github.com/breez/breez-lnurl/persist/relay"
+	"github.com/gobwas/ws"
+	"github.com/nbd-wtf/go-nostr"
+)
+
+func setupRelay(t *testing.T) string {
+	r := NewRelay(relay.NewMemoryStore())
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	go r.Start(ctx)
+
+	server := httptest.NewServer(r)
+	t.Cleanup(server.Close)
+	return "ws" + strings.TrimPrefix(server.URL, "http")
+}
+
+func signedEvent(t *testing.T, kind int, walletServicePubkey string) nostr.Event {
+	return signedEventBy(t, nostr.GeneratePrivateKey(), kind, walletServicePubkey)
+}
+
+func signedEventBy(t *testing.T, key string, kind int, walletServicePubkey string) nostr.Event {
+	event := nostr.Event{
+		Kind:      kind,
+		CreatedAt: nostr.Now(),
+		Tags:      nostr.Tags{{"p", walletServicePubkey}},
+		Content:   nostr.GeneratePrivateKey(),
+	}
+	if err := event.Sign(key); err != nil {
+		t.Fatalf("failed to sign event: %v", err)
+	}
+	return event
+}
+
+func TestRelayStoresAndDeliversNwcEvents(t *testing.T) {
+	url := setupRelay(t)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	client, err := nostr.RelayConnect(ctx, url)
+	if err != nil {
+		t.Fatalf("failed to connect: %v", err)
+	}
+	defer client.Close()
+
+	walletServicePubkey, _ := nostr.GetPublicKey(nostr.GeneratePrivateKey())
+	stored := signedEvent(t, 23194, walletServicePubkey)
+	if err := client.Publish(ctx, stored); err != nil {
+		t.Fatalf("failed to publish: %v", err)
+	}
+
+	sub, err := client.Subscribe(ctx, nostr.Filters{{
+		Kinds: []int{23194},
+		Tags:  nostr.TagMap{"p": []string{walletServicePubkey}},
+	}})
+	if err != nil {
+		t.Fatalf("failed to subscribe: %v", err)
+	}
+
+	live := signedEvent(t, 23194, walletServicePubkey)
+	for i, expected := range []nostr.Event{stored, live} {
+		select {
+		case event := <-sub.Events:
+			if event.ID != expected.ID {
+				t.Fatalf("expected event %v, got %v", expected.ID, event.ID)
+			}
+		case <-ctx.Done():
+			t.Fatalf("event %d not received", i)
+		}
+		if i == 0 {
+			<-sub.EndOfStoredEvents
+			if err := client.Publish(ctx, live); err != nil {
+				t.Fatalf("failed to publish: %v", err)
+			}
+		}
+	}
+}
+
+func TestRelayRejectsOtherKinds(t *testing.T) {
+	url := setupRelay(t)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	client, err := nostr.RelayConnect(ctx, url)
+	if err != nil {
+		t.Fatalf("failed to connect: %v", err)
+	}
+	defer client.Close()
+
+	err = client.Publish(ctx, signedEvent(t, nostr.KindTextNote, "pubkey"))
+	if err == nil || !strings.Contains(err.Error(), "blocked") {
+		t.Fatalf("expected the event to be blocked, got %v", err)
+	}
+}
+
+func TestRelayRateLimitsEvents(t *testing.T) {
+	maxEventsPerMinute := MaxEventsPerMinute
+	MaxEventsPerMinute = 2
+	t.Cleanup(func() { MaxEventsPerMinute = maxEventsPerMinute })
+
+	url := setupRelay(t)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	client, err := nostr.RelayConnect(ctx, url)
+	if err != nil {
+		t.Fatalf("failed to connect: %v", err)
+	}
+	defer client.Close()
+
+	for i := 0; i < 2; i++ {
+		if err := client.Publish(ctx, signedEvent(t, 23194, "pubkey")); err != nil {
+			t.Fatalf("failed to publish: %v", err)
+		}
+	}
+	err = client.Publish(ctx, signedEvent(t, 23194, "pubkey"))
+	if err == nil || !strings.Contains(err.Error(), "rate-limited") {
+		t.Fatalf("expected the event to be rate limited, got %v", err)
+	}
+}
+
+func TestRelayCapsEventsPerAuthor(t *testing.T) {
+	maxEventsPerAuthor := MaxEventsPerAuthor
+	MaxEventsPerAuthor = 2
+	t.Cleanup(func() { MaxEventsPerAuthor = maxEventsPerAuthor })
+
+	url := setupRelay(t)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	client, err := nostr.RelayConnect(ctx, url)
+	if err != nil {
+		t.Fatalf("failed to connect: %v", err)
+	}
+	defer client.Close()
+
+	key := nostr.GeneratePrivateKey()
+	for i := 0; i < 2; i++ {
+		if err := client.Publish(ctx, signedEventBy(t, key, 23194, "pubkey")); err != nil {
+			t.Fatalf("failed to publish: %v", err)
+		}
+	}
+	err = client.Publish(ctx, signedEventBy(t, key, 23194, "pubkey"))
+	if err == nil || !strings.Contains(err.Error(), "too many stored events") {
+		t.Fatalf("expected the event to be rejected, got %v", err)
+	}
+	// Replaceable events don't add up
+	if err := client.Publish(ctx, signedEventBy(t, key, 13194, "pubkey")); err != nil {
+		t.Fatalf("failed to publish the info event: %v", err)
+	}
+	// Other authors are not limited
+	if err := client.Publish(ctx, signedEvent(t, 23194, "pubkey")); err != nil {
+		t.Fatalf("failed to publish: %v", err)
+	}
+}
+
+func TestSlowConnectionClosed(t *testing.T) {
+	maxQueuedMessages := MaxQueuedMessages
+	MaxQueuedMessages = 1
+	t.Cleanup(func() { MaxQueuedMessages = maxQueuedMessages })
+
+	server, client := net.Pipe()
+	defer client.Close()
+	// The writer isn't started, as if the client didn't read
+	conn := newConnection(server)
+	event := signedEvent(t, 23194, "pubkey")
+	id := "sub"
+	conn.push(&nostr.EventEnvelope{SubscriptionID: &id, Event: event})
+	select {
+	case <-conn.closed:
+		t.Fatalf("expected the connection to stay open")
+	default:
+	}
+
+	conn.push(&nostr.EventEnvelope{SubscriptionID: &id, Event: event})
+	select {
+	case <-conn.closed:
+	default:
+		t.Fatalf("expected the connection to be closed")
+	}
+}
+
+func TestFragmentedMessageTooBig(t *testing.T) {
+	url := setupRelay(t)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	conn, _, _, err := ws.Dial(ctx, url)
+	if err != nil {
+		t.Fatalf("failed to connect: %v", err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+
+	// Each frame fits the limit, the message doesn't
+	fragment := []byte(strings.Repeat("a", MaxMessageLength/2))
+	for i := 0; i < 3; i++ {
+		opCode := ws.OpContinuation
+		if i == 0 {
+			opCode = ws.OpText
+		}
+		frame := ws.MaskFrameInPlace(ws.NewFrame(opCode, false, append([]byte(nil), fragment...)))
+		if err := ws.WriteFrame(conn, frame); err != nil {
+			// The relay may close the connection before the last fragments
+			break
+		}
+	}
+
+	for {
+		frame, err := ws.ReadFrame(conn)
+		if err != nil {
+			t.Fatalf("connection closed without a close frame: %v", err)
+		}
+		if frame.Header.OpCode != ws.OpClose {
+			continue
+		}
+		status, _ := ws.ParseCloseFrameData(frame.Payload)
+		if status != ws.StatusMessageTooBig {
+			t.Fatalf("expected close status %v, got %v", ws.StatusMessageTooBig, status)
+		}
+		return
+	}
+}
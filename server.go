@@ -2,10 +2,15 @@ package main
 
 import (
 	"context"
+	"crypto/subtle"
+	"expvar"
 	"fmt"
+	"log"
 	"net/http"
 	"net/url"
+	"strings"
 
+	"github.com/breez/breez-lnurl/bip353"
 	"github.com/breez/breez-lnurl/bolt12"
 	"github.com/breez/breez-lnurl/cache"
 	"github.com/breez/breez-lnurl/channel"
@@ -13,26 +18,41 @@ import (
 	"github.com/breez/breez-lnurl/lnurl"
 	"github.com/breez/breez-lnurl/nwc"
 	"github.com/breez/breez-lnurl/persist"
+	"github.com/breez/breez-lnurl/relay"
 	"github.com/gorilla/mux"
 )
 
+type ServerConfig struct {
+	// The bearer token required to access the operator endpoints under /admin.
+	// The operator endpoints are disabled when empty.
+	AdminToken string
+	// The settings of the Nostr Wallet Connect service
+	Nwc nwc.Config
+	// Whether to serve a relay for NWC events under /nostr
+	EmbeddedRelay bool
+}
+
 type Server struct {
 	internalURL *url.URL
 	externalURL *url.URL
 	storage     *persist.Store
 	dns         dns.DnsService
 	cache       cache.CacheService
+	config      ServerConfig
 	rootHandler *mux.Router
 }
 
-func NewServer(internalURL *url.URL, externalURL *url.URL, storage *persist.Store, dns dns.DnsService, cache cache.CacheService) *Server {
+// NewServer builds the server routes. The background services stop when the
+// context is done.
+func NewServer(ctx context.Context, internalURL *url.URL, externalURL *url.URL, storage *persist.Store, dns dns.DnsService, cache cache.CacheService, config ServerConfig) *Server {
 	server := &Server{
 		internalURL: internalURL,
 		externalURL: externalURL,
 		storage:     storage,
 		dns:         dns,
 		cache:       cache,
-		rootHandler: initRootHandler(externalURL, storage, dns, cache),
+		config:      config,
+		rootHandler: initRootHandler(ctx, externalURL, storage, dns, cache, config),
 	}
 
 	return server
@@ -42,12 +62,16 @@ func (s *Server) Serve() error {
 	return http.ListenAndServe("0.0.0.0:8080", s.rootHandler)
 }
 
-func initRootHandler(externalURL *url.URL, storage *persist.Store, dns dns.DnsService, cache cache.CacheService) *mux.Router {
+func initRootHandler(ctx context.Context, externalURL *url.URL, storage *persist.Store, dns dns.DnsService, cache cache.CacheService, config ServerConfig) *mux.Router {
 	rootRouter := mux.NewRouter()
 
+	// Routes for operators, authenticated by the admin token
+	adminRouter := rootRouter.PathPrefix("/admin").Subrouter()
+	adminRouter.Use(adminAuthMiddleware(config.AdminToken))
+
 	// start the cleanup service
 	cleanup := persist.NewCleanupService(storage)
-	cleanup.Start(context.Background())
+	cleanup.Start(ctx)
 
 	// The channel that handles the request/response cycle from the node.
 	// This specific channel handles that by invoking the registered webhook to reach the node
@@ -55,13 +79,50 @@ func initRootHandler(externalURL *url.URL, storage *persist.Store, dns dns.DnsSe
 	webhookChannel := channel.NewHttpCallbackChannel(rootRouter, fmt.Sprintf("%v/response", externalURL.String()))
 
 	// Routes to handle lnurl pay protocol.
-	lnurl.RegisterLnurlPayRouter(rootRouter, externalURL, storage, dns, cache, webhookChannel)
+	lnurl.RegisterLnurlPayRouter(rootRouter, externalURL, storage, cache, webhookChannel)
 
 	// Routes to handle BOLT12 Offers.
-	bolt12.RegisterBolt12OfferRouter(rootRouter, externalURL, storage, dns)
+	bolt12.RegisterBolt12OfferRouter(rootRouter, externalURL, storage)
+
+	// Publish the BIP353 DNS updates queued along with the offers
+	go bip353.NewPublisher(dns, storage.LnUrl).Start(ctx)
+
+	// Resolution, reconciliation, DNSSEC keys and proofs of the BIP353 records
+	bip353.RegisterBip353Router(ctx, rootRouter, adminRouter, externalURL, storage, dns)
+
+	// The relay for NWC events, read by the NostrManager directly from the store
+	if config.EmbeddedRelay {
+		relayUrl, err := relay.EmbeddedRelayUrl(externalURL)
+		if err != nil {
+			log.Fatalf("failed to build the embedded relay url: %v", err)
+		}
+		relay.RegisterRelayRouter(rootRouter, storage.Relay)
+		config.Nwc.EmbeddedRelayUrl = relayUrl
+		log.Printf("serving the embedded relay on %v", relayUrl)
+	}
 
 	// Routes to handle Nostr event subscriptions
-	nwc.RegisterNostrEventsRouter(rootRouter, externalURL, storage, cleanup.Nwc)
+	nwc.RegisterNostrEventsRouter(rootRouter, adminRouter, externalURL, storage, cleanup.Nwc, config.Nwc)
+
+	// Runtime metrics, such as the NWC app pubkeys that could not be subscribed
+	adminRouter.Handle("/debug/vars", expvar.Handler()).Methods("GET")
 
 	return rootRouter
 }
+
+func adminAuthMiddleware(adminToken string) mux.MiddlewareFunc {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if adminToken == "" {
+				http.Error(w, "admin endpoints disabled", http.StatusForbidden)
+				return
+			}
+			token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+			if subtle.ConstantTimeCompare([]byte(token), []byte(adminToken)) != 1 {
+				http.Error(w, "invalid token", http.StatusUnauthorized)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
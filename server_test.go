@@ -55,7 +55,7 @@ func setupServer(storage *persist.Store, dns dns.DnsService, cache cache.CacheSe
 	if err != nil {
 		return "", fmt.Errorf("failed to parse server URL %v", err)
 	}
-	server := NewServer(serverURL, serverURL, storage, dns, cache)
+	server := NewServer(context.Background(), serverURL, serverURL, storage, dns, cache, ServerConfig{})
 	go func() {
 		persist.NewCleanupService(storage).Start(context.Background())
 	}()